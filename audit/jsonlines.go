@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"encoding/json"
+	"github.com/yjv/goauth2-server/server"
+	"io"
+	"os"
+	"sync"
+)
+
+// JSONLinesSink writes every record as one line of json.
+type JSONLinesSink struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func (sink *JSONLinesSink) Record(record *server.AuditRecord) error {
+
+	line, error := json.Marshal(record)
+
+	if error != nil {
+
+		return error
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	_, error = sink.writer.Write(append(line, '\n'))
+	return error
+}
+
+// Close closes the underlying writer when it can be closed.
+func (sink *JSONLinesSink) Close() error {
+
+	if closer, ok := sink.writer.(io.Closer); ok {
+
+		return closer.Close()
+	}
+
+	return nil
+}
+
+func NewJSONLinesSink(writer io.Writer) *JSONLinesSink {
+
+	return &JSONLinesSink{writer: writer}
+}
+
+// OpenJSONLinesFile appends to the file at path, creating it readable by its
+// owner only when it does not exist yet.
+func OpenJSONLinesFile(path string) (*JSONLinesSink, error) {
+
+	file, error := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	return NewJSONLinesSink(file), nil
+}
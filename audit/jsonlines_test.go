@@ -0,0 +1,26 @@
+package audit
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/yjv/goauth2-server/server"
+	"testing"
+	"time"
+)
+
+func TestJSONLinesSinkRecord(t *testing.T) {
+
+	buffer := &bytes.Buffer{}
+	sink := NewJSONLinesSink(buffer)
+	now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Nil(t, sink.Record(&server.AuditRecord{Time: now, Event: "token_issued", ClientId: "client", Scopes: []string{"read"}}))
+	assert.Nil(t, sink.Record(&server.AuditRecord{Time: now, Event: "grant_failed", ErrorCode: "invalid_scope"}))
+	assert.Equal(
+		t,
+		`{"time":"2015-01-01T00:00:00Z","event":"token_issued","client_id":"client","scopes":["read"]}`+"\n"+
+			`{"time":"2015-01-01T00:00:00Z","event":"grant_failed","error_code":"invalid_scope"}`+"\n",
+		buffer.String(),
+	)
+	assert.Nil(t, sink.Close())
+}
@@ -0,0 +1,49 @@
+package audit
+
+import (
+	"context"
+	"github.com/yjv/goauth2-server/server"
+	"log/slog"
+)
+
+// SlogSink logs records at info level and failures at warn level.
+type SlogSink struct {
+	logger *slog.Logger
+}
+
+func (sink *SlogSink) Record(record *server.AuditRecord) error {
+
+	level := slog.LevelInfo
+
+	if record.ErrorCode != "" {
+
+		level = slog.LevelWarn
+	}
+
+	attributes := []slog.Attr{
+		slog.Time("time", record.Time),
+		slog.String("grant", record.Grant),
+		slog.String("client_id", record.ClientId),
+		slog.String("owner_id", record.OwnerId),
+		slog.Any("scopes", record.Scopes),
+		slog.String("source_address", record.SourceAddress),
+		slog.String("token_hash", record.TokenHash),
+	}
+
+	if record.ErrorCode != "" {
+
+		attributes = append(
+			attributes,
+			slog.String("error_code", record.ErrorCode),
+			slog.String("error_description", record.ErrorDescription),
+		)
+	}
+
+	sink.logger.LogAttrs(context.Background(), level, record.Event, attributes...)
+	return nil
+}
+
+func NewSlogSink(logger *slog.Logger) *SlogSink {
+
+	return &SlogSink{logger}
+}
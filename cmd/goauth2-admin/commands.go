@@ -0,0 +1,709 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/yjv/goauth2-server/server"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+func createClient(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	var redirectUris, grants, resources, scopes stringsFlag
+	id := flags.String("id", "", "the client id")
+	name := flags.String("name", "", "the client name")
+	public := flags.Bool("public", false, "create a public client without a secret")
+	flags.Var(&redirectUris, "redirect-uri", "a redirect uri, can be repeated")
+	flags.Var(&grants, "grant", "a grant the client may use, can be repeated, all grants when left out")
+	flags.Var(&resources, "resource", "a resource the client may ask tokens for, can be repeated, all resources when left out")
+	flags.Var(&scopes, "scope", "a scope the client may be granted, can be repeated, all scopes when left out")
+
+	if error := parseFlags(flags, arguments, "id"); error != nil {
+
+		return error
+	}
+
+	manager, error := admin.clientManager()
+
+	if error != nil {
+
+		return error
+	}
+
+	if existing, _ := manager.FindClientById(*id); existing != nil {
+
+		return fmt.Errorf("client %s already exists", *id)
+	}
+
+	if error := validateResources(resources); error != nil {
+
+		return error
+	}
+
+	client := &server.Client{
+		Id:               *id,
+		Name:             *name,
+		Type:             server.ConfidentialClient,
+		RedirectUris:     redirectUris,
+		AllowedGrants:    grants,
+		AllowedResources: resources,
+		AllowedScopes:    scopes,
+	}
+	secret := ""
+
+	if *public {
+
+		client.Type = server.PublicClient
+	} else if secret, error = generateSecret(); error != nil {
+
+		return error
+	}
+
+	if error := validateGrants(client); error != nil {
+
+		return error
+	}
+
+	if error := manager.SaveClient(client, secret); error != nil {
+
+		return error
+	}
+
+	fmt.Fprintf(admin.stdout, "created client %s\n", client.Id)
+
+	if secret != "" {
+
+		fmt.Fprintf(admin.stdout, "client secret: %s\n", secret)
+	}
+
+	return nil
+}
+
+func listClients(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	if error := parseFlags(flags, arguments); error != nil {
+
+		return error
+	}
+
+	manager, error := admin.clientManager()
+
+	if error != nil {
+
+		return error
+	}
+
+	clients, error := manager.FindAllClients()
+
+	if error != nil {
+
+		return error
+	}
+
+	writer := tabwriter.NewWriter(admin.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tNAME\tTYPE\tGRANTS\tSCOPES\tRESOURCES\tREDIRECT URIS")
+
+	for _, client := range clients {
+
+		fmt.Fprintf(
+			writer,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			client.Id,
+			client.Name,
+			client.Type,
+			orAll(client.AllowedGrants),
+			orAll(client.AllowedScopes),
+			orAll(client.AllowedResources),
+			strings.Join(client.RedirectUris, " "),
+		)
+	}
+
+	return writer.Flush()
+}
+
+// updateClient only changes what was given on the command line and keeps the
+// client secret.
+func updateClient(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	var redirectUris, grants, resources, scopes stringsFlag
+	id := flags.String("id", "", "the client id")
+	name := flags.String("name", "", "the new client name")
+	flags.Var(&redirectUris, "redirect-uri", "replaces the redirect uris, can be repeated")
+	flags.Var(&grants, "grant", "replaces the allowed grants, can be repeated")
+	flags.Var(&resources, "resource", "replaces the allowed resources, can be repeated")
+	flags.Var(&scopes, "scope", "replaces the allowed scopes, can be repeated")
+
+	if error := parseFlags(flags, arguments, "id"); error != nil {
+
+		return error
+	}
+
+	manager, error := admin.clientManager()
+
+	if error != nil {
+
+		return error
+	}
+
+	client, error := manager.FindClientById(*id)
+
+	if client == nil {
+
+		return error
+	}
+
+	flags.Visit(func(flag *flag.Flag) {
+
+		switch flag.Name {
+		case "name":
+			client.Name = *name
+		case "redirect-uri":
+			client.RedirectUris = redirectUris
+		case "grant":
+			client.AllowedGrants = grants
+		case "resource":
+			client.AllowedResources = resources
+		case "scope":
+			client.AllowedScopes = scopes
+		}
+	})
+
+	if error := validateResources(client.AllowedResources); error != nil {
+
+		return error
+	}
+
+	if error := validateGrants(client); error != nil {
+
+		return error
+	}
+
+	if error := manager.UpdateClient(client); error != nil {
+
+		return error
+	}
+
+	fmt.Fprintf(admin.stdout, "updated client %s\n", client.Id)
+	return nil
+}
+
+func deleteClient(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	id := flags.String("id", "", "the client id")
+
+	if error := parseFlags(flags, arguments, "id"); error != nil {
+
+		return error
+	}
+
+	manager, error := admin.clientManager()
+
+	if error != nil {
+
+		return error
+	}
+
+	if error := manager.DeleteClient(*id); error != nil {
+
+		return error
+	}
+
+	fmt.Fprintf(admin.stdout, "deleted client %s\n", *id)
+	return nil
+}
+
+func rotateClientSecret(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	id := flags.String("id", "", "the client id")
+
+	if error := parseFlags(flags, arguments, "id"); error != nil {
+
+		return error
+	}
+
+	manager, error := admin.clientManager()
+
+	if error != nil {
+
+		return error
+	}
+
+	client, error := manager.FindClientById(*id)
+
+	if client == nil {
+
+		return error
+	}
+
+	if client.IsPublic() {
+
+		return fmt.Errorf("client %s is public and has no secret", client.Id)
+	}
+
+	secret, error := generateSecret()
+
+	if error != nil {
+
+		return error
+	}
+
+	if error := manager.SaveClient(client, secret); error != nil {
+
+		return error
+	}
+
+	fmt.Fprintf(admin.stdout, "rotated the secret of client %s\nclient secret: %s\n", client.Id, secret)
+	return nil
+}
+
+// addOwner reads the password from stdin so it does not end up in the shell
+// history or the process list.
+func addOwner(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	id := flags.String("id", "", "the owner id")
+	username := flags.String("username", "", "the username the owner logs in with")
+	name := flags.String("name", "", "the owner name")
+
+	if error := parseFlags(flags, arguments, "id", "username"); error != nil {
+
+		return error
+	}
+
+	manager, error := admin.ownerManager()
+
+	if error != nil {
+
+		return error
+	}
+
+	password, error := bufio.NewReader(admin.stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+
+	if password == "" {
+
+		return fmt.Errorf("a password is required on stdin")
+	}
+
+	if error := manager.SaveOwner(&server.Owner{Id: *id, Name: *name}, *username, password); error != nil {
+
+		return error
+	}
+
+	fmt.Fprintf(admin.stdout, "saved owner %s\n", *id)
+	return nil
+}
+
+func deleteOwner(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	id := flags.String("id", "", "the owner id")
+
+	if error := parseFlags(flags, arguments, "id"); error != nil {
+
+		return error
+	}
+
+	manager, error := admin.ownerManager()
+
+	if error != nil {
+
+		return error
+	}
+
+	if error := manager.DeleteOwner(*id); error != nil {
+
+		return error
+	}
+
+	fmt.Fprintf(admin.stdout, "deleted owner %s\n", *id)
+	return nil
+}
+
+func addScope(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	var implies stringsFlag
+	name := flags.String("name", "", "the scope name, segments like repo:* make it a pattern scope")
+	id := flags.String("id", "", "the scope id, the name when left out")
+	description := flags.String("description", "", "the description shown on consent screens")
+	sensitive := flags.Bool("sensitive", false, "marks the scope as sensitive on consent screens")
+	flags.Var(&implies, "implies", "a scope granted along with this one, can be repeated")
+
+	if error := parseFlags(flags, arguments, "name"); error != nil {
+
+		return error
+	}
+
+	manager, error := admin.scopeManager()
+
+	if error != nil {
+
+		return error
+	}
+
+	scope := &server.Scope{Id: *id, Name: *name, Description: *description, Sensitive: *sensitive, Implies: implies}
+
+	if scope.Id == "" {
+
+		scope.Id = scope.Name
+	}
+
+	if error := manager.SaveScope(scope); error != nil {
+
+		return error
+	}
+
+	fmt.Fprintf(admin.stdout, "saved scope %s\n", scope.Name)
+	return nil
+}
+
+func listScopes(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	if error := parseFlags(flags, arguments); error != nil {
+
+		return error
+	}
+
+	manager, error := admin.scopeManager()
+
+	if error != nil {
+
+		return error
+	}
+
+	scopes, error := manager.FindAllScopes()
+
+	if error != nil {
+
+		return error
+	}
+
+	writer := tabwriter.NewWriter(admin.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tID\tSENSITIVE\tIMPLIES\tDESCRIPTION")
+
+	for _, scope := range scopes {
+
+		fmt.Fprintf(writer, "%s\t%s\t%t\t%s\t%s\n", scope.Name, scope.Id, scope.Sensitive, orNone(scope.Implies), scope.Description)
+	}
+
+	return writer.Flush()
+}
+
+func deleteScope(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	name := flags.String("name", "", "the scope name")
+
+	if error := parseFlags(flags, arguments, "name"); error != nil {
+
+		return error
+	}
+
+	manager, error := admin.scopeManager()
+
+	if error != nil {
+
+		return error
+	}
+
+	if error := manager.DeleteScope(*name); error != nil {
+
+		return error
+	}
+
+	fmt.Fprintf(admin.stdout, "deleted scope %s\n", *name)
+	return nil
+}
+
+func listSessions(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	sessions, error := findSessions(admin, flags, arguments, false)
+
+	if error != nil {
+
+		return error
+	}
+
+	writer := tabwriter.NewWriter(admin.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tCLIENT\tOWNER\tSCOPES\tCREATED")
+
+	for _, session := range sessions {
+
+		fmt.Fprintf(
+			writer,
+			"%s\t%s\t%s\t%s\t%s\n",
+			session.Id,
+			clientId(session),
+			ownerId(session),
+			strings.Join(scopeNames(session), " "),
+			session.CreatedAt.Format(time.RFC3339),
+		)
+	}
+
+	return writer.Flush()
+}
+
+// revokeSessions goes through the server so revocation metrics and listeners
+// see the sessions the same way they see revocations from the endpoint.
+func revokeSessions(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	sessions, error := findSessions(admin, flags, arguments, true)
+
+	if error != nil {
+
+		return error
+	}
+
+	oauthServer := newBuilder().BuildWithStorages(admin.file, admin.storages)
+
+	for _, session := range sessions {
+
+		oauthServer.RevokeSession(session)
+	}
+
+	fmt.Fprintf(admin.stdout, "revoked %d sessions\n", len(sessions))
+	return nil
+}
+
+func findSessions(admin *admin, flags *flag.FlagSet, arguments []string, byId bool) ([]*server.Session, error) {
+
+	id := ""
+
+	if byId {
+
+		flags.StringVar(&id, "id", "", "the session id")
+	}
+
+	ownerId := flags.String("owner", "", "the owner id")
+	clientId := flags.String("client", "", "the client id")
+
+	if error := parseFlags(flags, arguments); error != nil {
+
+		return nil, error
+	}
+
+	query, error := admin.sessionQuery()
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	switch {
+	case id != "":
+		session, error := query.FindSessionById(id)
+
+		if session == nil {
+
+			return nil, error
+		}
+
+		return []*server.Session{session}, nil
+	case *ownerId != "":
+		return query.FindSessionsByOwnerId(*ownerId)
+	case *clientId != "":
+		return query.FindSessionsByClientId(*clientId)
+	default:
+		return nil, fmt.Errorf("one of %s is required", strings.Join(sessionFlagNames(byId), ", "))
+	}
+}
+
+func sessionFlagNames(byId bool) []string {
+
+	if byId {
+
+		return []string{"-id", "-owner", "-client"}
+	}
+
+	return []string{"-owner", "-client"}
+}
+
+// testTokenGrant hands the server a session that was put together on the
+// command line so it goes through the same scope checks, lifetime policy and
+// listeners as sessions issued by the token endpoint.
+type testTokenGrant struct {
+	server.BaseGrant
+	session *server.Session
+}
+
+func (grant *testTokenGrant) GenerateSession(oauthSessionRequest server.OauthSessionRequest, oauthServer server.Server) (*server.Session, error) {
+
+	return grant.session, nil
+}
+
+func (grant *testTokenGrant) Name() string {
+
+	return "admin_test_token"
+}
+
+// savedSessionStorage lets the command wait for the session the server saves
+// in the background before it exits.
+type savedSessionStorage struct {
+	server.SessionStorage
+	saved sync.WaitGroup
+}
+
+func (storage *savedSessionStorage) SaveSession(session *server.Session) {
+
+	defer storage.saved.Done()
+	storage.SessionStorage.SaveSession(session)
+}
+
+func issueToken(admin *admin, flags *flag.FlagSet, arguments []string) error {
+
+	var scopes stringsFlag
+	clientId := flags.String("client", "", "the client the token is issued to")
+	username := flags.String("username", "", "the owner the token is issued for, the client itself when left out")
+	flags.Var(&scopes, "scope", "a scope to grant, can be repeated")
+
+	if error := parseFlags(flags, arguments, "client"); error != nil {
+
+		return error
+	}
+
+	client, error := admin.storages.Client.FindClientById(*clientId)
+
+	if client == nil {
+
+		return error
+	}
+
+	session := server.NewSession()
+	session.Client = client
+	session.Owner = server.NewOwnerFromClient(client)
+
+	if *username != "" {
+
+		if session.Owner, error = admin.storages.Owner.FindOwnerByUsername(*username); session.Owner == nil {
+
+			return error
+		}
+	}
+
+	sessionStorage := &savedSessionStorage{SessionStorage: admin.storages.Session}
+	storages := *admin.storages
+	storages.Session = sessionStorage
+	oauthServer := newBuilder().BuildWithStorages(admin.file, &storages)
+	grant := &testTokenGrant{session: session}
+	oauthServer.AddGrant(grant)
+	request := server.NewBasicOauthSessionRequest(grant.Name()).AddAll("scopes", scopes)
+	sessionStorage.saved.Add(1)
+	session, oauthError := oauthServer.GrantOauthSession(request)
+
+	if oauthError != nil {
+
+		sessionStorage.saved.Done()
+		return oauthError
+	}
+
+	sessionStorage.saved.Wait()
+	response := map[string]interface{}{
+		"session_id":   session.Id,
+		"access_token": session.AccessToken.Token,
+		"token_type":   "Bearer",
+		"scope":        strings.Join(scopeNames(session), " "),
+	}
+
+	if !session.AccessToken.ExpiresAt.IsZero() {
+
+		response["expires_at"] = session.AccessToken.ExpiresAt.Format(time.RFC3339)
+	}
+
+	encoder := json.NewEncoder(admin.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(response)
+}
+
+func generateSecret() (string, error) {
+
+	secret := make([]byte, 32)
+
+	if _, error := rand.Read(secret); error != nil {
+
+		return "", error
+	}
+
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// validateGrants refuses the client_credentials grant for public clients,
+// they have no secret to use it with.
+func validateGrants(client *server.Client) error {
+
+	if client.IsPublic() && len(client.AllowedGrants) > 0 && client.AllowsGrant("client_credentials") {
+
+		return fmt.Errorf("public clients can not use the client_credentials grant")
+	}
+
+	return nil
+}
+
+func validateResources(resources []string) error {
+
+	for _, resource := range resources {
+
+		if error := server.ValidateResource(resource); error != nil {
+
+			return error
+		}
+	}
+
+	return nil
+}
+
+func orAll(values []string) string {
+
+	if len(values) == 0 {
+
+		return "all"
+	}
+
+	return strings.Join(values, " ")
+}
+
+func orNone(values []string) string {
+
+	if len(values) == 0 {
+
+		return "-"
+	}
+
+	return strings.Join(values, " ")
+}
+
+func clientId(session *server.Session) string {
+
+	if session.Client == nil {
+
+		return ""
+	}
+
+	return session.Client.Id
+}
+
+func ownerId(session *server.Session) string {
+
+	if session.Owner == nil {
+
+		return ""
+	}
+
+	return session.Owner.Id
+}
+
+func scopeNames(session *server.Session) []string {
+
+	names := make([]string, 0, len(session.Scopes))
+
+	for name := range session.Scopes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
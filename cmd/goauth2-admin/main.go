@@ -0,0 +1,199 @@
+// Command goauth2-admin manages the clients, owners, scopes and sessions in
+// the storage configured for goauth2-server.
+//
+//	goauth2-admin -config goauth2.yaml client create -id app -name "My app"
+//	goauth2-admin -config goauth2.yaml session revoke -owner 42
+package main
+
+import (
+	"flag"
+	"fmt"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/yjv/goauth2-server/config"
+	"github.com/yjv/goauth2-server/server"
+	"github.com/yjv/goauth2-server/storage/mongo"
+	"github.com/yjv/goauth2-server/storage/sql"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// admin is what every command works with.
+type admin struct {
+	file     *config.File
+	storages *config.Storages
+	stdin    io.Reader
+	stdout   io.Writer
+}
+
+type command struct {
+	usage string
+	run   func(admin *admin, flags *flag.FlagSet, arguments []string) error
+}
+
+var commands = map[string]*command{
+	"client create":        {"-id ID [-name NAME] [-public] [-redirect-uri URI]... [-grant GRANT]... [-scope SCOPE]... [-resource URI]...", createClient},
+	"client list":          {"", listClients},
+	"client update":        {"-id ID [-name NAME] [-redirect-uri URI]... [-grant GRANT]... [-scope SCOPE]... [-resource URI]...", updateClient},
+	"client delete":        {"-id ID", deleteClient},
+	"client rotate-secret": {"-id ID", rotateClientSecret},
+	"owner add":            {"-id ID -username USERNAME [-name NAME], the password is read from stdin", addOwner},
+	"owner delete":         {"-id ID", deleteOwner},
+	"scope add":            {"-name NAME [-id ID] [-description TEXT] [-sensitive] [-implies SCOPE]...", addScope},
+	"scope list":           {"", listScopes},
+	"scope delete":         {"-name NAME", deleteScope},
+	"session list":         {"-owner ID | -client ID", listSessions},
+	"session revoke":       {"-id ID | -owner ID | -client ID", revokeSessions},
+	"token issue":          {"-client ID [-username USERNAME] [-scope SCOPE]...", issueToken},
+}
+
+func main() {
+
+	configPath := flag.String("config", "goauth2.yaml", "the json, yaml or toml config file")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+
+		usage()
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0) + " " + flag.Arg(1)
+	command, ok := commands[name]
+
+	if !ok {
+
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	if error := run(*configPath, name, command, flag.Args()[2:]); error != nil {
+
+		fmt.Fprintln(os.Stderr, error)
+		os.Exit(1)
+	}
+}
+
+func run(configPath string, name string, command *command, arguments []string) error {
+
+	file, error := config.Load(configPath)
+
+	if error != nil {
+
+		return error
+	}
+
+	storages, error := newBuilder().OpenStorages(file)
+
+	if error != nil {
+
+		return error
+	}
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	return command.run(&admin{file, storages, os.Stdin, os.Stdout}, flags, arguments)
+}
+
+func usage() {
+
+	names := make([]string, 0, len(commands))
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: goauth2-admin [-config FILE] COMMAND [FLAGS]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+
+	for _, name := range names {
+
+		fmt.Fprintln(os.Stderr, strings.TrimRight("  "+name+" "+commands[name].usage, " "))
+	}
+}
+
+// newBuilder registers the same storage backends as goauth2-server.
+func newBuilder() *config.Builder {
+
+	return config.NewBuilder().
+		RegisterStorage("sql", sql.StorageFactory).
+		RegisterStorage("mongo", mongo.StorageFactory)
+}
+
+func (admin *admin) clientManager() (server.ClientManager, error) {
+
+	if manager, ok := admin.storages.Client.(server.ClientManager); ok {
+
+		return manager, nil
+	}
+
+	return nil, fmt.Errorf("the %s storage backend can not manage clients", admin.file.Storage.Backend)
+}
+
+func (admin *admin) ownerManager() (server.OwnerManager, error) {
+
+	if manager, ok := admin.storages.Owner.(server.OwnerManager); ok {
+
+		return manager, nil
+	}
+
+	return nil, fmt.Errorf("the %s storage backend can not manage owners", admin.file.Storage.Backend)
+}
+
+func (admin *admin) scopeManager() (server.ScopeManager, error) {
+
+	if manager, ok := admin.storages.Scope.(server.ScopeManager); ok {
+
+		return manager, nil
+	}
+
+	return nil, fmt.Errorf("the %s storage backend can not manage scopes", admin.file.Storage.Backend)
+}
+
+func (admin *admin) sessionQuery() (server.SessionQuery, error) {
+
+	if query, ok := admin.storages.Session.(server.SessionQuery); ok {
+
+		return query, nil
+	}
+
+	return nil, fmt.Errorf("the %s storage backend can not query sessions", admin.file.Storage.Backend)
+}
+
+// stringsFlag collects a flag that can be given more than once.
+type stringsFlag []string
+
+func (values *stringsFlag) String() string {
+
+	return strings.Join(*values, ",")
+}
+
+func (values *stringsFlag) Set(value string) error {
+
+	*values = append(*values, value)
+	return nil
+}
+
+// parseFlags parses the arguments and checks the flags named in required are
+// not empty.
+func parseFlags(flags *flag.FlagSet, arguments []string, required ...string) error {
+
+	if error := flags.Parse(arguments); error != nil {
+
+		return error
+	}
+
+	for _, name := range required {
+
+		if flags.Lookup(name).Value.String() == "" {
+
+			return fmt.Errorf("-%s is required", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"github.com/yjv/goauth2-server/config"
+	goauth2http "github.com/yjv/goauth2-server/http"
+	"github.com/yjv/goauth2-server/jwt"
+	"github.com/yjv/goauth2-server/metrics/prometheus"
+	"github.com/yjv/goauth2-server/server"
+	"net/http"
+	"strings"
+)
+
+// newHandler mounts every endpoint that has a path in the config. The
+// metadata documents and registration need absolute urls so they are only
+// served when an issuer is configured.
+func newHandler(
+	file *config.File,
+	oauthServer *server.DefaultServer,
+	storages *config.Storages,
+	signers []jwt.Signer,
+	metrics *prometheus.Metrics,
+	probes *probes,
+) (http.Handler, error) {
+
+	mux := http.NewServeMux()
+	endpoints := file.Endpoints
+	handle := func(path string, handler http.Handler) {
+
+		if path != "" {
+
+			mux.Handle(path, handler)
+		}
+	}
+
+	tokenHandler := goauth2http.NewTokenHandler(oauthServer)
+	authenticator := goauth2http.NewBearerAuthenticator(oauthServer)
+	dpopValidator := config.BuildDPoPValidator(file)
+
+	//validation makes sure there is an issuer to build the urls proofs are made for
+	if dpopValidator != nil {
+
+		issuer := strings.TrimSuffix(file.Issuer, "/")
+		tokenHandler.SetDPoPValidator(dpopValidator, issuer+endpoints.Token)
+		authenticator.SetDPoPValidator(dpopValidator, issuer)
+	}
+
+	handle(endpoints.Token, tokenHandler)
+	handle(endpoints.Introspection, goauth2http.NewIntrospectionHandler(oauthServer))
+	handle(endpoints.Revocation, goauth2http.NewRevocationHandler(oauthServer))
+	handle(endpoints.UserInfo, goauth2http.NewUserInfoHandlerWithAuthenticator(authenticator, server.NewDefaultClaimsProvider()))
+	handle(endpoints.Health, http.HandlerFunc(probes.ServeHealth))
+	handle(endpoints.Ready, http.HandlerFunc(probes.ServeReady))
+
+	if endpoints.Admin != "" {
+
+		adminHandler := goauth2http.NewAdminHandler(oauthServer, endpoints.Admin, adminAuthorizer(authenticator, endpoints))
+
+		//the server storages may be wrapped for metrics, the admin api needs the backend itself
+		if clients, ok := storages.Client.(server.ClientManager); ok {
+
+			adminHandler.SetClientManager(clients)
+		}
+
+		if scopes, ok := storages.Scope.(server.ScopeManager); ok {
+
+			adminHandler.SetScopeManager(scopes)
+		}
+
+		if sessionQuery, ok := storages.Session.(server.SessionQuery); ok {
+
+			adminHandler.SetSessionQuery(sessionQuery)
+		}
+
+		mux.Handle(strings.TrimSuffix(endpoints.Admin, "/")+"/", adminHandler)
+	}
+
+	sessionQuery, canQuerySessions := storages.Session.(server.SessionQuery)
+
+	if endpoints.Consents != "" && storages.Consent != nil && canQuerySessions {
+
+		consentHandler := goauth2http.NewConsentHandlerWithAuthenticator(
+			authenticator,
+			server.NewConsentManager(oauthServer, storages.Consent, sessionQuery),
+			endpoints.Consents,
+			endpoints.ConsentsScope,
+		)
+		mux.Handle(endpoints.Consents, consentHandler)
+		mux.Handle(strings.TrimSuffix(endpoints.Consents, "/")+"/", consentHandler)
+	}
+
+	if len(signers) > 0 {
+
+		handle(endpoints.Jwks, goauth2http.NewJwksHandler(signers))
+	}
+
+	if metrics != nil {
+
+		if counter, ok := storages.Session.(server.ActiveSessionCounter); ok {
+
+			metrics.SetActiveSessionCounter(counter)
+		}
+
+		handle(endpoints.Metrics, metrics)
+	}
+
+	if file.Issuer == "" {
+
+		return mux, nil
+	}
+
+	issuer := strings.TrimSuffix(file.Issuer, "/")
+	endpointUrl := func(path string) string {
+
+		if path == "" {
+
+			return ""
+		}
+
+		return issuer + path
+	}
+
+	metadataConfig := goauth2http.NewMetadataConfig(file.Issuer)
+	metadataConfig.TokenEndpoint = endpointUrl(endpoints.Token)
+	metadataConfig.UserInfoEndpoint = endpointUrl(endpoints.UserInfo)
+	metadataConfig.RevocationEndpoint = endpointUrl(endpoints.Revocation)
+	metadataConfig.IntrospectionEndpoint = endpointUrl(endpoints.Introspection)
+	metadataConfig.TokenEndpointAuthMethods = []string{"client_secret_basic", "client_secret_post"}
+
+	if len(jwt.NewJWKSet(signers).Keys) > 0 {
+
+		metadataConfig.JwksUri = endpointUrl(endpoints.Jwks)
+	}
+
+	for _, signer := range signers {
+
+		metadataConfig.SigningAlgorithms = append(metadataConfig.SigningAlgorithms, signer.Algorithm())
+	}
+
+	if dpopValidator != nil {
+
+		metadataConfig.DPoPSigningAlgorithms = jwt.DPoPAlgorithms
+	}
+
+	//only backends that can save clients support dynamic registration, and only with an initial access token
+	if registry, ok := storages.Client.(server.ClientRegistry); ok && endpoints.Registration != "" && len(endpoints.RegistrationTokens) > 0 {
+
+		metadataConfig.RegistrationEndpoint = endpointUrl(endpoints.Registration)
+		registrar := server.NewClientRegistrar(oauthServer, registry).SetScopes(endpoints.RegistrationScopes)
+		registrar.SetDefaultScope(endpoints.RegistrationDefaultScope)
+		registrationHandler := goauth2http.NewRegistrationHandler(registrar, metadataConfig.RegistrationEndpoint)
+		registrationHandler.SetInitialAccessTokenValidator(goauth2http.InitialAccessTokens(endpoints.RegistrationTokens...))
+		mux.Handle(endpoints.Registration, registrationHandler)
+		mux.Handle(strings.TrimSuffix(endpoints.Registration, "/")+"/", registrationHandler)
+	}
+
+	if _, error := goauth2http.BuildMetadata(oauthServer, metadataConfig, false); error != nil {
+
+		return nil, fmt.Errorf("metadata: %s", error)
+	}
+
+	goauth2http.RegisterMetadataHandlers(mux, oauthServer, metadataConfig)
+	return mux, nil
+}
+
+// adminAuthorizer accepts the configured admin tokens and, when an admin
+// scope is configured, access tokens granted it to the admin clients or owners.
+func adminAuthorizer(authenticator *goauth2http.BearerAuthenticator, endpoints config.EndpointsConfig) goauth2http.AdminAuthorizerFunc {
+
+	tokenAuthorizer := goauth2http.AdminTokenAuthorizer(endpoints.AdminTokens...)
+
+	if endpoints.AdminScope == "" {
+
+		return tokenAuthorizer
+	}
+
+	scopeAuthorizer := goauth2http.AdminScopeAuthorizer(authenticator, endpoints.AdminScope, endpoints.AdminClients, endpoints.AdminOwners)
+	return func(request *http.Request) *goauth2http.BearerError {
+
+		if len(endpoints.AdminTokens) > 0 && tokenAuthorizer(request) == nil {
+
+			return nil
+		}
+
+		return scopeAuthorizer(request)
+	}
+}
@@ -0,0 +1,143 @@
+// Command goauth2-server runs an oauth2 server configured from a json, yaml
+// or toml file, see the config package for the format and the environment
+// overrides.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/yjv/goauth2-server/config"
+	"github.com/yjv/goauth2-server/jwt"
+	"github.com/yjv/goauth2-server/metrics/prometheus"
+	"github.com/yjv/goauth2-server/storage/mongo"
+	"github.com/yjv/goauth2-server/storage/sql"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+
+	configPath := flag.String("config", "goauth2.yaml", "the json, yaml or toml config file")
+	flag.Parse()
+
+	if error := run(*configPath); error != nil {
+
+		fmt.Fprintln(os.Stderr, error)
+		os.Exit(1)
+	}
+}
+
+func run(configPath string) error {
+
+	served := make(chan error, 1)
+	file, error := config.Load(configPath)
+
+	if error != nil {
+
+		return error
+	}
+
+	builder := newBuilder()
+	var metrics *prometheus.Metrics
+
+	if file.Endpoints.Metrics != "" {
+
+		metrics = prometheus.New()
+		builder.SetMetrics(metrics)
+	}
+
+	storages, error := builder.OpenStorages(file)
+
+	if error != nil {
+
+		return error
+	}
+
+	defer closeStorages(storages)
+	oauthServer := builder.BuildWithStorages(file, storages)
+	signers, error := config.BuildSigners(file)
+
+	if error != nil {
+
+		return error
+	}
+
+	//the first key signs new tokens, see BuildSigners
+	if file.Tokens.AccessTokenFormat == "jwt" {
+
+		oauthServer.SetTokenGenerator(jwt.NewAccessTokenGenerator(oauthServer.TokenGenerator(), signers[0]))
+	}
+
+	probes := newProbes(storages)
+	handler, error := newHandler(file, oauthServer, storages, signers, metrics, probes)
+
+	if error != nil {
+
+		return error
+	}
+
+	httpServer := &http.Server{
+		Addr:              file.Endpoints.Listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+
+		served <- serve(httpServer, file)
+	}()
+
+	slog.Info("goauth2-server listening", "address", file.Endpoints.Listen, "tls", file.Endpoints.TLSCertFile != "")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case error := <-served:
+		return error
+	case <-ctx.Done():
+	}
+
+	//fail readiness first so load balancers stop sending new requests
+	probes.SetShuttingDown()
+	slog.Info("goauth2-server shutting down", "timeout", time.Duration(file.Endpoints.ShutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(file.Endpoints.ShutdownTimeout))
+	defer cancel()
+	return httpServer.Shutdown(shutdownCtx)
+}
+
+func serve(httpServer *http.Server, file *config.File) error {
+
+	var error error
+
+	if file.Endpoints.TLSCertFile != "" {
+
+		error = httpServer.ListenAndServeTLS(file.Endpoints.TLSCertFile, file.Endpoints.TLSKeyFile)
+	} else {
+
+		error = httpServer.ListenAndServe()
+	}
+
+	if errors.Is(error, http.ErrServerClosed) {
+
+		return nil
+	}
+
+	return error
+}
+
+// newBuilder registers the storage backends the binary ships with. The sql
+// backend can use the postgres and sqlite3 drivers.
+func newBuilder() *config.Builder {
+
+	return config.NewBuilder().
+		RegisterStorage("sql", sql.StorageFactory).
+		RegisterStorage("mongo", mongo.StorageFactory)
+}
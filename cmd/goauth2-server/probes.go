@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/yjv/goauth2-server/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+// pinger is implemented by storages that can check their connection.
+type pinger interface {
+	Ping() error
+}
+
+// probes serves the liveness and readiness checks. The server is ready while
+// every storage that can be pinged answers and it is not shutting down.
+type probes struct {
+	pingers      []pinger
+	shuttingDown atomic.Bool
+}
+
+func (probes *probes) ServeHealth(writer http.ResponseWriter, request *http.Request) {
+
+	writer.Header().Set("Content-Type", "text/plain")
+	io.WriteString(writer, "ok\n")
+}
+
+func (probes *probes) ServeReady(writer http.ResponseWriter, request *http.Request) {
+
+	writer.Header().Set("Content-Type", "text/plain")
+
+	if probes.shuttingDown.Load() {
+
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(writer, "shutting down\n")
+		return
+	}
+
+	for _, pinger := range probes.pingers {
+
+		if error := pinger.Ping(); error != nil {
+
+			slog.Warn("storage is not ready", "error", error)
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(writer, "storage unavailable\n")
+			return
+		}
+	}
+
+	io.WriteString(writer, "ok\n")
+}
+
+func (probes *probes) SetShuttingDown() {
+
+	probes.shuttingDown.Store(true)
+}
+
+func newProbes(storages *config.Storages) *probes {
+
+	probes := &probes{}
+	seen := make(map[interface{}]bool)
+
+	for _, storage := range []interface{}{storages.Client, storages.Owner, storages.Session, storages.Scope, storages.Limit, storages.Consent} {
+
+		if pinger, ok := storage.(pinger); ok && !seen[storage] {
+
+			probes.pingers = append(probes.pingers, pinger)
+			seen[storage] = true
+		}
+	}
+
+	return probes
+}
+
+// closeStorages closes every distinct storage that can be closed.
+func closeStorages(storages *config.Storages) {
+
+	seen := make(map[interface{}]bool)
+
+	for _, storage := range []interface{}{storages.Client, storages.Owner, storages.Session, storages.Scope, storages.Limit, storages.Consent} {
+
+		if seen[storage] || storage == nil {
+
+			continue
+		}
+
+		seen[storage] = true
+
+		switch closer := storage.(type) {
+		case io.Closer:
+			closer.Close()
+		case interface{ Close() }:
+			closer.Close()
+		}
+	}
+}
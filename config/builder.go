@@ -0,0 +1,373 @@
+package config
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/yjv/goauth2-server/jwt"
+	"github.com/yjv/goauth2-server/server"
+	"github.com/yjv/goauth2-server/storage/memory"
+	"os"
+	"regexp"
+	"time"
+)
+
+// Storages are the storages a backend provides. Limit may be nil when the
+// backend can not keep rate limit state, rate limiting is then left off.
+type Storages struct {
+	Client  server.ClientStorage
+	Owner   server.OwnerStorage
+	Session server.SessionStorage
+	Scope   server.ScopeStorage
+	Limit   server.LimitStorage
+	Consent server.ConsentStorage
+}
+
+// StorageFactoryFunc opens the storages of a backend from the storage section
+// of a config file.
+type StorageFactoryFunc func(file *File) (*Storages, error)
+
+// Builder turns a config file into a wired server. Storage backends other
+// than memory are registered by the packages that provide them so this
+// package does not depend on their drivers.
+type Builder struct {
+	storageFactories map[string]StorageFactoryFunc
+	metrics          server.Metrics
+}
+
+func (builder *Builder) RegisterStorage(backend string, factory StorageFactoryFunc) *Builder {
+
+	builder.storageFactories[backend] = factory
+	return builder
+}
+
+// SetMetrics makes built servers report to metrics and wraps their storages
+// so storage calls are measured too.
+func (builder *Builder) SetMetrics(metrics server.Metrics) *Builder {
+
+	builder.metrics = metrics
+	return builder
+}
+
+func (builder *Builder) Build(file *File) (*server.DefaultServer, error) {
+
+	storages, error := builder.OpenStorages(file)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	return builder.BuildWithStorages(file, storages), nil
+}
+
+// OpenStorages opens the storages of the configured backend.
+func (builder *Builder) OpenStorages(file *File) (*Storages, error) {
+
+	factory, ok := builder.storageFactories[file.Storage.Backend]
+
+	if !ok {
+
+		return nil, fmt.Errorf("storage backend %q is not registered", file.Storage.Backend)
+	}
+
+	storages, error := factory(file)
+
+	if error != nil {
+
+		return nil, fmt.Errorf("storage backend %q: %s", file.Storage.Backend, error)
+	}
+
+	return storages, nil
+}
+
+// BuildWithStorages wires a server around storages that are already open, for
+// programs that also need the storages themselves.
+func (builder *Builder) BuildWithStorages(file *File, storages *Storages) *server.DefaultServer {
+
+	clientStorage, ownerStorage := storages.Client, storages.Owner
+	sessionStorage, scopeStorage := storages.Session, storages.Scope
+
+	if builder.metrics != nil {
+
+		clientStorage = server.NewInstrumentedClientStorage(clientStorage, builder.metrics)
+		ownerStorage = server.NewInstrumentedOwnerStorage(ownerStorage, builder.metrics)
+		sessionStorage = server.NewInstrumentedSessionStorage(sessionStorage, builder.metrics)
+		scopeStorage = server.NewInstrumentedScopeStorage(scopeStorage, builder.metrics)
+	}
+
+	policy := server.NewDefaultLifetimePolicy()
+	policy.MaxSessionLifetime = time.Duration(file.Tokens.MaxSessionLifetime)
+	policy.RefreshTokenIdleTimeout = time.Duration(file.Tokens.RefreshTokenIdleTimeout)
+
+	for scope, lifetime := range file.Tokens.ScopeAccessTokenLifetimes {
+
+		policy.ScopeAccessTokenExpires[scope] = time.Duration(lifetime)
+	}
+
+	config := server.NewConfig()
+	config.DefaultAccessTokenExpires = time.Duration(file.Tokens.AccessTokenLifetime)
+	config.DefaultRefreshTokenExpires = time.Duration(file.Tokens.RefreshTokenLifetime)
+	config.AllowRefresh = file.Tokens.AllowRefresh
+	config.LifetimePolicy = policy
+	config.Issuer = file.Issuer
+
+	if len(file.ScopeParameters) > 0 {
+
+		patterns := make(map[string]map[string]*regexp.Regexp, len(file.ScopeParameters))
+
+		for template, parameters := range file.ScopeParameters {
+
+			patterns[template] = make(map[string]*regexp.Regexp, len(parameters))
+
+			for name, pattern := range parameters {
+
+				patterns[template][name], _ = compileScopeParameterPattern(pattern)
+			}
+		}
+
+		config.ScopeParameterValidator = server.ScopeParameterPatterns(patterns)
+	}
+
+	oauthServer := server.NewWithConfigAndTokenGenerator(
+		config,
+		server.NewDefaultTokenGenerator(),
+		clientStorage,
+		ownerStorage,
+		sessionStorage,
+		scopeStorage,
+	)
+
+	if builder.metrics != nil {
+
+		oauthServer.SetMetrics(builder.metrics)
+	}
+
+	if grantConfig := file.Grants.ClientCredentials; grantConfig != nil {
+
+		grant := &server.ClientCredentialsGrant{}
+		grant.SetAccessTokenExpiration(time.Duration(grantConfig.AccessTokenLifetime))
+		oauthServer.AddGrant(grant)
+		setGrantRefreshTokenLifetime(policy, grant, grantConfig)
+	}
+
+	if grantConfig := file.Grants.Password; grantConfig != nil {
+
+		grant := &server.PasswordGrant{}
+		grant.SetAccessTokenExpiration(time.Duration(grantConfig.AccessTokenLifetime))
+		oauthServer.AddGrant(grant)
+		setGrantRefreshTokenLifetime(policy, grant, grantConfig)
+	}
+
+	if grantConfig := file.Grants.RefreshToken; grantConfig != nil {
+
+		grant := &server.RefreshTokenGrant{
+			RotateRefreshTokens: grantConfig.RotateRefreshTokens,
+			RefreshOwner:        grantConfig.RefreshOwner,
+		}
+		grant.SetAccessTokenExpiration(time.Duration(grantConfig.AccessTokenLifetime))
+		oauthServer.AddGrant(grant)
+		setGrantRefreshTokenLifetime(policy, grant, grantConfig)
+	}
+
+	if file.RateLimit != nil && storages.Limit != nil {
+
+		limiter := server.NewLimiter(storages.Limit)
+		limiter.Rate = file.RateLimit.Rate
+		limiter.Burst = file.RateLimit.Burst
+		limiter.LockoutThreshold = file.RateLimit.LockoutThreshold
+		limiter.LockoutDuration = time.Duration(file.RateLimit.LockoutDuration)
+		limiter.MaxLockout = time.Duration(file.RateLimit.MaxLockout)
+		oauthServer.SetLimiter(limiter)
+	}
+
+	//the limiter queries the backend itself, the instrumented storage can not be queried
+	if sessionQuery, ok := storages.Session.(server.SessionQuery); ok && file.SessionLimit != nil {
+
+		sessionLimiter := server.NewSessionLimiter(sessionQuery, file.SessionLimit.MaxSessions)
+		sessionLimiter.PerClient = file.SessionLimit.PerClient
+		sessionLimiter.CheckedGrants = file.SessionLimit.CheckedGrants
+
+		if file.SessionLimit.Action == "evict_oldest" {
+
+			sessionLimiter.Action = server.EvictOldestSession
+		}
+
+		oauthServer.SetSessionLimiter(sessionLimiter)
+	}
+
+	return oauthServer
+}
+
+func setGrantRefreshTokenLifetime(policy *server.DefaultLifetimePolicy, grant server.Grant, grantConfig *GrantConfig) {
+
+	if grantConfig.RefreshTokenLifetime > 0 {
+
+		policy.GrantRefreshTokenExpires[grant.Name()] = time.Duration(grantConfig.RefreshTokenLifetime)
+	}
+}
+
+// BuildSigners loads the configured keys in order, the first one is meant to
+// sign new tokens and the rest stay published while tokens signed with them
+// are still around.
+func BuildSigners(file *File) ([]jwt.Signer, error) {
+
+	signers := make([]jwt.Signer, 0, len(file.Keys))
+
+	for _, key := range file.Keys {
+
+		switch key.Algorithm {
+		case "HS256":
+			signers = append(signers, jwt.NewHMACSigner(key.Id, []byte(key.Secret)))
+		case "RS256":
+			privateKey, error := readRSAPrivateKey(key.PrivateKeyFile)
+
+			if error != nil {
+
+				return nil, fmt.Errorf("key %s: %s", key.Id, error)
+			}
+
+			signers = append(signers, jwt.NewRSASigner(key.Id, privateKey))
+		default:
+			return nil, fmt.Errorf("key %s: unsupported algorithm %q", key.Id, key.Algorithm)
+		}
+	}
+
+	return signers, nil
+}
+
+// BuildDPoPValidator returns nil when dpop is not configured. Used proofs are
+// remembered in memory, so with several instances a proof could be replayed
+// once against each of them until it expires.
+func BuildDPoPValidator(file *File) *jwt.DPoPValidator {
+
+	if file.DPoP == nil {
+
+		return nil
+	}
+
+	validator := jwt.NewDPoPValidator(memory.NewReplayCache())
+
+	if file.DPoP.ProofLifetime > 0 {
+
+		validator.ProofLifetime = time.Duration(file.DPoP.ProofLifetime)
+	}
+
+	if file.DPoP.NonceSecret != "" {
+
+		lifetime := 5 * time.Minute
+
+		if file.DPoP.NonceLifetime > 0 {
+
+			lifetime = time.Duration(file.DPoP.NonceLifetime)
+		}
+
+		validator.SetNonces(jwt.NewDPoPNonces([]byte(file.DPoP.NonceSecret), lifetime))
+	}
+
+	return validator
+}
+
+func readRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+
+	data, error := os.ReadFile(path)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+
+		return nil, fmt.Errorf("%s does not contain a PEM block", path)
+	}
+
+	if privateKey, error := x509.ParsePKCS1PrivateKey(block.Bytes); error == nil {
+
+		return privateKey, nil
+	}
+
+	parsed, error := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if error != nil {
+
+		return nil, fmt.Errorf("%s does not contain a PKCS1 or PKCS8 private key", path)
+	}
+
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+
+	if !ok {
+
+		return nil, fmt.Errorf("%s does not contain an RSA private key", path)
+	}
+
+	return privateKey, nil
+}
+
+// newMemoryStorages seeds the configured scopes and clients, which is the
+// only way to get clients into the memory backend besides registration.
+func newMemoryStorages(file *File) (*Storages, error) {
+
+	ownerClientStorage := memory.NewOwnerClientStorage()
+	scopeStorage := memory.NewScopeStorage()
+
+	for _, name := range file.Scopes {
+
+		scope := &server.Scope{Id: name, Name: name}
+
+		if details := file.ScopeDetails[name]; details != nil {
+
+			scope.Description = details.Description
+			scope.Sensitive = details.Sensitive
+			scope.Implies = details.Implies
+		}
+
+		scopeStorage.Set(name, scope)
+	}
+
+	for _, clientConfig := range file.Clients {
+
+		client := &server.Client{
+			Id:               clientConfig.Id,
+			Name:             clientConfig.Name,
+			Type:             server.ConfidentialClient,
+			RedirectUris:     clientConfig.RedirectUris,
+			AllowedGrants:    clientConfig.AllowedGrants,
+			AllowedResources: clientConfig.AllowedResources,
+			AllowedScopes:    clientConfig.AllowedScopes,
+		}
+
+		if clientConfig.Public {
+
+			client.Type = server.PublicClient
+		}
+
+		ownerClientStorage.AddClient(clientConfig.Id, clientConfig.Secret, client)
+	}
+
+	return &Storages{
+		ownerClientStorage,
+		ownerClientStorage,
+		memory.NewSessionStorage(),
+		scopeStorage,
+		memory.NewLimitStorage(),
+		memory.NewConsentStorage(),
+	}, nil
+}
+
+// compileScopeParameterPattern anchors the pattern so it has to match the
+// whole parameter.
+func compileScopeParameterPattern(pattern string) (*regexp.Regexp, error) {
+
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// NewBuilder returns a builder with the memory backend registered.
+func NewBuilder() *Builder {
+
+	builder := &Builder{make(map[string]StorageFactoryFunc), nil}
+	return builder.RegisterStorage("memory", newMemoryStorages)
+}
@@ -0,0 +1,279 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Duration reads durations written the way time.ParseDuration expects them,
+// like "15m" or "720h".
+type Duration time.Duration
+
+func (duration *Duration) UnmarshalText(text []byte) error {
+
+	parsed, error := time.ParseDuration(string(text))
+
+	if error != nil {
+
+		return fmt.Errorf("%q is not a duration like 30s or 1h", string(text))
+	}
+
+	*duration = Duration(parsed)
+	return nil
+}
+
+func (duration Duration) MarshalText() ([]byte, error) {
+
+	return []byte(time.Duration(duration).String()), nil
+}
+
+type File struct {
+	Issuer          string                       `json:"issuer" yaml:"issuer" toml:"issuer"`
+	Tokens          TokensConfig                 `json:"tokens" yaml:"tokens" toml:"tokens"`
+	Grants          GrantsConfig                 `json:"grants" yaml:"grants" toml:"grants"`
+	Storage         StorageConfig                `json:"storage" yaml:"storage" toml:"storage"`
+	Keys            []*KeyConfig                 `json:"keys" yaml:"keys" toml:"keys"`
+	Endpoints       EndpointsConfig              `json:"endpoints" yaml:"endpoints" toml:"endpoints"`
+	RateLimit       *RateLimitConfig             `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
+	SessionLimit    *SessionLimitConfig          `json:"session_limit" yaml:"session_limit" toml:"session_limit"`
+	DPoP            *DPoPConfig                  `json:"dpop" yaml:"dpop" toml:"dpop"`
+	Scopes          []string                     `json:"scopes" yaml:"scopes" toml:"scopes"`
+	ScopeDetails    map[string]*ScopeConfig      `json:"scope_details" yaml:"scope_details" toml:"scope_details"`
+	ScopeParameters map[string]map[string]string `json:"scope_parameters" yaml:"scope_parameters" toml:"scope_parameters"`
+	Clients         []*ClientConfig              `json:"clients" yaml:"clients" toml:"clients"`
+}
+
+type TokensConfig struct {
+	AccessTokenLifetime       Duration            `json:"access_token_lifetime" yaml:"access_token_lifetime" toml:"access_token_lifetime"`
+	RefreshTokenLifetime      Duration            `json:"refresh_token_lifetime" yaml:"refresh_token_lifetime" toml:"refresh_token_lifetime"`
+	AllowRefresh              bool                `json:"allow_refresh" yaml:"allow_refresh" toml:"allow_refresh"`
+	MaxSessionLifetime        Duration            `json:"max_session_lifetime" yaml:"max_session_lifetime" toml:"max_session_lifetime"`
+	RefreshTokenIdleTimeout   Duration            `json:"refresh_token_idle_timeout" yaml:"refresh_token_idle_timeout" toml:"refresh_token_idle_timeout"`
+	ScopeAccessTokenLifetimes map[string]Duration `json:"scope_access_token_lifetimes" yaml:"scope_access_token_lifetimes" toml:"scope_access_token_lifetimes"`
+	AccessTokenFormat         string              `json:"access_token_format" yaml:"access_token_format" toml:"access_token_format"`
+}
+
+// GrantsConfig enables a grant by configuring it, grants left out are not
+// available.
+type GrantsConfig struct {
+	ClientCredentials *GrantConfig `json:"client_credentials" yaml:"client_credentials" toml:"client_credentials"`
+	Password          *GrantConfig `json:"password" yaml:"password" toml:"password"`
+	RefreshToken      *GrantConfig `json:"refresh_token" yaml:"refresh_token" toml:"refresh_token"`
+}
+
+type GrantConfig struct {
+	AccessTokenLifetime  Duration `json:"access_token_lifetime" yaml:"access_token_lifetime" toml:"access_token_lifetime"`
+	RefreshTokenLifetime Duration `json:"refresh_token_lifetime" yaml:"refresh_token_lifetime" toml:"refresh_token_lifetime"`
+	RotateRefreshTokens  bool     `json:"rotate_refresh_tokens" yaml:"rotate_refresh_tokens" toml:"rotate_refresh_tokens"`
+	RefreshOwner         bool     `json:"refresh_owner" yaml:"refresh_owner" toml:"refresh_owner"`
+}
+
+// StorageConfig picks the storage backend, Driver and Dsn are used by the sql
+// backend and Url and Database by the mongo backend.
+type StorageConfig struct {
+	Backend  string `json:"backend" yaml:"backend" toml:"backend"`
+	Driver   string `json:"driver" yaml:"driver" toml:"driver"`
+	Dsn      string `json:"dsn" yaml:"dsn" toml:"dsn"`
+	Url      string `json:"url" yaml:"url" toml:"url"`
+	Database string `json:"database" yaml:"database" toml:"database"`
+}
+
+// KeyConfig is a signing key, HS256 keys take their Secret and RS256 keys
+// read a PEM encoded private key from PrivateKeyFile.
+type KeyConfig struct {
+	Id             string `json:"id" yaml:"id" toml:"id"`
+	Algorithm      string `json:"algorithm" yaml:"algorithm" toml:"algorithm"`
+	Secret         string `json:"secret" yaml:"secret" toml:"secret"`
+	PrivateKeyFile string `json:"private_key_file" yaml:"private_key_file" toml:"private_key_file"`
+}
+
+// EndpointsConfig holds the paths the standalone server serves, an empty path
+// turns the endpoint off. TLS is used when both TLS files are set and
+// ShutdownTimeout is how long in flight requests get to finish on shutdown.
+// The admin api accepts the AdminTokens as bearer tokens and access tokens
+// granted the AdminScope, issued to one of the AdminClients or for one of the
+// AdminOwners by a client whose allowed scopes list the AdminScope. Consents
+// lets owners list and revoke what they approved using their own access
+// tokens granted the ConsentsScope.
+// Registration is only served with RegistrationTokens, the initial access
+// tokens clients have to register with. Clients can register with the
+// RegistrationScopes, which may be patterns, and get the
+// RegistrationDefaultScope when they leave out the scope.
+type EndpointsConfig struct {
+	Listen                   string   `json:"listen" yaml:"listen" toml:"listen"`
+	Token                    string   `json:"token" yaml:"token" toml:"token"`
+	UserInfo                 string   `json:"userinfo" yaml:"userinfo" toml:"userinfo"`
+	Registration             string   `json:"registration" yaml:"registration" toml:"registration"`
+	RegistrationTokens       []string `json:"registration_tokens" yaml:"registration_tokens" toml:"registration_tokens"`
+	RegistrationScopes       []string `json:"registration_scopes" yaml:"registration_scopes" toml:"registration_scopes"`
+	RegistrationDefaultScope string   `json:"registration_default_scope" yaml:"registration_default_scope" toml:"registration_default_scope"`
+	Introspection            string   `json:"introspection" yaml:"introspection" toml:"introspection"`
+	Revocation               string   `json:"revocation" yaml:"revocation" toml:"revocation"`
+	Jwks                     string   `json:"jwks" yaml:"jwks" toml:"jwks"`
+	Metrics                  string   `json:"metrics" yaml:"metrics" toml:"metrics"`
+	Health                   string   `json:"health" yaml:"health" toml:"health"`
+	Ready                    string   `json:"ready" yaml:"ready" toml:"ready"`
+	Admin                    string   `json:"admin" yaml:"admin" toml:"admin"`
+	AdminTokens              []string `json:"admin_tokens" yaml:"admin_tokens" toml:"admin_tokens"`
+	AdminScope               string   `json:"admin_scope" yaml:"admin_scope" toml:"admin_scope"`
+	AdminClients             []string `json:"admin_clients" yaml:"admin_clients" toml:"admin_clients"`
+	AdminOwners              []string `json:"admin_owners" yaml:"admin_owners" toml:"admin_owners"`
+	Consents                 string   `json:"consents" yaml:"consents" toml:"consents"`
+	ConsentsScope            string   `json:"consents_scope" yaml:"consents_scope" toml:"consents_scope"`
+	TLSCertFile              string   `json:"tls_cert_file" yaml:"tls_cert_file" toml:"tls_cert_file"`
+	TLSKeyFile               string   `json:"tls_key_file" yaml:"tls_key_file" toml:"tls_key_file"`
+	ShutdownTimeout          Duration `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+type RateLimitConfig struct {
+	Rate             float64  `json:"rate" yaml:"rate" toml:"rate"`
+	Burst            float64  `json:"burst" yaml:"burst" toml:"burst"`
+	LockoutThreshold int      `json:"lockout_threshold" yaml:"lockout_threshold" toml:"lockout_threshold"`
+	LockoutDuration  Duration `json:"lockout_duration" yaml:"lockout_duration" toml:"lockout_duration"`
+	MaxLockout       Duration `json:"max_lockout" yaml:"max_lockout" toml:"max_lockout"`
+}
+
+// SessionLimitConfig caps the active sessions per owner, or per owner and
+// client with per_client. Action is reject, the default, or evict_oldest.
+// CheckedGrants are the grants whose new sessions are checked against the
+// limit, all of the owner's sessions count towards it.
+type SessionLimitConfig struct {
+	MaxSessions   int      `json:"max_sessions" yaml:"max_sessions" toml:"max_sessions"`
+	PerClient     bool     `json:"per_client" yaml:"per_client" toml:"per_client"`
+	Action        string   `json:"action" yaml:"action" toml:"action"`
+	CheckedGrants []string `json:"checked_grants" yaml:"checked_grants" toml:"checked_grants"`
+}
+
+// DPoPConfig turns on DPoP bound tokens from RFC 9449. Proofs are accepted
+// for proof_lifetime after they were issued, 5 minutes by default. With a
+// nonce_secret clients have to sign their proofs over a server nonce that
+// changes every nonce_lifetime; instances sharing the secret accept each
+// other's nonces.
+type DPoPConfig struct {
+	ProofLifetime Duration `json:"proof_lifetime" yaml:"proof_lifetime" toml:"proof_lifetime"`
+	NonceSecret   string   `json:"nonce_secret" yaml:"nonce_secret" toml:"nonce_secret"`
+	NonceLifetime Duration `json:"nonce_lifetime" yaml:"nonce_lifetime" toml:"nonce_lifetime"`
+}
+
+// ScopeConfig describes one of the seeded scopes, Implies names the scopes it
+// grants as well.
+type ScopeConfig struct {
+	Description string   `json:"description" yaml:"description" toml:"description"`
+	Sensitive   bool     `json:"sensitive" yaml:"sensitive" toml:"sensitive"`
+	Implies     []string `json:"implies" yaml:"implies" toml:"implies"`
+}
+
+// ClientConfig seeds a client into storages that can not be managed any other
+// way, like the memory storage.
+type ClientConfig struct {
+	Id               string   `json:"id" yaml:"id" toml:"id"`
+	Secret           string   `json:"secret" yaml:"secret" toml:"secret"`
+	Name             string   `json:"name" yaml:"name" toml:"name"`
+	Public           bool     `json:"public" yaml:"public" toml:"public"`
+	RedirectUris     []string `json:"redirect_uris" yaml:"redirect_uris" toml:"redirect_uris"`
+	AllowedGrants    []string `json:"allowed_grants" yaml:"allowed_grants" toml:"allowed_grants"`
+	AllowedResources []string `json:"allowed_resources" yaml:"allowed_resources" toml:"allowed_resources"`
+	AllowedScopes    []string `json:"allowed_scopes" yaml:"allowed_scopes" toml:"allowed_scopes"`
+}
+
+// NewFile returns the defaults every loaded file starts from.
+func NewFile() *File {
+
+	return &File{
+		Tokens: TokensConfig{
+			AccessTokenLifetime:  Duration(time.Hour),
+			RefreshTokenLifetime: Duration(7 * 24 * time.Hour),
+		},
+		Storage: StorageConfig{Backend: "memory"},
+		Endpoints: EndpointsConfig{
+			Listen:          ":8080",
+			Token:           "/token",
+			UserInfo:        "/userinfo",
+			Introspection:   "/introspect",
+			Revocation:      "/revoke",
+			Jwks:            "/jwks.json",
+			Health:          "/healthz",
+			Ready:           "/readyz",
+			ShutdownTimeout: Duration(30 * time.Second),
+		},
+	}
+}
+
+// Load reads the file at path picking the format from its extension, applies
+// the environment overrides and validates the result.
+func Load(path string) (*File, error) {
+
+	data, error := os.ReadFile(path)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	file, error := Parse(data, strings.TrimPrefix(filepath.Ext(path), "."))
+
+	if error != nil {
+
+		return nil, fmt.Errorf("%s: %s", path, error)
+	}
+
+	if error := ApplyEnvironment(file, EnvironmentPrefix, os.LookupEnv); error != nil {
+
+		return nil, error
+	}
+
+	if error := file.Validate(); error != nil {
+
+		return nil, error
+	}
+
+	return file, nil
+}
+
+// Parse decodes json, yaml or toml on top of the defaults. Unknown fields are
+// errors so typos do not silently fall back to defaults.
+func Parse(data []byte, format string) (*File, error) {
+
+	file := NewFile()
+	var error error
+
+	switch format {
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		error = decoder.Decode(file)
+	case "yaml", "yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+
+		//an empty yaml document leaves the defaults
+		if error = decoder.Decode(file); error == io.EOF {
+
+			error = nil
+		}
+	case "toml":
+		var metadata toml.MetaData
+
+		if metadata, error = toml.Decode(string(data), file); error == nil && len(metadata.Undecoded()) > 0 {
+
+			error = fmt.Errorf("unknown field %s", metadata.Undecoded()[0])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format %q, use json, yaml or toml", format)
+	}
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	return file, nil
+}
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yjv/goauth2-server/jwt"
+	"github.com/yjv/goauth2-server/server"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+
+	documents := map[string]string{
+		"json": `{
+			"issuer": "https://auth.example.com",
+			"tokens": {"access_token_lifetime": "15m", "allow_refresh": true},
+			"grants": {"password": {}, "refresh_token": {"rotate_refresh_tokens": true}},
+			"storage": {"backend": "sql", "driver": "sqlite3", "dsn": "oauth.db"},
+			"scopes": ["read", "write"]
+		}`,
+		"yaml": `
+issuer: https://auth.example.com
+tokens:
+  access_token_lifetime: 15m
+  allow_refresh: true
+grants:
+  password: {}
+  refresh_token:
+    rotate_refresh_tokens: true
+storage:
+  backend: sql
+  driver: sqlite3
+  dsn: oauth.db
+scopes: [read, write]
+`,
+		"toml": `
+issuer = "https://auth.example.com"
+scopes = ["read", "write"]
+
+[tokens]
+access_token_lifetime = "15m"
+allow_refresh = true
+
+[grants.password]
+
+[grants.refresh_token]
+rotate_refresh_tokens = true
+
+[storage]
+backend = "sql"
+driver = "sqlite3"
+dsn = "oauth.db"
+`,
+	}
+
+	for format, document := range documents {
+
+		file, error := Parse([]byte(document), format)
+		assert.Nil(t, error, format)
+		assert.Equal(t, "https://auth.example.com", file.Issuer, format)
+		assert.Equal(t, Duration(15*time.Minute), file.Tokens.AccessTokenLifetime, format)
+		assert.Equal(t, Duration(7*24*time.Hour), file.Tokens.RefreshTokenLifetime, format)
+		assert.True(t, file.Tokens.AllowRefresh, format)
+		assert.Equal(t, &GrantConfig{}, file.Grants.Password, format)
+		assert.Nil(t, file.Grants.ClientCredentials, format)
+		assert.True(t, file.Grants.RefreshToken.RotateRefreshTokens, format)
+		assert.Equal(t, StorageConfig{Backend: "sql", Driver: "sqlite3", Dsn: "oauth.db"}, file.Storage, format)
+		assert.Equal(t, "/token", file.Endpoints.Token, format)
+		assert.Equal(t, []string{"read", "write"}, file.Scopes, format)
+		assert.Nil(t, file.Validate(), format)
+	}
+}
+
+func TestParseRejectsUnknownFieldsAndFormats(t *testing.T) {
+
+	_, error := Parse([]byte(`{"tokens": {"acess_token_lifetime": "1h"}}`), "json")
+	assert.NotNil(t, error)
+
+	_, error = Parse([]byte("tokens:\n  acess_token_lifetime: 1h\n"), "yaml")
+	assert.NotNil(t, error)
+
+	_, error = Parse([]byte("[tokens]\nacess_token_lifetime = \"1h\"\n"), "toml")
+	assert.EqualError(t, error, "unknown field tokens.acess_token_lifetime")
+
+	_, error = Parse([]byte(`{"tokens": {"access_token_lifetime": "an hour"}}`), "json")
+	assert.NotNil(t, error)
+
+	_, error = Parse([]byte(""), "ini")
+	assert.EqualError(t, error, `unsupported config format "ini", use json, yaml or toml`)
+}
+
+func TestApplyEnvironment(t *testing.T) {
+
+	environment := map[string]string{
+		"GOAUTH2_ISSUER":                             "https://env.example.com",
+		"GOAUTH2_TOKENS_ACCESS_TOKEN_LIFETIME":       "5m",
+		"GOAUTH2_TOKENS_ALLOW_REFRESH":               "true",
+		"GOAUTH2_GRANTS_REFRESH_TOKEN_REFRESH_OWNER": "true",
+		"GOAUTH2_RATE_LIMIT_BURST":                   "3",
+		"GOAUTH2_KEYS_0_SECRET":                      "from the environment",
+		"GOAUTH2_SCOPES":                             "read, write",
+	}
+	lookupEnv := func(key string) (string, bool) {
+
+		value, ok := environment[key]
+		return value, ok
+	}
+	file := NewFile()
+	file.Keys = []*KeyConfig{{Id: "first", Algorithm: "HS256"}}
+
+	assert.Nil(t, ApplyEnvironment(file, EnvironmentPrefix, lookupEnv))
+	assert.Equal(t, "https://env.example.com", file.Issuer)
+	assert.Equal(t, Duration(5*time.Minute), file.Tokens.AccessTokenLifetime)
+	assert.True(t, file.Tokens.AllowRefresh)
+	assert.Equal(t, &GrantConfig{RefreshOwner: true}, file.Grants.RefreshToken)
+	assert.Nil(t, file.Grants.Password)
+	assert.Equal(t, &RateLimitConfig{Burst: 3}, file.RateLimit)
+	assert.Equal(t, "from the environment", file.Keys[0].Secret)
+	assert.Equal(t, []string{"read", "write"}, file.Scopes)
+
+	environment = map[string]string{"GOAUTH2_TOKENS_ALLOW_REFRESH": "maybe"}
+	assert.EqualError(t, ApplyEnvironment(NewFile(), EnvironmentPrefix, lookupEnv), `GOAUTH2_TOKENS_ALLOW_REFRESH: "maybe" is not a valid bool`)
+}
+
+func TestValidate(t *testing.T) {
+
+	file := NewFile()
+	file.Issuer = "auth.example.com"
+	file.Tokens.AccessTokenLifetime = 0
+	file.Tokens.AccessTokenFormat = "paseto"
+	file.Grants.RefreshToken = &GrantConfig{}
+	file.Grants.Password = &GrantConfig{RotateRefreshTokens: true}
+	file.Storage = StorageConfig{Backend: "mongo", Url: "mongodb://localhost"}
+	file.Keys = []*KeyConfig{{Id: "a", Algorithm: "HS256", Secret: "short"}, {Id: "a", Algorithm: "none"}}
+	file.Endpoints.Token = "token"
+	file.Endpoints.Admin = "/admin"
+	file.Endpoints.Registration = "/register"
+	file.Endpoints.Consents = "/consents"
+	file.RateLimit = &RateLimitConfig{Rate: 1, LockoutThreshold: 3}
+	file.SessionLimit = &SessionLimitConfig{Action: "logout"}
+	file.DPoP = &DPoPConfig{ProofLifetime: Duration(-time.Minute), NonceSecret: "short"}
+	file.Scopes = []string{"admin"}
+	file.ScopeDetails = map[string]*ScopeConfig{"admin": {Implies: []string{"write"}}, "read": {}}
+	file.ScopeParameters = map[string]map[string]string{"account": {"id": "("}, "transaction:{id}": {"amount": "[0-9]+"}}
+	file.Clients = []*ClientConfig{
+		{Id: "client", AllowedResources: []string{"https://api.example.com/billing", "billing"}},
+		{Id: "public", Public: true, AllowedGrants: []string{"client_credentials"}},
+	}
+
+	error := file.Validate()
+	assert.IsType(t, &ValidationError{}, error)
+	assert.Equal(t, []string{
+		"clients.0.allowed_resources must be absolute uris without a fragment, got \"billing\"",
+		"clients.0.secret is required for confidential clients",
+		"clients.1.allowed_grants can not contain client_credentials for public clients",
+		"dpop.nonce_secret must be at least 32 bytes",
+		"dpop.proof_lifetime must not be negative",
+		"endpoints.admin needs endpoints.admin_tokens or endpoints.admin_scope",
+		"endpoints.consents needs endpoints.consents_scope",
+		"endpoints.registration needs endpoints.registration_scopes",
+		"endpoints.registration needs endpoints.registration_tokens",
+		"endpoints.token must start with a /, got \"token\"",
+		"grants.password.rotate_refresh_tokens only applies to the refresh_token grant",
+		"grants.refresh_token needs tokens.allow_refresh to be true",
+		"issuer must be an absolute url without a query or fragment, got \"auth.example.com\"",
+		"keys.0.secret must be at least 32 bytes for HS256",
+		"keys.1.algorithm must be HS256 or RS256, got \"none\"",
+		"keys.1.id \"a\" is used by another key",
+		"rate_limit.burst must be at least 1 when a rate is set",
+		"rate_limit.lockout_duration must be positive when a lockout_threshold is set",
+		"scope_details.admin.implies contains \"write\" which is missing from scopes",
+		"scope_details.read describes a scope missing from scopes",
+		"scope_parameters.account is not a scope template like transaction:{id}",
+		"scope_parameters.account.id is not a parameter of the template",
+		"scope_parameters.account.id must be a valid regular expression, got \"(\"",
+		"scope_parameters.transaction:{id}.amount is not a parameter of the template",
+		"session_limit.action must be reject or evict_oldest, got \"logout\"",
+		"session_limit.max_sessions must be at least 1",
+		"storage.database is required for the mongo backend",
+		"tokens.access_token_format must be opaque or jwt, got \"paseto\"",
+		"tokens.access_token_lifetime must be positive",
+	}, error.(*ValidationError).Problems)
+
+	assert.Equal(t, &ValidationError{[]string{"grants must enable at least one grant"}}, NewFile().Validate())
+
+	file = NewFile()
+	file.Grants.Password = &GrantConfig{}
+	file.Tokens.AccessTokenFormat = "jwt"
+	assert.Equal(t, &ValidationError{[]string{"tokens.access_token_format jwt needs a signing key in keys"}}, file.Validate())
+
+	file = NewFile()
+	file.Grants.Password = &GrantConfig{}
+	file.DPoP = &DPoPConfig{}
+	assert.Equal(t, &ValidationError{[]string{"dpop needs an issuer to check the htu of proofs against"}}, file.Validate())
+
+	file = NewFile()
+	file.Grants.Password = &GrantConfig{}
+	file.Endpoints.Admin = "/admin"
+	file.Endpoints.AdminScope = "admin"
+	assert.Equal(t, &ValidationError{[]string{"endpoints.admin_scope needs endpoints.admin_clients or endpoints.admin_owners"}}, file.Validate())
+
+	file = NewFile()
+	file.Grants.Password = &GrantConfig{}
+	file.Endpoints.Registration = "/register"
+	file.Endpoints.RegistrationTokens = []string{"initial-token"}
+	file.Endpoints.RegistrationScopes = []string{"read", "repo:*"}
+	file.Endpoints.RegistrationDefaultScope = "read repo:goauth2 write"
+	assert.Equal(t, &ValidationError{[]string{"endpoints.registration_default_scope contains \"write\" which is missing from endpoints.registration_scopes"}}, file.Validate())
+}
+
+func TestBuildDPoPValidator(t *testing.T) {
+
+	file := NewFile()
+	assert.Nil(t, BuildDPoPValidator(file))
+
+	file.DPoP = &DPoPConfig{}
+	validator := BuildDPoPValidator(file)
+	assert.Equal(t, 5*time.Minute, validator.ProofLifetime)
+	assert.Nil(t, validator.Nonces())
+
+	file.DPoP = &DPoPConfig{ProofLifetime: Duration(time.Minute), NonceSecret: "0123456789abcdef0123456789abcdef"}
+	validator = BuildDPoPValidator(file)
+	assert.Equal(t, time.Minute, validator.ProofLifetime)
+	assert.Equal(t, jwt.NewDPoPNonces([]byte(file.DPoP.NonceSecret), 5*time.Minute), validator.Nonces())
+}
+
+func TestBuilderBuild(t *testing.T) {
+
+	file := NewFile()
+	file.Issuer = "https://auth.example.com"
+	file.Tokens.AllowRefresh = true
+	file.Tokens.MaxSessionLifetime = Duration(24 * time.Hour)
+	file.Tokens.ScopeAccessTokenLifetimes = map[string]Duration{"admin": Duration(time.Minute)}
+	file.Grants.ClientCredentials = &GrantConfig{AccessTokenLifetime: Duration(10 * time.Minute)}
+	file.Grants.RefreshToken = &GrantConfig{RefreshTokenLifetime: Duration(time.Hour), RotateRefreshTokens: true}
+	file.RateLimit = &RateLimitConfig{Rate: 2, Burst: 4}
+	file.SessionLimit = &SessionLimitConfig{MaxSessions: 3, Action: "evict_oldest", CheckedGrants: []string{"password"}}
+	file.Scopes = []string{"read", "admin"}
+	file.ScopeDetails = map[string]*ScopeConfig{"admin": {Description: "Full access", Sensitive: true, Implies: []string{"read"}}}
+	file.ScopeParameters = map[string]map[string]string{"transaction:{id}": {"id": "[0-9]+"}}
+	file.Clients = []*ClientConfig{{Id: "client", Secret: "secret", Name: "name"}}
+
+	oauthServer, error := NewBuilder().Build(file)
+	assert.Nil(t, error)
+	assert.Equal(t, "https://auth.example.com", oauthServer.Config().Issuer)
+	assert.True(t, oauthServer.Config().AllowRefresh)
+	assert.Equal(t, time.Hour, oauthServer.Config().DefaultAccessTokenExpires)
+
+	policy := oauthServer.Config().LifetimePolicy.(*server.DefaultLifetimePolicy)
+	assert.Equal(t, 24*time.Hour, policy.MaxSessionLifetime)
+	assert.Equal(t, map[string]time.Duration{"admin": time.Minute}, policy.ScopeAccessTokenExpires)
+	assert.Equal(t, map[string]time.Duration{"refresh_token": time.Hour}, policy.GrantRefreshTokenExpires)
+
+	grant, ok := oauthServer.GetGrant("client_credentials")
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Minute, grant.AccessTokenExpiration())
+	grant, ok = oauthServer.GetGrant("refresh_token")
+	assert.True(t, ok)
+	assert.True(t, grant.(*server.RefreshTokenGrant).RotateRefreshTokens)
+	_, ok = oauthServer.GetGrant("password")
+	assert.False(t, ok)
+
+	limiter := oauthServer.Limiter().(*server.DefaultLimiter)
+	assert.Equal(t, 2.0, limiter.Rate)
+	assert.Equal(t, 4.0, limiter.Burst)
+
+	validateScopeParameters := oauthServer.Config().ScopeParameterValidator
+	assert.Nil(t, validateScopeParameters(nil, &server.Scope{Name: "transaction:{id}"}, map[string]string{"id": "1234"}))
+	assert.NotNil(t, validateScopeParameters(nil, &server.Scope{Name: "transaction:{id}"}, map[string]string{"id": "1234a"}))
+
+	sessionLimiter := oauthServer.SessionLimiter().(*server.DefaultSessionLimiter)
+	assert.Equal(t, 3, sessionLimiter.MaxSessions)
+	assert.Equal(t, server.EvictOldestSession, sessionLimiter.Action)
+	assert.Equal(t, []string{"password"}, sessionLimiter.CheckedGrants)
+
+	client, error := oauthServer.ClientStorage().FindClientByIdAndSecret("client", "secret")
+	assert.Nil(t, error)
+	assert.Equal(t, &server.Client{Id: "client", Name: "name", Type: server.ConfidentialClient}, client)
+	scope, error := oauthServer.ScopeStorage().FindScopeByName("read")
+	assert.Nil(t, error)
+	assert.Equal(t, &server.Scope{Id: "read", Name: "read"}, scope)
+	scope, error = oauthServer.ScopeStorage().FindScopeByName("admin")
+	assert.Nil(t, error)
+	assert.Equal(t, &server.Scope{Id: "admin", Name: "admin", Description: "Full access", Sensitive: true, Implies: []string{"read"}}, scope)
+
+	file.Storage.Backend = "sql"
+	_, error = NewBuilder().Build(file)
+	assert.EqualError(t, error, `storage backend "sql" is not registered`)
+}
+
+func TestBuildSigners(t *testing.T) {
+
+	file := NewFile()
+	file.Keys = []*KeyConfig{{Id: "hmac", Algorithm: "HS256", Secret: "a secret that is at least 32 bytes"}}
+
+	signers, error := BuildSigners(file)
+	assert.Nil(t, error)
+	assert.Len(t, signers, 1)
+	assert.Equal(t, "HS256", signers[0].Algorithm())
+	assert.Equal(t, "hmac", signers[0].KeyId())
+
+	file.Keys = []*KeyConfig{{Id: "rsa", Algorithm: "RS256", PrivateKeyFile: "/does/not/exist.pem"}}
+	_, error = BuildSigners(file)
+	assert.NotNil(t, error)
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvironmentPrefix starts the name of every environment override. The rest
+// of the name is the path to the field in upper case joined by underscores,
+// so GOAUTH2_STORAGE_DSN sets storage.dsn and GOAUTH2_KEYS_0_SECRET sets the
+// secret of the first key. Lists of strings are comma separated.
+const EnvironmentPrefix = "GOAUTH2"
+
+type LookupEnvFunc func(key string) (string, bool)
+
+// ApplyEnvironment overrides the fields of file that have an environment
+// variable set. Optional sections like a grant are created when one of their
+// fields is set.
+func ApplyEnvironment(file *File, prefix string, lookupEnv LookupEnvFunc) error {
+
+	_, error := applyEnvironment(reflect.ValueOf(file).Elem(), prefix, lookupEnv)
+	return error
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func applyEnvironment(value reflect.Value, name string, lookupEnv LookupEnvFunc) (bool, error) {
+
+	if reflect.PointerTo(value.Type()).Implements(textUnmarshalerType) {
+
+		return applyEnvironmentValue(value, name, lookupEnv)
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		changed := false
+
+		for i := 0; i < value.NumField(); i++ {
+
+			tag := strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0]
+			fieldChanged, error := applyEnvironment(value.Field(i), name+"_"+strings.ToUpper(tag), lookupEnv)
+
+			if error != nil {
+
+				return false, error
+			}
+
+			changed = changed || fieldChanged
+		}
+
+		return changed, nil
+	case reflect.Ptr:
+		if value.Type().Elem().Kind() != reflect.Struct {
+
+			return false, nil
+		}
+
+		target := value
+
+		if value.IsNil() {
+
+			target = reflect.New(value.Type().Elem())
+		}
+
+		changed, error := applyEnvironment(target.Elem(), name, lookupEnv)
+
+		if changed && value.IsNil() {
+
+			value.Set(target)
+		}
+
+		return changed, error
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.String {
+
+			return applyEnvironmentValue(value, name, lookupEnv)
+		}
+
+		changed := false
+
+		for i := 0; i < value.Len(); i++ {
+
+			elementChanged, error := applyEnvironment(value.Index(i), name+"_"+strconv.Itoa(i), lookupEnv)
+
+			if error != nil {
+
+				return false, error
+			}
+
+			changed = changed || elementChanged
+		}
+
+		return changed, nil
+	case reflect.Map:
+		return false, nil
+	default:
+		return applyEnvironmentValue(value, name, lookupEnv)
+	}
+}
+
+func applyEnvironmentValue(value reflect.Value, name string, lookupEnv LookupEnvFunc) (bool, error) {
+
+	raw, ok := lookupEnv(name)
+
+	if !ok {
+
+		return false, nil
+	}
+
+	var error error
+
+	if unmarshaler, ok := value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+
+		error = unmarshaler.UnmarshalText([]byte(raw))
+	} else {
+
+		switch value.Kind() {
+		case reflect.String:
+			value.SetString(raw)
+		case reflect.Bool:
+			var parsed bool
+
+			if parsed, error = strconv.ParseBool(raw); error == nil {
+
+				value.SetBool(parsed)
+			}
+		case reflect.Int:
+			var parsed int64
+
+			if parsed, error = strconv.ParseInt(raw, 10, 64); error == nil {
+
+				value.SetInt(parsed)
+			}
+		case reflect.Float64:
+			var parsed float64
+
+			if parsed, error = strconv.ParseFloat(raw, 64); error == nil {
+
+				value.SetFloat(parsed)
+			}
+		case reflect.Slice:
+			parts := []string{}
+
+			for _, part := range strings.Split(raw, ",") {
+
+				if part = strings.TrimSpace(part); part != "" {
+
+					parts = append(parts, part)
+				}
+			}
+
+			value.Set(reflect.ValueOf(parts))
+		default:
+			error = fmt.Errorf("can not be set from the environment")
+		}
+	}
+
+	if _, ok := error.(*strconv.NumError); ok {
+
+		error = fmt.Errorf("%q is not a valid %s", raw, value.Kind())
+	}
+
+	if error != nil {
+
+		return false, fmt.Errorf("%s: %s", name, error)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,279 @@
+package config
+
+import (
+	"fmt"
+	"github.com/yjv/goauth2-server/server"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ValidationError lists every problem found in a config file at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (error *ValidationError) Error() string {
+
+	return "invalid configuration:\n  " + strings.Join(error.Problems, "\n  ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (validator *validator) check(ok bool, format string, arguments ...interface{}) {
+
+	if !ok {
+
+		validator.problems = append(validator.problems, fmt.Sprintf(format, arguments...))
+	}
+}
+
+func (file *File) Validate() error {
+
+	validator := &validator{}
+
+	if file.Issuer != "" {
+
+		issuer, error := url.Parse(file.Issuer)
+		validator.check(
+			error == nil && issuer.IsAbs() && issuer.RawQuery == "" && issuer.Fragment == "",
+			"issuer must be an absolute url without a query or fragment, got %q",
+			file.Issuer,
+		)
+	}
+
+	validator.check(file.Tokens.AccessTokenLifetime > 0, "tokens.access_token_lifetime must be positive")
+	validator.check(file.Tokens.RefreshTokenLifetime > 0, "tokens.refresh_token_lifetime must be positive")
+	validator.check(file.Tokens.MaxSessionLifetime >= 0, "tokens.max_session_lifetime must not be negative")
+	validator.check(file.Tokens.RefreshTokenIdleTimeout >= 0, "tokens.refresh_token_idle_timeout must not be negative")
+
+	for scope, lifetime := range file.Tokens.ScopeAccessTokenLifetimes {
+
+		validator.check(lifetime > 0, "tokens.scope_access_token_lifetimes.%s must be positive", scope)
+	}
+
+	switch file.Tokens.AccessTokenFormat {
+	case "", "opaque":
+	case "jwt":
+		validator.check(len(file.Keys) > 0, "tokens.access_token_format jwt needs a signing key in keys")
+	default:
+		validator.check(false, "tokens.access_token_format must be opaque or jwt, got %q", file.Tokens.AccessTokenFormat)
+	}
+
+	grants := map[string]*GrantConfig{
+		"client_credentials": file.Grants.ClientCredentials,
+		"password":           file.Grants.Password,
+		"refresh_token":      file.Grants.RefreshToken,
+	}
+	enabled := 0
+
+	for name, grant := range grants {
+
+		if grant == nil {
+
+			continue
+		}
+
+		enabled++
+		validator.check(grant.AccessTokenLifetime >= 0, "grants.%s.access_token_lifetime must not be negative", name)
+		validator.check(grant.RefreshTokenLifetime >= 0, "grants.%s.refresh_token_lifetime must not be negative", name)
+		validator.check(!grant.RotateRefreshTokens || name == "refresh_token", "grants.%s.rotate_refresh_tokens only applies to the refresh_token grant", name)
+		validator.check(!grant.RefreshOwner || name == "refresh_token", "grants.%s.refresh_owner only applies to the refresh_token grant", name)
+	}
+
+	validator.check(enabled > 0, "grants must enable at least one grant")
+	validator.check(file.Grants.RefreshToken == nil || file.Tokens.AllowRefresh, "grants.refresh_token needs tokens.allow_refresh to be true")
+
+	switch file.Storage.Backend {
+	case "memory":
+	case "sql":
+		validator.check(file.Storage.Driver != "", "storage.driver is required for the sql backend")
+		validator.check(file.Storage.Dsn != "", "storage.dsn is required for the sql backend")
+	case "mongo":
+		validator.check(file.Storage.Url != "", "storage.url is required for the mongo backend")
+		validator.check(file.Storage.Database != "", "storage.database is required for the mongo backend")
+	default:
+		validator.check(false, "storage.backend must be memory, sql or mongo, got %q", file.Storage.Backend)
+	}
+
+	keyIds := make(map[string]bool)
+
+	for index, key := range file.Keys {
+
+		validator.check(key.Id != "", "keys.%d.id is required", index)
+		validator.check(!keyIds[key.Id], "keys.%d.id %q is used by another key", index, key.Id)
+		keyIds[key.Id] = true
+
+		switch key.Algorithm {
+		case "HS256":
+			validator.check(len(key.Secret) >= 32, "keys.%d.secret must be at least 32 bytes for HS256", index)
+		case "RS256":
+			validator.check(key.PrivateKeyFile != "", "keys.%d.private_key_file is required for RS256", index)
+		default:
+			validator.check(false, "keys.%d.algorithm must be HS256 or RS256, got %q", index, key.Algorithm)
+		}
+	}
+
+	for name, path := range map[string]string{
+		"token":         file.Endpoints.Token,
+		"userinfo":      file.Endpoints.UserInfo,
+		"registration":  file.Endpoints.Registration,
+		"introspection": file.Endpoints.Introspection,
+		"revocation":    file.Endpoints.Revocation,
+		"jwks":          file.Endpoints.Jwks,
+		"metrics":       file.Endpoints.Metrics,
+		"health":        file.Endpoints.Health,
+		"ready":         file.Endpoints.Ready,
+		"admin":         file.Endpoints.Admin,
+		"consents":      file.Endpoints.Consents,
+	} {
+
+		validator.check(path == "" || strings.HasPrefix(path, "/"), "endpoints.%s must start with a /, got %q", name, path)
+	}
+
+	validator.check(
+		(file.Endpoints.TLSCertFile == "") == (file.Endpoints.TLSKeyFile == ""),
+		"endpoints.tls_cert_file and endpoints.tls_key_file must be set together",
+	)
+	validator.check(
+		file.Endpoints.Admin == "" || len(file.Endpoints.AdminTokens) > 0 || file.Endpoints.AdminScope != "",
+		"endpoints.admin needs endpoints.admin_tokens or endpoints.admin_scope",
+	)
+	validator.check(
+		file.Endpoints.AdminScope == "" || len(file.Endpoints.AdminClients) > 0 || len(file.Endpoints.AdminOwners) > 0,
+		"endpoints.admin_scope needs endpoints.admin_clients or endpoints.admin_owners",
+	)
+	validator.check(
+		file.Endpoints.Registration == "" || len(file.Endpoints.RegistrationTokens) > 0,
+		"endpoints.registration needs endpoints.registration_tokens",
+	)
+	validator.check(
+		file.Endpoints.Registration == "" || len(file.Endpoints.RegistrationScopes) > 0,
+		"endpoints.registration needs endpoints.registration_scopes",
+	)
+
+	for _, scope := range strings.Fields(file.Endpoints.RegistrationDefaultScope) {
+
+		validator.check(
+			matchesAnyScope(file.Endpoints.RegistrationScopes, scope),
+			"endpoints.registration_default_scope contains %q which is missing from endpoints.registration_scopes",
+			scope,
+		)
+	}
+	validator.check(
+		file.Endpoints.Consents == "" || file.Endpoints.ConsentsScope != "",
+		"endpoints.consents needs endpoints.consents_scope",
+	)
+	validator.check(file.Endpoints.ShutdownTimeout >= 0, "endpoints.shutdown_timeout must not be negative")
+
+	if file.RateLimit != nil {
+
+		validator.check(file.RateLimit.Rate >= 0, "rate_limit.rate must not be negative")
+		validator.check(file.RateLimit.Rate == 0 || file.RateLimit.Burst >= 1, "rate_limit.burst must be at least 1 when a rate is set")
+		validator.check(file.RateLimit.LockoutThreshold >= 0, "rate_limit.lockout_threshold must not be negative")
+		validator.check(
+			file.RateLimit.LockoutThreshold == 0 || file.RateLimit.LockoutDuration > 0,
+			"rate_limit.lockout_duration must be positive when a lockout_threshold is set",
+		)
+		validator.check(file.RateLimit.MaxLockout >= 0, "rate_limit.max_lockout must not be negative")
+	}
+
+	if file.SessionLimit != nil {
+
+		validator.check(file.SessionLimit.MaxSessions >= 1, "session_limit.max_sessions must be at least 1")
+		validator.check(
+			file.SessionLimit.Action == "" || file.SessionLimit.Action == "reject" || file.SessionLimit.Action == "evict_oldest",
+			"session_limit.action must be reject or evict_oldest, got %q",
+			file.SessionLimit.Action,
+		)
+	}
+
+	if file.DPoP != nil {
+
+		validator.check(file.Issuer != "", "dpop needs an issuer to check the htu of proofs against")
+		validator.check(file.DPoP.ProofLifetime >= 0, "dpop.proof_lifetime must not be negative")
+		validator.check(file.DPoP.NonceLifetime >= 0, "dpop.nonce_lifetime must not be negative")
+		validator.check(
+			file.DPoP.NonceSecret == "" || len(file.DPoP.NonceSecret) >= 32,
+			"dpop.nonce_secret must be at least 32 bytes",
+		)
+	}
+
+	scopes := make(map[string]bool, len(file.Scopes))
+
+	for _, scope := range file.Scopes {
+
+		scopes[scope] = true
+	}
+
+	for name, scope := range file.ScopeDetails {
+
+		validator.check(scopes[name], "scope_details.%s describes a scope missing from scopes", name)
+
+		for _, implied := range scope.Implies {
+
+			validator.check(scopes[implied], "scope_details.%s.implies contains %q which is missing from scopes", name, implied)
+		}
+	}
+
+	for template, parameters := range file.ScopeParameters {
+
+		templateScope := &server.Scope{Name: template}
+		validator.check(templateScope.IsTemplate(), "scope_parameters.%s is not a scope template like transaction:{id}", template)
+
+		for name, pattern := range parameters {
+
+			validator.check(
+				strings.Contains(template, "{"+name+"}"),
+				"scope_parameters.%s.%s is not a parameter of the template",
+				template,
+				name,
+			)
+			_, error := compileScopeParameterPattern(pattern)
+			validator.check(error == nil, "scope_parameters.%s.%s must be a valid regular expression, got %q", template, name, pattern)
+		}
+	}
+
+	clientIds := make(map[string]bool)
+
+	for index, client := range file.Clients {
+
+		validator.check(client.Id != "", "clients.%d.id is required", index)
+		validator.check(!clientIds[client.Id], "clients.%d.id %q is used by another client", index, client.Id)
+		validator.check(client.Public || client.Secret != "", "clients.%d.secret is required for confidential clients", index)
+		clientIds[client.Id] = true
+
+		for _, grant := range client.AllowedGrants {
+
+			validator.check(!client.Public || grant != "client_credentials", "clients.%d.allowed_grants can not contain client_credentials for public clients", index)
+		}
+
+		for _, resource := range client.AllowedResources {
+
+			validator.check(server.ValidateResource(resource) == nil, "clients.%d.allowed_resources must be absolute uris without a fragment, got %q", index, resource)
+		}
+	}
+
+	if len(validator.problems) > 0 {
+
+		sort.Strings(validator.problems)
+		return &ValidationError{validator.problems}
+	}
+
+	return nil
+}
+
+func matchesAnyScope(scopes []string, scope string) bool {
+
+	for _, candidate := range scopes {
+
+		if server.MatchScope(candidate, scope) {
+
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,767 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/yjv/goauth2-server/server"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// AdminAuthorizerFunc decides whether a request may use the admin api. The
+// returned error is written as the response when it may not.
+type AdminAuthorizerFunc func(request *http.Request) *BearerError
+
+// AdminTokenAuthorizer lets requests through that carry one of the admin
+// tokens as their bearer token.
+func AdminTokenAuthorizer(adminTokens ...string) AdminAuthorizerFunc {
+
+	return func(request *http.Request) *BearerError {
+
+		token, bearerError := BearerToken(request)
+
+		if bearerError != nil {
+
+			return bearerError
+		}
+
+		for _, adminToken := range adminTokens {
+
+			if subtle.ConstantTimeCompare([]byte(adminToken), []byte(token)) == 1 {
+
+				return nil
+			}
+		}
+
+		return NewInvalidBearerTokenError("The admin token is invalid.")
+	}
+}
+
+// AdminScopeAuthorizer lets requests through that carry an access token
+// satisfying the scope which was issued to one of the admin clients or for one
+// of the admin owners. The client the token was issued to also has to list the
+// scope in its allowed scopes, a client that is not restricted to any scopes
+// never gets access.
+func AdminScopeAuthorizer(authenticator *BearerAuthenticator, scope string, clientIds []string, ownerIds []string) AdminAuthorizerFunc {
+
+	return func(request *http.Request) *BearerError {
+
+		session, bearerError := authenticator.AuthenticateScopes(request, scope)
+
+		if bearerError != nil {
+
+			return bearerError
+		}
+
+		if session.Client == nil {
+
+			return NewInsufficientScopeError(scope)
+		}
+
+		//the stored client may have lost the scope since the token was issued
+		client, error := authenticator.server.ClientStorage().FindClientById(session.Client.Id)
+
+		if error != nil || !containsString(client.AllowedScopes, scope) {
+
+			return NewInsufficientScopeError(scope)
+		}
+
+		if containsString(clientIds, client.Id) || (session.Owner != nil && containsString(ownerIds, session.Owner.Id)) {
+
+			return nil
+		}
+
+		return NewInsufficientScopeError(scope)
+	}
+}
+
+func containsString(values []string, value string) bool {
+
+	for _, candidate := range values {
+
+		if candidate == value {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+const (
+	adminDefaultPageSize = 100
+	adminMaxPageSize     = 1000
+)
+
+type adminClient struct {
+	ClientId         string            `json:"client_id"`
+	ClientName       string            `json:"client_name,omitempty"`
+	ClientType       server.ClientType `json:"client_type"`
+	ClientSecret     string            `json:"client_secret,omitempty"`
+	RedirectUris     []string          `json:"redirect_uris,omitempty"`
+	GrantTypes       []string          `json:"grant_types,omitempty"`
+	AllowedResources []string          `json:"allowed_resources,omitempty"`
+	AllowedScopes    []string          `json:"allowed_scopes,omitempty"`
+}
+
+type adminScope struct {
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Sensitive   bool     `json:"sensitive,omitempty"`
+	Implies     []string `json:"implies,omitempty"`
+}
+
+type adminSession struct {
+	Id                    string `json:"id"`
+	ClientId              string `json:"client_id,omitempty"`
+	OwnerId               string `json:"owner_id,omitempty"`
+	Scope                 string `json:"scope"`
+	Issuer                string `json:"iss,omitempty"`
+	CreatedAt             int64  `json:"created_at"`
+	AccessTokenExpiresAt  int64  `json:"access_token_expires_at,omitempty"`
+	RefreshTokenExpiresAt int64  `json:"refresh_token_expires_at,omitempty"`
+}
+
+type adminSessionPage struct {
+	Sessions   []*adminSession `json:"sessions"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// AdminHandler serves a json api for support staff mounted at its path:
+//
+//	GET, DELETE      /sessions?owner_id=&client_id=&scope=&cursor=&limit=
+//	GET, DELETE      /sessions/{id}
+//	GET, POST        /clients
+//	GET, PUT, DELETE /clients/{id}
+//	POST             /clients/{id}/secret
+//	GET, POST        /scopes
+//	DELETE           /scopes/{name}
+//
+// Deleting sessions revokes them through the server. Client secrets are only
+// ever returned by the request that generated them. Every request has to get
+// past the authorizer.
+type AdminHandler struct {
+	server       *server.DefaultServer
+	path         string
+	authorizer   AdminAuthorizerFunc
+	clients      server.ClientManager
+	scopes       server.ScopeManager
+	sessionQuery server.SessionQuery
+}
+
+func (handler *AdminHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	if bearerError := handler.authorizer(request); bearerError != nil {
+
+		bearerError.WriteResponse(writer, "")
+		return
+	}
+
+	path := strings.TrimSuffix(request.URL.EscapedPath(), "/")
+
+	if !strings.HasPrefix(path, handler.path+"/") {
+
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	segments := strings.Split(strings.TrimPrefix(path, handler.path+"/"), "/")
+
+	for index, segment := range segments {
+
+		unescaped, error := url.PathUnescape(segment)
+
+		if error != nil || unescaped == "" {
+
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		segments[index] = unescaped
+	}
+
+	switch {
+	case segments[0] == "sessions" && len(segments) == 1:
+		handler.sessions(writer, request)
+	case segments[0] == "sessions" && len(segments) == 2:
+		handler.session(writer, request, segments[1])
+	case segments[0] == "clients" && len(segments) == 1:
+		handler.clientCollection(writer, request)
+	case segments[0] == "clients" && len(segments) == 2:
+		handler.client(writer, request, segments[1])
+	case segments[0] == "clients" && len(segments) == 3 && segments[2] == "secret":
+		handler.clientSecret(writer, request, segments[1])
+	case segments[0] == "scopes" && len(segments) == 1:
+		handler.scopeCollection(writer, request)
+	case segments[0] == "scopes" && len(segments) == 2:
+		handler.scope(writer, request, segments[1])
+	default:
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// sessions lists a page of the active sessions matching the owner_id,
+// client_id and scope parameters or revokes all of them. Revoking needs at
+// least one of the parameters so every session can not be revoked by
+// accident.
+func (handler *AdminHandler) sessions(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != "GET" && request.Method != "DELETE" {
+
+		writer.Header().Set("Allow", "GET, DELETE")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if handler.sessionQuery == nil {
+
+		writeAdminError(writer, http.StatusNotImplemented, "unsupported", "The session storage can not be queried.")
+		return
+	}
+
+	query := request.URL.Query()
+	filter := &server.SessionFilter{
+		OwnerId:  query.Get("owner_id"),
+		ClientId: query.Get("client_id"),
+		Scope:    query.Get("scope"),
+	}
+
+	if request.Method == "DELETE" {
+
+		if *filter == (server.SessionFilter{}) {
+
+			writeAdminError(writer, http.StatusBadRequest, "invalid_request", "One of owner_id, client_id or scope is required.")
+			return
+		}
+
+		page, error := handler.sessionQuery.QuerySessions(filter, nil, 0)
+
+		if error != nil {
+
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		for _, session := range page.Sessions {
+
+			handler.server.RevokeSession(session)
+		}
+
+		writeAdminResponse(writer, http.StatusOK, map[string]int{"revoked": len(page.Sessions)})
+		return
+	}
+
+	cursor, error := server.ParseSessionCursor(query.Get("cursor"))
+
+	if error != nil {
+
+		writeAdminError(writer, http.StatusBadRequest, "invalid_request", "The cursor is invalid.")
+		return
+	}
+
+	limit := adminDefaultPageSize
+
+	if query.Get("limit") != "" {
+
+		if limit, error = strconv.Atoi(query.Get("limit")); error != nil || limit < 1 || limit > adminMaxPageSize {
+
+			writeAdminError(writer, http.StatusBadRequest, "invalid_request", fmt.Sprintf("limit must be between 1 and %d.", adminMaxPageSize))
+			return
+		}
+	}
+
+	page, error := handler.sessionQuery.QuerySessions(filter, cursor, limit)
+
+	if error != nil {
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := &adminSessionPage{Sessions: make([]*adminSession, 0, len(page.Sessions))}
+
+	for _, session := range page.Sessions {
+
+		response.Sessions = append(response.Sessions, newAdminSession(session))
+	}
+
+	if page.NextCursor != nil {
+
+		response.NextCursor = page.NextCursor.String()
+	}
+
+	writeAdminResponse(writer, http.StatusOK, response)
+}
+
+func (handler *AdminHandler) session(writer http.ResponseWriter, request *http.Request, sessionId string) {
+
+	if request.Method != "GET" && request.Method != "DELETE" {
+
+		writer.Header().Set("Allow", "GET, DELETE")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if handler.sessionQuery == nil {
+
+		writeAdminError(writer, http.StatusNotImplemented, "unsupported", "The session storage can not be queried.")
+		return
+	}
+
+	session, _ := handler.sessionQuery.FindSessionById(sessionId)
+
+	if session == nil {
+
+		writeAdminError(writer, http.StatusNotFound, "not_found", fmt.Sprintf("There is no active session with id %s.", sessionId))
+		return
+	}
+
+	if request.Method == "DELETE" {
+
+		handler.server.RevokeSession(session)
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeAdminResponse(writer, http.StatusOK, newAdminSession(session))
+}
+
+func (handler *AdminHandler) clientCollection(writer http.ResponseWriter, request *http.Request) {
+
+	if handler.clients == nil {
+
+		writeAdminError(writer, http.StatusNotImplemented, "unsupported", "The client storage can not manage clients.")
+		return
+	}
+
+	switch request.Method {
+	case "GET":
+		clients, error := handler.clients.FindAllClients()
+
+		if error != nil {
+
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		response := make([]*adminClient, 0, len(clients))
+
+		for _, client := range clients {
+
+			response = append(response, newAdminClient(client, ""))
+		}
+
+		writeAdminResponse(writer, http.StatusOK, map[string]interface{}{"clients": response})
+	case "POST":
+		body := &adminClient{}
+
+		if error := json.NewDecoder(request.Body).Decode(body); error != nil || body.ClientId == "" {
+
+			writeAdminError(writer, http.StatusBadRequest, "invalid_request", "The request body must be a json object with a client_id.")
+			return
+		}
+
+		if existing, _ := handler.clients.FindClientById(body.ClientId); existing != nil {
+
+			writeAdminError(writer, http.StatusConflict, "conflict", fmt.Sprintf("The client %s already exists.", body.ClientId))
+			return
+		}
+
+		if error := validateResources(body.AllowedResources); error != nil {
+
+			writeAdminError(writer, http.StatusBadRequest, "invalid_request", error.Error())
+			return
+		}
+
+		client := &server.Client{
+			Id:               body.ClientId,
+			Name:             body.ClientName,
+			Type:             body.ClientType,
+			RedirectUris:     body.RedirectUris,
+			AllowedGrants:    body.GrantTypes,
+			AllowedResources: body.AllowedResources,
+			AllowedScopes:    body.AllowedScopes,
+		}
+
+		switch client.Type {
+		case "":
+			client.Type = server.ConfidentialClient
+		case server.ConfidentialClient, server.PublicClient:
+		default:
+			writeAdminError(writer, http.StatusBadRequest, "invalid_request", "client_type must be confidential or public.")
+			return
+		}
+
+		if publicClientCredentials(client.Type, client.AllowedGrants) {
+
+			writeAdminError(writer, http.StatusBadRequest, "invalid_request", "Public clients can not use the client_credentials grant.")
+			return
+		}
+
+		clientSecret := ""
+
+		if !client.IsPublic() {
+
+			clientSecret = server.GenerateTokenId()
+		}
+
+		if error := handler.clients.SaveClient(client, clientSecret); error != nil {
+
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeAdminResponse(writer, http.StatusCreated, newAdminClient(client, clientSecret))
+	default:
+		writer.Header().Set("Allow", "GET, POST")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// client replaces the name, redirect uris and grant types of a client on PUT
+// and keeps its type and secret.
+func (handler *AdminHandler) client(writer http.ResponseWriter, request *http.Request, clientId string) {
+
+	client, ok := handler.findClient(writer, clientId)
+
+	if !ok {
+
+		return
+	}
+
+	switch request.Method {
+	case "GET":
+		writeAdminResponse(writer, http.StatusOK, newAdminClient(client, ""))
+	case "PUT":
+		body := &adminClient{}
+
+		if error := json.NewDecoder(request.Body).Decode(body); error != nil {
+
+			writeAdminError(writer, http.StatusBadRequest, "invalid_request", "The request body must be a json object.")
+			return
+		}
+
+		if body.ClientId != clientId {
+
+			writeAdminError(writer, http.StatusBadRequest, "invalid_request", "client_id must match the client being updated.")
+			return
+		}
+
+		if body.ClientType != "" && body.ClientType != client.Type {
+
+			writeAdminError(writer, http.StatusBadRequest, "invalid_request", "client_type can not be changed.")
+			return
+		}
+
+		if error := validateResources(body.AllowedResources); error != nil {
+
+			writeAdminError(writer, http.StatusBadRequest, "invalid_request", error.Error())
+			return
+		}
+
+		if publicClientCredentials(client.Type, body.GrantTypes) {
+
+			writeAdminError(writer, http.StatusBadRequest, "invalid_request", "Public clients can not use the client_credentials grant.")
+			return
+		}
+
+		client.Name = body.ClientName
+		client.RedirectUris = body.RedirectUris
+		client.AllowedGrants = body.GrantTypes
+		client.AllowedResources = body.AllowedResources
+		client.AllowedScopes = body.AllowedScopes
+
+		if error := handler.clients.UpdateClient(client); error != nil {
+
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeAdminResponse(writer, http.StatusOK, newAdminClient(client, ""))
+	case "DELETE":
+		if error := handler.clients.DeleteClient(clientId); error != nil {
+
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writer.Header().Set("Allow", "GET, PUT, DELETE")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// clientSecret rotates the secret of a confidential client. The old secret
+// stops working right away.
+func (handler *AdminHandler) clientSecret(writer http.ResponseWriter, request *http.Request, clientId string) {
+
+	if request.Method != "POST" {
+
+		writer.Header().Set("Allow", "POST")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, ok := handler.findClient(writer, clientId)
+
+	if !ok {
+
+		return
+	}
+
+	if client.IsPublic() {
+
+		writeAdminError(writer, http.StatusBadRequest, "invalid_request", "Public clients do not have a secret.")
+		return
+	}
+
+	clientSecret := server.GenerateTokenId()
+
+	if error := handler.clients.SaveClient(client, clientSecret); error != nil {
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeAdminResponse(writer, http.StatusOK, newAdminClient(client, clientSecret))
+}
+
+func (handler *AdminHandler) findClient(writer http.ResponseWriter, clientId string) (*server.Client, bool) {
+
+	if handler.clients == nil {
+
+		writeAdminError(writer, http.StatusNotImplemented, "unsupported", "The client storage can not manage clients.")
+		return nil, false
+	}
+
+	client, _ := handler.clients.FindClientById(clientId)
+
+	if client == nil {
+
+		writeAdminError(writer, http.StatusNotFound, "not_found", fmt.Sprintf("There is no client with id %s.", clientId))
+		return nil, false
+	}
+
+	return client, true
+}
+
+func (handler *AdminHandler) scopeCollection(writer http.ResponseWriter, request *http.Request) {
+
+	if handler.scopes == nil {
+
+		writeAdminError(writer, http.StatusNotImplemented, "unsupported", "The scope storage can not manage scopes.")
+		return
+	}
+
+	switch request.Method {
+	case "GET":
+		scopes, error := handler.scopes.FindAllScopes()
+
+		if error != nil {
+
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		response := make([]*adminScope, 0, len(scopes))
+
+		for _, scope := range scopes {
+
+			response = append(response, &adminScope{scope.Id, scope.Name, scope.Description, scope.Sensitive, scope.Implies})
+		}
+
+		writeAdminResponse(writer, http.StatusOK, map[string]interface{}{"scopes": response})
+	case "POST":
+		body := &adminScope{}
+
+		if error := json.NewDecoder(request.Body).Decode(body); error != nil || body.Name == "" {
+
+			writeAdminError(writer, http.StatusBadRequest, "invalid_request", "The request body must be a json object with a name.")
+			return
+		}
+
+		if body.Id == "" {
+
+			body.Id = body.Name
+		}
+
+		scope := &server.Scope{
+			Id:          body.Id,
+			Name:        body.Name,
+			Description: body.Description,
+			Sensitive:   body.Sensitive,
+			Implies:     body.Implies,
+		}
+
+		if error := handler.scopes.SaveScope(scope); error != nil {
+
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeAdminResponse(writer, http.StatusCreated, body)
+	default:
+		writer.Header().Set("Allow", "GET, POST")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (handler *AdminHandler) scope(writer http.ResponseWriter, request *http.Request, name string) {
+
+	if request.Method != "DELETE" {
+
+		writer.Header().Set("Allow", "DELETE")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if handler.scopes == nil {
+
+		writeAdminError(writer, http.StatusNotImplemented, "unsupported", "The scope storage can not manage scopes.")
+		return
+	}
+
+	if scope, _ := handler.scopes.FindScopeByName(name); scope == nil {
+
+		writeAdminError(writer, http.StatusNotFound, "not_found", fmt.Sprintf("There is no scope named %s.", name))
+		return
+	}
+
+	if error := handler.scopes.DeleteScope(name); error != nil {
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// SetClientManager overrides the client storage of the server, which is
+// needed when the server storages are wrapped for metrics or tracing.
+func (handler *AdminHandler) SetClientManager(clients server.ClientManager) *AdminHandler {
+
+	handler.clients = clients
+	return handler
+}
+
+func (handler *AdminHandler) SetScopeManager(scopes server.ScopeManager) *AdminHandler {
+
+	handler.scopes = scopes
+	return handler
+}
+
+func (handler *AdminHandler) SetSessionQuery(sessionQuery server.SessionQuery) *AdminHandler {
+
+	handler.sessionQuery = sessionQuery
+	return handler
+}
+
+func newAdminClient(client *server.Client, clientSecret string) *adminClient {
+
+	return &adminClient{
+		ClientId:         client.Id,
+		ClientName:       client.Name,
+		ClientType:       client.Type,
+		ClientSecret:     clientSecret,
+		RedirectUris:     client.RedirectUris,
+		GrantTypes:       client.AllowedGrants,
+		AllowedResources: client.AllowedResources,
+		AllowedScopes:    client.AllowedScopes,
+	}
+}
+
+// publicClientCredentials reports whether a public client would be allowed
+// the client_credentials grant explicitly, it has no secret to use it with.
+func publicClientCredentials(clientType server.ClientType, grantTypes []string) bool {
+
+	if clientType != server.PublicClient {
+
+		return false
+	}
+
+	for _, grantType := range grantTypes {
+
+		if grantType == "client_credentials" {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateResources(resources []string) server.OauthError {
+
+	for _, resource := range resources {
+
+		if error := server.ValidateResource(resource); error != nil {
+
+			return error
+		}
+	}
+
+	return nil
+}
+
+func newAdminSession(session *server.Session) *adminSession {
+
+	response := &adminSession{
+		Id:        session.Id,
+		Scope:     scopeString(session),
+		Issuer:    session.Issuer,
+		CreatedAt: unixTime(session.CreatedAt),
+	}
+
+	if session.Client != nil {
+
+		response.ClientId = session.Client.Id
+	}
+
+	if session.Owner != nil {
+
+		response.OwnerId = session.Owner.Id
+	}
+
+	if session.AccessToken != nil {
+
+		response.AccessTokenExpiresAt = unixTime(session.AccessToken.ExpiresAt)
+	}
+
+	if session.RefreshToken != nil {
+
+		response.RefreshTokenExpiresAt = unixTime(session.RefreshToken.ExpiresAt)
+	}
+
+	return response
+}
+
+func writeAdminResponse(writer http.ResponseWriter, status int, response interface{}) {
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(response)
+}
+
+func writeAdminError(writer http.ResponseWriter, status int, code string, description string) {
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(&errorResponse{code, description})
+}
+
+// NewAdminHandler uses the server storages when they can manage clients and
+// scopes and query sessions, the setters can point it at other ones.
+func NewAdminHandler(oauthServer *server.DefaultServer, path string, authorizer AdminAuthorizerFunc) *AdminHandler {
+
+	handler := &AdminHandler{oauthServer, strings.TrimSuffix(path, "/"), authorizer, nil, nil, nil}
+	handler.clients, _ = oauthServer.ClientStorage().(server.ClientManager)
+	handler.scopes, _ = oauthServer.ScopeStorage().(server.ScopeManager)
+	handler.sessionQuery, _ = oauthServer.SessionStorage().(server.SessionQuery)
+	return handler
+}
@@ -0,0 +1,287 @@
+package http
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yjv/goauth2-server/server"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminTokenAuthorizer(t *testing.T) {
+
+	fixture := newTestFixture()
+	handler := NewAdminHandler(fixture.server, "/admin", AdminTokenAuthorizer("admin-token"))
+
+	recorder := serve(handler, newBearerRequest("GET", "/admin/clients", "", nil))
+	assert.Equal(t, 401, recorder.Code)
+	assert.Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+
+	recorder = serve(handler, newBearerRequest("GET", "/admin/clients", "wrong", nil))
+	assert.Equal(t, 401, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+
+	recorder = serve(handler, newBearerRequest("GET", "/admin/clients", "admin-token", nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.Len(t, decodeResponse(t, recorder)["clients"], 2)
+}
+
+func TestAdminScopeAuthorizer(t *testing.T) {
+
+	fixture := newTestFixture()
+	fixture.scopes.Set("admin", &server.Scope{Id: "admin", Name: "admin"})
+	fixture.clients.AddClient("console", "secret", &server.Client{Id: "console", Type: server.ConfidentialClient, AllowedScopes: []string{"admin"}})
+	fixture.clients.AddClient("support", "secret", &server.Client{Id: "support", Type: server.ConfidentialClient, AllowedScopes: []string{"read", "admin"}})
+	fixture.saveSession("console", "", "console", "admin")
+	fixture.saveSession("staff", "alice", "support", "admin")
+	fixture.saveSession("customer", "bob", "support", "admin")
+	fixture.saveSession("unrestricted", "alice", "app", "admin")
+	fixture.saveSession("reader", "alice", "support", "read")
+	handler := NewAdminHandler(
+		fixture.server,
+		"/admin",
+		AdminScopeAuthorizer(NewBearerAuthenticator(fixture.server), "admin", []string{"console", "app"}, []string{"alice"}),
+	)
+
+	recorder := serve(handler, newBearerRequest("GET", "/admin/scopes", "access-reader", nil))
+	assert.Equal(t, 403, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), `scope="admin"`)
+
+	recorder = serve(handler, newBearerRequest("GET", "/admin/scopes", "access-unknown", nil))
+	assert.Equal(t, 401, recorder.Code)
+
+	recorder = serve(handler, newBearerRequest("GET", "/admin/scopes", "access-console", nil))
+	assert.Equal(t, 200, recorder.Code)
+
+	recorder = serve(handler, newBearerRequest("GET", "/admin/scopes", "access-staff", nil))
+	assert.Equal(t, 200, recorder.Code)
+
+	//only the admin clients and owners get in
+	recorder = serve(handler, newBearerRequest("GET", "/admin/scopes", "access-customer", nil))
+	assert.Equal(t, 403, recorder.Code)
+
+	//a client without allowed scopes could have been granted any scope
+	recorder = serve(handler, newBearerRequest("GET", "/admin/scopes", "access-unrestricted", nil))
+	assert.Equal(t, 403, recorder.Code)
+
+	//clients that lose the scope lose access with the tokens they already have
+	fixture.clients.UpdateClient(&server.Client{Id: "console", Type: server.ConfidentialClient, AllowedScopes: []string{"read"}})
+	recorder = serve(handler, newBearerRequest("GET", "/admin/scopes", "access-console", nil))
+	assert.Equal(t, 403, recorder.Code)
+}
+
+func TestAdminHandlerPaths(t *testing.T) {
+
+	fixture := newTestFixture()
+	fixture.clients.AddClient("a/b", "secret", &server.Client{Id: "a/b", Type: server.ConfidentialClient})
+	handler := NewAdminHandler(fixture.server, "/admin/", AdminTokenAuthorizer("admin-token"))
+
+	//escaped slashes belong to the segment they are in
+	recorder := serve(handler, newBearerRequest("GET", "/admin/clients/a%2Fb", "admin-token", nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "a/b", decodeResponse(t, recorder)["client_id"])
+
+	recorder = serve(handler, newBearerRequest("GET", "/admin/clients/app/", "admin-token", nil))
+	assert.Equal(t, 200, recorder.Code)
+
+	for _, path := range []string{
+		"/admin",
+		"/admin/",
+		"/administration/clients",
+		"/admin/unknown",
+		"/admin/clients//secret",
+		"/admin/clients/app/secret/more",
+	} {
+
+		recorder = serve(handler, newBearerRequest("GET", path, "admin-token", nil))
+		assert.Equal(t, 404, recorder.Code, path)
+	}
+
+	recorder = serve(handler, newBearerRequest("GET", "/admin/clients/missing", "admin-token", nil))
+	assertErrorResponse(t, recorder, 404, "not_found")
+}
+
+func TestAdminHandlerSessions(t *testing.T) {
+
+	fixture := newTestFixture()
+	fixture.saveSession("a", "bob", "app", "read")
+	second := fixture.saveSession("b", "bob", "spa", "read")
+	second.CreatedAt = testNow.Add(time.Second)
+	fixture.sessions.SaveSession(second)
+	fixture.saveSession("c", "alice", "app", "read")
+	handler := NewAdminHandler(fixture.server, "/admin", AdminTokenAuthorizer("admin-token"))
+
+	recorder := serve(handler, newBearerRequest("GET", "/admin/sessions?owner_id=bob&limit=1", "admin-token", nil))
+	assert.Equal(t, 200, recorder.Code)
+	response := decodeResponse(t, recorder)
+	assert.Equal(t, "a", response["sessions"].([]interface{})[0].(map[string]interface{})["id"])
+	assert.NotEmpty(t, response["next_cursor"])
+
+	recorder = serve(handler, newBearerRequest("GET", "/admin/sessions?owner_id=bob&limit=1&cursor="+response["next_cursor"].(string), "admin-token", nil))
+	assert.Equal(t, 200, recorder.Code)
+	response = decodeResponse(t, recorder)
+	assert.Equal(t, "b", response["sessions"].([]interface{})[0].(map[string]interface{})["id"])
+	assert.Nil(t, response["next_cursor"])
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=many", "cursor=invalid"} {
+
+		recorder = serve(handler, newBearerRequest("GET", "/admin/sessions?"+query, "admin-token", nil))
+		assertErrorResponse(t, recorder, 400, "invalid_request")
+	}
+
+	recorder = serve(handler, newBearerRequest("GET", "/admin/sessions/c", "admin-token", nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "alice", decodeResponse(t, recorder)["owner_id"])
+
+	recorder = serve(handler, newBearerRequest("PUT", "/admin/sessions", "admin-token", nil))
+	assert.Equal(t, 405, recorder.Code)
+	assert.Equal(t, "GET, DELETE", recorder.Header().Get("Allow"))
+
+	recorder = serve(handler, newBearerRequest("GET", "/admin/sessions/missing", "admin-token", nil))
+	assertErrorResponse(t, recorder, 404, "not_found")
+}
+
+func TestAdminHandlerDeleteSessions(t *testing.T) {
+
+	fixture := newTestFixture()
+	fixture.saveSession("a", "bob", "app", "read")
+	fixture.saveSession("b", "bob", "spa", "read")
+	fixture.saveSession("c", "alice", "app", "read")
+	handler := NewAdminHandler(fixture.server, "/admin", AdminTokenAuthorizer("admin-token"))
+
+	//revoking every session needs a filter so it can not happen by accident
+	recorder := serve(handler, newBearerRequest("DELETE", "/admin/sessions", "admin-token", nil))
+	assertErrorResponse(t, recorder, 400, "invalid_request")
+	count, _ := fixture.sessions.CountActiveSessions()
+	assert.Equal(t, 3, count)
+
+	recorder = serve(handler, newBearerRequest("DELETE", "/admin/sessions?owner_id=bob", "admin-token", nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, float64(2), decodeResponse(t, recorder)["revoked"])
+
+	recorder = serve(handler, newBearerRequest("DELETE", "/admin/sessions/c", "admin-token", nil))
+	assert.Equal(t, 204, recorder.Code)
+	count, _ = fixture.sessions.CountActiveSessions()
+	assert.Equal(t, 0, count)
+}
+
+func TestAdminHandlerCreateClient(t *testing.T) {
+
+	fixture := newTestFixture()
+	handler := NewAdminHandler(fixture.server, "/admin", AdminTokenAuthorizer("admin-token"))
+
+	recorder := serve(handler, newBearerRequest("POST", "/admin/clients", "admin-token", strings.NewReader(`{"client_id":"backend","allowed_scopes":["read"]}`)))
+	assert.Equal(t, 201, recorder.Code)
+	response := decodeResponse(t, recorder)
+	assert.Equal(t, "confidential", response["client_type"])
+	assert.NotEmpty(t, response["client_secret"])
+	client, _ := fixture.clients.FindClientByIdAndSecret("backend", response["client_secret"].(string))
+	assert.Equal(t, []string{"read"}, client.AllowedScopes)
+
+	recorder = serve(handler, newBearerRequest("POST", "/admin/clients", "admin-token", strings.NewReader(`{"client_id":"mobile","client_type":"public"}`)))
+	assert.Equal(t, 201, recorder.Code)
+	assert.Nil(t, decodeResponse(t, recorder)["client_secret"])
+
+	for body, status := range map[string]int{
+		`{"client_name":"nameless"}`: 400,
+		`not json`:                   400,
+		`{"client_id":"app"}`:        409,
+		`{"client_id":"other","client_type":"trusted"}`:                                     400,
+		`{"client_id":"other","client_type":"public","grant_types":["client_credentials"]}`: 400,
+		`{"client_id":"other","allowed_resources":["relative"]}`:                            400,
+	} {
+
+		recorder = serve(handler, newBearerRequest("POST", "/admin/clients", "admin-token", strings.NewReader(body)))
+		assert.Equal(t, status, recorder.Code, body)
+	}
+
+	client, _ = fixture.clients.FindClientById("other")
+	assert.Nil(t, client)
+}
+
+func TestAdminHandlerUpdateClient(t *testing.T) {
+
+	fixture := newTestFixture()
+	handler := NewAdminHandler(fixture.server, "/admin", AdminTokenAuthorizer("admin-token"))
+
+	for path, body := range map[string]string{
+		"/admin/clients/app": `{"client_id":"other"}`,
+		"/admin/clients/spa": `{"client_id":"spa","client_type":"confidential"}`,
+	} {
+
+		recorder := serve(handler, newBearerRequest("PUT", path, "admin-token", strings.NewReader(body)))
+		assertErrorResponse(t, recorder, 400, "invalid_request")
+	}
+
+	recorder := serve(handler, newBearerRequest("PUT", "/admin/clients/spa", "admin-token", strings.NewReader(`{"client_id":"spa","grant_types":["client_credentials"]}`)))
+	assertErrorResponse(t, recorder, 400, "invalid_request")
+
+	//updating keeps the type and secret of the client
+	recorder = serve(handler, newBearerRequest("PUT", "/admin/clients/app", "admin-token", strings.NewReader(`{"client_id":"app","client_name":"App"}`)))
+	assert.Equal(t, 200, recorder.Code)
+	client, _ := fixture.clients.FindClientByIdAndSecret("app", "secret")
+	assert.Equal(t, "App", client.Name)
+	assert.Equal(t, server.ConfidentialClient, client.Type)
+
+	recorder = serve(handler, newBearerRequest("DELETE", "/admin/clients/app", "admin-token", nil))
+	assert.Equal(t, 204, recorder.Code)
+	client, _ = fixture.clients.FindClientById("app")
+	assert.Nil(t, client)
+}
+
+func TestAdminHandlerRotateClientSecret(t *testing.T) {
+
+	fixture := newTestFixture()
+	handler := NewAdminHandler(fixture.server, "/admin", AdminTokenAuthorizer("admin-token"))
+
+	recorder := serve(handler, newBearerRequest("POST", "/admin/clients/app/secret", "admin-token", nil))
+	assert.Equal(t, 200, recorder.Code)
+	clientSecret := decodeResponse(t, recorder)["client_secret"].(string)
+	assert.NotEqual(t, "secret", clientSecret)
+
+	client, _ := fixture.clients.FindClientByIdAndSecret("app", "secret")
+	assert.Nil(t, client)
+	client, _ = fixture.clients.FindClientByIdAndSecret("app", clientSecret)
+	assert.NotNil(t, client)
+
+	recorder = serve(handler, newBearerRequest("POST", "/admin/clients/spa/secret", "admin-token", nil))
+	assertErrorResponse(t, recorder, 400, "invalid_request")
+
+	recorder = serve(handler, newBearerRequest("GET", "/admin/clients/app/secret", "admin-token", nil))
+	assert.Equal(t, 405, recorder.Code)
+}
+
+func TestAdminHandlerScopes(t *testing.T) {
+
+	fixture := newTestFixture()
+	handler := NewAdminHandler(fixture.server, "/admin", AdminTokenAuthorizer("admin-token"))
+
+	recorder := serve(handler, newBearerRequest("POST", "/admin/scopes", "admin-token", strings.NewReader(`{"name":"write","implies":["read"]}`)))
+	assert.Equal(t, 201, recorder.Code)
+	scope, _ := fixture.scopes.FindScopeByName("write")
+	assert.Equal(t, &server.Scope{Id: "write", Name: "write", Implies: []string{"read"}}, scope)
+
+	recorder = serve(handler, newBearerRequest("POST", "/admin/scopes", "admin-token", strings.NewReader(`{"description":"nameless"}`)))
+	assertErrorResponse(t, recorder, 400, "invalid_request")
+
+	recorder = serve(handler, newBearerRequest("DELETE", "/admin/scopes/write", "admin-token", nil))
+	assert.Equal(t, 204, recorder.Code)
+
+	recorder = serve(handler, newBearerRequest("DELETE", "/admin/scopes/write", "admin-token", nil))
+	assertErrorResponse(t, recorder, 404, "not_found")
+}
+
+func TestAdminHandlerWithoutManagers(t *testing.T) {
+
+	fixture := newTestFixture()
+	handler := NewAdminHandler(fixture.server, "/admin", AdminTokenAuthorizer("admin-token")).
+		SetClientManager(nil).
+		SetScopeManager(nil).
+		SetSessionQuery(nil)
+
+	for _, path := range []string{"/admin/clients", "/admin/clients/app", "/admin/scopes", "/admin/sessions", "/admin/sessions/a"} {
+
+		recorder := serve(handler, newBearerRequest("GET", path, "admin-token", nil))
+		assertErrorResponse(t, recorder, 501, "unsupported")
+	}
+}
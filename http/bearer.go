@@ -0,0 +1,445 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"github.com/yjv/goauth2-server/jwt"
+	"github.com/yjv/goauth2-server/server"
+	"net/http"
+	"strings"
+)
+
+const (
+	BearerInvalidRequest    = "invalid_request"
+	BearerInvalidToken      = "invalid_token"
+	BearerInsufficientScope = "insufficient_scope"
+	DPoPInvalidProof        = "invalid_dpop_proof"
+	DPoPUseNonce            = "use_dpop_nonce"
+)
+
+// BearerError is a failed authentication of a request to a resource server.
+// Errors about DPoP proofs are challenged with the DPoP scheme from RFC 9449
+// section 7.1 instead of Bearer.
+type BearerError struct {
+	status      int
+	code        string
+	description string
+	scope       string
+	scheme      string
+	nonce       string
+}
+
+func (error *BearerError) Error() string {
+
+	if error.code == "" {
+
+		return "The request is missing a bearer token."
+	}
+
+	return fmt.Sprintf("%s: %s", error.code, error.description)
+}
+
+func (error *BearerError) Status() int {
+
+	return error.status
+}
+
+func (error *BearerError) Code() string {
+
+	return error.code
+}
+
+// WriteResponse sets the WWW-Authenticate challenge from RFC 6750 section 3
+// and writes the matching status code
+func (error *BearerError) WriteResponse(writer http.ResponseWriter, realm string) {
+
+	params := []string{}
+
+	if realm != "" {
+
+		params = append(params, fmt.Sprintf("realm=%q", realm))
+	}
+
+	if error.code != "" {
+
+		params = append(params, fmt.Sprintf("error=%q", error.code))
+	}
+
+	if error.description != "" {
+
+		params = append(params, fmt.Sprintf("error_description=%q", error.description))
+	}
+
+	if error.scope != "" {
+
+		params = append(params, fmt.Sprintf("scope=%q", error.scope))
+	}
+
+	challenge := "Bearer"
+
+	if error.scheme != "" {
+
+		challenge = error.scheme
+	}
+
+	if challenge == "DPoP" {
+
+		params = append(params, fmt.Sprintf("algs=%q", strings.Join(jwt.DPoPAlgorithms, " ")))
+	}
+
+	if error.nonce != "" {
+
+		writer.Header().Set("DPoP-Nonce", error.nonce)
+	}
+
+	if len(params) > 0 {
+
+		challenge += " " + strings.Join(params, ", ")
+	}
+
+	writer.Header().Set("WWW-Authenticate", challenge)
+	writer.WriteHeader(error.status)
+}
+
+func NewMissingBearerTokenError() *BearerError {
+
+	return &BearerError{http.StatusUnauthorized, "", "", "", "", ""}
+}
+
+func NewInvalidBearerRequestError(description string) *BearerError {
+
+	return &BearerError{http.StatusBadRequest, BearerInvalidRequest, description, "", "", ""}
+}
+
+func NewInvalidBearerTokenError(description string) *BearerError {
+
+	return &BearerError{http.StatusUnauthorized, BearerInvalidToken, description, "", "", ""}
+}
+
+func NewInsufficientScopeError(scope string) *BearerError {
+
+	return &BearerError{
+		http.StatusForbidden,
+		BearerInsufficientScope,
+		"The access token does not have the required scope.",
+		scope,
+		"",
+		"",
+	}
+}
+
+func NewInvalidDPoPProofError(description string) *BearerError {
+
+	return &BearerError{http.StatusUnauthorized, DPoPInvalidProof, description, "", "DPoP", ""}
+}
+
+// NewUseDPoPNonceError asks the client to retry with a proof signed over the
+// nonce, which is sent in the DPoP-Nonce header.
+func NewUseDPoPNonceError(nonce string) *BearerError {
+
+	return &BearerError{
+		http.StatusUnauthorized,
+		DPoPUseNonce,
+		"The DPoP proof must carry the nonce from the DPoP-Nonce header.",
+		"",
+		"DPoP",
+		nonce,
+	}
+}
+
+// DPoPToken reads the access token from an Authorization header using the
+// DPoP scheme from RFC 9449 section 7.1, ok is false for any other scheme.
+func DPoPToken(request *http.Request) (string, bool) {
+
+	header := request.Header.Get("Authorization")
+
+	if len(header) < 5 || !strings.EqualFold(header[:5], "DPoP ") {
+
+		return "", false
+	}
+
+	return strings.TrimSpace(header[5:]), true
+}
+
+// BearerToken reads the access token from the Authorization header or from a
+// form encoded POST body. Sending it both ways is an invalid request.
+func BearerToken(request *http.Request) (string, *BearerError) {
+
+	header := request.Header.Get("Authorization")
+	headerToken := ""
+
+	if header != "" {
+
+		if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+
+			return "", NewInvalidBearerRequestError("The Authorization header must use the Bearer scheme.")
+		}
+
+		headerToken = strings.TrimSpace(header[7:])
+	}
+
+	bodyToken := ""
+
+	if request.Method == "POST" && strings.HasPrefix(request.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+
+		if error := request.ParseForm(); error != nil {
+
+			return "", NewInvalidBearerRequestError("The request body could not be parsed.")
+		}
+
+		bodyToken = request.PostForm.Get("access_token")
+	}
+
+	if headerToken != "" && bodyToken != "" {
+
+		return "", NewInvalidBearerRequestError("The access token must only be sent one way.")
+	}
+
+	if headerToken != "" {
+
+		return headerToken, nil
+	}
+
+	if bodyToken != "" {
+
+		return bodyToken, nil
+	}
+
+	return "", NewMissingBearerTokenError()
+}
+
+// BearerAuthenticator authenticates requests to resource servers by their
+// access token. With a DPoP validator it also accepts DPoP bound tokens sent
+// with the DPoP scheme and a proof signed by the key they are bound to.
+type BearerAuthenticator struct {
+	server        server.Server
+	realm         string
+	auditSink     server.AuditSink
+	audience      string
+	dpopValidator *jwt.DPoPValidator
+	dpopBaseUrl   string
+}
+
+func (authenticator *BearerAuthenticator) Authenticate(request *http.Request) (*server.Session, *BearerError) {
+
+	accessToken, isDPoP := DPoPToken(request)
+	var bearerError *BearerError
+
+	if !isDPoP {
+
+		accessToken, bearerError = BearerToken(request)
+	}
+
+	if bearerError != nil {
+
+		authenticator.audit(request, "", bearerError)
+		return nil, bearerError
+	}
+
+	session, _ := authenticator.server.SessionStorage().FindSessionByAccessToken(accessToken)
+
+	//tokens from another tenant sharing the storage are not valid here
+	if session == nil || session.Issuer != authenticator.server.Config().Issuer {
+
+		bearerError = NewInvalidBearerTokenError("The access token is invalid or expired.")
+		authenticator.audit(request, accessToken, bearerError)
+		return nil, bearerError
+	}
+
+	//a token meant for another resource must not be replayed here
+	if authenticator.audience != "" && !session.HasAudience(authenticator.audience) {
+
+		bearerError = NewInvalidBearerTokenError("The access token is not meant for this resource.")
+		authenticator.audit(request, accessToken, bearerError)
+		return nil, bearerError
+	}
+
+	if isDPoP || session.IsDPoPBound() {
+
+		if bearerError = authenticator.checkDPoPProof(request, accessToken, session, isDPoP); bearerError != nil {
+
+			authenticator.audit(request, accessToken, bearerError)
+			return nil, bearerError
+		}
+	}
+
+	return session, nil
+}
+
+// checkDPoPProof makes sure a DPoP bound access token is only used with a
+// proof signed by the key it is bound to, RFC 9449 section 7.
+func (authenticator *BearerAuthenticator) checkDPoPProof(request *http.Request, accessToken string, session *server.Session, isDPoP bool) *BearerError {
+
+	//a bound token sent as a bearer token could be replayed by anyone who got hold of it
+	if !isDPoP {
+
+		return NewInvalidBearerTokenError("The access token is bound to a key and must be sent with the DPoP scheme.")
+	}
+
+	if !session.IsDPoPBound() {
+
+		return NewInvalidBearerTokenError("The access token is not bound to a key and must be sent with the Bearer scheme.")
+	}
+
+	if authenticator.dpopValidator == nil {
+
+		return NewInvalidBearerTokenError("DPoP bound access tokens are not accepted.")
+	}
+
+	proofs := request.Header.Values("DPoP")
+
+	if len(proofs) != 1 {
+
+		return NewInvalidDPoPProofError("The request must carry exactly one DPoP proof.")
+	}
+
+	proof, oauthError := authenticator.dpopValidator.Validate(proofs[0], request.Method, authenticator.dpopBaseUrl+request.URL.EscapedPath(), accessToken)
+
+	if nonceError, ok := oauthError.(*server.UseDPoPNonceError); ok {
+
+		return NewUseDPoPNonceError(nonceError.Nonce())
+	}
+
+	if oauthError != nil {
+
+		return NewInvalidDPoPProofError(oauthError.Error())
+	}
+
+	if proof.Thumbprint != session.DPoPThumbprint {
+
+		return NewInvalidDPoPProofError("The DPoP proof is not signed by the key the access token is bound to.")
+	}
+
+	return nil
+}
+
+// AuthenticateScopes authenticates the request like Authenticate and also
+// requires the access token to satisfy every scope, directly, through a
+// scope implying it or through a matching pattern scope.
+func (authenticator *BearerAuthenticator) AuthenticateScopes(request *http.Request, scopes ...string) (*server.Session, *BearerError) {
+
+	session, bearerError := authenticator.Authenticate(request)
+
+	if bearerError != nil {
+
+		return nil, bearerError
+	}
+
+	if !server.NewScopeMatcher(authenticator.server.ScopeStorage()).Satisfies(session.Scopes, scopes...) {
+
+		return nil, NewInsufficientScopeError(strings.Join(scopes, " "))
+	}
+
+	return session, nil
+}
+
+func (authenticator *BearerAuthenticator) audit(request *http.Request, accessToken string, bearerError *BearerError) {
+
+	//requests without any credentials are not failed authentications
+	if authenticator.auditSink == nil || bearerError.Code() == "" {
+
+		return
+	}
+
+	record := &server.AuditRecord{
+		Time:             authenticator.server.Clock().Now(),
+		Event:            "bearer_authentication_failed",
+		SourceAddress:    remoteAddress(request),
+		ErrorCode:        bearerError.Code(),
+		ErrorDescription: bearerError.Error(),
+	}
+
+	if accessToken != "" {
+
+		record.TokenHash = server.HashToken(accessToken)
+	}
+
+	go authenticator.auditSink.Record(record)
+}
+
+// SetAuditSink records every failed bearer authentication in the sink.
+func (authenticator *BearerAuthenticator) SetAuditSink(auditSink server.AuditSink) *BearerAuthenticator {
+
+	authenticator.auditSink = auditSink
+	return authenticator
+}
+
+func (authenticator *BearerAuthenticator) Realm() string {
+
+	return authenticator.realm
+}
+
+func (authenticator *BearerAuthenticator) SetRealm(realm string) *BearerAuthenticator {
+
+	authenticator.realm = realm
+	return authenticator
+}
+
+func (authenticator *BearerAuthenticator) Audience() string {
+
+	return authenticator.audience
+}
+
+// SetAudience only accepts access tokens issued for the resource, the uri
+// clients send as the resource parameter from RFC 8707. Tokens issued without
+// an audience are refused as well. Empty accepts any token.
+func (authenticator *BearerAuthenticator) SetAudience(audience string) *BearerAuthenticator {
+
+	authenticator.audience = audience
+	return authenticator
+}
+
+func (authenticator *BearerAuthenticator) DPoPValidator() *jwt.DPoPValidator {
+
+	return authenticator.dpopValidator
+}
+
+// SetDPoPValidator accepts DPoP bound access tokens. The htu of their proofs
+// is checked against the base url, the scheme and host the resource server
+// is reached at, followed by the path of the request.
+func (authenticator *BearerAuthenticator) SetDPoPValidator(validator *jwt.DPoPValidator, baseUrl string) *BearerAuthenticator {
+
+	authenticator.dpopValidator = validator
+	authenticator.dpopBaseUrl = strings.TrimSuffix(baseUrl, "/")
+	return authenticator
+}
+
+func NewBearerAuthenticator(oauthServer server.Server) *BearerAuthenticator {
+
+	return &BearerAuthenticator{oauthServer, "", nil, "", nil, ""}
+}
+
+type bearerSessionContextKey struct{}
+
+func ContextWithBearerSession(ctx context.Context, session *server.Session) context.Context {
+
+	return context.WithValue(ctx, bearerSessionContextKey{}, session)
+}
+
+func BearerSessionFromContext(ctx context.Context) (*server.Session, bool) {
+
+	session, ok := ctx.Value(bearerSessionContextKey{}).(*server.Session)
+	return session, ok
+}
+
+// RequireScopes returns middleware that only lets requests through to the
+// handler that carry an access token satisfying every scope. The session of
+// the token is put in the request context.
+func RequireScopes(authenticator *BearerAuthenticator, scopes ...string) func(http.Handler) http.Handler {
+
+	return func(handler http.Handler) http.Handler {
+
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+
+			session, bearerError := authenticator.AuthenticateScopes(request, scopes...)
+
+			if bearerError != nil {
+
+				bearerError.WriteResponse(writer, authenticator.Realm())
+				return
+			}
+
+			handler.ServeHTTP(writer, request.WithContext(ContextWithBearerSession(request.Context(), session)))
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package http
+
+import (
+	"fmt"
+	"github.com/yjv/goauth2-server/server"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type ownerConsent struct {
+	ClientId  string `json:"client_id"`
+	Scope     string `json:"scope"`
+	GrantedAt int64  `json:"granted_at"`
+	UpdatedAt int64  `json:"updated_at"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+type revokedConsent struct {
+	ClientId        string `json:"client_id"`
+	RevokedSessions int    `json:"revoked_sessions"`
+}
+
+// ConsentHandler lets owners see and take back what they approved, using an
+// access token granted to them and the scope:
+//
+//	GET    {path}
+//	DELETE {path}/{client_id}
+//
+// Revoking a consent also revokes the sessions issued on the strength of it.
+type ConsentHandler struct {
+	authenticator *BearerAuthenticator
+	manager       *server.ConsentManager
+	path          string
+	scope         string
+}
+
+func (handler *ConsentHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	session, bearerError := handler.authenticator.AuthenticateScopes(request, handler.scope)
+
+	if bearerError != nil {
+
+		bearerError.WriteResponse(writer, handler.authenticator.Realm())
+		return
+	}
+
+	//clients acting on their own behalf have an owner with their id, which could be a real owner's id
+	if session.Owner == nil || session.Owner.FromClient {
+
+		NewInvalidBearerTokenError("The access token was not granted by an owner.").WriteResponse(writer, handler.authenticator.Realm())
+		return
+	}
+
+	path := strings.TrimSuffix(request.URL.EscapedPath(), "/")
+
+	if path == handler.path {
+
+		handler.consents(writer, request, session.Owner)
+		return
+	}
+
+	clientId, error := url.PathUnescape(strings.TrimPrefix(path, handler.path+"/"))
+
+	if !strings.HasPrefix(path, handler.path+"/") || error != nil || clientId == "" || strings.Contains(clientId, "/") {
+
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	handler.consent(writer, request, session.Owner, clientId)
+}
+
+func (handler *ConsentHandler) consents(writer http.ResponseWriter, request *http.Request, owner *server.Owner) {
+
+	if request.Method != "GET" {
+
+		writer.Header().Set("Allow", "GET")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	consents, error := handler.manager.Consents(owner.Id)
+
+	if error != nil {
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]*ownerConsent, 0, len(consents))
+
+	for _, consent := range consents {
+
+		response = append(response, &ownerConsent{
+			ClientId:  consent.ClientId,
+			Scope:     strings.Join(consent.Scopes, " "),
+			GrantedAt: unixTime(consent.GrantedAt),
+			UpdatedAt: unixTime(consent.UpdatedAt),
+			ExpiresAt: unixTime(consent.ExpiresAt),
+		})
+	}
+
+	writeAdminResponse(writer, http.StatusOK, response)
+}
+
+func (handler *ConsentHandler) consent(writer http.ResponseWriter, request *http.Request, owner *server.Owner, clientId string) {
+
+	if request.Method != "DELETE" {
+
+		writer.Header().Set("Allow", "DELETE")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	consent := handler.manager.FindConsent(owner.Id, clientId)
+
+	if consent == nil {
+
+		writeAdminError(writer, http.StatusNotFound, "not_found", fmt.Sprintf("There is no consent for client %s.", clientId))
+		return
+	}
+
+	revoked, error := handler.manager.Revoke(consent)
+
+	if error != nil {
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeAdminResponse(writer, http.StatusOK, &revokedConsent{clientId, revoked})
+}
+
+func NewConsentHandler(oauthServer server.Server, manager *server.ConsentManager, path string, scope string) *ConsentHandler {
+
+	return NewConsentHandlerWithAuthenticator(NewBearerAuthenticator(oauthServer), manager, path, scope)
+}
+
+func NewConsentHandlerWithAuthenticator(authenticator *BearerAuthenticator, manager *server.ConsentManager, path string, scope string) *ConsentHandler {
+
+	return &ConsentHandler{authenticator, manager, strings.TrimSuffix(path, "/"), scope}
+}
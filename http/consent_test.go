@@ -0,0 +1,75 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/yjv/goauth2-server/server"
+	"github.com/yjv/goauth2-server/storage/memory"
+	"testing"
+)
+
+func TestConsentHandler(t *testing.T) {
+
+	fixture := newTestFixture()
+	manager := server.NewConsentManager(fixture.server, memory.NewConsentStorage(), fixture.sessions)
+	owner := &server.Owner{Id: "bob"}
+	app, _ := fixture.clients.FindClientById("app")
+	spa, _ := fixture.clients.FindClientById("spa")
+	consent, _ := manager.Approve(owner, app, []string{"read"})
+	manager.Approve(owner, spa, []string{"openid"})
+
+	linked := fixture.saveSession("linked", "bob", "app", "read")
+	linked.ConsentId = consent.Id
+	fixture.sessions.SaveSession(linked)
+	fixture.saveSession("unlinked", "bob", "app", "read")
+	fixture.saveSession("consents", "bob", "spa", "consents")
+	fixture.saveSession("reader", "bob", "spa", "read")
+	fixture.saveSession("ownerless", "", "spa", "consents")
+	fixture.clients.AddClient("bob", "secret", &server.Client{Id: "bob", Type: server.ConfidentialClient})
+	impersonating := fixture.saveSession("impersonating", "", "bob", "consents")
+	impersonating.Owner = server.NewOwnerFromClient(impersonating.Client)
+	fixture.sessions.SaveSession(impersonating)
+	handler := NewConsentHandler(fixture.server, manager, "/consents", "consents")
+
+	recorder := serve(handler, newBearerRequest("GET", "/consents", "", nil))
+	assert.Equal(t, 401, recorder.Code)
+
+	recorder = serve(handler, newBearerRequest("GET", "/consents", "access-reader", nil))
+	assert.Equal(t, 403, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), `scope="consents"`)
+
+	recorder = serve(handler, newBearerRequest("GET", "/consents", "access-ownerless", nil))
+	assert.Equal(t, 401, recorder.Code)
+
+	//a client named like an owner does not get to the owner's consents
+	recorder = serve(handler, newBearerRequest("GET", "/consents", "access-impersonating", nil))
+	assert.Equal(t, 401, recorder.Code)
+
+	recorder = serve(handler, newBearerRequest("GET", "/consents/", "access-consents", nil))
+	assert.Equal(t, 200, recorder.Code)
+	consents := []map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &consents))
+	assert.Len(t, consents, 2)
+
+	for _, path := range []string{"/consents/app/more", "/consents/a%2Fb", "/consentsapp"} {
+
+		recorder = serve(handler, newBearerRequest("DELETE", path, "access-consents", nil))
+		assert.Equal(t, 404, recorder.Code, path)
+	}
+
+	recorder = serve(handler, newBearerRequest("DELETE", "/consents/unknown", "access-consents", nil))
+	assertErrorResponse(t, recorder, 404, "not_found")
+
+	recorder = serve(handler, newBearerRequest("GET", "/consents/app", "access-consents", nil))
+	assert.Equal(t, 405, recorder.Code)
+
+	//only the sessions issued on the strength of the consent are revoked
+	recorder = serve(handler, newBearerRequest("DELETE", "/consents/app", "access-consents", nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, map[string]interface{}{"client_id": "app", "revoked_sessions": float64(1)}, decodeResponse(t, recorder))
+	session, _ := fixture.sessions.FindSessionByAccessToken("access-linked")
+	assert.Nil(t, session)
+	session, _ = fixture.sessions.FindSessionByAccessToken("access-unlinked")
+	assert.NotNil(t, session)
+	assert.Nil(t, manager.FindConsent("bob", "app"))
+}
@@ -1,16 +1,21 @@
 package http
 
 import (
+	"context"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 type RequestFormOauthSessionRequest struct {
-	request *http.Request
+	request        *http.Request
+	dpopThumbprint string
 }
 
 func NewRequestFormOauthSessionRequest(request *http.Request) *RequestFormOauthSessionRequest {
 
-	return &RequestFormOauthSessionRequest{request}
+	return &RequestFormOauthSessionRequest{request, ""}
 }
 
 func (request *RequestFormOauthSessionRequest) Get(name string) []string {
@@ -18,6 +23,12 @@ func (request *RequestFormOauthSessionRequest) Get(name string) []string {
 	request.parseRequestForm()
 	value, ok := request.request.Form[name]
 
+	//RFC 6749 sends the scopes space separated in a single scope parameter
+	if !ok && name == "scopes" && request.request.Form.Get("scope") != "" {
+
+		return strings.Fields(request.request.Form.Get("scope"))
+	}
+
 	if !ok {
 		return []string{}
 	}
@@ -29,13 +40,72 @@ func (request *RequestFormOauthSessionRequest) GetFirst(name string) (string, bo
 
 	request.parseRequestForm()
 	_, ok := request.request.Form[name]
+
+	//RFC 6749 section 2.3.1 lets clients send their credentials with basic auth
+	if !ok && (name == "client_id" || name == "client_secret") {
+
+		return request.basicAuthCredential(name)
+	}
+
 	return request.request.Form.Get(name), ok
 }
 
+func (request *RequestFormOauthSessionRequest) basicAuthCredential(name string) (string, bool) {
+
+	clientId, clientSecret, ok := request.request.BasicAuth()
+
+	if !ok {
+
+		return "", false
+	}
+
+	value := clientId
+
+	if name == "client_secret" {
+
+		value = clientSecret
+	}
+
+	value, error := url.QueryUnescape(value)
+	return value, error == nil
+}
+
 func (request *RequestFormOauthSessionRequest) Grant() string {
 
 	request.parseRequestForm()
-	return request.request.Form.Get("grant")
+
+	if grant := request.request.Form.Get("grant"); grant != "" {
+
+		return grant
+	}
+
+	return request.request.Form.Get("grant_type")
+}
+
+// RemoteAddress is the ip the request came from, forwarding headers are not
+// trusted.
+func (request *RequestFormOauthSessionRequest) RemoteAddress() string {
+
+	return remoteAddress(request.request)
+}
+
+// Context carries the trace context from the request headers.
+func (request *RequestFormOauthSessionRequest) Context() context.Context {
+
+	return TraceContext(request.request)
+}
+
+// DPoPThumbprint is the thumbprint of the key that signed the DPoP proof of
+// the request, the token handler sets it once the proof was validated.
+func (request *RequestFormOauthSessionRequest) DPoPThumbprint() string {
+
+	return request.dpopThumbprint
+}
+
+func (request *RequestFormOauthSessionRequest) SetDPoPThumbprint(thumbprint string) *RequestFormOauthSessionRequest {
+
+	request.dpopThumbprint = thumbprint
+	return request
 }
 
 func (request *RequestFormOauthSessionRequest) parseRequestForm() {
@@ -47,3 +117,15 @@ func (request *RequestFormOauthSessionRequest) parseRequestForm() {
 		panic("The request params couldnt be parsed")
 	}
 }
+
+func remoteAddress(request *http.Request) string {
+
+	host, _, error := net.SplitHostPort(request.RemoteAddr)
+
+	if error != nil {
+
+		return request.RemoteAddr
+	}
+
+	return host
+}
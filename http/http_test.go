@@ -0,0 +1,129 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/yjv/goauth2-server/server"
+	"github.com/yjv/goauth2-server/storage/memory"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Now()
+
+// testFixture is a server over memory storages with a confidential client
+// app, a public client spa and the read and openid scopes.
+type testFixture struct {
+	server   *server.DefaultServer
+	clients  *memory.OwnerClientStorage
+	sessions *memory.SessionStorage
+	scopes   *memory.ScopeStorage
+}
+
+// saveSession stores a session whose access token is access-{id} and refresh
+// token refresh-{id}.
+func (fixture *testFixture) saveSession(id string, ownerId string, clientId string, scopes ...string) *server.Session {
+
+	session := server.NewSession()
+	session.Id = id
+	session.Client, _ = fixture.clients.FindClientById(clientId)
+	session.CreatedAt = testNow
+	session.Issuer = fixture.server.Config().Issuer
+	session.AccessToken = server.NewToken("access-"+id, testNow, time.Hour)
+	session.RefreshToken = server.NewToken("refresh-"+id, testNow, 24*time.Hour)
+
+	if ownerId != "" {
+
+		session.Owner = &server.Owner{Id: ownerId, Name: ownerId}
+	}
+
+	for _, scope := range scopes {
+
+		session.Scopes[scope] = &server.Scope{Id: scope, Name: scope}
+	}
+
+	fixture.sessions.SaveSession(session)
+	return session
+}
+
+func newTestFixture() *testFixture {
+
+	fixture := &testFixture{
+		clients:  memory.NewOwnerClientStorage(),
+		sessions: memory.NewSessionStorage(),
+		scopes:   memory.NewScopeStorage(),
+	}
+	fixture.server = server.New(fixture.clients, fixture.clients, fixture.sessions, fixture.scopes)
+	fixture.server.AddGrant(&server.ClientCredentialsGrant{})
+	fixture.server.AddGrant(&server.RefreshTokenGrant{})
+	fixture.clients.AddClient("app", "secret", &server.Client{Id: "app", Type: server.ConfidentialClient})
+	fixture.clients.AddClient("spa", "", &server.Client{Id: "spa", Type: server.PublicClient})
+	fixture.scopes.Set("read", &server.Scope{Id: "read", Name: "read"})
+	fixture.scopes.Set("openid", &server.Scope{Id: "openid", Name: "openid"})
+	return fixture
+}
+
+func newBearerRequest(method string, target string, token string, body io.Reader) *http.Request {
+
+	request := httptest.NewRequest(method, target, body)
+
+	if token != "" {
+
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return request
+}
+
+func newFormRequest(target string, form url.Values) *http.Request {
+
+	request := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return request
+}
+
+func serve(handler http.Handler, request *http.Request) *httptest.ResponseRecorder {
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func decodeResponse(t *testing.T, recorder *httptest.ResponseRecorder) map[string]interface{} {
+
+	response := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response), recorder.Body.String())
+	return response
+}
+
+func assertErrorResponse(t *testing.T, recorder *httptest.ResponseRecorder, status int, code string) {
+
+	assert.Equal(t, status, recorder.Code, recorder.Body.String())
+	assert.Equal(t, code, decodeResponse(t, recorder)["error"])
+}
+
+func TestRequestFormOauthSessionRequest(t *testing.T) {
+
+	request := newFormRequest("/token?grant_type=ignored", url.Values{"grant_type": {"client_credentials"}, "scope": {"read"}})
+	request.SetBasicAuth("app", "secret")
+	oauthSessionRequest := NewRequestFormOauthSessionRequest(request)
+
+	assert.Equal(t, "client_credentials", oauthSessionRequest.Grant())
+	assert.Equal(t, []string{"read"}, oauthSessionRequest.Get("scopes"))
+
+	clientId, ok := oauthSessionRequest.GetFirst("client_id")
+	assert.True(t, ok)
+	assert.Equal(t, "app", clientId)
+
+	clientSecret, ok := oauthSessionRequest.GetFirst("client_secret")
+	assert.True(t, ok)
+	assert.Equal(t, "secret", clientSecret)
+
+	_, ok = oauthSessionRequest.GetFirst("missing")
+	assert.False(t, ok)
+}
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/yjv/goauth2-server/server"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type introspectionResponse struct {
+	Active               bool                         `json:"active"`
+	Scope                string                       `json:"scope,omitempty"`
+	ClientId             string                       `json:"client_id,omitempty"`
+	Username             string                       `json:"username,omitempty"`
+	TokenType            string                       `json:"token_type,omitempty"`
+	ExpiresAt            int64                        `json:"exp,omitempty"`
+	IssuedAt             int64                        `json:"iat,omitempty"`
+	Subject              string                       `json:"sub,omitempty"`
+	Issuer               string                       `json:"iss,omitempty"`
+	Audience             []string                     `json:"aud,omitempty"`
+	AuthorizationDetails []server.AuthorizationDetail `json:"authorization_details,omitempty"`
+	Confirmation         map[string]string            `json:"cnf,omitempty"`
+}
+
+// IntrospectionHandler serves the token introspection endpoint from RFC 7662.
+// Any authenticated client may introspect, resource servers are expected to
+// be registered as clients.
+type IntrospectionHandler struct {
+	server server.Server
+}
+
+func (handler *IntrospectionHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != "POST" {
+
+		writer.Header().Set("Allow", "POST")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	oauthSessionRequest := NewRequestFormOauthSessionRequest(request)
+
+	if _, error := server.AuthenticateClient(oauthSessionRequest, handler.server); error != nil {
+
+		writeClientAuthenticationError(writer, error)
+		return
+	}
+
+	token, ok := oauthSessionRequest.GetFirst("token")
+
+	if !ok {
+
+		writeTokenErrorResponse(writer, http.StatusBadRequest, "invalid_request", "The token parameter is required.")
+		return
+	}
+
+	response := &introspectionResponse{}
+	hint, _ := oauthSessionRequest.GetFirst("token_type_hint")
+	session, found := findSessionByToken(handler.server.SessionStorage(), token, hint)
+	now := handler.server.Clock().Now()
+
+	if session != nil && session.Issuer == handler.server.Config().Issuer && !found.IsExpired(now) {
+
+		response = &introspectionResponse{
+			Active:               true,
+			Scope:                scopeString(session),
+			TokenType:            "Bearer",
+			IssuedAt:             found.IssuedAt.Unix(),
+			Issuer:               session.Issuer,
+			Audience:             session.Audience,
+			AuthorizationDetails: session.AuthorizationDetails,
+		}
+
+		//RFC 9449 section 6.2, resource servers check the proof against the jkt
+		if session.IsDPoPBound() {
+
+			response.TokenType = "DPoP"
+			response.Confirmation = map[string]string{"jkt": session.DPoPThumbprint}
+		}
+
+		if found == session.RefreshToken {
+
+			response.TokenType = "refresh_token"
+		}
+
+		if !found.ExpiresAt.IsZero() {
+
+			response.ExpiresAt = found.ExpiresAt.Unix()
+		}
+
+		if session.Client != nil {
+
+			response.ClientId = session.Client.Id
+		}
+
+		if session.Owner != nil {
+
+			response.Subject = session.Owner.Id
+			response.Username = session.Owner.Name
+		}
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(writer).Encode(response)
+}
+
+func NewIntrospectionHandler(oauthServer server.Server) *IntrospectionHandler {
+
+	return &IntrospectionHandler{oauthServer}
+}
+
+// findSessionByToken looks the token up as the hinted type first as RFC 7009
+// and RFC 7662 ask, then as the other type. It returns the session along with
+// the token that matched.
+func findSessionByToken(storage server.SessionStorage, token string, hint string) (*server.Session, *server.Token) {
+
+	findByAccessToken := func() (*server.Session, *server.Token) {
+
+		if session, _ := storage.FindSessionByAccessToken(token); session != nil {
+
+			return session, session.AccessToken
+		}
+
+		return nil, nil
+	}
+	findByRefreshToken := func() (*server.Session, *server.Token) {
+
+		if session, _ := storage.FindSessionByRefreshToken(token); session != nil && session.RefreshToken != nil {
+
+			return session, session.RefreshToken
+		}
+
+		return nil, nil
+	}
+
+	first, second := findByAccessToken, findByRefreshToken
+
+	if hint == "refresh_token" {
+
+		first, second = findByRefreshToken, findByAccessToken
+	}
+
+	if session, found := first(); session != nil {
+
+		return session, found
+	}
+
+	return second()
+}
+
+func scopeString(session *server.Session) string {
+
+	scopes := make([]string, 0, len(session.Scopes))
+
+	for scopeName := range session.Scopes {
+		scopes = append(scopes, scopeName)
+	}
+
+	sort.Strings(scopes)
+	return strings.Join(scopes, " ")
+}
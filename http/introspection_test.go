@@ -0,0 +1,56 @@
+package http
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+)
+
+func TestIntrospectionHandler(t *testing.T) {
+
+	fixture := newTestFixture()
+	session := fixture.saveSession("session", "bob", "spa", "read", "openid")
+	session.Audience = []string{"https://api.example.com"}
+	session.DPoPThumbprint = "thumbprint"
+	fixture.sessions.SaveSession(session)
+	handler := NewIntrospectionHandler(fixture.server)
+
+	recorder := serve(handler, newFormRequest("/introspect", url.Values{"client_id": {"app"}, "client_secret": {"wrong"}, "token": {"access-session"}}))
+	assertErrorResponse(t, recorder, 401, "invalid_client")
+
+	recorder = serve(handler, newFormRequest("/introspect", url.Values{"client_id": {"app"}, "client_secret": {"secret"}}))
+	assertErrorResponse(t, recorder, 400, "invalid_request")
+
+	recorder = serve(handler, newFormRequest("/introspect", url.Values{"client_id": {"app"}, "client_secret": {"secret"}, "token": {"unknown"}}))
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, map[string]interface{}{"active": false}, decodeResponse(t, recorder))
+
+	//any authenticated client may introspect tokens of other clients
+	recorder = serve(handler, newFormRequest("/introspect", url.Values{"client_id": {"app"}, "client_secret": {"secret"}, "token": {"access-session"}}))
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	response := decodeResponse(t, recorder)
+	assert.Equal(t, true, response["active"])
+	assert.Equal(t, "openid read", response["scope"])
+	assert.Equal(t, "spa", response["client_id"])
+	assert.Equal(t, "bob", response["sub"])
+	assert.Equal(t, "DPoP", response["token_type"])
+	assert.Equal(t, []interface{}{"https://api.example.com"}, response["aud"])
+	assert.Equal(t, map[string]interface{}{"jkt": "thumbprint"}, response["cnf"])
+
+	recorder = serve(handler, newFormRequest("/introspect", url.Values{"client_id": {"app"}, "client_secret": {"secret"}, "token": {"refresh-session"}, "token_type_hint": {"refresh_token"}}))
+	assert.Equal(t, "refresh_token", decodeResponse(t, recorder)["token_type"])
+
+	recorder = serve(handler, newBearerRequest("GET", "/introspect", "", nil))
+	assert.Equal(t, 405, recorder.Code)
+}
+
+func TestIntrospectionHandlerWithOtherIssuer(t *testing.T) {
+
+	fixture := newTestFixture()
+	fixture.saveSession("session", "bob", "app", "read")
+	fixture.server.Config().Issuer = "https://other.example.com"
+
+	recorder := serve(NewIntrospectionHandler(fixture.server), newFormRequest("/introspect", url.Values{"client_id": {"app"}, "client_secret": {"secret"}, "token": {"access-session"}}))
+	assert.Equal(t, map[string]interface{}{"active": false}, decodeResponse(t, recorder))
+}
@@ -0,0 +1,31 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/yjv/goauth2-server/jwt"
+	"net/http"
+)
+
+// JwksHandler publishes the public keys tokens are signed with at the
+// jwks_uri advertised in the metadata document.
+type JwksHandler struct {
+	keySet *jwt.JWKSet
+}
+
+func (handler *JwksHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != "GET" {
+
+		writer.Header().Set("Allow", "GET")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(handler.keySet)
+}
+
+func NewJwksHandler(signers []jwt.Signer) *JwksHandler {
+
+	return &JwksHandler{jwt.NewJWKSet(signers)}
+}
@@ -0,0 +1,29 @@
+package http
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/yjv/goauth2-server/jwt"
+	"testing"
+)
+
+func TestJwksHandler(t *testing.T) {
+
+	key, error := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, error)
+
+	//symmetric keys are secret and never published
+	handler := NewJwksHandler([]jwt.Signer{jwt.NewRSASigner("rsa", key), jwt.NewHMACSigner("hmac", []byte("secret"))})
+
+	recorder := serve(handler, newBearerRequest("GET", "/jwks", "", nil))
+	assert.Equal(t, 200, recorder.Code)
+	keys := decodeResponse(t, recorder)["keys"].([]interface{})
+	assert.Len(t, keys, 1)
+	assert.Equal(t, "rsa", keys[0].(map[string]interface{})["kid"])
+	assert.Equal(t, "RSA", keys[0].(map[string]interface{})["kty"])
+
+	recorder = serve(handler, newBearerRequest("POST", "/jwks", "", nil))
+	assert.Equal(t, 405, recorder.Code)
+	assert.Equal(t, "GET", recorder.Header().Get("Allow"))
+}
@@ -0,0 +1,169 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yjv/goauth2-server/server"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+const (
+	AuthorizationServerMetadataPath = "/.well-known/oauth-authorization-server"
+	OpenIdConfigurationPath         = "/.well-known/openid-configuration"
+)
+
+type MetadataConfig struct {
+	Issuer                   string
+	AuthorizationEndpoint    string
+	TokenEndpoint            string
+	UserInfoEndpoint         string
+	RegistrationEndpoint     string
+	RevocationEndpoint       string
+	IntrospectionEndpoint    string
+	JwksUri                  string
+	TokenEndpointAuthMethods []string
+	CodeChallengeMethods     []string
+	SigningAlgorithms        []string
+	DPoPSigningAlgorithms    []string
+}
+
+func NewMetadataConfig(issuer string) *MetadataConfig {
+
+	return &MetadataConfig{
+		Issuer:                   issuer,
+		TokenEndpointAuthMethods: []string{"client_secret_post"},
+	}
+}
+
+// Metadata holds the fields from RFC 8414 section 2 plus the OpenID Connect
+// Discovery fields that are only written for the openid-configuration document.
+type Metadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                     string   `json:"token_endpoint,omitempty"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	RegistrationEndpoint              string   `json:"registration_endpoint,omitempty"`
+	RevocationEndpoint                string   `json:"revocation_endpoint,omitempty"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint,omitempty"`
+	JwksUri                           string   `json:"jwks_uri,omitempty"`
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	SubjectTypesSupported             []string `json:"subject_types_supported,omitempty"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported,omitempty"`
+	UserInfoSigningAlgValuesSupported []string `json:"userinfo_signing_alg_values_supported,omitempty"`
+	AuthorizationDetailsTypes         []string `json:"authorization_details_types_supported,omitempty"`
+	DPoPSigningAlgValuesSupported     []string `json:"dpop_signing_alg_values_supported,omitempty"`
+}
+
+func BuildMetadata(oauthServer *server.DefaultServer, config *MetadataConfig, openId bool) (*Metadata, error) {
+
+	issuer, error := url.Parse(config.Issuer)
+
+	if error != nil || issuer.Scheme == "" || issuer.Host == "" || issuer.RawQuery != "" || issuer.Fragment != "" {
+
+		return nil, fmt.Errorf("issuer %q must be an absolute url without a query or fragment", config.Issuer)
+	}
+
+	metadata := &Metadata{
+		Issuer:                            config.Issuer,
+		AuthorizationEndpoint:             config.AuthorizationEndpoint,
+		TokenEndpoint:                     config.TokenEndpoint,
+		UserInfoEndpoint:                  config.UserInfoEndpoint,
+		RegistrationEndpoint:              config.RegistrationEndpoint,
+		RevocationEndpoint:                config.RevocationEndpoint,
+		IntrospectionEndpoint:             config.IntrospectionEndpoint,
+		JwksUri:                           config.JwksUri,
+		ResponseTypesSupported:            []string{},
+		TokenEndpointAuthMethodsSupported: config.TokenEndpointAuthMethods,
+		CodeChallengeMethodsSupported:     config.CodeChallengeMethods,
+		AuthorizationDetailsTypes:         oauthServer.AuthorizationDetailTypes(),
+		DPoPSigningAlgValuesSupported:     config.DPoPSigningAlgorithms,
+	}
+
+	for name := range oauthServer.Grants() {
+
+		metadata.GrantTypesSupported = append(metadata.GrantTypesSupported, name)
+	}
+
+	sort.Strings(metadata.GrantTypesSupported)
+
+	if _, ok := oauthServer.GetGrant("authorization_code"); ok {
+
+		metadata.ResponseTypesSupported = append(metadata.ResponseTypesSupported, "code")
+	}
+
+	if lister, ok := oauthServer.ScopeStorage().(server.ScopeLister); ok {
+
+		scopes, error := lister.FindAllScopes()
+
+		if error != nil {
+
+			return nil, error
+		}
+
+		for _, scope := range scopes {
+
+			metadata.ScopesSupported = append(metadata.ScopesSupported, scope.Name)
+		}
+	}
+
+	if openId {
+
+		metadata.SubjectTypesSupported = []string{"public"}
+		metadata.IdTokenSigningAlgValuesSupported = config.SigningAlgorithms
+		metadata.UserInfoSigningAlgValuesSupported = config.SigningAlgorithms
+	}
+
+	return metadata, nil
+}
+
+// MetadataHandler builds the document on every request so grants and scopes
+// added after startup are advertised without a restart.
+type MetadataHandler struct {
+	server *server.DefaultServer
+	config *MetadataConfig
+	openId bool
+}
+
+func (handler *MetadataHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != "GET" {
+
+		writer.Header().Set("Allow", "GET")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	metadata, error := BuildMetadata(handler.server, handler.config, handler.openId)
+
+	if error != nil {
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(metadata)
+}
+
+func NewMetadataHandler(oauthServer *server.DefaultServer, config *MetadataConfig) *MetadataHandler {
+
+	return &MetadataHandler{oauthServer, config, false}
+}
+
+func NewOpenIdConfigurationHandler(oauthServer *server.DefaultServer, config *MetadataConfig) *MetadataHandler {
+
+	return &MetadataHandler{oauthServer, config, true}
+}
+
+// RegisterMetadataHandlers adds both well known documents to the mux.
+func RegisterMetadataHandlers(mux *http.ServeMux, oauthServer *server.DefaultServer, config *MetadataConfig) {
+
+	mux.Handle(AuthorizationServerMetadataPath, NewMetadataHandler(oauthServer, config))
+	mux.Handle(OpenIdConfigurationPath, NewOpenIdConfigurationHandler(oauthServer, config))
+}
@@ -0,0 +1,54 @@
+package http
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestMetadataHandler(t *testing.T) {
+
+	fixture := newTestFixture()
+	config := NewMetadataConfig("https://auth.example.com")
+	config.TokenEndpoint = "https://auth.example.com/token"
+	handler := NewMetadataHandler(fixture.server, config)
+
+	recorder := serve(handler, newBearerRequest("GET", AuthorizationServerMetadataPath, "", nil))
+	assert.Equal(t, 200, recorder.Code)
+	metadata := decodeResponse(t, recorder)
+	assert.Equal(t, "https://auth.example.com", metadata["issuer"])
+	assert.Equal(t, "https://auth.example.com/token", metadata["token_endpoint"])
+	assert.Equal(t, []interface{}{"client_credentials", "refresh_token"}, metadata["grant_types_supported"])
+	assert.ElementsMatch(t, []interface{}{"openid", "read"}, metadata["scopes_supported"])
+	assert.Equal(t, []interface{}{}, metadata["response_types_supported"])
+	assert.Nil(t, metadata["subject_types_supported"])
+
+	recorder = serve(handler, newBearerRequest("POST", AuthorizationServerMetadataPath, "", nil))
+	assert.Equal(t, 405, recorder.Code)
+}
+
+func TestOpenIdConfigurationHandler(t *testing.T) {
+
+	fixture := newTestFixture()
+	config := NewMetadataConfig("https://auth.example.com")
+	config.SigningAlgorithms = []string{"RS256"}
+	mux := http.NewServeMux()
+	RegisterMetadataHandlers(mux, fixture.server, config)
+
+	recorder := serve(mux, newBearerRequest("GET", OpenIdConfigurationPath, "", nil))
+	assert.Equal(t, 200, recorder.Code)
+	metadata := decodeResponse(t, recorder)
+	assert.Equal(t, []interface{}{"public"}, metadata["subject_types_supported"])
+	assert.Equal(t, []interface{}{"RS256"}, metadata["id_token_signing_alg_values_supported"])
+}
+
+func TestMetadataHandlerWithInvalidIssuer(t *testing.T) {
+
+	fixture := newTestFixture()
+
+	for _, issuer := range []string{"", "auth.example.com", "https://auth.example.com?tenant=acme", "https://auth.example.com#top"} {
+
+		recorder := serve(NewMetadataHandler(fixture.server, NewMetadataConfig(issuer)), newBearerRequest("GET", AuthorizationServerMetadataPath, "", nil))
+		assert.Equal(t, 500, recorder.Code, issuer)
+	}
+}
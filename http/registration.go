@@ -0,0 +1,264 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/yjv/goauth2-server/server"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type InitialAccessTokenValidatorFunc func(initialAccessToken string) bool
+
+// InitialAccessTokens accepts any of the fixed initial access tokens.
+func InitialAccessTokens(initialAccessTokens ...string) InitialAccessTokenValidatorFunc {
+
+	return func(initialAccessToken string) bool {
+
+		for _, candidate := range initialAccessTokens {
+
+			if subtle.ConstantTimeCompare([]byte(candidate), []byte(initialAccessToken)) == 1 {
+
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+type clientInformationResponse struct {
+	*server.ClientMetadata
+	ClientId                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientIdIssuedAt        int64  `json:"client_id_issued_at,omitempty"`
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientUri   string `json:"registration_client_uri"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// RegistrationHandler serves the client registration endpoint from RFC 7591
+// at the endpoint path and the client configuration endpoint from RFC 7592
+// at the endpoint path followed by the client id.
+type RegistrationHandler struct {
+	registrar                   *server.ClientRegistrar
+	endpoint                    string
+	path                        string
+	initialAccessTokenValidator InitialAccessTokenValidatorFunc
+}
+
+func (handler *RegistrationHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	path := strings.TrimSuffix(request.URL.EscapedPath(), "/")
+
+	if path == handler.path {
+
+		handler.register(writer, request)
+		return
+	}
+
+	if !strings.HasPrefix(path, handler.path+"/") {
+
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	clientId, error := url.PathUnescape(strings.TrimPrefix(path, handler.path+"/"))
+
+	if error != nil || clientId == "" {
+
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	handler.manage(writer, request, clientId)
+}
+
+func (handler *RegistrationHandler) register(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != "POST" {
+
+		writer.Header().Set("Allow", "POST")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if handler.initialAccessTokenValidator != nil {
+
+		initialAccessToken, bearerError := BearerToken(request)
+
+		if bearerError != nil {
+
+			bearerError.WriteResponse(writer, "")
+			return
+		}
+
+		if !handler.initialAccessTokenValidator(initialAccessToken) {
+
+			NewInvalidBearerTokenError("The initial access token is invalid.").WriteResponse(writer, "")
+			return
+		}
+	}
+
+	metadata := &server.ClientMetadata{}
+
+	if error := json.NewDecoder(request.Body).Decode(metadata); error != nil {
+
+		writeRegistrationError(writer, "invalid_client_metadata", "The request body must be a json object.")
+		return
+	}
+
+	registered, oauthError := handler.registrar.Register(metadata)
+
+	if oauthError != nil {
+
+		writeRegistrationOauthError(writer, oauthError)
+		return
+	}
+
+	handler.writeClientInformation(writer, http.StatusCreated, registered)
+}
+
+func (handler *RegistrationHandler) manage(writer http.ResponseWriter, request *http.Request, clientId string) {
+
+	registrationAccessToken, bearerError := BearerToken(request)
+
+	if bearerError != nil {
+
+		bearerError.WriteResponse(writer, "")
+		return
+	}
+
+	registration, oauthError := handler.registrar.Authenticate(clientId, registrationAccessToken)
+
+	if oauthError != nil {
+
+		NewInvalidBearerTokenError("The registration access token is invalid.").WriteResponse(writer, "")
+		return
+	}
+
+	switch request.Method {
+	case "GET":
+		handler.writeClientInformation(writer, http.StatusOK, &server.RegisteredClient{Registration: registration})
+	case "PUT":
+		metadata := &server.ClientMetadata{}
+		body := struct {
+			*server.ClientMetadata
+			ClientId string `json:"client_id"`
+		}{metadata, ""}
+
+		if error := json.NewDecoder(request.Body).Decode(&body); error != nil {
+
+			writeRegistrationError(writer, "invalid_client_metadata", "The request body must be a json object.")
+			return
+		}
+
+		if body.ClientId != clientId {
+
+			writeRegistrationError(writer, "invalid_client_metadata", "client_id must match the client being updated.")
+			return
+		}
+
+		registered, oauthError := handler.registrar.Update(registration, metadata)
+
+		if oauthError != nil {
+
+			writeRegistrationOauthError(writer, oauthError)
+			return
+		}
+
+		handler.writeClientInformation(writer, http.StatusOK, registered)
+	case "DELETE":
+		if oauthError := handler.registrar.Delete(registration); oauthError != nil {
+
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writer.Header().Set("Allow", "GET, PUT, DELETE")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (handler *RegistrationHandler) writeClientInformation(writer http.ResponseWriter, status int, registered *server.RegisteredClient) {
+
+	registration := registered.Registration
+
+	response := &clientInformationResponse{
+		ClientMetadata:          registration.Metadata,
+		ClientId:                registration.Client.Id,
+		ClientSecret:            registered.ClientSecret,
+		ClientIdIssuedAt:        unixTime(registration.ClientIdIssuedAt),
+		ClientSecretExpiresAt:   unixTime(registration.ClientSecretExpiresAt),
+		RegistrationAccessToken: registered.RegistrationAccessToken,
+		RegistrationClientUri:   strings.TrimSuffix(handler.endpoint, "/") + "/" + url.PathEscape(registration.Client.Id),
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Pragma", "no-cache")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(response)
+}
+
+// SetInitialAccessTokenValidator makes registration require a bearer initial
+// access token as described in RFC 7591 section 3.
+func (handler *RegistrationHandler) SetInitialAccessTokenValidator(validator InitialAccessTokenValidatorFunc) *RegistrationHandler {
+
+	handler.initialAccessTokenValidator = validator
+	return handler
+}
+
+// unixTime writes the zero time as 0, which RFC 7591 uses for secrets that
+// never expire.
+func unixTime(value time.Time) int64 {
+
+	if value.IsZero() {
+
+		return 0
+	}
+
+	return value.Unix()
+}
+
+func writeRegistrationOauthError(writer http.ResponseWriter, oauthError server.OauthError) {
+
+	switch oauthError.OauthErrorCode() {
+	case server.InvalidRedirectUri:
+		writeRegistrationError(writer, "invalid_redirect_uri", oauthError.Error())
+	case server.InvalidClientMetadata:
+		writeRegistrationError(writer, "invalid_client_metadata", oauthError.Error())
+	default:
+		writer.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func writeRegistrationError(writer http.ResponseWriter, code string, description string) {
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(writer).Encode(&errorResponse{code, description})
+}
+
+// NewRegistrationHandler takes the absolute url the handler is mounted at so
+// it can hand out registration_client_uri values.
+func NewRegistrationHandler(registrar *server.ClientRegistrar, endpoint string) *RegistrationHandler {
+
+	path := endpoint
+
+	if parsed, error := url.Parse(endpoint); error == nil {
+
+		path = parsed.EscapedPath()
+	}
+
+	return &RegistrationHandler{registrar, endpoint, strings.TrimSuffix(path, "/"), nil}
+}
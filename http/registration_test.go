@@ -0,0 +1,112 @@
+package http
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yjv/goauth2-server/server"
+	"strings"
+	"testing"
+)
+
+func newTestRegistrationHandler(fixture *testFixture) *RegistrationHandler {
+
+	registrar := server.NewClientRegistrar(fixture.server, fixture.clients).SetScopes([]string{"read"})
+	return NewRegistrationHandler(registrar, "https://auth.example.com/register").
+		SetInitialAccessTokenValidator(InitialAccessTokens("initial-token"))
+}
+
+func TestRegistrationHandlerRegister(t *testing.T) {
+
+	fixture := newTestFixture()
+	handler := newTestRegistrationHandler(fixture)
+	body := `{"grant_types":["client_credentials"],"scope":"read"}`
+
+	recorder := serve(handler, newBearerRequest("POST", "/register", "", strings.NewReader(body)))
+	assert.Equal(t, 401, recorder.Code)
+
+	recorder = serve(handler, newBearerRequest("POST", "/register", "wrong", strings.NewReader(body)))
+	assert.Equal(t, 401, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+
+	recorder = serve(handler, newBearerRequest("POST", "/register", "initial-token", strings.NewReader("not json")))
+	assertErrorResponse(t, recorder, 400, "invalid_client_metadata")
+
+	recorder = serve(handler, newBearerRequest("POST", "/register", "initial-token", strings.NewReader(`{"grant_types":["client_credentials"],"scope":"openid"}`)))
+	assertErrorResponse(t, recorder, 400, "invalid_client_metadata")
+
+	recorder = serve(handler, newBearerRequest("POST", "/register", "initial-token", strings.NewReader(`{"grant_types":["client_credentials"],"scope":"read","redirect_uris":["javascript:alert(1)"]}`)))
+	assertErrorResponse(t, recorder, 400, "invalid_redirect_uri")
+
+	recorder = serve(handler, newBearerRequest("POST", "/register", "initial-token", strings.NewReader(body)))
+	assert.Equal(t, 201, recorder.Code)
+	assert.Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	response := decodeResponse(t, recorder)
+	clientId := response["client_id"].(string)
+	assert.Equal(t, "https://auth.example.com/register/"+clientId, response["registration_client_uri"])
+	assert.NotEmpty(t, response["registration_access_token"])
+
+	//registered clients are limited to the scopes they registered with
+	client, _ := fixture.clients.FindClientByIdAndSecret(clientId, response["client_secret"].(string))
+	assert.Equal(t, []string{"read"}, client.AllowedScopes)
+
+	recorder = serve(handler, newBearerRequest("GET", "/register", "initial-token", nil))
+	assert.Equal(t, 405, recorder.Code)
+}
+
+func TestRegistrationHandlerWithoutInitialAccessTokens(t *testing.T) {
+
+	fixture := newTestFixture()
+	registrar := server.NewClientRegistrar(fixture.server, fixture.clients).SetScopes([]string{"read"}).SetDefaultScope("read")
+	handler := NewRegistrationHandler(registrar, "https://auth.example.com/register")
+
+	recorder := serve(handler, newBearerRequest("POST", "/register", "", strings.NewReader(`{}`)))
+	assert.Equal(t, 201, recorder.Code)
+	response := decodeResponse(t, recorder)
+	assert.Equal(t, []interface{}{"client_credentials"}, response["grant_types"])
+	assert.Equal(t, "read", response["scope"])
+}
+
+func TestRegistrationHandlerManage(t *testing.T) {
+
+	fixture := newTestFixture()
+	handler := newTestRegistrationHandler(fixture)
+
+	recorder := serve(handler, newBearerRequest("POST", "/register", "initial-token", strings.NewReader(`{"grant_types":["client_credentials"],"scope":"read"}`)))
+	response := decodeResponse(t, recorder)
+	clientId := response["client_id"].(string)
+	registrationAccessToken := response["registration_access_token"].(string)
+	path := "/register/" + clientId
+
+	recorder = serve(handler, newBearerRequest("GET", path, "", nil))
+	assert.Equal(t, 401, recorder.Code)
+
+	recorder = serve(handler, newBearerRequest("GET", path, "initial-token", nil))
+	assert.Equal(t, 401, recorder.Code)
+
+	recorder = serve(handler, newBearerRequest("GET", "/register/app", registrationAccessToken, nil))
+	assert.Equal(t, 401, recorder.Code)
+
+	recorder = serve(handler, newBearerRequest("GET", path, registrationAccessToken, nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, clientId, decodeResponse(t, recorder)["client_id"])
+
+	recorder = serve(handler, newBearerRequest("PUT", path, registrationAccessToken, strings.NewReader(`{"client_id":"other","scope":"read"}`)))
+	assertErrorResponse(t, recorder, 400, "invalid_client_metadata")
+
+	//updating hands out a new registration access token
+	recorder = serve(handler, newBearerRequest("PUT", path, registrationAccessToken, strings.NewReader(`{"client_id":"`+clientId+`","grant_types":["client_credentials"],"scope":"read","client_name":"App"}`)))
+	assert.Equal(t, 200, recorder.Code)
+	response = decodeResponse(t, recorder)
+	assert.Equal(t, "App", response["client_name"])
+	assert.Nil(t, response["client_secret"])
+
+	recorder = serve(handler, newBearerRequest("DELETE", path, registrationAccessToken, nil))
+	assert.Equal(t, 401, recorder.Code)
+
+	recorder = serve(handler, newBearerRequest("DELETE", path, response["registration_access_token"].(string), nil))
+	assert.Equal(t, 204, recorder.Code)
+	client, _ := fixture.clients.FindClientById(clientId)
+	assert.Nil(t, client)
+
+	recorder = serve(handler, newBearerRequest("GET", "/registered", registrationAccessToken, nil))
+	assert.Equal(t, 404, recorder.Code)
+}
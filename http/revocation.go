@@ -0,0 +1,56 @@
+package http
+
+import (
+	"github.com/yjv/goauth2-server/server"
+	"net/http"
+)
+
+// RevocationHandler serves the token revocation endpoint from RFC 7009.
+// Revoking either token revokes the whole session, and tokens that are
+// unknown or belong to another client are ignored as the RFC asks.
+type RevocationHandler struct {
+	server *server.DefaultServer
+}
+
+func (handler *RevocationHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != "POST" {
+
+		writer.Header().Set("Allow", "POST")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	oauthSessionRequest := NewRequestFormOauthSessionRequest(request)
+	client, error := server.AuthenticateClient(oauthSessionRequest, handler.server)
+
+	if client == nil {
+
+		writeClientAuthenticationError(writer, error)
+		return
+	}
+
+	token, ok := oauthSessionRequest.GetFirst("token")
+
+	if !ok {
+
+		writeTokenErrorResponse(writer, http.StatusBadRequest, "invalid_request", "The token parameter is required.")
+		return
+	}
+
+	hint, _ := oauthSessionRequest.GetFirst("token_type_hint")
+	session, _ := findSessionByToken(handler.server.SessionStorage(), token, hint)
+
+	if session != nil && session.Client != nil && session.Client.Id == client.Id {
+
+		handler.server.RevokeSession(session)
+	}
+
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(http.StatusOK)
+}
+
+func NewRevocationHandler(oauthServer *server.DefaultServer) *RevocationHandler {
+
+	return &RevocationHandler{oauthServer}
+}
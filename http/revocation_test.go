@@ -0,0 +1,45 @@
+package http
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+)
+
+func TestRevocationHandler(t *testing.T) {
+
+	fixture := newTestFixture()
+	fixture.saveSession("app", "bob", "app", "read")
+	fixture.saveSession("spa", "bob", "spa", "read")
+	handler := NewRevocationHandler(fixture.server)
+
+	recorder := serve(handler, newFormRequest("/revoke", url.Values{"client_id": {"app"}, "client_secret": {"wrong"}, "token": {"access-app"}}))
+	assertErrorResponse(t, recorder, 401, "invalid_client")
+
+	recorder = serve(handler, newFormRequest("/revoke", url.Values{"client_id": {"app"}, "client_secret": {"secret"}}))
+	assertErrorResponse(t, recorder, 400, "invalid_request")
+
+	//tokens of other clients are left alone but the response does not tell
+	recorder = serve(handler, newFormRequest("/revoke", url.Values{"client_id": {"app"}, "client_secret": {"secret"}, "token": {"access-spa"}}))
+	assert.Equal(t, 200, recorder.Code)
+	session, _ := fixture.sessions.FindSessionByAccessToken("access-spa")
+	assert.NotNil(t, session)
+
+	recorder = serve(handler, newFormRequest("/revoke", url.Values{"client_id": {"app"}, "client_secret": {"secret"}, "token": {"unknown"}}))
+	assert.Equal(t, 200, recorder.Code)
+
+	//revoking the refresh token revokes the whole session
+	recorder = serve(handler, newFormRequest("/revoke", url.Values{"client_id": {"app"}, "client_secret": {"secret"}, "token": {"refresh-app"}, "token_type_hint": {"refresh_token"}}))
+	assert.Equal(t, 200, recorder.Code)
+	session, _ = fixture.sessions.FindSessionByAccessToken("access-app")
+	assert.Nil(t, session)
+
+	//public clients revoke their tokens with just their client id
+	recorder = serve(handler, newFormRequest("/revoke", url.Values{"client_id": {"spa"}, "token": {"access-spa"}}))
+	assert.Equal(t, 200, recorder.Code)
+	session, _ = fixture.sessions.FindSessionByAccessToken("access-spa")
+	assert.Nil(t, session)
+
+	recorder = serve(handler, newBearerRequest("GET", "/revoke", "", nil))
+	assert.Equal(t, 405, recorder.Code)
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"context"
+	"github.com/yjv/goauth2-server/jwt"
+	"github.com/yjv/goauth2-server/server"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Tenant is one realm hosted by the process. Each tenant has its own server
+// so its grants, storages and config, including the issuer, stay separate.
+type Tenant struct {
+	Id     string
+	Server *server.DefaultServer
+	Signer jwt.Signer
+}
+
+// TenantResolver picks the tenant a request is for. It returns the request to
+// hand to the tenant, which lets path based resolvers strip their prefix.
+type TenantResolver interface {
+	ResolveTenant(request *http.Request) (*Tenant, *http.Request, bool)
+}
+
+type tenantContextKey struct{}
+
+func ContextWithTenant(ctx context.Context, tenant *Tenant) context.Context {
+
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+func TenantFromContext(ctx context.Context) (*Tenant, bool) {
+
+	tenant, ok := ctx.Value(tenantContextKey{}).(*Tenant)
+	return tenant, ok
+}
+
+// HostTenantResolver picks the tenant by the host the request was sent to,
+// ignoring the port.
+type HostTenantResolver struct {
+	mutex         sync.RWMutex
+	tenantsByHost map[string]*Tenant
+}
+
+func (resolver *HostTenantResolver) ResolveTenant(request *http.Request) (*Tenant, *http.Request, bool) {
+
+	host := request.Host
+
+	if hostname, _, error := net.SplitHostPort(host); error == nil {
+
+		host = hostname
+	}
+
+	resolver.mutex.RLock()
+	defer resolver.mutex.RUnlock()
+	tenant, ok := resolver.tenantsByHost[strings.ToLower(host)]
+	return tenant, request, ok
+}
+
+func (resolver *HostTenantResolver) AddTenant(host string, tenant *Tenant) *HostTenantResolver {
+
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	resolver.tenantsByHost[strings.ToLower(host)] = tenant
+	return resolver
+}
+
+func NewHostTenantResolver() *HostTenantResolver {
+
+	return &HostTenantResolver{tenantsByHost: make(map[string]*Tenant)}
+}
+
+// PathTenantResolver picks the tenant by the first path segment and strips
+// it, so /acme/token reaches the acme tenant as /token.
+type PathTenantResolver struct {
+	mutex           sync.RWMutex
+	tenantsByPrefix map[string]*Tenant
+}
+
+func (resolver *PathTenantResolver) ResolveTenant(request *http.Request) (*Tenant, *http.Request, bool) {
+
+	path := strings.TrimPrefix(request.URL.Path, "/")
+	prefix, rest := path, ""
+
+	if index := strings.Index(path, "/"); index >= 0 {
+
+		prefix, rest = path[:index], path[index:]
+	}
+
+	resolver.mutex.RLock()
+	tenant, ok := resolver.tenantsByPrefix[prefix]
+	resolver.mutex.RUnlock()
+
+	if !ok {
+
+		return nil, request, false
+	}
+
+	if rest == "" {
+
+		rest = "/"
+	}
+
+	stripped := request.Clone(request.Context())
+	stripped.URL.Path = rest
+	stripped.URL.RawPath = ""
+	return tenant, stripped, true
+}
+
+func (resolver *PathTenantResolver) AddTenant(prefix string, tenant *Tenant) *PathTenantResolver {
+
+	resolver.mutex.Lock()
+	defer resolver.mutex.Unlock()
+	resolver.tenantsByPrefix[strings.Trim(prefix, "/")] = tenant
+	return resolver
+}
+
+func NewPathTenantResolver() *PathTenantResolver {
+
+	return &PathTenantResolver{tenantsByPrefix: make(map[string]*Tenant)}
+}
+
+type TenantHandlerFactoryFunc func(tenant *Tenant) http.Handler
+
+// TenantRouter sends every request to the handler of its tenant, building the
+// handler the first time the tenant is seen. Requests for unknown tenants get
+// a 404.
+type TenantRouter struct {
+	resolver         TenantResolver
+	handlerFactory   TenantHandlerFactoryFunc
+	mutex            sync.Mutex
+	handlersByTenant map[*Tenant]http.Handler
+}
+
+func (router *TenantRouter) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	tenant, tenantRequest, ok := router.resolver.ResolveTenant(request)
+
+	if !ok {
+
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	router.handler(tenant).ServeHTTP(writer, tenantRequest.WithContext(ContextWithTenant(tenantRequest.Context(), tenant)))
+}
+
+func (router *TenantRouter) handler(tenant *Tenant) http.Handler {
+
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+	handler, ok := router.handlersByTenant[tenant]
+
+	if !ok {
+
+		handler = router.handlerFactory(tenant)
+		router.handlersByTenant[tenant] = handler
+	}
+
+	return handler
+}
+
+func NewTenantRouter(resolver TenantResolver, handlerFactory TenantHandlerFactoryFunc) *TenantRouter {
+
+	return &TenantRouter{
+		resolver:         resolver,
+		handlerFactory:   handlerFactory,
+		handlersByTenant: make(map[*Tenant]http.Handler),
+	}
+}
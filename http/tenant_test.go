@@ -0,0 +1,77 @@
+package http
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+// tenantEcho answers with the tenant from the context and the path the
+// tenant handler saw.
+func tenantEcho(built map[string]int) TenantHandlerFactoryFunc {
+
+	return func(tenant *Tenant) http.Handler {
+
+		built[tenant.Id]++
+
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+
+			fromContext, _ := TenantFromContext(request.Context())
+			writer.Write([]byte(fromContext.Id + " " + request.URL.Path))
+		})
+	}
+}
+
+func TestTenantRouterWithHostTenantResolver(t *testing.T) {
+
+	built := map[string]int{}
+	resolver := NewHostTenantResolver().
+		AddTenant("Acme.example.com", &Tenant{Id: "acme"}).
+		AddTenant("globex.example.com", &Tenant{Id: "globex"})
+	router := NewTenantRouter(resolver, tenantEcho(built))
+
+	for host, body := range map[string]string{
+		"acme.example.com":        "acme /token",
+		"ACME.example.com:8443":   "acme /token",
+		"globex.example.com:8443": "globex /token",
+	} {
+
+		request := newBearerRequest("POST", "/token", "", nil)
+		request.Host = host
+		recorder := serve(router, request)
+		assert.Equal(t, 200, recorder.Code, host)
+		assert.Equal(t, body, recorder.Body.String(), host)
+	}
+
+	request := newBearerRequest("POST", "/token", "", nil)
+	request.Host = "initech.example.com"
+	assert.Equal(t, 404, serve(router, request).Code)
+
+	//each tenant gets its handler built once
+	assert.Equal(t, map[string]int{"acme": 1, "globex": 1}, built)
+}
+
+func TestTenantRouterWithPathTenantResolver(t *testing.T) {
+
+	built := map[string]int{}
+	resolver := NewPathTenantResolver().AddTenant("/acme/", &Tenant{Id: "acme"})
+	router := NewTenantRouter(resolver, tenantEcho(built))
+
+	for path, body := range map[string]string{
+		"/acme/token":               "acme /token",
+		"/acme":                     "acme /",
+		"/acme/admin/clients/a%2Fb": "acme /admin/clients/a/b",
+	} {
+
+		recorder := serve(router, newBearerRequest("GET", path, "", nil))
+		assert.Equal(t, 200, recorder.Code, path)
+		assert.Equal(t, body, recorder.Body.String(), path)
+	}
+
+	for _, path := range []string{"/", "/token", "/acmecorp/token"} {
+
+		assert.Equal(t, 404, serve(router, newBearerRequest("GET", path, "", nil)).Code, path)
+	}
+
+	assert.Equal(t, map[string]int{"acme": 1}, built)
+}
@@ -0,0 +1,243 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/yjv/goauth2-server/jwt"
+	"github.com/yjv/goauth2-server/server"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type tokenResponse struct {
+	AccessToken          string                       `json:"access_token"`
+	TokenType            string                       `json:"token_type"`
+	ExpiresIn            int64                        `json:"expires_in,omitempty"`
+	RefreshToken         string                       `json:"refresh_token,omitempty"`
+	Scope                string                       `json:"scope,omitempty"`
+	AuthorizationDetails []server.AuthorizationDetail `json:"authorization_details,omitempty"`
+}
+
+// TokenHandler serves the token endpoint from RFC 6749 section 3.2. With a
+// DPoP validator the tokens of requests with a DPoP proof are bound to the key
+// that signed it, RFC 9449 section 5.
+type TokenHandler struct {
+	server        server.Server
+	dpopValidator *jwt.DPoPValidator
+	dpopUri       string
+}
+
+func (handler *TokenHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != "POST" {
+
+		writer.Header().Set("Allow", "POST")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	oauthSessionRequest := NewRequestFormOauthSessionRequest(request)
+
+	if oauthError := handler.validateDPoPProof(writer, request, oauthSessionRequest); oauthError != nil {
+
+		WriteTokenError(writer, oauthError)
+		return
+	}
+
+	session, oauthError := handler.server.GrantOauthSession(oauthSessionRequest)
+
+	if oauthError != nil {
+
+		WriteTokenError(writer, oauthError)
+		return
+	}
+
+	now := handler.server.Clock().Now()
+	response := &tokenResponse{
+		AccessToken:          session.AccessToken.Token,
+		TokenType:            "Bearer",
+		AuthorizationDetails: session.AuthorizationDetails,
+	}
+
+	if session.IsDPoPBound() {
+
+		response.TokenType = "DPoP"
+	}
+
+	if expiresIn := session.AccessToken.ExpiresIn(now); expiresIn != server.NoExpiration {
+
+		response.ExpiresIn = int64(expiresIn.Round(time.Second) / time.Second)
+	}
+
+	if session.RefreshToken != nil {
+
+		response.RefreshToken = session.RefreshToken.Token
+	}
+
+	scopes := make([]string, 0, len(session.Scopes))
+
+	for scopeName := range session.Scopes {
+		scopes = append(scopes, scopeName)
+	}
+
+	sort.Strings(scopes)
+	response.Scope = strings.Join(scopes, " ")
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Pragma", "no-cache")
+	json.NewEncoder(writer).Encode(response)
+}
+
+// validateDPoPProof passes the thumbprint of the key that signed the DPoP
+// proof on to the server. Without a validator DPoP is not supported and the
+// header is ignored.
+func (handler *TokenHandler) validateDPoPProof(writer http.ResponseWriter, request *http.Request, oauthSessionRequest *RequestFormOauthSessionRequest) server.OauthError {
+
+	if handler.dpopValidator == nil {
+
+		return nil
+	}
+
+	if nonce := handler.dpopValidator.Nonce(); nonce != "" {
+
+		writer.Header().Set("DPoP-Nonce", nonce)
+	}
+
+	proofs := request.Header.Values("DPoP")
+
+	if len(proofs) == 0 {
+
+		return nil
+	}
+
+	if len(proofs) > 1 {
+
+		return server.NewInvalidDPoPProofError("only one proof may be sent")
+	}
+
+	proof, oauthError := handler.dpopValidator.Validate(proofs[0], request.Method, handler.dpopUri, "")
+
+	if oauthError != nil {
+
+		return oauthError
+	}
+
+	oauthSessionRequest.SetDPoPThumbprint(proof.Thumbprint)
+	return nil
+}
+
+func (handler *TokenHandler) DPoPValidator() *jwt.DPoPValidator {
+
+	return handler.dpopValidator
+}
+
+// SetDPoPValidator binds tokens to the keys of the DPoP proofs requests come
+// with. The proofs have to be made for uri, the absolute url of the token
+// endpoint.
+func (handler *TokenHandler) SetDPoPValidator(validator *jwt.DPoPValidator, uri string) *TokenHandler {
+
+	handler.dpopValidator = validator
+	handler.dpopUri = uri
+	return handler
+}
+
+func NewTokenHandler(oauthServer server.Server) *TokenHandler {
+
+	return &TokenHandler{oauthServer, nil, ""}
+}
+
+// WriteTokenError writes the error response from RFC 6749 section 5.2, rate
+// limited requests get a 429 with a Retry-After header instead.
+func WriteTokenError(writer http.ResponseWriter, oauthError server.OauthError) {
+
+	status := http.StatusBadRequest
+	code := "invalid_request"
+
+	switch oauthError.OauthErrorCode() {
+	case server.RequiredValueMissing:
+		code = "invalid_request"
+	case server.InvalidScope:
+		code = "invalid_scope"
+	case server.InvalidAuthorizationDetails:
+		code = "invalid_authorization_details"
+	case server.InvalidTarget:
+		code = "invalid_target"
+	case server.InvalidDPoPProof:
+		code = "invalid_dpop_proof"
+	case server.UseDPoPNonce:
+		code = "use_dpop_nonce"
+
+		if nonceError, ok := oauthError.(*server.UseDPoPNonceError); ok {
+
+			writer.Header().Set("DPoP-Nonce", nonceError.Nonce())
+		}
+	case server.GrantNotFound:
+		code = "unsupported_grant_type"
+	case server.UnauthorizedClient:
+		code = "unauthorized_client"
+	case server.Vetoed:
+		code = "invalid_grant"
+	case server.StorageSearchFailed:
+		code = "invalid_grant"
+
+		if storageError, ok := oauthError.(*server.StorageSearchFailedError); ok && storageError.StoredType() == "client" {
+
+			status = http.StatusUnauthorized
+			code = "invalid_client"
+		}
+	case server.SessionLimitReached:
+		code = "invalid_grant"
+	case server.RateLimited:
+		status = http.StatusTooManyRequests
+		code = "temporarily_unavailable"
+
+		if rateLimitedError, ok := oauthError.(*server.RateLimitedError); ok {
+
+			writer.Header().Set("Retry-After", RetryAfter(rateLimitedError.RetryAfter()))
+		}
+	default:
+		status = http.StatusInternalServerError
+		code = "server_error"
+	}
+
+	writeTokenErrorResponse(writer, status, code, oauthError.Error())
+}
+
+func writeTokenErrorResponse(writer http.ResponseWriter, status int, code string, description string) {
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Pragma", "no-cache")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(&errorResponse{code, description})
+}
+
+// writeClientAuthenticationError writes the error AuthenticateClient returned
+// for endpoints other than the token endpoint.
+func writeClientAuthenticationError(writer http.ResponseWriter, error error) {
+
+	if oauthError, ok := error.(server.OauthError); ok {
+
+		WriteTokenError(writer, oauthError)
+		return
+	}
+
+	writeTokenErrorResponse(writer, http.StatusInternalServerError, "server_error", "")
+}
+
+// RetryAfter formats a wait as the whole seconds Retry-After expects, rounding
+// up so clients never come back too early.
+func RetryAfter(wait time.Duration) string {
+
+	seconds := int64((wait + time.Second - 1) / time.Second)
+
+	if seconds < 1 {
+
+		seconds = 1
+	}
+
+	return strconv.FormatInt(seconds, 10)
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/yjv/goauth2-server/server"
+	"net/http"
+	"strings"
+)
+
+// TraceContext returns the request's context carrying the span from its W3C
+// traceparent header so spans the server starts join the caller's trace.
+// Malformed headers are ignored as the trace context spec asks.
+func TraceContext(request *http.Request) context.Context {
+
+	ctx := request.Context()
+
+	if spanContext, ok := ParseTraceParent(request.Header.Get("traceparent")); ok {
+
+		return server.ContextWithSpanContext(ctx, spanContext)
+	}
+
+	return ctx
+}
+
+func ParseTraceParent(traceParent string) (server.SpanContext, bool) {
+
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" || !isHex(parts[3], 2) {
+
+		return server.SpanContext{}, false
+	}
+
+	//version 00 has exactly four fields, later versions may add more
+	if parts[0] == "00" && len(parts) != 4 {
+
+		return server.SpanContext{}, false
+	}
+
+	traceId, spanId := parts[1], parts[2]
+
+	if !isHex(traceId, 32) || !isHex(spanId, 16) || isZero(traceId) || isZero(spanId) {
+
+		return server.SpanContext{}, false
+	}
+
+	return server.SpanContext{TraceId: traceId, SpanId: spanId}, true
+}
+
+func isHex(value string, length int) bool {
+
+	if len(value) != length || strings.ToLower(value) != value {
+
+		return false
+	}
+
+	_, error := hex.DecodeString(value)
+	return error == nil
+}
+
+func isZero(value string) bool {
+
+	return strings.Trim(value, "0") == ""
+}
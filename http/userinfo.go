@@ -0,0 +1,103 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/yjv/goauth2-server/jwt"
+	"github.com/yjv/goauth2-server/server"
+	"net/http"
+)
+
+type UserInfoHandler struct {
+	authenticator  *BearerAuthenticator
+	claimsProvider server.ClaimsProvider
+	signer         jwt.Signer
+	issuer         string
+}
+
+func (handler *UserInfoHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != "GET" && request.Method != "POST" {
+
+		writer.Header().Set("Allow", "GET, POST")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, bearerError := handler.authenticator.Authenticate(request)
+
+	if bearerError != nil {
+
+		bearerError.WriteResponse(writer, handler.authenticator.Realm())
+		return
+	}
+
+	if _, ok := session.Scopes["openid"]; !ok {
+
+		NewInsufficientScopeError("openid").WriteResponse(writer, handler.authenticator.Realm())
+		return
+	}
+
+	claims, error := handler.claimsProvider.Claims(session)
+
+	if error != nil {
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Cache-Control", "no-store")
+
+	if handler.signer == nil {
+
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(claims)
+		return
+	}
+
+	signedClaims := make(map[string]interface{}, len(claims)+2)
+
+	for name, value := range claims {
+
+		signedClaims[name] = value
+	}
+
+	if handler.issuer != "" {
+
+		signedClaims["iss"] = handler.issuer
+	}
+
+	if session.Client != nil {
+
+		signedClaims["aud"] = session.Client.Id
+	}
+
+	token, error := jwt.Encode(signedClaims, handler.signer)
+
+	if error != nil {
+
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/jwt")
+	writer.Write([]byte(token))
+}
+
+// SetSigner switches the response to a signed JWT as allowed by OpenID Connect
+// Core section 5.3.2. The issuer is added as the iss claim when not empty.
+func (handler *UserInfoHandler) SetSigner(signer jwt.Signer, issuer string) *UserInfoHandler {
+
+	handler.signer = signer
+	handler.issuer = issuer
+	return handler
+}
+
+func NewUserInfoHandler(oauthServer server.Server, claimsProvider server.ClaimsProvider) *UserInfoHandler {
+
+	return NewUserInfoHandlerWithAuthenticator(NewBearerAuthenticator(oauthServer), claimsProvider)
+}
+
+func NewUserInfoHandlerWithAuthenticator(authenticator *BearerAuthenticator, claimsProvider server.ClaimsProvider) *UserInfoHandler {
+
+	return &UserInfoHandler{authenticator, claimsProvider, nil, ""}
+}
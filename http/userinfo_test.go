@@ -0,0 +1,75 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/yjv/goauth2-server/jwt"
+	"github.com/yjv/goauth2-server/server"
+	"strings"
+	"testing"
+)
+
+func ownerClaims(session *server.Session) (server.Claims, error) {
+
+	return server.Claims{"sub": session.Owner.Id}, nil
+}
+
+func TestUserInfoHandler(t *testing.T) {
+
+	fixture := newTestFixture()
+	fixture.saveSession("openid", "bob", "app", "openid")
+	fixture.saveSession("reader", "bob", "app", "read")
+	handler := NewUserInfoHandler(fixture.server, server.ClaimsProviderFunc(ownerClaims))
+
+	recorder := serve(handler, newBearerRequest("GET", "/userinfo", "", nil))
+	assert.Equal(t, 401, recorder.Code)
+
+	recorder = serve(handler, newBearerRequest("GET", "/userinfo", "access-unknown", nil))
+	assert.Equal(t, 401, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+
+	recorder = serve(handler, newBearerRequest("GET", "/userinfo", "access-reader", nil))
+	assert.Equal(t, 403, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), `scope="openid"`)
+
+	recorder = serve(handler, newBearerRequest("POST", "/userinfo", "access-openid", nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	assert.Equal(t, map[string]interface{}{"sub": "bob"}, decodeResponse(t, recorder))
+
+	recorder = serve(handler, newBearerRequest("DELETE", "/userinfo", "access-openid", nil))
+	assert.Equal(t, 405, recorder.Code)
+	assert.Equal(t, "GET, POST", recorder.Header().Get("Allow"))
+}
+
+func TestUserInfoHandlerWithOtherIssuer(t *testing.T) {
+
+	fixture := newTestFixture()
+	fixture.saveSession("openid", "bob", "app", "openid")
+	fixture.server.Config().Issuer = "https://other.example.com"
+	handler := NewUserInfoHandler(fixture.server, server.ClaimsProviderFunc(ownerClaims))
+
+	recorder := serve(handler, newBearerRequest("GET", "/userinfo", "access-openid", nil))
+	assert.Equal(t, 401, recorder.Code)
+}
+
+func TestUserInfoHandlerWithSigner(t *testing.T) {
+
+	fixture := newTestFixture()
+	fixture.saveSession("openid", "bob", "app", "openid")
+	handler := NewUserInfoHandler(fixture.server, server.ClaimsProviderFunc(ownerClaims)).
+		SetSigner(jwt.NewHMACSigner("key", []byte("secret")), "https://auth.example.com")
+
+	recorder := serve(handler, newBearerRequest("GET", "/userinfo", "access-openid", nil))
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "application/jwt", recorder.Header().Get("Content-Type"))
+
+	parts := strings.Split(recorder.Body.String(), ".")
+	assert.Len(t, parts, 3)
+	payload, error := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.Nil(t, error)
+	claims := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(payload, &claims))
+	assert.Equal(t, map[string]interface{}{"sub": "bob", "iss": "https://auth.example.com", "aud": "app"}, claims)
+}
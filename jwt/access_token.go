@@ -0,0 +1,106 @@
+package jwt
+
+import (
+	"github.com/yjv/goauth2-server/server"
+	"sort"
+	"strings"
+)
+
+// AccessTokenGenerator issues access tokens in the JWT profile from RFC 9068.
+// The wrapped generator makes the token and decides its lifetime, its opaque
+// value becomes the jti claim. Sessions are still looked up by the whole
+// token, so revoking a session revokes its JWT as well.
+type AccessTokenGenerator struct {
+	generator server.TokenGenerator
+	signer    Signer
+}
+
+// GenerateAccessToken keeps the opaque token when it can not be signed, it is
+// just as valid at the introspection endpoint.
+func (generator *AccessTokenGenerator) GenerateAccessToken(config *server.Config, grant server.Grant, session *server.Session) *server.Token {
+
+	token := generator.generator.GenerateAccessToken(config, grant, session)
+
+	if signed, error := EncodeWithType(AccessTokenClaims(config, session, token), generator.signer, "at+jwt"); error == nil {
+
+		token.Token = signed
+	}
+
+	return token
+}
+
+func (generator *AccessTokenGenerator) GenerateRefreshToken(config *server.Config, grant server.Grant, session *server.Session) *server.Token {
+
+	return generator.generator.GenerateRefreshToken(config, grant, session)
+}
+
+func (generator *AccessTokenGenerator) Signer() Signer {
+
+	return generator.signer
+}
+
+// AccessTokenClaims returns the claims of a JWT access token for the session.
+// The subject is the owner or, for tokens a client was granted for itself,
+// the client. The aud claim holds the resources the token is meant for and is
+// left out for tokens without an audience.
+func AccessTokenClaims(config *server.Config, session *server.Session, token *server.Token) map[string]interface{} {
+
+	claims := map[string]interface{}{
+		"jti": token.Token,
+		"iat": token.IssuedAt.Unix(),
+	}
+
+	if config.Issuer != "" {
+
+		claims["iss"] = config.Issuer
+	}
+
+	if !token.ExpiresAt.IsZero() {
+
+		claims["exp"] = token.ExpiresAt.Unix()
+	}
+
+	if session.Client != nil {
+
+		claims["client_id"] = session.Client.Id
+		claims["sub"] = session.Client.Id
+	}
+
+	if session.Owner != nil {
+
+		claims["sub"] = session.Owner.Id
+	}
+
+	switch len(session.Audience) {
+	case 0:
+	case 1:
+		claims["aud"] = session.Audience[0]
+	default:
+		claims["aud"] = session.Audience
+	}
+
+	if len(session.Scopes) > 0 {
+
+		scopes := make([]string, 0, len(session.Scopes))
+
+		for name := range session.Scopes {
+
+			scopes = append(scopes, name)
+		}
+
+		sort.Strings(scopes)
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+
+	if session.IsDPoPBound() {
+
+		claims["cnf"] = map[string]string{"jkt": session.DPoPThumbprint}
+	}
+
+	return claims
+}
+
+func NewAccessTokenGenerator(generator server.TokenGenerator, signer Signer) *AccessTokenGenerator {
+
+	return &AccessTokenGenerator{generator, signer}
+}
@@ -0,0 +1,68 @@
+package jwt
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yjv/goauth2-server/server"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessTokenGenerator(t *testing.T) {
+
+	now := time.Unix(1700000000, 0)
+	inner := server.NewDefaultTokenGeneratorWithGeneratorFunc(func() string { return "token-id" })
+	inner.SetClock(server.NewFakeClock(now))
+	signer := NewHMACSigner("key1", []byte("secret"))
+	generator := NewAccessTokenGenerator(inner, signer)
+	assert.Equal(t, signer, generator.Signer())
+
+	config := server.NewConfig()
+	config.Issuer = "https://auth.example.com"
+	session := server.NewSession()
+	session.Client = &server.Client{Id: "client"}
+	session.Owner = &server.Owner{Id: "owner"}
+	session.Scopes["write"] = &server.Scope{Name: "write"}
+	session.Scopes["read"] = &server.Scope{Name: "read"}
+	session.Audience = []string{"https://api.example.com/billing"}
+
+	token := generator.GenerateAccessToken(config, &server.ClientCredentialsGrant{}, session)
+	assert.Equal(t, now, token.IssuedAt)
+	assert.Equal(t, now.Add(time.Hour), token.ExpiresAt)
+
+	parts := strings.Split(token.Token, ".")
+	assert.Len(t, parts, 3)
+	assert.Equal(t, map[string]interface{}{"alg": "HS256", "typ": "at+jwt", "kid": "key1"}, decodeSegment(t, parts[0]))
+	assert.Equal(t, map[string]interface{}{
+		"jti":       "token-id",
+		"iat":       float64(now.Unix()),
+		"exp":       float64(now.Add(time.Hour).Unix()),
+		"iss":       "https://auth.example.com",
+		"client_id": "client",
+		"sub":       "owner",
+		"aud":       "https://api.example.com/billing",
+		"scope":     "read write",
+	}, decodeSegment(t, parts[1]))
+
+	refreshToken := generator.GenerateRefreshToken(config, &server.ClientCredentialsGrant{}, session)
+	assert.Equal(t, "token-id", refreshToken.Token)
+}
+
+func TestAccessTokenClaims(t *testing.T) {
+
+	session := server.NewSession()
+	session.Client = &server.Client{Id: "client"}
+	session.Audience = []string{"https://api.example.com/billing", "https://api.example.com/hr"}
+	token := &server.Token{Token: "token-id", IssuedAt: time.Unix(1700000000, 0)}
+
+	assert.Equal(t, map[string]interface{}{
+		"jti":       "token-id",
+		"iat":       int64(1700000000),
+		"client_id": "client",
+		"sub":       "client",
+		"aud":       []string{"https://api.example.com/billing", "https://api.example.com/hr"},
+	}, AccessTokenClaims(server.NewConfig(), session, token))
+
+	session.DPoPThumbprint = "thumbprint"
+	assert.Equal(t, map[string]string{"jkt": "thumbprint"}, AccessTokenClaims(server.NewConfig(), session, token)["cnf"])
+}
@@ -0,0 +1,334 @@
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/yjv/goauth2-server/server"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DPoPAlgorithms are the algorithms DPoP proofs may be signed with, the ones
+// advertised as dpop_signing_alg_values_supported.
+var DPoPAlgorithms = []string{"ES256", "EdDSA", "PS256", "RS256"}
+
+// dpopClockSkew is how far in the future a proof may have been issued, the
+// clocks of browsers are not always right.
+const dpopClockSkew = time.Minute
+
+// DPoPProof is a parsed DPoP proof from RFC 9449 section 4.2 whose signature
+// was checked against the key it carries. Thumbprint is the RFC 7638
+// thumbprint of that key which tokens are bound to.
+type DPoPProof struct {
+	Key             *JWK
+	Thumbprint      string
+	Id              string
+	Method          string
+	Uri             string
+	IssuedAt        time.Time
+	AccessTokenHash string
+	Nonce           string
+}
+
+type dpopHeader struct {
+	Type      string                     `json:"typ"`
+	Algorithm string                     `json:"alg"`
+	Key       map[string]json.RawMessage `json:"jwk"`
+}
+
+type dpopClaims struct {
+	Id              string `json:"jti"`
+	Method          string `json:"htm"`
+	Uri             string `json:"htu"`
+	IssuedAt        int64  `json:"iat"`
+	AccessTokenHash string `json:"ath"`
+	Nonce           string `json:"nonce"`
+}
+
+// ParseDPoPProof parses the proof and checks it is signed by the public key
+// in its header with one of the DPoPAlgorithms. Whether it was made for the
+// request it came with is up to DPoPValidator.
+func ParseDPoPProof(proof string) (*DPoPProof, error) {
+
+	parts := strings.Split(proof, ".")
+
+	if len(parts) != 3 {
+
+		return nil, fmt.Errorf("it is not a signed JWT")
+	}
+
+	header := &dpopHeader{}
+
+	if error := decodeJSONSegment(parts[0], header); error != nil {
+
+		return nil, fmt.Errorf("its header can not be decoded")
+	}
+
+	if header.Type != "dpop+jwt" {
+
+		return nil, fmt.Errorf("its typ must be dpop+jwt")
+	}
+
+	if !containsAlgorithm(DPoPAlgorithms, header.Algorithm) {
+
+		return nil, fmt.Errorf("the algorithm %q is not supported", header.Algorithm)
+	}
+
+	for _, member := range []string{"d", "p", "q", "dp", "dq", "qi", "k"} {
+
+		if _, ok := header.Key[member]; ok {
+
+			return nil, fmt.Errorf("its jwk must not contain a private key")
+		}
+	}
+
+	key := &JWK{}
+	encodedKey, _ := json.Marshal(header.Key)
+
+	if error := json.Unmarshal(encodedKey, key); error != nil || header.Key == nil {
+
+		return nil, fmt.Errorf("its jwk is missing or malformed")
+	}
+
+	publicKey, error := key.PublicKey()
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	signature, error := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if error != nil {
+
+		return nil, fmt.Errorf("its signature can not be decoded")
+	}
+
+	if error := verify(header.Algorithm, publicKey, parts[0]+"."+parts[1], signature); error != nil {
+
+		return nil, fmt.Errorf("its signature is invalid")
+	}
+
+	claims := &dpopClaims{}
+
+	if error := decodeJSONSegment(parts[1], claims); error != nil {
+
+		return nil, fmt.Errorf("its claims can not be decoded")
+	}
+
+	if claims.Id == "" || claims.Method == "" || claims.Uri == "" || claims.IssuedAt == 0 {
+
+		return nil, fmt.Errorf("it needs the jti, htm, htu and iat claims")
+	}
+
+	thumbprint, error := key.Thumbprint()
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	return &DPoPProof{
+		Key:             key,
+		Thumbprint:      thumbprint,
+		Id:              claims.Id,
+		Method:          claims.Method,
+		Uri:             claims.Uri,
+		IssuedAt:        time.Unix(claims.IssuedAt, 0),
+		AccessTokenHash: claims.AccessTokenHash,
+		Nonce:           claims.Nonce,
+	}, nil
+}
+
+// AccessTokenHash is the ath claim a proof sent with the access token has to
+// carry.
+func AccessTokenHash(accessToken string) string {
+
+	hash := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// DPoPNonces hands out the server provided nonces from RFC 9449 section 8
+// without storing them. A nonce is an HMAC of the current period under the
+// secret, so every instance sharing the secret accepts it. The nonce of the
+// period before is accepted as well so a nonce is not refused right after it
+// was handed out.
+type DPoPNonces struct {
+	secret   []byte
+	lifetime time.Duration
+}
+
+func (nonces *DPoPNonces) Nonce(now time.Time) string {
+
+	return nonces.periodNonce(now.UnixNano() / int64(nonces.lifetime))
+}
+
+func (nonces *DPoPNonces) Valid(nonce string, now time.Time) bool {
+
+	period := now.UnixNano() / int64(nonces.lifetime)
+	return hmac.Equal([]byte(nonce), []byte(nonces.periodNonce(period))) ||
+		hmac.Equal([]byte(nonce), []byte(nonces.periodNonce(period-1)))
+}
+
+func (nonces *DPoPNonces) periodNonce(period int64) string {
+
+	mac := hmac.New(sha256.New, nonces.secret)
+	binary.Write(mac, binary.BigEndian, period)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func NewDPoPNonces(secret []byte, lifetime time.Duration) *DPoPNonces {
+
+	return &DPoPNonces{secret, lifetime}
+}
+
+// DPoPValidator checks DPoP proofs were made for the request they came with.
+// Proofs are accepted for ProofLifetime after they were issued and only once,
+// their jti is remembered in the replay cache for as long.
+type DPoPValidator struct {
+	replayCache   server.ReplayCache
+	nonces        *DPoPNonces
+	clock         server.Clock
+	ProofLifetime time.Duration
+}
+
+// Validate checks the proof was signed for a request with the method to the
+// uri. Resource servers pass the access token the proof came with, the proof
+// has to carry its hash then. When nonces are handed out a proof without a
+// current nonce gets a UseDPoPNonceError with a new one.
+func (validator *DPoPValidator) Validate(proof string, method string, uri string, accessToken string) (*DPoPProof, server.OauthError) {
+
+	parsed, error := ParseDPoPProof(proof)
+
+	if error != nil {
+
+		return nil, server.NewInvalidDPoPProofError(error.Error())
+	}
+
+	if parsed.Method != method {
+
+		return nil, server.NewInvalidDPoPProofError(fmt.Sprintf("it was made for a %s request", parsed.Method))
+	}
+
+	if normalizeDPoPUri(parsed.Uri) != normalizeDPoPUri(uri) {
+
+		return nil, server.NewInvalidDPoPProofError(fmt.Sprintf("it was made for %s", parsed.Uri))
+	}
+
+	now := validator.clock.Now()
+
+	if parsed.IssuedAt.Before(now.Add(-validator.ProofLifetime)) || parsed.IssuedAt.After(now.Add(dpopClockSkew)) {
+
+		return nil, server.NewInvalidDPoPProofError("it was issued too long ago or in the future")
+	}
+
+	if accessToken != "" && !hmac.Equal([]byte(parsed.AccessTokenHash), []byte(AccessTokenHash(accessToken))) {
+
+		return nil, server.NewInvalidDPoPProofError("its ath does not match the access token")
+	}
+
+	if validator.nonces != nil && !validator.nonces.Valid(parsed.Nonce, now) {
+
+		return nil, server.NewUseDPoPNonceError(validator.nonces.Nonce(now))
+	}
+
+	//the jti only has to be unique for the key, so it is remembered with the thumbprint
+	fresh, error := validator.replayCache.Remember(parsed.Thumbprint+":"+parsed.Id, parsed.IssuedAt.Add(validator.ProofLifetime), now)
+
+	if error != nil || !fresh {
+
+		return nil, server.NewInvalidDPoPProofError("it was used before")
+	}
+
+	return parsed, nil
+}
+
+// Nonce returns the nonce clients should sign their next proof with, it is
+// empty when no nonces are handed out.
+func (validator *DPoPValidator) Nonce() string {
+
+	if validator.nonces == nil {
+
+		return ""
+	}
+
+	return validator.nonces.Nonce(validator.clock.Now())
+}
+
+func (validator *DPoPValidator) Nonces() *DPoPNonces {
+
+	return validator.nonces
+}
+
+// SetNonces makes clients sign their proofs with a nonce from nonces. Nil
+// accepts proofs without nonces.
+func (validator *DPoPValidator) SetNonces(nonces *DPoPNonces) *DPoPValidator {
+
+	validator.nonces = nonces
+	return validator
+}
+
+func (validator *DPoPValidator) Clock() server.Clock {
+
+	return validator.clock
+}
+
+func (validator *DPoPValidator) SetClock(clock server.Clock) *DPoPValidator {
+
+	validator.clock = clock
+	return validator
+}
+
+// normalizeDPoPUri compares uris the way RFC 9449 section 4.3 asks for, with
+// the scheme and host lower cased, default ports removed and without the
+// query and fragment.
+func normalizeDPoPUri(uri string) string {
+
+	parsed, error := url.Parse(uri)
+
+	if error != nil {
+
+		return uri
+	}
+
+	scheme := strings.ToLower(parsed.Scheme)
+	host := strings.ToLower(parsed.Hostname())
+	port := parsed.Port()
+
+	if port != "" && !(scheme == "https" && port == "443") && !(scheme == "http" && port == "80") {
+
+		host += ":" + port
+	}
+
+	path := parsed.EscapedPath()
+
+	if path == "" {
+
+		path = "/"
+	}
+
+	return scheme + "://" + host + path
+}
+
+func containsAlgorithm(algorithms []string, algorithm string) bool {
+
+	for _, candidate := range algorithms {
+
+		if candidate == algorithm {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func NewDPoPValidator(replayCache server.ReplayCache) *DPoPValidator {
+
+	return &DPoPValidator{replayCache, nil, server.NewSystemClock(), 5 * time.Minute}
+}
@@ -0,0 +1,223 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/yjv/goauth2-server/server"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const dpopTokenUri = "https://auth.example.com/token"
+
+func TestJWKThumbprint(t *testing.T) {
+
+	//the example from RFC 7638 section 3.1
+	jwk := &JWK{
+		KeyType:   "RSA",
+		KeyId:     "2011-04-29",
+		Algorithm: "RS256",
+		Modulus:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		Exponent:  "AQAB",
+	}
+
+	thumbprint, error := jwk.Thumbprint()
+	assert.Nil(t, error)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint)
+
+	_, error = (&JWK{KeyType: "oct"}).Thumbprint()
+	assert.NotNil(t, error)
+}
+
+func TestParseDPoPProof(t *testing.T) {
+
+	ecdsaKey, error := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, error)
+	_, ed25519Key, error := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, error)
+	rsaKey, error := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, error)
+
+	for _, key := range []crypto.Signer{ecdsaKey, ed25519Key, rsaKey} {
+
+		proof, error := ParseDPoPProof(signDPoPProof(t, "dpop+jwt", key, proofClaims(time.Unix(1700000000, 0))))
+		assert.Nil(t, error)
+		assert.Equal(t, "proof-id", proof.Id)
+		assert.Equal(t, "POST", proof.Method)
+		assert.Equal(t, dpopTokenUri, proof.Uri)
+		assert.Equal(t, time.Unix(1700000000, 0), proof.IssuedAt)
+
+		thumbprint, error := proof.Key.Thumbprint()
+		assert.Nil(t, error)
+		assert.Equal(t, thumbprint, proof.Thumbprint)
+	}
+
+	_, error = ParseDPoPProof(signDPoPProof(t, "JWT", ecdsaKey, proofClaims(time.Unix(1700000000, 0))))
+	assert.EqualError(t, error, "its typ must be dpop+jwt")
+
+	claims := proofClaims(time.Unix(1700000000, 0))
+	delete(claims, "jti")
+	_, error = ParseDPoPProof(signDPoPProof(t, "dpop+jwt", ecdsaKey, claims))
+	assert.EqualError(t, error, "it needs the jti, htm, htu and iat claims")
+
+	otherKey, error := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, error)
+	proof := signDPoPProof(t, "dpop+jwt", ecdsaKey, proofClaims(time.Unix(1700000000, 0)))
+	forged := signDPoPProof(t, "dpop+jwt", otherKey, proofClaims(time.Unix(1700000000, 0)))
+	_, error = ParseDPoPProof(proof[:len(proof)-86] + forged[len(forged)-86:])
+	assert.EqualError(t, error, "its signature is invalid")
+
+	_, error = ParseDPoPProof("not.a.proof.at.all")
+	assert.EqualError(t, error, "it is not a signed JWT")
+}
+
+func TestDPoPValidatorValidate(t *testing.T) {
+
+	now := time.Unix(1700000000, 0)
+	key, error := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, error)
+	validator := NewDPoPValidator(newDPoPReplayCache()).SetClock(server.NewFakeClock(now))
+
+	proof, oauthError := validator.Validate(signDPoPProof(t, "dpop+jwt", key, proofClaims(now)), "POST", "https://AUTH.example.com:443/token?x=1", "")
+	assert.Nil(t, oauthError)
+	assert.Equal(t, "proof-id", proof.Id)
+
+	_, oauthError = validator.Validate(signDPoPProof(t, "dpop+jwt", key, proofClaims(now)), "POST", dpopTokenUri, "")
+	assert.Equal(t, server.NewInvalidDPoPProofError("it was used before"), oauthError)
+
+	claims := proofClaims(now)
+	claims["jti"] = "other-id"
+	_, oauthError = validator.Validate(signDPoPProof(t, "dpop+jwt", key, claims), "GET", dpopTokenUri, "")
+	assert.Equal(t, server.NewInvalidDPoPProofError("it was made for a POST request"), oauthError)
+
+	_, oauthError = validator.Validate(signDPoPProof(t, "dpop+jwt", key, claims), "POST", "https://auth.example.com/introspect", "")
+	assert.Equal(t, server.NewInvalidDPoPProofError("it was made for "+dpopTokenUri), oauthError)
+
+	claims = proofClaims(now.Add(-10 * time.Minute))
+	claims["jti"] = "old-id"
+	_, oauthError = validator.Validate(signDPoPProof(t, "dpop+jwt", key, claims), "POST", dpopTokenUri, "")
+	assert.Equal(t, server.NewInvalidDPoPProofError("it was issued too long ago or in the future"), oauthError)
+
+	claims = proofClaims(now)
+	claims["jti"] = "token-id"
+	claims["ath"] = AccessTokenHash("access-token")
+	_, oauthError = validator.Validate(signDPoPProof(t, "dpop+jwt", key, claims), "POST", dpopTokenUri, "other-token")
+	assert.Equal(t, server.NewInvalidDPoPProofError("its ath does not match the access token"), oauthError)
+
+	_, oauthError = validator.Validate(signDPoPProof(t, "dpop+jwt", key, claims), "POST", dpopTokenUri, "access-token")
+	assert.Nil(t, oauthError)
+}
+
+func TestDPoPValidatorValidateWithNonces(t *testing.T) {
+
+	now := time.Unix(1700000000, 0)
+	key, error := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, error)
+	nonces := NewDPoPNonces([]byte("0123456789abcdef0123456789abcdef"), time.Minute)
+	clock := server.NewFakeClock(now)
+	validator := NewDPoPValidator(newDPoPReplayCache()).SetClock(clock).SetNonces(nonces)
+	assert.Equal(t, nonces.Nonce(now), validator.Nonce())
+	assert.Equal(t, "", NewDPoPValidator(newDPoPReplayCache()).Nonce())
+
+	_, oauthError := validator.Validate(signDPoPProof(t, "dpop+jwt", key, proofClaims(now)), "POST", dpopTokenUri, "")
+	assert.Equal(t, server.NewUseDPoPNonceError(nonces.Nonce(now)), oauthError)
+
+	claims := proofClaims(now)
+	claims["nonce"] = nonces.Nonce(now)
+	_, oauthError = validator.Validate(signDPoPProof(t, "dpop+jwt", key, claims), "POST", dpopTokenUri, "")
+	assert.Nil(t, oauthError)
+
+	//the nonce of the period before is still accepted, the one before that is not
+	assert.True(t, nonces.Valid(nonces.Nonce(now), now.Add(time.Minute)))
+	assert.False(t, nonces.Valid(nonces.Nonce(now), now.Add(2*time.Minute)))
+	assert.False(t, NewDPoPNonces([]byte("another secret of at least 32 bytes"), time.Minute).Valid(nonces.Nonce(now), now))
+}
+
+func TestAccessTokenHash(t *testing.T) {
+
+	hash := sha256.Sum256([]byte("access-token"))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(hash[:]), AccessTokenHash("access-token"))
+}
+
+func proofClaims(issuedAt time.Time) map[string]interface{} {
+
+	return map[string]interface{}{
+		"jti": "proof-id",
+		"htm": "POST",
+		"htu": dpopTokenUri,
+		"iat": issuedAt.Unix(),
+	}
+}
+
+func signDPoPProof(t *testing.T, typ string, key crypto.Signer, claims map[string]interface{}) string {
+
+	header := map[string]interface{}{"typ": typ}
+	encode := func(value *big.Int, size int) string {
+
+		return base64.RawURLEncoding.EncodeToString(value.FillBytes(make([]byte, size)))
+	}
+
+	switch publicKey := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		header["alg"] = "ES256"
+		header["jwk"] = map[string]string{"kty": "EC", "crv": "P-256", "x": encode(publicKey.X, 32), "y": encode(publicKey.Y, 32)}
+	case ed25519.PublicKey:
+		header["alg"] = "EdDSA"
+		header["jwk"] = map[string]string{"kty": "OKP", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(publicKey)}
+	case *rsa.PublicKey:
+		header["alg"] = "RS256"
+		header["jwk"] = map[string]string{"kty": "RSA", "n": encode(publicKey.N, publicKey.Size()), "e": "AQAB"}
+	}
+
+	encodedHeader, error := json.Marshal(header)
+	assert.Nil(t, error)
+	encodedClaims, error := json.Marshal(claims)
+	assert.Nil(t, error)
+	signingInput := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." + base64.RawURLEncoding.EncodeToString(encodedClaims)
+	hash := sha256.Sum256([]byte(signingInput))
+	var signature []byte
+
+	switch signer := key.(type) {
+	case *ecdsa.PrivateKey:
+		r, s, error := ecdsa.Sign(rand.Reader, signer, hash[:])
+		assert.Nil(t, error)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(signer, []byte(signingInput))
+	case *rsa.PrivateKey:
+		signature, error = rsa.SignPKCS1v15(rand.Reader, signer, crypto.SHA256, hash[:])
+		assert.Nil(t, error)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// dpopReplayCache keeps the tests independent of the storage packages.
+type dpopReplayCache struct {
+	ids map[string]time.Time
+}
+
+func (cache *dpopReplayCache) Remember(id string, expiresAt time.Time, now time.Time) (bool, error) {
+
+	if seenExpiresAt, ok := cache.ids[id]; ok && seenExpiresAt.After(now) {
+
+		return false, nil
+	}
+
+	cache.ids[id] = expiresAt
+	return true, nil
+}
+
+func newDPoPReplayCache() *dpopReplayCache {
+
+	return &dpopReplayCache{map[string]time.Time{}}
+}
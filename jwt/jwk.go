@@ -0,0 +1,153 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// PublicKeySigner is implemented by signers whose tokens can be verified by
+// anyone holding the public key, which is what gets published in a key set.
+type PublicKeySigner interface {
+	Signer
+	PublicKey() *rsa.PublicKey
+}
+
+func (signer *RSASigner) PublicKey() *rsa.PublicKey {
+
+	return &signer.key.PublicKey
+}
+
+// JWK is a public key as described in RFC 7517. The keys published for the
+// signers are RSA keys from RFC 7518 section 6.3, the keys clients sign DPoP
+// proofs with can also be elliptic curve keys from section 6.2 and Ed25519
+// keys from RFC 8037.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	KeyId     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Modulus   string `json:"n,omitempty"`
+	Exponent  string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// PublicKey returns the key as an *rsa.PublicKey, *ecdsa.PublicKey on P-256
+// or ed25519.PublicKey. Other key types and curves are not supported.
+func (jwk *JWK) PublicKey() (crypto.PublicKey, error) {
+
+	switch {
+	case jwk.KeyType == "RSA":
+		modulus, modulusError := base64.RawURLEncoding.DecodeString(jwk.Modulus)
+		exponent, exponentError := base64.RawURLEncoding.DecodeString(jwk.Exponent)
+
+		if modulusError != nil || exponentError != nil || len(modulus) == 0 || len(exponent) == 0 || len(exponent) > 4 {
+
+			return nil, fmt.Errorf("the RSA key is malformed")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}, nil
+	case jwk.KeyType == "EC" && jwk.Curve == "P-256":
+		x, xError := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, yError := base64.RawURLEncoding.DecodeString(jwk.Y)
+
+		if xError != nil || yError != nil || len(x) != 32 || len(y) != 32 {
+
+			return nil, fmt.Errorf("the EC key is malformed")
+		}
+
+		//ecdh refuses points that are not on the curve
+		if _, error := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); error != nil {
+
+			return nil, fmt.Errorf("the EC key is not on the P-256 curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case jwk.KeyType == "OKP" && jwk.Curve == "Ed25519":
+		x, error := base64.RawURLEncoding.DecodeString(jwk.X)
+
+		if error != nil || len(x) != ed25519.PublicKeySize {
+
+			return nil, fmt.Errorf("the Ed25519 key is malformed")
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("keys of type %q on curve %q are not supported", jwk.KeyType, jwk.Curve)
+}
+
+// Thumbprint is the base64url encoded SHA-256 thumbprint of the key from RFC
+// 7638, the hash of its required members in lexicographic order.
+func (jwk *JWK) Thumbprint() (string, error) {
+
+	var canonical string
+
+	switch jwk.KeyType {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%s,"kty":"RSA","n":%s}`, jsonString(jwk.Exponent), jsonString(jwk.Modulus))
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%s,"kty":"EC","x":%s,"y":%s}`, jsonString(jwk.Curve), jsonString(jwk.X), jsonString(jwk.Y))
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%s,"kty":"OKP","x":%s}`, jsonString(jwk.Curve), jsonString(jwk.X))
+	default:
+		return "", fmt.Errorf("keys of type %q have no thumbprint", jwk.KeyType)
+	}
+
+	hash := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+func jsonString(value string) string {
+
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+type JWKSet struct {
+	Keys []*JWK `json:"keys"`
+}
+
+func NewJWK(signer PublicKeySigner) *JWK {
+
+	publicKey := signer.PublicKey()
+
+	return &JWK{
+		"RSA",
+		"sig",
+		signer.KeyId(),
+		signer.Algorithm(),
+		base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		"",
+		"",
+		"",
+	}
+}
+
+// NewJWKSet publishes the signers that have a public key, symmetric keys like
+// the ones used by HMACSigner must never be published and are left out.
+func NewJWKSet(signers []Signer) *JWKSet {
+
+	set := &JWKSet{[]*JWK{}}
+
+	for _, signer := range signers {
+
+		if publicKeySigner, ok := signer.(PublicKeySigner); ok {
+
+			set.Keys = append(set.Keys, NewJWK(publicKeySigner))
+		}
+	}
+
+	return set
+}
@@ -0,0 +1,30 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestNewJWKSet(t *testing.T) {
+
+	key, error := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, error)
+
+	set := NewJWKSet([]Signer{NewHMACSigner("hmac", []byte("secret")), NewRSASigner("rsa", key)})
+	assert.Len(t, set.Keys, 1)
+	assert.Equal(t, "RSA", set.Keys[0].KeyType)
+	assert.Equal(t, "sig", set.Keys[0].Use)
+	assert.Equal(t, "rsa", set.Keys[0].KeyId)
+	assert.Equal(t, "RS256", set.Keys[0].Algorithm)
+	assert.Equal(t, "AQAB", set.Keys[0].Exponent)
+
+	modulus, error := base64.RawURLEncoding.DecodeString(set.Keys[0].Modulus)
+	assert.Nil(t, error)
+	assert.Equal(t, 0, key.N.Cmp(new(big.Int).SetBytes(modulus)))
+
+	assert.Equal(t, &JWKSet{[]*JWK{}}, NewJWKSet(nil))
+}
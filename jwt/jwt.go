@@ -0,0 +1,197 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+type Signer interface {
+	Algorithm() string
+	KeyId() string
+	Sign(data []byte) ([]byte, error)
+}
+
+type HMACSigner struct {
+	keyId string
+	key   []byte
+}
+
+func (signer *HMACSigner) Algorithm() string {
+
+	return "HS256"
+}
+
+func (signer *HMACSigner) KeyId() string {
+
+	return signer.keyId
+}
+
+func (signer *HMACSigner) Sign(data []byte) ([]byte, error) {
+
+	mac := hmac.New(sha256.New, signer.key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+func NewHMACSigner(keyId string, key []byte) *HMACSigner {
+
+	return &HMACSigner{keyId, key}
+}
+
+type RSASigner struct {
+	keyId string
+	key   *rsa.PrivateKey
+}
+
+func (signer *RSASigner) Algorithm() string {
+
+	return "RS256"
+}
+
+func (signer *RSASigner) KeyId() string {
+
+	return signer.keyId
+}
+
+func (signer *RSASigner) Sign(data []byte) ([]byte, error) {
+
+	digest := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, signer.key, crypto.SHA256, digest[:])
+}
+
+func NewRSASigner(keyId string, key *rsa.PrivateKey) *RSASigner {
+
+	return &RSASigner{keyId, key}
+}
+
+func Encode(claims map[string]interface{}, signer Signer) (string, error) {
+
+	return EncodeWithType(claims, signer, "JWT")
+}
+
+// EncodeWithType sets the typ header to tokenType, like at+jwt for access
+// tokens, so one kind of token can not be passed off as another.
+func EncodeWithType(claims map[string]interface{}, signer Signer, tokenType string) (string, error) {
+
+	header := map[string]string{
+		"alg": signer.Algorithm(),
+		"typ": tokenType,
+	}
+
+	if signer.KeyId() != "" {
+
+		header["kid"] = signer.KeyId()
+	}
+
+	encodedHeader, error := encodeSegment(header)
+
+	if error != nil {
+
+		return "", error
+	}
+
+	encodedClaims, error := encodeSegment(claims)
+
+	if error != nil {
+
+		return "", error
+	}
+
+	signingInput := encodedHeader + "." + encodedClaims
+	signature, error := signer.Sign([]byte(signingInput))
+
+	if error != nil {
+
+		return "", error
+	}
+
+	return strings.Join([]string{signingInput, base64.RawURLEncoding.EncodeToString(signature)}, "."), nil
+}
+
+// verify checks the signature over the signing input with the public key,
+// which has to be of the kind the algorithm is for.
+func verify(algorithm string, publicKey crypto.PublicKey, signingInput string, signature []byte) error {
+
+	hash := sha256.Sum256([]byte(signingInput))
+
+	switch algorithm {
+	case "RS256", "PS256":
+		rsaKey, ok := publicKey.(*rsa.PublicKey)
+
+		if !ok || rsaKey.N.BitLen() < 2048 {
+
+			return fmt.Errorf("%s needs an RSA key of at least 2048 bits", algorithm)
+		}
+
+		if algorithm == "PS256" {
+
+			return rsa.VerifyPSS(rsaKey, crypto.SHA256, hash[:], signature, nil)
+		}
+
+		return rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, hash[:], signature)
+	case "ES256":
+		ecdsaKey, ok := publicKey.(*ecdsa.PublicKey)
+
+		if !ok || len(signature) != 64 {
+
+			return fmt.Errorf("ES256 needs a P-256 key and a 64 byte signature")
+		}
+
+		if !ecdsa.Verify(ecdsaKey, hash[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+
+			return fmt.Errorf("the signature does not match")
+		}
+
+		return nil
+	case "EdDSA":
+		ed25519Key, ok := publicKey.(ed25519.PublicKey)
+
+		if !ok {
+
+			return fmt.Errorf("EdDSA needs an Ed25519 key")
+		}
+
+		if !ed25519.Verify(ed25519Key, []byte(signingInput), signature) {
+
+			return fmt.Errorf("the signature does not match")
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("the algorithm %q is not supported", algorithm)
+}
+
+func encodeSegment(value interface{}) (string, error) {
+
+	encoded, error := json.Marshal(value)
+
+	if error != nil {
+
+		return "", error
+	}
+
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+func decodeJSONSegment(segment string, value interface{}) error {
+
+	decoded, error := base64.RawURLEncoding.DecodeString(segment)
+
+	if error != nil {
+
+		return error
+	}
+
+	return json.Unmarshal(decoded, value)
+}
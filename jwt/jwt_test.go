@@ -0,0 +1,63 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestEncodeWithHMACSigner(t *testing.T) {
+
+	signer := NewHMACSigner("key1", []byte("secret"))
+	assert.Equal(t, "HS256", signer.Algorithm())
+	assert.Equal(t, "key1", signer.KeyId())
+
+	token, error := Encode(map[string]interface{}{"sub": "owner"}, signer)
+	assert.Nil(t, error)
+
+	parts := strings.Split(token, ".")
+	assert.Len(t, parts, 3)
+	assert.Equal(t, map[string]interface{}{"alg": "HS256", "typ": "JWT", "kid": "key1"}, decodeSegment(t, parts[0]))
+	assert.Equal(t, map[string]interface{}{"sub": "owner"}, decodeSegment(t, parts[1]))
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), parts[2])
+}
+
+func TestEncodeWithRSASigner(t *testing.T) {
+
+	key, error := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, error)
+
+	signer := NewRSASigner("", key)
+	assert.Equal(t, "RS256", signer.Algorithm())
+
+	token, error := Encode(map[string]interface{}{"sub": "owner"}, signer)
+	assert.Nil(t, error)
+
+	parts := strings.Split(token, ".")
+	assert.Equal(t, map[string]interface{}{"alg": "RS256", "typ": "JWT"}, decodeSegment(t, parts[0]))
+
+	signature, error := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.Nil(t, error)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.Nil(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature))
+}
+
+func decodeSegment(t *testing.T, segment string) map[string]interface{} {
+
+	decoded, error := base64.RawURLEncoding.DecodeString(segment)
+	assert.Nil(t, error)
+
+	value := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal(decoded, &value))
+	return value
+}
@@ -0,0 +1,241 @@
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/yjv/goauth2-server/server"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (histogram *histogram) observe(buckets []float64, value float64) {
+
+	for index, bound := range buckets {
+
+		if value <= bound {
+
+			histogram.counts[index]++
+		}
+	}
+
+	histogram.count++
+	histogram.sum += value
+}
+
+// Metrics keeps the server's measurements in memory and serves them in the
+// Prometheus text exposition format.
+type Metrics struct {
+	mutex              sync.Mutex
+	buckets            []float64
+	tokenRequests      map[[2]string]uint64
+	tokenDurations     map[string]*histogram
+	storageDurations   map[string]*histogram
+	revocations        uint64
+	refreshTokenReuses uint64
+	sessionCounter     server.ActiveSessionCounter
+}
+
+func (metrics *Metrics) TokenRequest(grant string, outcome string, duration time.Duration) {
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.tokenRequests[[2]string{grant, outcome}]++
+	metrics.observe(metrics.tokenDurations, grant, duration)
+}
+
+func (metrics *Metrics) StorageCall(method string, duration time.Duration) {
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.observe(metrics.storageDurations, method, duration)
+}
+
+func (metrics *Metrics) Revocation() {
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.revocations++
+}
+
+func (metrics *Metrics) RefreshTokenReuse() {
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.refreshTokenReuses++
+}
+
+func (metrics *Metrics) observe(histograms map[string]*histogram, key string, duration time.Duration) {
+
+	observed, ok := histograms[key]
+
+	if !ok {
+
+		observed = &histogram{counts: make([]uint64, len(metrics.buckets))}
+		histograms[key] = observed
+	}
+
+	observed.observe(metrics.buckets, duration.Seconds())
+}
+
+// SetActiveSessionCounter makes the active session gauge available, the
+// sessions are counted on every scrape.
+func (metrics *Metrics) SetActiveSessionCounter(sessionCounter server.ActiveSessionCounter) *Metrics {
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.sessionCounter = sessionCounter
+	return metrics
+}
+
+func (metrics *Metrics) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+
+	writer.Header().Set("Content-Type", ContentType)
+	metrics.Write(writer)
+}
+
+func (metrics *Metrics) Write(writer io.Writer) error {
+
+	//counting can take a while on a big storage, token requests should not have to wait for it
+	activeSessions, counted := metrics.countActiveSessions()
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	buffered := bufio.NewWriter(writer)
+
+	writeHeader(buffered, "goauth2_token_requests_total", "counter", "Token requests by grant and outcome.")
+
+	for _, key := range sortedPairs(metrics.tokenRequests) {
+
+		fmt.Fprintf(buffered, "goauth2_token_requests_total{grant=%s,outcome=%s} %d\n", quote(key[0]), quote(key[1]), metrics.tokenRequests[key])
+	}
+
+	metrics.writeHistograms(buffered, "goauth2_token_request_duration_seconds", "Token request latency by grant.", "grant", metrics.tokenDurations)
+	metrics.writeHistograms(buffered, "goauth2_storage_call_duration_seconds", "Storage call latency by method.", "method", metrics.storageDurations)
+
+	writeHeader(buffered, "goauth2_session_revocations_total", "counter", "Sessions revoked.")
+	fmt.Fprintf(buffered, "goauth2_session_revocations_total %d\n", metrics.revocations)
+
+	writeHeader(buffered, "goauth2_refresh_token_reuse_total", "counter", "Rotated out refresh tokens that were presented again.")
+	fmt.Fprintf(buffered, "goauth2_refresh_token_reuse_total %d\n", metrics.refreshTokenReuses)
+
+	if counted {
+
+		writeHeader(buffered, "goauth2_active_sessions", "gauge", "Sessions with a usable token.")
+		fmt.Fprintf(buffered, "goauth2_active_sessions %d\n", activeSessions)
+	}
+
+	return buffered.Flush()
+}
+
+// countActiveSessions reports false when there is no counter or it failed.
+func (metrics *Metrics) countActiveSessions() (int, bool) {
+
+	metrics.mutex.Lock()
+	sessionCounter := metrics.sessionCounter
+	metrics.mutex.Unlock()
+
+	if sessionCounter == nil {
+
+		return 0, false
+	}
+
+	count, error := sessionCounter.CountActiveSessions()
+	return count, error == nil
+}
+
+func (metrics *Metrics) writeHistograms(writer io.Writer, name string, help string, label string, histograms map[string]*histogram) {
+
+	writeHeader(writer, name, "histogram", help)
+	keys := make([]string, 0, len(histograms))
+
+	for key := range histograms {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+
+		observed := histograms[key]
+		labels := label + "=" + quote(key)
+
+		for index, bound := range metrics.buckets {
+
+			fmt.Fprintf(writer, "%s_bucket{%s,le=%s} %d\n", name, labels, quote(formatFloat(bound)), observed.counts[index])
+		}
+
+		fmt.Fprintf(writer, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, observed.count)
+		fmt.Fprintf(writer, "%s_sum{%s} %s\n", name, labels, formatFloat(observed.sum))
+		fmt.Fprintf(writer, "%s_count{%s} %d\n", name, labels, observed.count)
+	}
+}
+
+func New() *Metrics {
+
+	return NewWithBuckets(DefaultBuckets)
+}
+
+func NewWithBuckets(buckets []float64) *Metrics {
+
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+
+	return &Metrics{
+		buckets:          sorted,
+		tokenRequests:    make(map[[2]string]uint64),
+		tokenDurations:   make(map[string]*histogram),
+		storageDurations: make(map[string]*histogram),
+	}
+}
+
+func writeHeader(writer io.Writer, name string, metricType string, help string) {
+
+	fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func sortedPairs(values map[[2]string]uint64) [][2]string {
+
+	keys := make([][2]string, 0, len(values))
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i int, j int) bool {
+
+		if keys[i][0] != keys[j][0] {
+
+			return keys[i][0] < keys[j][0]
+		}
+
+		return keys[i][1] < keys[j][1]
+	})
+
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quote(value string) string {
+
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func formatFloat(value float64) string {
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
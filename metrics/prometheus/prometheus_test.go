@@ -0,0 +1,93 @@
+package prometheus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type sessionCounter int
+
+func (counter sessionCounter) CountActiveSessions() (int, error) {
+
+	return int(counter), nil
+}
+
+type sessionCounterFunc func() (int, error)
+
+func (counter sessionCounterFunc) CountActiveSessions() (int, error) {
+
+	return counter()
+}
+
+func TestMetricsServeHTTP(t *testing.T) {
+
+	metrics := NewWithBuckets([]float64{1, 0.1})
+	metrics.TokenRequest("password", "success", 50*time.Millisecond)
+	metrics.TokenRequest("password", "success", 500*time.Millisecond)
+	metrics.TokenRequest("client_credentials", "invalid_scope", 2*time.Second)
+	metrics.StorageCall("FindClientById", 250*time.Millisecond)
+	metrics.Revocation()
+	metrics.RefreshTokenReuse()
+	assert.Equal(t, metrics, metrics.SetActiveSessionCounter(sessionCounter(3)))
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, ContentType, recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `# HELP goauth2_token_requests_total Token requests by grant and outcome.
+# TYPE goauth2_token_requests_total counter
+goauth2_token_requests_total{grant="client_credentials",outcome="invalid_scope"} 1
+goauth2_token_requests_total{grant="password",outcome="success"} 2
+# HELP goauth2_token_request_duration_seconds Token request latency by grant.
+# TYPE goauth2_token_request_duration_seconds histogram
+goauth2_token_request_duration_seconds_bucket{grant="client_credentials",le="0.1"} 0
+goauth2_token_request_duration_seconds_bucket{grant="client_credentials",le="1"} 0
+goauth2_token_request_duration_seconds_bucket{grant="client_credentials",le="+Inf"} 1
+goauth2_token_request_duration_seconds_sum{grant="client_credentials"} 2
+goauth2_token_request_duration_seconds_count{grant="client_credentials"} 1
+goauth2_token_request_duration_seconds_bucket{grant="password",le="0.1"} 1
+goauth2_token_request_duration_seconds_bucket{grant="password",le="1"} 2
+goauth2_token_request_duration_seconds_bucket{grant="password",le="+Inf"} 2
+goauth2_token_request_duration_seconds_sum{grant="password"} 0.55
+goauth2_token_request_duration_seconds_count{grant="password"} 2
+# HELP goauth2_storage_call_duration_seconds Storage call latency by method.
+# TYPE goauth2_storage_call_duration_seconds histogram
+goauth2_storage_call_duration_seconds_bucket{method="FindClientById",le="0.1"} 0
+goauth2_storage_call_duration_seconds_bucket{method="FindClientById",le="1"} 1
+goauth2_storage_call_duration_seconds_bucket{method="FindClientById",le="+Inf"} 1
+goauth2_storage_call_duration_seconds_sum{method="FindClientById"} 0.25
+goauth2_storage_call_duration_seconds_count{method="FindClientById"} 1
+# HELP goauth2_session_revocations_total Sessions revoked.
+# TYPE goauth2_session_revocations_total counter
+goauth2_session_revocations_total 1
+# HELP goauth2_refresh_token_reuse_total Rotated out refresh tokens that were presented again.
+# TYPE goauth2_refresh_token_reuse_total counter
+goauth2_refresh_token_reuse_total 1
+# HELP goauth2_active_sessions Sessions with a usable token.
+# TYPE goauth2_active_sessions gauge
+goauth2_active_sessions 3
+`, recorder.Body.String())
+}
+
+func TestMetricsWriteCountsSessionsWithoutTheLock(t *testing.T) {
+
+	metrics := NewWithBuckets([]float64{1})
+
+	//a storage wrapped for metrics records the call to count the sessions
+	metrics.SetActiveSessionCounter(sessionCounterFunc(func() (int, error) {
+
+		metrics.StorageCall("CountActiveSessions", time.Millisecond)
+		return 1, nil
+	}))
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, recorder.Body.String(), "goauth2_active_sessions 1\n")
+}
+
+func TestQuote(t *testing.T) {
+
+	assert.Equal(t, `"a\"b\\c\nd"`, quote("a\"b\\c\nd"))
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+// AuditRecord is what gets written to the audit log. It must never hold
+// secrets or raw tokens, tokens are identified by their TokenHash.
+type AuditRecord struct {
+	Time             time.Time `json:"time"`
+	Event            string    `json:"event"`
+	Grant            string    `json:"grant,omitempty"`
+	ClientId         string    `json:"client_id,omitempty"`
+	OwnerId          string    `json:"owner_id,omitempty"`
+	Scopes           []string  `json:"scopes,omitempty"`
+	SourceAddress    string    `json:"source_address,omitempty"`
+	TokenHash        string    `json:"token_hash,omitempty"`
+	ErrorCode        string    `json:"error_code,omitempty"`
+	ErrorDescription string    `json:"error_description,omitempty"`
+}
+
+type AuditSink interface {
+	Record(record *AuditRecord) error
+}
+
+// RemoteAddressRequest is implemented by session requests that know where
+// they came from.
+type RemoteAddressRequest interface {
+	RemoteAddress() string
+}
+
+// AuditListener turns server events into audit records.
+type AuditListener struct {
+	sink AuditSink
+}
+
+func (listener *AuditListener) HandleEvent(event *Event) error {
+
+	record := &AuditRecord{Time: event.Time}
+
+	switch event.Type {
+	case AfterTokenIssue:
+		record.Event = "token_issued"
+	case SessionRevoked:
+		record.Event = "session_revoked"
+	case GrantFailed:
+		record.Event = "grant_failed"
+	default:
+		return nil
+	}
+
+	if event.Request != nil {
+
+		record.Grant = event.Request.Grant()
+		record.ClientId, _ = event.Request.GetFirst("client_id")
+
+		if remoteAddressRequest, ok := event.Request.(RemoteAddressRequest); ok {
+
+			record.SourceAddress = remoteAddressRequest.RemoteAddress()
+		}
+	}
+
+	if record.Event == "token_issued" && record.Grant == "refresh_token" {
+
+		record.Event = "token_refreshed"
+	}
+
+	if event.Client != nil {
+
+		record.ClientId = event.Client.Id
+	}
+
+	if event.Session != nil {
+
+		if event.Session.Owner != nil {
+
+			record.OwnerId = event.Session.Owner.Id
+		}
+
+		for scopeName := range event.Session.Scopes {
+
+			record.Scopes = append(record.Scopes, scopeName)
+		}
+
+		sort.Strings(record.Scopes)
+
+		if event.Session.AccessToken != nil && event.Session.AccessToken.Token != "" {
+
+			record.TokenHash = HashToken(event.Session.AccessToken.Token)
+		}
+	}
+
+	if event.Error != nil {
+
+		record.ErrorCode = Unexpected.String()
+
+		if oauthError, ok := event.Error.(OauthError); ok {
+
+			record.ErrorCode = oauthError.OauthErrorCode().String()
+		}
+
+		record.ErrorDescription = event.Error.Error()
+	}
+
+	return listener.sink.Record(record)
+}
+
+func NewAuditListener(sink AuditSink) *AuditListener {
+
+	return &AuditListener{sink}
+}
+
+// AuditEvents records token issuance, refreshes, revocations and failures
+// from the server in the sink. The records are written asynchronously so a
+// slow sink never holds up a token request.
+func AuditEvents(events *EventDispatcher, sink AuditSink) {
+
+	listener := NewAuditListener(sink)
+
+	for _, eventType := range []EventType{AfterTokenIssue, SessionRevoked, GrantFailed} {
+
+		events.AddAsyncListener(eventType, listener)
+	}
+}
+
+// HashToken identifies a token in logs without giving it away.
+func HashToken(token string) string {
+
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+type MockAuditSink struct {
+	mock.Mock
+}
+
+func (sink *MockAuditSink) Record(record *AuditRecord) error {
+
+	return sink.Mock.Called(record).Error(0)
+}
+
+type remoteAddressRequest struct {
+	*BasicOauthSessionRequest
+}
+
+func (request *remoteAddressRequest) RemoteAddress() string {
+
+	return "10.0.0.1"
+}
+
+func TestAuditListenerRecordsTokenIssue(t *testing.T) {
+
+	sink := &MockAuditSink{}
+	listener := NewAuditListener(sink)
+	request := &remoteAddressRequest{NewBasicOauthSessionRequest("password").Set("client_id", "client").Set("client_secret", "secret")}
+	session := NewSession()
+	session.Client = &Client{Id: "client"}
+	session.Owner = &Owner{Id: "owner"}
+	session.Scopes["write"] = &Scope{Id: "id", Name: "write"}
+	session.Scopes["read"] = &Scope{Id: "id", Name: "read"}
+	session.AccessToken = &Token{Token: "token"}
+	sink.On("Record", &AuditRecord{
+		Time:          testNow,
+		Event:         "token_issued",
+		Grant:         "password",
+		ClientId:      "client",
+		OwnerId:       "owner",
+		Scopes:        []string{"read", "write"},
+		SourceAddress: "10.0.0.1",
+		TokenHash:     HashToken("token"),
+	}).Return(nil).Once()
+
+	assert.Nil(t, listener.HandleEvent(&Event{Type: AfterTokenIssue, Time: testNow, Request: request, Client: session.Client, Session: session}))
+	sink.AssertExpectations(t)
+}
+
+func TestAuditListenerRecordsNoHashWithoutTokenValue(t *testing.T) {
+
+	sink := &MockAuditSink{}
+	listener := NewAuditListener(sink)
+	request := NewBasicOauthSessionRequest("refresh_token").Set("client_id", "client")
+	session := NewSession()
+	session.Client = &Client{Id: "client"}
+
+	//sessions found by their refresh token come back without the access token value
+	session.AccessToken = &Token{ExpiresAt: testNow}
+	sink.On("Record", &AuditRecord{Time: testNow, Event: "token_refreshed", Grant: "refresh_token", ClientId: "client"}).Return(nil).Once()
+
+	assert.Nil(t, listener.HandleEvent(&Event{Type: AfterTokenIssue, Time: testNow, Request: request, Client: session.Client, Session: session}))
+	sink.AssertExpectations(t)
+}
+
+func TestAuditListenerRecordsRefreshAndFailure(t *testing.T) {
+
+	sink := &MockAuditSink{}
+	listener := NewAuditListener(sink)
+	request := NewBasicOauthSessionRequest("refresh_token").Set("client_id", "client")
+	sink.On("Record", &AuditRecord{Time: testNow, Event: "token_refreshed", Grant: "refresh_token", ClientId: "client"}).Return(nil).Once()
+	sink.On("Record", &AuditRecord{
+		Time:             testNow,
+		Event:            "grant_failed",
+		Grant:            "refresh_token",
+		ClientId:         "client",
+		ErrorCode:        "required_value_missing",
+		ErrorDescription: "refresh_token is required.",
+	}).Return(errors.New("disk full")).Once()
+	sink.On("Record", &AuditRecord{
+		Time:             testNow,
+		Event:            "grant_failed",
+		Grant:            "refresh_token",
+		ClientId:         "client",
+		ErrorCode:        "unexpected",
+		ErrorDescription: "boom",
+	}).Return(nil).Once()
+
+	assert.Nil(t, listener.HandleEvent(&Event{Type: AfterTokenIssue, Time: testNow, Request: request}))
+	assert.Equal(t, errors.New("disk full"), listener.HandleEvent(&Event{Type: GrantFailed, Time: testNow, Request: request, Error: &RequiredValueMissingError{"refresh_token"}}))
+	assert.Nil(t, listener.HandleEvent(&Event{Type: GrantFailed, Time: testNow, Request: request, Error: errors.New("boom")}))
+	assert.Nil(t, listener.HandleEvent(&Event{Type: BeforeSessionIssue, Time: testNow, Request: request}))
+	sink.AssertExpectations(t)
+}
+
+func TestAuditEvents(t *testing.T) {
+
+	sink := &MockAuditSink{}
+	events := NewEventDispatcher()
+	recorded := make(chan *AuditRecord, 1)
+	sink.On("Record", mock.AnythingOfType("*server.AuditRecord")).Return(nil).Run(func(args mock.Arguments) {
+
+		recorded <- args.Get(0).(*AuditRecord)
+	})
+	AuditEvents(events, sink)
+
+	session := NewSession()
+	session.Client = &Client{Id: "client"}
+	events.Dispatch(&Event{Type: SessionRevoked, Time: testNow, Client: session.Client, Session: session})
+
+	assert.Equal(t, &AuditRecord{Time: testNow, Event: "session_revoked", ClientId: "client"}, <-recorded)
+}
+
+func TestHashToken(t *testing.T) {
+
+	assert.Equal(t, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", HashToken("foo"))
+	assert.Equal(t, HashToken("foo"), HashRegistrationAccessToken("foo"))
+}
+
+func TestErrorCodeString(t *testing.T) {
+
+	assert.Equal(t, "invalid_scope", InvalidScope.String())
+	assert.Equal(t, "vetoed", Vetoed.String())
+	assert.Equal(t, "unknown", ErrorCode(100).String())
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// AuthorizationDetail is one object of the authorization_details parameter
+// from RFC 9396. It is kept the way the client sent it, the type specific
+// fields next to the common ones like type, locations and actions, so it can
+// be returned in token and introspection responses unchanged.
+type AuthorizationDetail map[string]interface{}
+
+func (detail AuthorizationDetail) Type() string {
+
+	detailType, _ := detail["type"].(string)
+	return detailType
+}
+
+// AuthorizationDetailHandler approves the authorization details of its type.
+// It returns the detail to approve, which may be narrowed down to what the
+// client or owner of the session may do, or an error refusing it.
+type AuthorizationDetailHandler interface {
+	Type() string
+	Approve(detail AuthorizationDetail, session *Session) (AuthorizationDetail, error)
+}
+
+type authorizationDetailHandlerFunc struct {
+	detailType string
+	approve    func(detail AuthorizationDetail, session *Session) (AuthorizationDetail, error)
+}
+
+func (handler *authorizationDetailHandlerFunc) Type() string {
+
+	return handler.detailType
+}
+
+func (handler *authorizationDetailHandlerFunc) Approve(detail AuthorizationDetail, session *Session) (AuthorizationDetail, error) {
+
+	return handler.approve(detail, session)
+}
+
+// NewAuthorizationDetailHandler makes a handler for the type from a function.
+func NewAuthorizationDetailHandler(
+	detailType string,
+	approve func(detail AuthorizationDetail, session *Session) (AuthorizationDetail, error),
+) AuthorizationDetailHandler {
+
+	return &authorizationDetailHandlerFunc{detailType, approve}
+}
+
+// ParseAuthorizationDetails reads the authorization_details parameter, a json
+// array of objects that each name their type. It returns nil when the
+// parameter was not sent.
+func ParseAuthorizationDetails(oauthSessionRequest OauthSessionRequest) ([]AuthorizationDetail, OauthError) {
+
+	raw, ok := oauthSessionRequest.GetFirst("authorization_details")
+
+	if !ok {
+
+		return nil, nil
+	}
+
+	details := []AuthorizationDetail{}
+
+	if error := json.Unmarshal([]byte(raw), &details); error != nil {
+
+		return nil, &InvalidAuthorizationDetailsError{"they must be a json array of objects", error}
+	}
+
+	if len(details) == 0 {
+
+		return nil, &InvalidAuthorizationDetailsError{"the array must not be empty", nil}
+	}
+
+	for index, detail := range details {
+
+		if detail.Type() == "" {
+
+			return nil, &InvalidAuthorizationDetailsError{fmt.Sprintf("entry %d has no type", index), nil}
+		}
+	}
+
+	return details, nil
+}
+
+// approveAuthorizationDetails has the handler of its type approve every
+// detail a new session asks for. A refresh may only ask for details the
+// session was approved and narrows the session down to them as RFC 9396
+// section 6 asks, without any it keeps the details it has.
+func (server *DefaultServer) approveAuthorizationDetails(oauthSessionRequest OauthSessionRequest, session *Session, isNew bool) OauthError {
+
+	details, oauthError := ParseAuthorizationDetails(oauthSessionRequest)
+
+	if details == nil {
+
+		return oauthError
+	}
+
+	if !isNew {
+
+		for _, detail := range details {
+
+			if !containsAuthorizationDetail(session.AuthorizationDetails, detail) {
+
+				return &InvalidAuthorizationDetailsError{fmt.Sprintf("a %s detail was not approved for the session", detail.Type()), nil}
+			}
+		}
+
+		session.AuthorizationDetails = details
+		return nil
+	}
+
+	approved := make([]AuthorizationDetail, 0, len(details))
+
+	for _, detail := range details {
+
+		handler, ok := server.detailHandlers[detail.Type()]
+
+		if !ok {
+
+			return &InvalidAuthorizationDetailsError{fmt.Sprintf("the type %s is not supported", detail.Type()), nil}
+		}
+
+		approvedDetail, error := handler.Approve(detail, session)
+
+		if error != nil {
+
+			return &InvalidAuthorizationDetailsError{fmt.Sprintf("the %s detail was refused", detail.Type()), error}
+		}
+
+		approved = append(approved, approvedDetail)
+	}
+
+	session.AuthorizationDetails = approved
+	return nil
+}
+
+// containsAuthorizationDetail compares the details as json, details read back
+// from a storage can hold other map and number types than the ones parsed
+// from a request.
+func containsAuthorizationDetail(details []AuthorizationDetail, detail AuthorizationDetail) bool {
+
+	encoded, error := json.Marshal(detail)
+
+	if error != nil {
+
+		return false
+	}
+
+	for _, candidate := range details {
+
+		if encodedCandidate, error := json.Marshal(candidate); error == nil && bytes.Equal(encoded, encodedCandidate) {
+
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const paymentDetails = `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"100.00"},"creditorAccount":{"iban":"DE02100100109307118603"}}]`
+
+func TestParseAuthorizationDetails(t *testing.T) {
+
+	details, error := ParseAuthorizationDetails(NewBasicOauthSessionRequest("test"))
+	assert.Nil(t, details)
+	assert.Nil(t, error)
+
+	details, error = ParseAuthorizationDetails(NewBasicOauthSessionRequest("test").Set("authorization_details", paymentDetails))
+	assert.Nil(t, error)
+	assert.Equal(t, []AuthorizationDetail{{
+		"type":             "payment_initiation",
+		"instructedAmount": map[string]interface{}{"currency": "EUR", "amount": "100.00"},
+		"creditorAccount":  map[string]interface{}{"iban": "DE02100100109307118603"},
+	}}, details)
+	assert.Equal(t, "payment_initiation", details[0].Type())
+
+	for _, invalid := range []string{`{"type":"payment_initiation"}`, `not json`, `[]`, `[{"actions":["read"]}]`, `[{"type":1}]`} {
+
+		details, error = ParseAuthorizationDetails(NewBasicOauthSessionRequest("test").Set("authorization_details", invalid))
+		assert.Nil(t, details, invalid)
+
+		if assert.NotNil(t, error, invalid) {
+
+			assert.Equal(t, InvalidAuthorizationDetails, error.OauthErrorCode())
+		}
+	}
+}
+
+func TestServerAuthorizationDetailTypes(t *testing.T) {
+
+	server := NewWithTokenGenerator(&MockTokenGenerator{}, &MockOwnerClientStorage{}, &MockOwnerClientStorage{}, &MockSessionStorage{}, &MockScopeStorage{})
+	assert.Equal(t, []string{}, server.AuthorizationDetailTypes())
+
+	handler := NewAuthorizationDetailHandler("payment_initiation", nil)
+	assert.Equal(t, server, server.AddAuthorizationDetailHandler(handler))
+	server.AddAuthorizationDetailHandler(NewAuthorizationDetailHandler("account_information", nil))
+	assert.Equal(t, []string{"account_information", "payment_initiation"}, server.AuthorizationDetailTypes())
+}
+
+func TestServerGrantOauthSessionApprovesAuthorizationDetails(t *testing.T) {
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test").Set("authorization_details", paymentDetails)
+	server, grant, _ := newMockGrantServer(oauthSessionRequest)
+	var approvedSession *Session
+	server.AddAuthorizationDetailHandler(NewAuthorizationDetailHandler(
+		"payment_initiation",
+		func(detail AuthorizationDetail, session *Session) (AuthorizationDetail, error) {
+
+			approvedSession = session
+			return AuthorizationDetail{"type": detail.Type(), "creditorAccount": detail["creditorAccount"]}, nil
+		},
+	))
+	server.AddGrant(grant)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Same(t, returnedSession, approvedSession)
+	assert.Equal(t, []AuthorizationDetail{{
+		"type":            "payment_initiation",
+		"creditorAccount": map[string]interface{}{"iban": "DE02100100109307118603"},
+	}}, returnedSession.AuthorizationDetails)
+}
+
+func TestServerGrantOauthSessionWhereAuthorizationDetailsAreNotApproved(t *testing.T) {
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test").Set("authorization_details", paymentDetails)
+	server, grant, _ := newMockGrantServer(oauthSessionRequest)
+	server.AddGrant(grant)
+	refusal := errors.New("over the limit")
+	refusingHandler := NewAuthorizationDetailHandler(
+		"payment_initiation",
+		func(detail AuthorizationDetail, refusedSession *Session) (AuthorizationDetail, error) {
+
+			return nil, refusal
+		},
+	)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, &InvalidAuthorizationDetailsError{"the type payment_initiation is not supported", nil}, error)
+
+	server.AddAuthorizationDetailHandler(refusingHandler)
+	returnedSession, error = server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, &InvalidAuthorizationDetailsError{"the payment_initiation detail was refused", refusal}, error)
+	assert.Equal(t, InvalidAuthorizationDetails, error.OauthErrorCode())
+}
+
+func TestServerGrantOauthSessionRefreshNarrowsAuthorizationDetails(t *testing.T) {
+
+	approved := []AuthorizationDetail{
+		{"type": "account_information", "actions": []interface{}{"read"}},
+		{"type": "payment_initiation", "instructedAmount": map[string]interface{}{"currency": "EUR", "amount": "100.00"}},
+	}
+	oauthSessionRequest := NewBasicOauthSessionRequest("test").Set("authorization_details", `[{"type":"account_information","actions":["read"]}]`)
+	server, grant, session := newMockGrantServer(oauthSessionRequest)
+	session.CreatedAt = time.Now()
+	session.AuthorizationDetails = approved
+	server.AddGrant(grant)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, approved[:1], returnedSession.AuthorizationDetails)
+
+	oauthSessionRequest.Delete("authorization_details")
+	returnedSession, error = server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, approved, returnedSession.AuthorizationDetails)
+
+	oauthSessionRequest.Set("authorization_details", `[{"type":"account_information","actions":["write"]}]`)
+	returnedSession, error = server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, &InvalidAuthorizationDetailsError{"a account_information detail was not approved for the session", nil}, error)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+)
+
+type Claims map[string]interface{}
+
+type ClaimsProvider interface {
+	Claims(session *Session) (Claims, error)
+}
+
+type ClaimsProviderFunc func(session *Session) (Claims, error)
+
+func (provider ClaimsProviderFunc) Claims(session *Session) (Claims, error) {
+
+	return provider(session)
+}
+
+// DefaultClaimsProvider only knows about the fields on Owner. The sub claim is
+// always returned and name is added when the profile scope was granted.
+type DefaultClaimsProvider struct {
+}
+
+func (provider *DefaultClaimsProvider) Claims(session *Session) (Claims, error) {
+
+	if session.Owner == nil {
+
+		return nil, fmt.Errorf("session %s has no owner to return claims for", session.Id)
+	}
+
+	claims := Claims{"sub": session.Owner.Id}
+
+	if _, ok := session.Scopes["profile"]; ok {
+
+		claims["name"] = session.Owner.Name
+	}
+
+	return claims, nil
+}
+
+func NewDefaultClaimsProvider() *DefaultClaimsProvider {
+
+	return &DefaultClaimsProvider{}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDefaultClaimsProvider(t *testing.T) {
+
+	provider := NewDefaultClaimsProvider()
+	session := NewSession()
+
+	claims, error := provider.Claims(session)
+	assert.Nil(t, claims)
+	assert.NotNil(t, error)
+
+	session.Owner = &Owner{"id", "name", false}
+
+	claims, error = provider.Claims(session)
+	assert.Equal(t, Claims{"sub": "id"}, claims)
+	assert.Nil(t, error)
+
+	session.Scopes["profile"] = &Scope{Id: "id", Name: "profile"}
+
+	claims, error = provider.Claims(session)
+	assert.Equal(t, Claims{"sub": "id", "name": "name"}, claims)
+	assert.Nil(t, error)
+}
+
+func TestClaimsProviderFunc(t *testing.T) {
+
+	session := NewSession()
+	provider := ClaimsProviderFunc(func(passedSession *Session) (Claims, error) {
+
+		assert.Equal(t, session, passedSession)
+		return Claims{"sub": "func"}, nil
+	})
+
+	claims, error := provider.Claims(session)
+	assert.Equal(t, Claims{"sub": "func"}, claims)
+	assert.Nil(t, error)
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type SystemClock struct {
+}
+
+func (clock *SystemClock) Now() time.Time {
+
+	return time.Now().UTC()
+}
+
+func NewSystemClock() *SystemClock {
+
+	return &SystemClock{}
+}
+
+// FakeClock only moves when told to so tests can travel in time instead of
+// sleeping.
+type FakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (clock *FakeClock) Now() time.Time {
+
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *FakeClock) Set(now time.Time) *FakeClock {
+
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = now
+	return clock
+}
+
+func (clock *FakeClock) Advance(duration time.Duration) *FakeClock {
+
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = clock.now.Add(duration)
+	return clock
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+
+	return &FakeClock{now: now}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSystemClock(t *testing.T) {
+
+	now := NewSystemClock().Now()
+	assert.WithinDuration(t, time.Now(), now, time.Second)
+	assert.Equal(t, time.UTC, now.Location())
+}
+
+func TestFakeClock(t *testing.T) {
+
+	now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	assert.Equal(t, now, clock.Now())
+	assert.Equal(t, now.Add(time.Hour), clock.Advance(time.Hour).Now())
+	assert.Equal(t, now, clock.Set(now).Now())
+}
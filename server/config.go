@@ -1,16 +1,30 @@
 package server
 
+import (
+	"time"
+)
+
+// Config holds the server settings. Issuer is stamped on every session and
+// sessions issued by another issuer are rejected, which keeps tenants sharing
+// a storage apart. ScopeParameterValidator decides which values clients may
+// put in parameterized scopes, they are refused when it is nil.
 type Config struct {
-	DefaultAccessTokenExpires  int
-	DefaultRefreshTokenExpires int
+	DefaultAccessTokenExpires  time.Duration
+	DefaultRefreshTokenExpires time.Duration
 	AllowRefresh               bool
+	LifetimePolicy             LifetimePolicy
+	Issuer                     string
+	ScopeParameterValidator    ScopeParameterValidatorFunc
 }
 
 func NewConfig() *Config {
 
 	return &Config{
-		3600,   //1 hour
-		604800, //1 week
+		time.Hour,
+		7 * 24 * time.Hour,
 		false,
+		NewDefaultLifetimePolicy(),
+		"",
+		nil,
 	}
 }
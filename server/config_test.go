@@ -3,13 +3,17 @@ package server
 import (
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestNewConfig(t *testing.T) {
 
 	assert.Equal(t, &Config{
-		3600,   //1 hour
-		604800, //1 week
+		time.Hour,
+		7 * 24 * time.Hour,
 		false,
+		NewDefaultLifetimePolicy(),
+		"",
+		nil,
 	}, NewConfig())
 }
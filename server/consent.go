@@ -0,0 +1,199 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Consent records the scopes an owner approved for a client. There is at most
+// one consent per owner and client, approving more scopes later adds them to
+// it. A zero ExpiresAt remembers the consent until it is revoked.
+type Consent struct {
+	Id        string
+	OwnerId   string
+	ClientId  string
+	Scopes    []string
+	GrantedAt time.Time
+	UpdatedAt time.Time
+	ExpiresAt time.Time
+}
+
+func (consent *Consent) IsExpired(now time.Time) bool {
+
+	return !consent.ExpiresAt.IsZero() && !now.Before(consent.ExpiresAt)
+}
+
+// ConsentStorage keeps the consents. FindConsent returns an error when the
+// owner never approved anything for the client.
+type ConsentStorage interface {
+	FindConsent(ownerId string, clientId string) (*Consent, error)
+	FindConsentsByOwnerId(ownerId string) ([]*Consent, error)
+	SaveConsent(consent *Consent) error
+	DeleteConsent(consent *Consent) error
+}
+
+// ConsentManager remembers what owners approved so the approval prompt only
+// has to ask for scopes that were not approved before. Sessions issued while
+// the owner has a consent for the client carry its id in ConsentId, which is
+// how revoking a consent finds the sessions to revoke with it.
+//
+// RememberFor is how long an approval is remembered, forever when it is 0.
+type ConsentManager struct {
+	server       *DefaultServer
+	storage      ConsentStorage
+	sessionQuery SessionQuery
+	RememberFor  time.Duration
+}
+
+// MissingScopes returns the scopes the owner still has to approve for the
+// client. The prompt can be skipped when there are none.
+func (manager *ConsentManager) MissingScopes(owner *Owner, client *Client, scopes []string) []string {
+
+	consent := manager.FindConsent(owner.Id, client.Id)
+	missing := []string{}
+
+	for _, scope := range scopes {
+
+		if consent == nil || !containsString(consent.Scopes, scope) {
+
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
+
+// Approve remembers that the owner approved the scopes for the client on top
+// of what was approved before, and gives the consent to link the session
+// being issued to.
+func (manager *ConsentManager) Approve(owner *Owner, client *Client, scopes []string) (*Consent, error) {
+
+	now := manager.server.Clock().Now()
+	consent := manager.FindConsent(owner.Id, client.Id)
+
+	if consent == nil {
+
+		consent = &Consent{Id: GenerateTokenId(), OwnerId: owner.Id, ClientId: client.Id, GrantedAt: now}
+	}
+
+	for _, scope := range scopes {
+
+		if !containsString(consent.Scopes, scope) {
+
+			consent.Scopes = append(consent.Scopes, scope)
+		}
+	}
+
+	sort.Strings(consent.Scopes)
+	consent.UpdatedAt = now
+	consent.ExpiresAt = time.Time{}
+
+	if manager.RememberFor > 0 {
+
+		consent.ExpiresAt = now.Add(manager.RememberFor)
+	}
+
+	if error := manager.storage.SaveConsent(consent); error != nil {
+
+		return nil, error
+	}
+
+	return consent, nil
+}
+
+// Consents lists what the owner approved, expired consents left out.
+func (manager *ConsentManager) Consents(ownerId string) ([]*Consent, error) {
+
+	consents, error := manager.storage.FindConsentsByOwnerId(ownerId)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	now := manager.server.Clock().Now()
+	remembered := []*Consent{}
+
+	for _, consent := range consents {
+
+		if !consent.IsExpired(now) {
+
+			remembered = append(remembered, consent)
+		}
+	}
+
+	return remembered, nil
+}
+
+// Revoke forgets the consent and revokes every session that was issued on
+// the strength of it, returning how many sessions were revoked.
+func (manager *ConsentManager) Revoke(consent *Consent) (int, error) {
+
+	if error := manager.storage.DeleteConsent(consent); error != nil {
+
+		return 0, error
+	}
+
+	page, error := manager.sessionQuery.QuerySessions(&SessionFilter{OwnerId: consent.OwnerId, ClientId: consent.ClientId}, nil, 0)
+
+	if error != nil {
+
+		return 0, fmt.Errorf("the consent was revoked but its sessions could not be found: %s", error)
+	}
+
+	revoked := 0
+
+	for _, session := range page.Sessions {
+
+		if session.ConsentId == consent.Id {
+
+			manager.server.RevokeSession(session)
+			revoked++
+		}
+	}
+
+	return revoked, nil
+}
+
+// HandleEvent links sessions about to be issued to the consent the owner has
+// for the client. Refreshed sessions keep the consent they were linked to.
+func (manager *ConsentManager) HandleEvent(event *Event) error {
+
+	session := event.Session
+
+	if session == nil || session.ConsentId != "" || session.Owner == nil || session.Owner.FromClient || session.Client == nil {
+
+		return nil
+	}
+
+	if consent := manager.FindConsent(session.Owner.Id, session.Client.Id); consent != nil {
+
+		session.ConsentId = consent.Id
+	}
+
+	return nil
+}
+
+// FindConsent returns nil when the owner has no consent for the client that
+// is still remembered.
+func (manager *ConsentManager) FindConsent(ownerId string, clientId string) *Consent {
+
+	consent, _ := manager.storage.FindConsent(ownerId, clientId)
+
+	if consent == nil || consent.IsExpired(manager.server.Clock().Now()) {
+
+		return nil
+	}
+
+	return consent
+}
+
+// NewConsentManager listens for sessions being issued by the server to link
+// them to their consent.
+func NewConsentManager(server *DefaultServer, storage ConsentStorage, sessionQuery SessionQuery) *ConsentManager {
+
+	manager := &ConsentManager{server, storage, sessionQuery, 0}
+	server.Events().AddListener(BeforeSessionIssue, manager)
+	return manager
+}
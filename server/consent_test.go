@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestConsentManagerMissingScopes(t *testing.T) {
+
+	consentStorage := &MockConsentStorage{}
+	server := New(nil, nil, nil, nil)
+	server.SetClock(NewFakeClock(testNow))
+	manager := NewConsentManager(server, consentStorage, nil)
+	owner := &Owner{Id: "owner"}
+	client := &Client{Id: "client"}
+
+	consentStorage.On("FindConsent", "owner", "client").Return(nil, errors.New("not found")).Once()
+	assert.Equal(t, []string{"read", "write"}, manager.MissingScopes(owner, client, []string{"read", "write"}))
+
+	consent := &Consent{Id: "consent", Scopes: []string{"read"}}
+	consentStorage.On("FindConsent", "owner", "client").Return(consent, nil).Once()
+	assert.Equal(t, []string{"write"}, manager.MissingScopes(owner, client, []string{"read", "write"}))
+
+	consentStorage.On("FindConsent", "owner", "client").Return(consent, nil).Once()
+	assert.Equal(t, []string{}, manager.MissingScopes(owner, client, []string{"read"}))
+
+	consent.ExpiresAt = testNow
+	consentStorage.On("FindConsent", "owner", "client").Return(consent, nil).Once()
+	assert.Equal(t, []string{"read"}, manager.MissingScopes(owner, client, []string{"read"}))
+
+	consentStorage.AssertExpectations(t)
+}
+
+func TestConsentManagerApprove(t *testing.T) {
+
+	consentStorage := &MockConsentStorage{}
+	server := New(nil, nil, nil, nil)
+	server.SetClock(NewFakeClock(testNow))
+	manager := NewConsentManager(server, consentStorage, nil)
+	owner := &Owner{Id: "owner"}
+	client := &Client{Id: "client"}
+
+	consentStorage.On("FindConsent", "owner", "client").Return(nil, errors.New("not found")).Once()
+	consentStorage.On("SaveConsent", mock.Anything).Return(nil).Once()
+	consent, error := manager.Approve(owner, client, []string{"write", "read"})
+	assert.Nil(t, error)
+	assert.NotEmpty(t, consent.Id)
+	assert.Equal(t, &Consent{consent.Id, "owner", "client", []string{"read", "write"}, testNow, testNow, time.Time{}}, consent)
+
+	//approving more later keeps the id and what was approved before
+	existing := &Consent{"consent", "owner", "client", []string{"read"}, testNow.Add(-time.Hour), testNow.Add(-time.Hour), time.Time{}}
+	manager.RememberFor = time.Hour
+	consentStorage.On("FindConsent", "owner", "client").Return(existing, nil).Once()
+	consentStorage.On("SaveConsent", existing).Return(nil).Once()
+	consent, error = manager.Approve(owner, client, []string{"profile", "read"})
+	assert.Nil(t, error)
+	assert.Equal(t, &Consent{"consent", "owner", "client", []string{"profile", "read"}, testNow.Add(-time.Hour), testNow, testNow.Add(time.Hour)}, consent)
+
+	consentStorage.On("FindConsent", "owner", "client").Return(nil, errors.New("not found")).Once()
+	consentStorage.On("SaveConsent", mock.Anything).Return(errors.New("down")).Once()
+	consent, error = manager.Approve(owner, client, []string{"read"})
+	assert.Nil(t, consent)
+	assert.Equal(t, errors.New("down"), error)
+
+	consentStorage.AssertExpectations(t)
+}
+
+func TestConsentManagerConsents(t *testing.T) {
+
+	consentStorage := &MockConsentStorage{}
+	server := New(nil, nil, nil, nil)
+	server.SetClock(NewFakeClock(testNow))
+	manager := NewConsentManager(server, consentStorage, nil)
+	remembered := &Consent{Id: "a", ExpiresAt: testNow.Add(time.Second)}
+	forever := &Consent{Id: "b"}
+	expired := &Consent{Id: "c", ExpiresAt: testNow}
+
+	consentStorage.On("FindConsentsByOwnerId", "owner").Return([]*Consent{remembered, expired, forever}, nil).Once()
+	consents, error := manager.Consents("owner")
+	assert.Nil(t, error)
+	assert.Equal(t, []*Consent{remembered, forever}, consents)
+
+	consentStorage.On("FindConsentsByOwnerId", "owner").Return(nil, errors.New("down")).Once()
+	consents, error = manager.Consents("owner")
+	assert.Nil(t, consents)
+	assert.Equal(t, errors.New("down"), error)
+}
+
+func TestConsentManagerRevoke(t *testing.T) {
+
+	consentStorage := &MockConsentStorage{}
+	sessionStorage := &MockSessionStorage{}
+	sessionQuery := &MockSessionQuery{}
+	server := New(nil, nil, sessionStorage, nil)
+	manager := NewConsentManager(server, consentStorage, sessionQuery)
+	consent := &Consent{Id: "consent", OwnerId: "owner", ClientId: "client"}
+	linked := &Session{Id: "linked", ConsentId: "consent"}
+	unlinked := &Session{Id: "unlinked"}
+	filter := &SessionFilter{OwnerId: "owner", ClientId: "client"}
+
+	consentStorage.On("DeleteConsent", consent).Return(nil).Once()
+	sessionQuery.On("QuerySessions", filter, (*SessionCursor)(nil), 0).Return(&SessionPage{[]*Session{linked, unlinked}, nil}, nil).Once()
+	sessionStorage.On("DeleteSession", linked).Return().Once()
+	revoked, error := manager.Revoke(consent)
+	assert.Nil(t, error)
+	assert.Equal(t, 1, revoked)
+
+	consentStorage.On("DeleteConsent", consent).Return(errors.New("down")).Once()
+	revoked, error = manager.Revoke(consent)
+	assert.Equal(t, 0, revoked)
+	assert.Equal(t, errors.New("down"), error)
+
+	consentStorage.AssertExpectations(t)
+	sessionStorage.AssertExpectations(t)
+	sessionQuery.AssertExpectations(t)
+}
+
+func TestConsentManagerLinksIssuedSessions(t *testing.T) {
+
+	consentStorage := &MockConsentStorage{}
+	sessionStorage := &MockSessionStorage{}
+	sessionQuery := &MockSessionQuery{}
+	server := NewWithTokenGenerator(
+		&MockTokenGenerator{},
+		&MockOwnerClientStorage{},
+		&MockOwnerClientStorage{},
+		sessionStorage,
+		&MockScopeStorage{},
+	)
+	manager := NewConsentManager(server, consentStorage, sessionQuery)
+	consent := &Consent{Id: "consent", OwnerId: "owner", ClientId: "client"}
+	oauthSessionRequest := NewBasicOauthSessionRequest("test")
+	session := NewSession()
+	session.AccessToken = &Token{}
+	session.Client = &Client{Id: "client"}
+	session.Owner = &Owner{Id: "owner"}
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	grant.On("GenerateSession", oauthSessionRequest, server).Return(session, nil)
+	server.AddGrant(grant)
+	sessionStorage.On("SaveSession", session).Return()
+
+	consentStorage.On("FindConsent", "owner", "client").Return(consent, nil).Once()
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+	assert.Nil(t, error)
+	assert.Equal(t, "consent", returnedSession.ConsentId)
+
+	//revoking the consent finds the session through the id it was issued with
+	consentStorage.On("DeleteConsent", consent).Return(nil).Once()
+	sessionQuery.On("QuerySessions", &SessionFilter{OwnerId: "owner", ClientId: "client"}, (*SessionCursor)(nil), 0).Return(&SessionPage{[]*Session{returnedSession}, nil}, nil).Once()
+	sessionStorage.On("DeleteSession", returnedSession).Return().Once()
+	revoked, revokeError := manager.Revoke(consent)
+	assert.Nil(t, revokeError)
+	assert.Equal(t, 1, revoked)
+
+	consentStorage.AssertExpectations(t)
+	sessionQuery.AssertExpectations(t)
+}
+
+func TestConsentManagerHandleEvent(t *testing.T) {
+
+	consentStorage := &MockConsentStorage{}
+	server := New(nil, nil, nil, nil)
+	server.SetClock(NewFakeClock(testNow))
+	manager := NewConsentManager(server, consentStorage, nil)
+	session := &Session{Client: &Client{Id: "client"}, Owner: &Owner{Id: "owner"}}
+
+	consentStorage.On("FindConsent", "owner", "client").Return(nil, errors.New("not found")).Once()
+	assert.Nil(t, manager.HandleEvent(&Event{Type: BeforeSessionIssue, Session: session}))
+	assert.Equal(t, "", session.ConsentId)
+
+	//a refreshed session stays linked to the consent it was issued with
+	session.ConsentId = "earlier"
+	assert.Nil(t, manager.HandleEvent(&Event{Type: BeforeSessionIssue, Session: session}))
+	assert.Equal(t, "earlier", session.ConsentId)
+
+	assert.Nil(t, manager.HandleEvent(&Event{Type: BeforeSessionIssue, Session: &Session{}}))
+
+	//clients acting on their own behalf are not linked to the consent of an owner with their id
+	session = &Session{Client: &Client{Id: "owner"}, Owner: NewOwnerFromClient(&Client{Id: "owner"})}
+	assert.Nil(t, manager.HandleEvent(&Event{Type: BeforeSessionIssue, Session: session}))
+	assert.Equal(t, "", session.ConsentId)
+	consentStorage.AssertExpectations(t)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"time"
+)
+
+// ReplayCache remembers values that may only be used once, like the jti of a
+// DPoP proof, until they expire.
+type ReplayCache interface {
+	// Remember reports false when the id is remembered already and has not
+	// expired yet.
+	Remember(id string, expiresAt time.Time, now time.Time) (bool, error)
+}
+
+// DPoPRequest is implemented by token requests that can carry a DPoP proof
+// from RFC 9449. DPoPThumbprint is the thumbprint of the key that signed the
+// proof once it was validated and empty without a proof.
+type DPoPRequest interface {
+	DPoPThumbprint() string
+}
+
+// IsDPoPBound reports whether the access token of the session may only be
+// used together with a proof signed by the key with the DPoPThumbprint.
+func (session *Session) IsDPoPBound() bool {
+
+	return session.DPoPThumbprint != ""
+}
+
+// bindDPoPKey binds the tokens of the session to the key that signed the
+// proof of the request. Public clients have nothing but the key to prove a
+// refresh token is theirs, so refreshing a session of theirs that is bound
+// needs a proof signed by the same key. The access tokens of confidential
+// clients are bound to whichever key signed the proof of the refresh.
+func (server *DefaultServer) bindDPoPKey(oauthSessionRequest OauthSessionRequest, session *Session, isNew bool) OauthError {
+
+	thumbprint := ""
+
+	if dpopRequest, ok := oauthSessionRequest.(DPoPRequest); ok {
+
+		thumbprint = dpopRequest.DPoPThumbprint()
+	}
+
+	if !isNew && session.IsDPoPBound() && session.Client != nil && session.Client.IsPublic() && thumbprint != session.DPoPThumbprint {
+
+		return &InvalidDPoPProofError{"the refresh token is bound to another key"}
+	}
+
+	session.DPoPThumbprint = thumbprint
+	return nil
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type dpopOauthSessionRequest struct {
+	*BasicOauthSessionRequest
+	thumbprint string
+}
+
+func (request *dpopOauthSessionRequest) DPoPThumbprint() string {
+
+	return request.thumbprint
+}
+
+func TestServerGrantOauthSessionBindsTheDPoPKey(t *testing.T) {
+
+	oauthSessionRequest := &dpopOauthSessionRequest{NewBasicOauthSessionRequest("test"), "thumbprint"}
+	server, grant, session := newMockGrantServer(oauthSessionRequest)
+	session.Client = &Client{Id: "client"}
+	server.AddGrant(grant)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, "thumbprint", returnedSession.DPoPThumbprint)
+	assert.True(t, returnedSession.IsDPoPBound())
+}
+
+func TestServerGrantOauthSessionRefreshOfAPublicClientNeedsTheSameKey(t *testing.T) {
+
+	oauthSessionRequest := &dpopOauthSessionRequest{NewBasicOauthSessionRequest("test"), "other"}
+	server, grant, session := newMockGrantServer(oauthSessionRequest)
+	session.Client = &Client{Id: "client", Type: PublicClient}
+	session.CreatedAt = time.Now()
+	session.DPoPThumbprint = "thumbprint"
+	server.AddGrant(grant)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, &InvalidDPoPProofError{"the refresh token is bound to another key"}, error)
+	assert.Equal(t, InvalidDPoPProof, error.OauthErrorCode())
+
+	oauthSessionRequest.thumbprint = ""
+	returnedSession, error = server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, &InvalidDPoPProofError{"the refresh token is bound to another key"}, error)
+
+	oauthSessionRequest.thumbprint = "thumbprint"
+	returnedSession, error = server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, "thumbprint", returnedSession.DPoPThumbprint)
+}
+
+func TestServerGrantOauthSessionRefreshOfAConfidentialClientRebinds(t *testing.T) {
+
+	oauthSessionRequest := &dpopOauthSessionRequest{NewBasicOauthSessionRequest("test"), "other"}
+	server, grant, session := newMockGrantServer(oauthSessionRequest)
+	session.Client = &Client{Id: "client", Type: ConfidentialClient}
+	session.CreatedAt = time.Now()
+	session.DPoPThumbprint = "thumbprint"
+	server.AddGrant(grant)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, "other", returnedSession.DPoPThumbprint)
+
+	oauthSessionRequest.thumbprint = ""
+	returnedSession, error = server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.False(t, returnedSession.IsDPoPBound())
+}
@@ -1,42 +1,211 @@
 package server
 
+import (
+	"time"
+)
+
+type ClientType string
+
+const (
+	ConfidentialClient ClientType = "confidential"
+	PublicClient       ClientType = "public"
+)
+
 type Client struct {
-	Id          string
-	Name        string
-	RedirectUri string
+	Id                  string
+	Name                string
+	Type                ClientType
+	RedirectUris        []string
+	AllowedGrants       []string
+	AllowedResources    []string
+	AllowedScopes       []string
+	AccessTokenExpires  time.Duration
+	RefreshTokenExpires time.Duration
+	Contacts            []string
+	LogoUri             string
+	PolicyUri           string
+	Metadata            map[string]string
 }
 
+// HasRedirectUri only accepts an exact match against one of the registered
+// redirect uris.
+func (client *Client) HasRedirectUri(redirectUri string) bool {
+
+	return containsString(client.RedirectUris, redirectUri)
+}
+
+// AllowsGrant reports whether the client may use the grant. A client without
+// any allowed grants is not restricted.
+func (client *Client) AllowsGrant(name string) bool {
+
+	return len(client.AllowedGrants) == 0 || containsString(client.AllowedGrants, name)
+}
+
+// AllowsResource reports whether the client may ask for tokens meant for the
+// resource. A client without any allowed resources is not restricted.
+func (client *Client) AllowsResource(resource string) bool {
+
+	return len(client.AllowedResources) == 0 || containsString(client.AllowedResources, resource)
+}
+
+// AllowsScope reports whether the client may be granted the scope. Allowed
+// scopes can be patterns like repo:* or templates like transaction:{id}. A
+// client without any allowed scopes is not restricted.
+func (client *Client) AllowsScope(scope *Scope) bool {
+
+	if len(client.AllowedScopes) == 0 {
+
+		return true
+	}
+
+	for _, allowed := range client.AllowedScopes {
+
+		if MatchScope(allowed, scope.Name) {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (client *Client) IsPublic() bool {
+
+	return client.Type == PublicClient
+}
+
+// Owner is who a session was granted for. FromClient is set on the owners
+// NewOwnerFromClient makes for clients acting on their own behalf, their id is
+// the client id and can be the same as the id of a real owner.
 type Owner struct {
-	Id   string
-	Name string
+	Id         string
+	Name       string
+	FromClient bool
 }
 
+// Token expires at ExpiresAt. A zero ExpiresAt means the token never expires.
 type Token struct {
-	Token   string
-	Expires int
+	Token     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+func (token *Token) IsExpired(now time.Time) bool {
+
+	return !token.ExpiresAt.IsZero() && !now.Before(token.ExpiresAt)
 }
 
+// ExpiresIn returns how long the token is still valid for, 0 once it expired
+// and NoExpiration when it never expires.
+func (token *Token) ExpiresIn(now time.Time) time.Duration {
+
+	if token.ExpiresAt.IsZero() {
+
+		return NoExpiration
+	}
+
+	if token.IsExpired(now) {
+
+		return 0
+	}
+
+	return token.ExpiresAt.Sub(now)
+}
+
+func NewToken(token string, issuedAt time.Time, lifetime time.Duration) *Token {
+
+	return &Token{token, issuedAt, expiresAt(issuedAt, lifetime)}
+}
+
+func expiresAt(from time.Time, lifetime time.Duration) time.Time {
+
+	if lifetime == NoExpiration {
+
+		return time.Time{}
+	}
+
+	return from.Add(lifetime)
+}
+
+// Scope is a permission clients can ask for. Implies names the scopes it
+// grants as well, and a Name made of pattern segments like repo:* defines
+// every scope it matches, see MatchScope. Description and Sensitive are
+// there for consent screens.
+//
+// A Name with parameter segments like transaction:{id} is a template, the
+// scopes on a session resolved from it have the concrete Name, the Template
+// they came from and its Parameters.
 type Scope struct {
-	Id   string
-	Name string
+	Id          string
+	Name        string
+	Description string
+	Sensitive   bool
+	Implies     []string
+	Template    string
+	Parameters  map[string]string
 }
 
 const (
-	NoExpiration int = -1
+	NoExpiration time.Duration = -1
 )
 
 type AuthCode struct {
 }
 
 type Session struct {
-	Id           string
-	AccessToken  *Token
-	RefreshToken *Token
-	AuthCode     *AuthCode
-	Scopes       map[string]*Scope
-	Client       *Client
-	Owner        *Owner
-	ExtraData    map[string]string
+	Id                   string
+	AccessToken          *Token
+	RefreshToken         *Token
+	AuthCode             *AuthCode
+	Scopes               map[string]*Scope
+	Client               *Client
+	Owner                *Owner
+	ExtraData            map[string]string
+	CreatedAt            time.Time
+	Issuer               string
+	ConsentId            string
+	AuthorizationDetails []AuthorizationDetail
+	Resources            []string
+	Audience             []string
+	DPoPThumbprint       string
+}
+
+// Copy returns a session that can be changed without changing this one. The
+// tokens, scopes, extra data and lists are copied, the client, owner and the
+// scopes themselves are shared.
+func (session *Session) Copy() *Session {
+
+	copied := *session
+	copied.AccessToken = copyToken(session.AccessToken)
+	copied.RefreshToken = copyToken(session.RefreshToken)
+	copied.Scopes = make(map[string]*Scope, len(session.Scopes))
+	copied.ExtraData = make(map[string]string, len(session.ExtraData))
+	copied.AuthorizationDetails = append(session.AuthorizationDetails[:0:0], session.AuthorizationDetails...)
+	copied.Resources = append(session.Resources[:0:0], session.Resources...)
+	copied.Audience = append(session.Audience[:0:0], session.Audience...)
+
+	for name, scope := range session.Scopes {
+
+		copied.Scopes[name] = scope
+	}
+
+	for key, value := range session.ExtraData {
+
+		copied.ExtraData[key] = value
+	}
+
+	return &copied
+}
+
+func copyToken(token *Token) *Token {
+
+	if token == nil {
+
+		return nil
+	}
+
+	copied := *token
+	return &copied
 }
 
 func NewSession() *Session {
@@ -48,5 +217,5 @@ func NewSession() *Session {
 
 func NewOwnerFromClient(client *Client) *Owner {
 
-	return &Owner{client.Id, client.Name}
+	return &Owner{client.Id, client.Name, true}
 }
@@ -3,18 +3,87 @@ package server
 import (
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestOwnerFromClient(t *testing.T) {
 
 	client := &Client{
-		"id",
-		"name",
-		"redirectUri",
+		Id:           "id",
+		Name:         "name",
+		RedirectUris: []string{"redirectUri"},
 	}
 
 	assert.Equal(t, &Owner{
 		"id",
 		"name",
+		true,
 	}, NewOwnerFromClient(client))
 }
+
+func TestClientRedirectUris(t *testing.T) {
+
+	client := &Client{
+		Id:           "id",
+		RedirectUris: []string{"https://example.com/cb", "https://example.com/other"},
+	}
+
+	assert.True(t, client.HasRedirectUri("https://example.com/cb"))
+	assert.True(t, client.HasRedirectUri("https://example.com/other"))
+	assert.False(t, client.HasRedirectUri("https://example.com/cb/"))
+	assert.False(t, client.HasRedirectUri("https://example.com/cb?extra=1"))
+	assert.False(t, client.HasRedirectUri(""))
+}
+
+func TestClientAllowsGrant(t *testing.T) {
+
+	client := &Client{Id: "id"}
+	assert.True(t, client.AllowsGrant("password"))
+
+	client.AllowedGrants = []string{"client_credentials"}
+	assert.True(t, client.AllowsGrant("client_credentials"))
+	assert.False(t, client.AllowsGrant("password"))
+}
+
+func TestClientAllowsScope(t *testing.T) {
+
+	client := &Client{Id: "id"}
+	assert.True(t, client.AllowsScope(&Scope{Name: "admin"}))
+
+	client.AllowedScopes = []string{"read", "repo:*", "transaction:{id}"}
+	assert.True(t, client.AllowsScope(&Scope{Name: "read"}))
+	assert.True(t, client.AllowsScope(&Scope{Name: "repo:goauth2"}))
+	assert.True(t, client.AllowsScope(&Scope{Name: "transaction:1234", Template: "transaction:{id}"}))
+	assert.False(t, client.AllowsScope(&Scope{Name: "admin"}))
+}
+
+func TestClientIsPublic(t *testing.T) {
+
+	client := &Client{Id: "id"}
+	assert.False(t, client.IsPublic())
+
+	client.Type = ConfidentialClient
+	assert.False(t, client.IsPublic())
+
+	client.Type = PublicClient
+	assert.True(t, client.IsPublic())
+}
+
+func TestToken(t *testing.T) {
+
+	issuedAt := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	token := NewToken("token", issuedAt, time.Hour)
+
+	assert.Equal(t, &Token{"token", issuedAt, issuedAt.Add(time.Hour)}, token)
+	assert.False(t, token.IsExpired(issuedAt))
+	assert.Equal(t, time.Hour, token.ExpiresIn(issuedAt))
+	assert.Equal(t, time.Minute, token.ExpiresIn(issuedAt.Add(59*time.Minute)))
+	assert.True(t, token.IsExpired(issuedAt.Add(time.Hour)))
+	assert.Equal(t, time.Duration(0), token.ExpiresIn(issuedAt.Add(2*time.Hour)))
+
+	token = NewToken("token", issuedAt, NoExpiration)
+
+	assert.Equal(t, &Token{"token", issuedAt, time.Time{}}, token)
+	assert.False(t, token.IsExpired(issuedAt.Add(100000*time.Hour)))
+	assert.Equal(t, NoExpiration, token.ExpiresIn(issuedAt))
+}
@@ -2,18 +2,57 @@ package server
 
 import (
 	"fmt"
+	"time"
 )
 
 type ErrorCode int
 
 const (
-	StorageSearchFailed  ErrorCode = iota
-	InvalidScope         ErrorCode = iota
-	RequiredValueMissing ErrorCode = iota
-	GrantNotFound        ErrorCode = iota
-	Unexpected           ErrorCode = iota
+	StorageSearchFailed         ErrorCode = iota
+	InvalidScope                ErrorCode = iota
+	RequiredValueMissing        ErrorCode = iota
+	GrantNotFound               ErrorCode = iota
+	Unexpected                  ErrorCode = iota
+	InvalidClientMetadata       ErrorCode = iota
+	InvalidRedirectUri          ErrorCode = iota
+	UnauthorizedClient          ErrorCode = iota
+	Vetoed                      ErrorCode = iota
+	RateLimited                 ErrorCode = iota
+	SessionLimitReached         ErrorCode = iota
+	InvalidAuthorizationDetails ErrorCode = iota
+	InvalidTarget               ErrorCode = iota
+	InvalidDPoPProof            ErrorCode = iota
+	UseDPoPNonce                ErrorCode = iota
 )
 
+var errorCodeNames = map[ErrorCode]string{
+	StorageSearchFailed:         "storage_search_failed",
+	InvalidScope:                "invalid_scope",
+	RequiredValueMissing:        "required_value_missing",
+	GrantNotFound:               "grant_not_found",
+	Unexpected:                  "unexpected",
+	InvalidClientMetadata:       "invalid_client_metadata",
+	InvalidRedirectUri:          "invalid_redirect_uri",
+	UnauthorizedClient:          "unauthorized_client",
+	Vetoed:                      "vetoed",
+	RateLimited:                 "rate_limited",
+	SessionLimitReached:         "session_limit_reached",
+	InvalidAuthorizationDetails: "invalid_authorization_details",
+	InvalidTarget:               "invalid_target",
+	InvalidDPoPProof:            "invalid_dpop_proof",
+	UseDPoPNonce:                "use_dpop_nonce",
+}
+
+func (code ErrorCode) String() string {
+
+	if name, ok := errorCodeNames[code]; ok {
+
+		return name
+	}
+
+	return "unknown"
+}
+
 type OauthError interface {
 	error
 	OauthErrorCode() ErrorCode
@@ -41,6 +80,10 @@ func (error *StorageSearchFailedError) Previous() error {
 	return error.previous
 }
 
+func (error *StorageSearchFailedError) StoredType() string {
+	return error.storedType
+}
+
 type RequiredValueMissingError struct {
 	value string
 }
@@ -93,3 +136,166 @@ func (error *InvalidScopeError) OauthErrorCode() ErrorCode {
 func (error *InvalidScopeError) Previous() error {
 	return error.previous
 }
+
+type InvalidClientMetadataError struct {
+	field  string
+	reason string
+}
+
+func (error *InvalidClientMetadataError) Error() string {
+	return fmt.Sprintf("%s %s.", error.field, error.reason)
+}
+
+func (error *InvalidClientMetadataError) OauthErrorCode() ErrorCode {
+	return InvalidClientMetadata
+}
+
+type InvalidRedirectUriError struct {
+	uri    string
+	reason string
+}
+
+func (error *InvalidRedirectUriError) Error() string {
+	return fmt.Sprintf("the redirect uri %s %s.", error.uri, error.reason)
+}
+
+func (error *InvalidRedirectUriError) OauthErrorCode() ErrorCode {
+	return InvalidRedirectUri
+}
+
+type UnauthorizedClientError struct {
+	clientId string
+	grant    string
+}
+
+func (error *UnauthorizedClientError) Error() string {
+	return fmt.Sprintf("the client %s is not allowed to use the %s grant.", error.clientId, error.grant)
+}
+
+func (error *UnauthorizedClientError) OauthErrorCode() ErrorCode {
+	return UnauthorizedClient
+}
+
+type VetoedError struct {
+	eventType EventType
+	previous  error
+}
+
+func (error *VetoedError) Error() string {
+	return fmt.Sprintf("a %s listener stopped the request.", error.eventType)
+}
+
+func (error *VetoedError) OauthErrorCode() ErrorCode {
+	return Vetoed
+}
+
+func (error *VetoedError) Previous() error {
+	return error.previous
+}
+
+// RefreshTokenReusedError is returned by session storages that see a refresh
+// token that was already rotated out being used again, which means it was
+// most likely stolen.
+type RefreshTokenReusedError struct {
+}
+
+func (error *RefreshTokenReusedError) Error() string {
+	return "A refresh token that was already used was presented again."
+}
+
+type RateLimitedError struct {
+	retryAfter time.Duration
+}
+
+func (error *RateLimitedError) Error() string {
+	return fmt.Sprintf("too many attempts, try again in %s.", error.retryAfter.Round(time.Second))
+}
+
+func (error *RateLimitedError) OauthErrorCode() ErrorCode {
+	return RateLimited
+}
+
+func (error *RateLimitedError) RetryAfter() time.Duration {
+	return error.retryAfter
+}
+
+type SessionLimitReachedError struct {
+	ownerId     string
+	maxSessions int
+}
+
+func (error *SessionLimitReachedError) Error() string {
+	return fmt.Sprintf("The owner %s already has the maximum of %d active sessions.", error.ownerId, error.maxSessions)
+}
+
+func (error *SessionLimitReachedError) OauthErrorCode() ErrorCode {
+	return SessionLimitReached
+}
+
+type InvalidAuthorizationDetailsError struct {
+	reason   string
+	previous error
+}
+
+func (error *InvalidAuthorizationDetailsError) Error() string {
+	return fmt.Sprintf("the authorization details are invalid, %s.", error.reason)
+}
+
+func (error *InvalidAuthorizationDetailsError) OauthErrorCode() ErrorCode {
+	return InvalidAuthorizationDetails
+}
+
+func (error *InvalidAuthorizationDetailsError) Previous() error {
+	return error.previous
+}
+
+type InvalidTargetError struct {
+	resource string
+	reason   string
+}
+
+func (error *InvalidTargetError) Error() string {
+	return fmt.Sprintf("the resource %s %s.", error.resource, error.reason)
+}
+
+func (error *InvalidTargetError) OauthErrorCode() ErrorCode {
+	return InvalidTarget
+}
+
+type InvalidDPoPProofError struct {
+	reason string
+}
+
+func (error *InvalidDPoPProofError) Error() string {
+	return fmt.Sprintf("the DPoP proof is invalid, %s.", error.reason)
+}
+
+func (error *InvalidDPoPProofError) OauthErrorCode() ErrorCode {
+	return InvalidDPoPProof
+}
+
+// UseDPoPNonceError asks the client to sign its DPoP proof again with the
+// nonce, RFC 9449 section 8.
+type UseDPoPNonceError struct {
+	nonce string
+}
+
+func (error *UseDPoPNonceError) Error() string {
+	return "the DPoP proof has to include the nonce the server provided."
+}
+
+func (error *UseDPoPNonceError) OauthErrorCode() ErrorCode {
+	return UseDPoPNonce
+}
+
+func (error *UseDPoPNonceError) Nonce() string {
+	return error.nonce
+}
+
+func NewInvalidDPoPProofError(reason string) *InvalidDPoPProofError {
+	return &InvalidDPoPProofError{reason}
+}
+
+func NewUseDPoPNonceError(nonce string) *UseDPoPNonceError {
+	return &UseDPoPNonceError{nonce}
+}
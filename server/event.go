@@ -0,0 +1,175 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType int
+
+const (
+	BeforeClientAuthentication EventType = iota
+	AfterClientAuthentication  EventType = iota
+	BeforeSessionIssue         EventType = iota
+	AfterTokenIssue            EventType = iota
+	SessionRefreshed           EventType = iota
+	SessionRevoked             EventType = iota
+	GrantFailed                EventType = iota
+)
+
+var eventTypeNames = map[EventType]string{
+	BeforeClientAuthentication: "before_client_authentication",
+	AfterClientAuthentication:  "after_client_authentication",
+	BeforeSessionIssue:         "before_session_issue",
+	AfterTokenIssue:            "after_token_issue",
+	SessionRefreshed:           "session_refreshed",
+	SessionRevoked:             "session_revoked",
+	GrantFailed:                "grant_failed",
+}
+
+func (eventType EventType) String() string {
+
+	if name, ok := eventTypeNames[eventType]; ok {
+
+		return name
+	}
+
+	return "unknown"
+}
+
+// IsVetoable is true for the events dispatched before the server acts. An
+// error returned by a synchronous listener stops the server for those events
+// and is ignored for the others.
+func (eventType EventType) IsVetoable() bool {
+
+	return eventType == BeforeClientAuthentication || eventType == BeforeSessionIssue
+}
+
+// Event carries whatever the server knew when it was dispatched. Request is
+// nil for revocations, Client and Session are nil until they are known and
+// Error is only set on failures. Listeners for BeforeSessionIssue may change
+// the session before tokens are generated for it.
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Request OauthSessionRequest
+	Client  *Client
+	Session *Session
+	Error   error
+}
+
+// snapshot copies the event and its session for an asynchronous listener, so
+// the server going on to change the session does not change it underneath
+// the listener.
+func (event *Event) snapshot() *Event {
+
+	snapshot := *event
+
+	if event.Session != nil {
+
+		snapshot.Session = event.Session.Copy()
+	}
+
+	return &snapshot
+}
+
+type Listener interface {
+	HandleEvent(event *Event) error
+}
+
+type ListenerFunc func(event *Event) error
+
+func (listenerFunc ListenerFunc) HandleEvent(event *Event) error {
+
+	return listenerFunc(event)
+}
+
+// EventSource is implemented by servers that dispatch lifecycle events.
+type EventSource interface {
+	Events() *EventDispatcher
+}
+
+type registeredListener struct {
+	listener Listener
+	async    bool
+}
+
+// EventDispatcher delivers events to synchronous listeners in the order they
+// were added and to asynchronous listeners on their own goroutine.
+type EventDispatcher struct {
+	mutex     sync.RWMutex
+	listeners map[EventType][]*registeredListener
+}
+
+func (dispatcher *EventDispatcher) AddListener(eventType EventType, listener Listener) *EventDispatcher {
+
+	return dispatcher.add(eventType, &registeredListener{listener, false})
+}
+
+// AddAsyncListener registers a listener that can not veto or change anything
+// and whose errors are dropped, in exchange it never slows the server down.
+func (dispatcher *EventDispatcher) AddAsyncListener(eventType EventType, listener Listener) *EventDispatcher {
+
+	return dispatcher.add(eventType, &registeredListener{listener, true})
+}
+
+func (dispatcher *EventDispatcher) add(eventType EventType, listener *registeredListener) *EventDispatcher {
+
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	dispatcher.listeners[eventType] = append(dispatcher.listeners[eventType], listener)
+	return dispatcher
+}
+
+// Dispatch returns the first error returned by a synchronous listener, the
+// listeners after it are not called. Asynchronous listeners get a snapshot of
+// the event as it is when they are dispatched.
+func (dispatcher *EventDispatcher) Dispatch(event *Event) error {
+
+	dispatcher.mutex.RLock()
+	listeners := dispatcher.listeners[event.Type]
+	dispatcher.mutex.RUnlock()
+
+	for _, registered := range listeners {
+
+		if registered.async {
+
+			go registered.listener.HandleEvent(event.snapshot())
+			continue
+		}
+
+		if error := registered.listener.HandleEvent(event); error != nil {
+
+			return error
+		}
+	}
+
+	return nil
+}
+
+func NewEventDispatcher() *EventDispatcher {
+
+	return &EventDispatcher{listeners: make(map[EventType][]*registeredListener)}
+}
+
+// dispatchEvent sends the event through the server's dispatcher when it has
+// one and stamps it with the server's clock.
+func dispatchEvent(server Server, event *Event) OauthError {
+
+	source, ok := server.(EventSource)
+
+	if !ok {
+
+		return nil
+	}
+
+	event.Time = server.Clock().Now()
+	error := source.Events().Dispatch(event)
+
+	if error != nil && event.Type.IsVetoable() {
+
+		return &VetoedError{event.Type, error}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEventTypeString(t *testing.T) {
+
+	assert.Equal(t, "before_session_issue", BeforeSessionIssue.String())
+	assert.Equal(t, "grant_failed", GrantFailed.String())
+	assert.Equal(t, "unknown", EventType(100).String())
+	assert.True(t, BeforeClientAuthentication.IsVetoable())
+	assert.False(t, AfterTokenIssue.IsVetoable())
+}
+
+func TestEventDispatcherDispatch(t *testing.T) {
+
+	dispatcher := NewEventDispatcher()
+	called := []string{}
+	asyncEvents := make(chan *Event, 1)
+	event := &Event{Type: BeforeSessionIssue}
+
+	assert.Nil(t, dispatcher.Dispatch(event))
+
+	assert.Equal(t, dispatcher, dispatcher.AddListener(BeforeSessionIssue, ListenerFunc(func(event *Event) error {
+
+		called = append(called, "first")
+		return nil
+	})))
+	assert.Equal(t, dispatcher, dispatcher.AddAsyncListener(BeforeSessionIssue, ListenerFunc(func(event *Event) error {
+
+		asyncEvents <- event
+		return errors.New("ignored")
+	})))
+	dispatcher.AddListener(AfterTokenIssue, ListenerFunc(func(event *Event) error {
+
+		called = append(called, "other")
+		return nil
+	}))
+
+	assert.Nil(t, dispatcher.Dispatch(event))
+	assert.Equal(t, event, <-asyncEvents)
+	assert.Equal(t, []string{"first"}, called)
+
+	dispatcher.AddListener(BeforeSessionIssue, ListenerFunc(func(event *Event) error {
+
+		return errors.New("vetoed")
+	}))
+	dispatcher.AddListener(BeforeSessionIssue, ListenerFunc(func(event *Event) error {
+
+		called = append(called, "last")
+		return nil
+	}))
+
+	assert.Equal(t, errors.New("vetoed"), dispatcher.Dispatch(event))
+	<-asyncEvents
+	assert.Equal(t, []string{"first", "first"}, called)
+}
+
+func TestEventDispatcherDispatchesSnapshotsToAsyncListeners(t *testing.T) {
+
+	dispatcher := NewEventDispatcher()
+	changed := make(chan bool)
+	asyncEvents := make(chan *Event, 1)
+	session := NewSession()
+	session.AccessToken = &Token{Token: "access"}
+	session.Scopes["read"] = &Scope{Id: "read", Name: "read"}
+	event := &Event{Type: AfterTokenIssue, Client: &Client{Id: "client"}, Session: session}
+
+	dispatcher.AddAsyncListener(AfterTokenIssue, ListenerFunc(func(event *Event) error {
+
+		<-changed
+		asyncEvents <- event
+		return nil
+	}))
+
+	assert.Nil(t, dispatcher.Dispatch(event))
+
+	session.AccessToken.Token = "rotated"
+	session.Scopes["write"] = &Scope{Id: "write", Name: "write"}
+	changed <- true
+	snapshot := <-asyncEvents
+
+	assert.NotSame(t, event, snapshot)
+	assert.Equal(t, event.Client, snapshot.Client)
+	assert.Equal(t, "access", snapshot.Session.AccessToken.Token)
+	assert.Equal(t, map[string]*Scope{"read": {Id: "read", Name: "read"}}, snapshot.Session.Scopes)
+}
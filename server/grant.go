@@ -2,12 +2,13 @@ package server
 
 import (
 	"fmt"
+	"time"
 )
 
 type Grant interface {
 	GenerateSession(oauthSessionRequest OauthSessionRequest, server Server) (*Session, error)
 	Name() string
-	AccessTokenExpiration() int
+	AccessTokenExpiration() time.Duration
 	ShouldGenerateRefreshToken(session *Session) bool
 }
 
@@ -17,14 +18,19 @@ type PostProcessingGrant interface {
 }
 
 type BaseGrant struct {
-	accessTokenExpiration int
+	accessTokenExpiration time.Duration
 }
 
-func (grant *BaseGrant) AccessTokenExpiration() int {
+func (grant *BaseGrant) AccessTokenExpiration() time.Duration {
 
 	return grant.accessTokenExpiration
 }
 
+func (grant *BaseGrant) SetAccessTokenExpiration(expiration time.Duration) {
+
+	grant.accessTokenExpiration = expiration
+}
+
 func (grant *BaseGrant) ShouldGenerateRefreshToken(session *Session) bool {
 
 	return false
@@ -36,13 +42,19 @@ type ClientCredentialsGrant struct {
 
 func (grant *ClientCredentialsGrant) GenerateSession(oauthSessionRequest OauthSessionRequest, server Server) (*Session, error) {
 
-	client, error := AuthenticateClient(oauthSessionRequest, server.ClientStorage())
+	client, error := AuthenticateClient(oauthSessionRequest, server)
 
 	if client == nil {
 
 		return nil, error
 	}
 
+	//public clients authenticate with their id alone which anyone can send
+	if client.IsPublic() {
+
+		return nil, &UnauthorizedClientError{client.Id, grant.Name()}
+	}
+
 	session := NewSession()
 	session.Client = client
 	session.Owner = NewOwnerFromClient(client)
@@ -61,7 +73,7 @@ type PasswordGrant struct {
 
 func (grant *PasswordGrant) GenerateSession(oauthSessionRequest OauthSessionRequest, server Server) (*Session, error) {
 
-	client, error := AuthenticateClient(oauthSessionRequest, server.ClientStorage())
+	client, error := AuthenticateClient(oauthSessionRequest, server)
 
 	if client == nil {
 
@@ -85,7 +97,15 @@ func (grant *PasswordGrant) GenerateSession(oauthSessionRequest OauthSessionRequ
 		return nil, &RequiredValueMissingError{"password"}
 	}
 
+	keys := limitKeys(oauthSessionRequest, "username:"+username)
+
+	if error := checkLimits(server, keys); error != nil {
+
+		return nil, error
+	}
+
 	owner, error := server.OwnerStorage().FindOwnerByUsernameAndPassword(username, password)
+	recordAttempt(server, keys, owner != nil)
 
 	if owner == nil {
 
@@ -115,7 +135,7 @@ type RefreshTokenGrant struct {
 
 func (grant *RefreshTokenGrant) GenerateSession(oauthSessionRequest OauthSessionRequest, server Server) (*Session, error) {
 
-	client, error := AuthenticateClient(oauthSessionRequest, server.ClientStorage())
+	client, error := AuthenticateClient(oauthSessionRequest, server)
 
 	if client == nil {
 		return nil, error
@@ -133,6 +153,9 @@ func (grant *RefreshTokenGrant) GenerateSession(oauthSessionRequest OauthSession
 		return nil, &StorageSearchFailedError{"session", error}
 	}
 
+	//storages may hand out the session they keep, it must not change before the server issued new tokens
+	session = session.Copy()
+
 	if session.Client.Id != client.Id {
 		return nil, &StorageSearchFailedError{"session", fmt.Errorf(
 			"client id %s on sessoin did not match client id %s found with client credentials",
@@ -141,6 +164,14 @@ func (grant *RefreshTokenGrant) GenerateSession(oauthSessionRequest OauthSession
 		)}
 	}
 
+	if session.Issuer != server.Config().Issuer {
+		return nil, &StorageSearchFailedError{"session", fmt.Errorf(
+			"session was issued by %q not %q",
+			session.Issuer,
+			server.Config().Issuer,
+		)}
+	}
+
 	session.Client = client
 
 	if grant.RefreshOwner {
@@ -154,16 +185,29 @@ func (grant *RefreshTokenGrant) GenerateSession(oauthSessionRequest OauthSession
 		session.Owner = owner
 	}
 
-	//make sure the requested scopes are already in the session. Cant add new scopes.
+	//make sure the requested scopes are already in the session or covered by its scopes. Cant add new scopes.
 	//Actual instantiation of the scopes will happen in the server
 	for _, scopeName := range oauthSessionRequest.Get("scopes") {
 		_, ok := session.Scopes[scopeName]
 
-		if !ok {
+		if !ok && !NewScopeMatcher(server.ScopeStorage()).Satisfies(session.Scopes, scopeName) {
 			return nil, &InvalidScopeError{scopeName, nil}
 		}
 	}
 
+	config := server.Config()
+	policy := lifetimePolicy(config)
+	now := server.Clock().Now()
+	refreshLifetime := policy.RefreshTokenLifetime(config, grant, session, now)
+
+	if refreshLifetime == 0 {
+
+		return nil, &StorageSearchFailedError{"session", fmt.Errorf(
+			"session %s has reached its maximum lifetime",
+			session.Id,
+		)}
+	}
+
 	//clear scopes so only the request scopes are assigned
 	session.Scopes = make(map[string]*Scope)
 	session.AccessToken = nil
@@ -171,6 +215,9 @@ func (grant *RefreshTokenGrant) GenerateSession(oauthSessionRequest OauthSession
 	if grant.RotateRefreshTokens {
 
 		session.RefreshToken = nil
+	} else if policy.ExtendsRefreshTokens() {
+
+		session.RefreshToken.ExpiresAt = expiresAt(now, refreshLifetime)
 	}
 
 	return session, nil
@@ -186,7 +233,41 @@ func (grant *RefreshTokenGrant) ShouldGenerateRefreshToken(session *Session) boo
 	return grant.RotateRefreshTokens
 }
 
-func AuthenticateClient(oauthSessionRequest OauthSessionRequest, storage ClientStorage) (*Client, error) {
+// AuthenticateClient authenticates the client sending the request and lets the
+// server's client authentication listeners know about it.
+func AuthenticateClient(oauthSessionRequest OauthSessionRequest, server Server) (*Client, error) {
+
+	server, span := startSpan(server, "AuthenticateClient")
+
+	if error := dispatchEvent(server, &Event{Type: BeforeClientAuthentication, Request: oauthSessionRequest}); error != nil {
+
+		endSpan(span, error)
+		return nil, error
+	}
+
+	clientId, _ := oauthSessionRequest.GetFirst("client_id")
+	keys := limitKeys(oauthSessionRequest, "client:"+clientId)
+
+	if error := checkLimits(server, keys); error != nil {
+
+		endSpan(span, error)
+		return nil, error
+	}
+
+	client, error := authenticateClient(oauthSessionRequest, server.ClientStorage())
+	recordAttempt(server, keys, client != nil)
+	dispatchEvent(server, &Event{Type: AfterClientAuthentication, Request: oauthSessionRequest, Client: client, Error: error})
+
+	if client != nil {
+
+		span.SetAttribute("oauth.client_id", client.Id)
+	}
+
+	endSpan(span, error)
+	return client, error
+}
+
+func authenticateClient(oauthSessionRequest OauthSessionRequest, storage ClientStorage) (*Client, error) {
 
 	clientId, exists := oauthSessionRequest.GetFirst("client_id")
 
@@ -199,7 +280,15 @@ func AuthenticateClient(oauthSessionRequest OauthSessionRequest, storage ClientS
 
 	if !exists {
 
-		return nil, &RequiredValueMissingError{"client_secret"}
+		//public clients have no secret so the client id is all they can send
+		client, _ := storage.FindClientById(clientId)
+
+		if client == nil || !client.IsPublic() {
+
+			return nil, &RequiredValueMissingError{"client_secret"}
+		}
+
+		return client, nil
 	}
 
 	client, error := storage.FindClientByIdAndSecret(clientId, clientSecret)
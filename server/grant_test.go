@@ -4,19 +4,20 @@ import (
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestClientCredentialsGrant(t *testing.T) {
 
-	grant := &ClientCredentialsGrant{BaseGrant{123}}
+	grant := &ClientCredentialsGrant{BaseGrant{123 * time.Second}}
 	assert.Equal(t, "client_credentials", grant.Name())
-	assert.Equal(t, grant.AccessTokenExpiration(), 123)
+	assert.Equal(t, grant.AccessTokenExpiration(), 123*time.Second)
 	assert.False(t, grant.ShouldGenerateRefreshToken(NewSession()))
 }
 
 func TestClientCredentialsGrantGenerateSession(t *testing.T) {
 
-	grant := &ClientCredentialsGrant{BaseGrant{123}}
+	grant := &ClientCredentialsGrant{BaseGrant{123 * time.Second}}
 	server := &MockServer{}
 	client, request, _ := runClientLoadAssertions(t, grant, server)
 	session := NewSession()
@@ -27,17 +28,31 @@ func TestClientCredentialsGrantGenerateSession(t *testing.T) {
 	assert.Nil(t, error)
 }
 
+func TestClientCredentialsGrantGenerateSessionWithPublicClient(t *testing.T) {
+
+	grant := &ClientCredentialsGrant{}
+	server := &MockServer{}
+	storage := &MockOwnerClientStorage{}
+	server.On("ClientStorage").Return(storage)
+	request := NewBasicOauthSessionRequest("client_credentials").Set("client_id", "client_id")
+	storage.On("FindClientById", "client_id").Return(&Client{Id: "client_id", Type: PublicClient}, nil)
+
+	session, error := grant.GenerateSession(request, server)
+	assert.Nil(t, session)
+	assert.Equal(t, &UnauthorizedClientError{"client_id", "client_credentials"}, error)
+}
+
 func TestPasswordGrant(t *testing.T) {
 
-	grant := &PasswordGrant{BaseGrant{123}}
+	grant := &PasswordGrant{BaseGrant{123 * time.Second}}
 	assert.Equal(t, "password", grant.Name())
-	assert.Equal(t, grant.AccessTokenExpiration(), 123)
+	assert.Equal(t, grant.AccessTokenExpiration(), 123*time.Second)
 	assert.True(t, grant.ShouldGenerateRefreshToken(NewSession()))
 }
 
 func TestPasswordGrantGenerateSessionWithUsernameMissing(t *testing.T) {
 
-	grant := &PasswordGrant{BaseGrant{123}}
+	grant := &PasswordGrant{BaseGrant{123 * time.Second}}
 	server := &MockServer{}
 	_, request, _ := runClientLoadAssertions(t, grant, server)
 
@@ -48,7 +63,7 @@ func TestPasswordGrantGenerateSessionWithUsernameMissing(t *testing.T) {
 
 func TestPasswordGrantGenerateSessionWithPasswordMissing(t *testing.T) {
 
-	grant := &PasswordGrant{BaseGrant{123}}
+	grant := &PasswordGrant{BaseGrant{123 * time.Second}}
 	server := &MockServer{}
 	_, request, _ := runClientLoadAssertions(t, grant, server)
 
@@ -61,7 +76,7 @@ func TestPasswordGrantGenerateSessionWithPasswordMissing(t *testing.T) {
 
 func TestPasswordGrantGenerateSessionWhereOwnerFailedToLoad(t *testing.T) {
 
-	grant := &PasswordGrant{BaseGrant{123}}
+	grant := &PasswordGrant{BaseGrant{123 * time.Second}}
 	server := &MockServer{}
 	_, request, storage := runClientLoadAssertions(t, grant, server)
 
@@ -78,7 +93,7 @@ func TestPasswordGrantGenerateSessionWhereOwnerFailedToLoad(t *testing.T) {
 
 func TestPasswordGrantGenerateSessionWhereAllGood(t *testing.T) {
 
-	grant := &PasswordGrant{BaseGrant{123}}
+	grant := &PasswordGrant{BaseGrant{123 * time.Second}}
 	server := &MockServer{}
 	client, request, storage := runClientLoadAssertions(t, grant, server)
 
@@ -90,6 +105,7 @@ func TestPasswordGrantGenerateSessionWhereAllGood(t *testing.T) {
 	owner := &Owner{
 		"id",
 		"name",
+		false,
 	}
 
 	request.Set("username", "right_username")
@@ -107,9 +123,9 @@ func TestPasswordGrantGenerateSessionWhereAllGood(t *testing.T) {
 
 func TestRefreshGrant(t *testing.T) {
 
-	grant := &RefreshTokenGrant{BaseGrant{123}, false, false}
+	grant := &RefreshTokenGrant{BaseGrant{123 * time.Second}, false, false}
 	assert.Equal(t, "refresh_token", grant.Name())
-	assert.Equal(t, grant.AccessTokenExpiration(), 123)
+	assert.Equal(t, grant.AccessTokenExpiration(), 123*time.Second)
 	assert.False(t, grant.ShouldGenerateRefreshToken(NewSession()))
 	grant.RotateRefreshTokens = true
 	assert.True(t, grant.ShouldGenerateRefreshToken(NewSession()))
@@ -121,6 +137,7 @@ func TestRefreshGrantGenerateSessionWithRefreshTokenMissing(t *testing.T) {
 	server := &MockServer{}
 	config := NewConfig()
 	server.On("Config").Return(config)
+	server.On("Clock").Return(NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
 	_, request, _ := runClientLoadAssertions(t, grant, server)
 
 	session, error := grant.GenerateSession(request, server)
@@ -134,6 +151,7 @@ func TestRefreshGrantGenerateSessionWhereSessionFailsToLoad(t *testing.T) {
 	server := &MockServer{}
 	config := NewConfig()
 	server.On("Config").Return(config)
+	server.On("Clock").Return(NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
 	_, request, _ := runClientLoadAssertions(t, grant, server)
 
 	request.Set("refresh_token", "refresh_token")
@@ -154,6 +172,7 @@ func TestRefreshGrantGenerateSessionWhereSessionLoadsButClientsDontMatch(t *test
 	server := &MockServer{}
 	config := NewConfig()
 	server.On("Config").Return(config)
+	server.On("Clock").Return(NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
 	_, request, _ := runClientLoadAssertions(t, grant, server)
 
 	request.Set("refresh_token", "refresh_token")
@@ -165,13 +184,14 @@ func TestRefreshGrantGenerateSessionWhereSessionLoadsButClientsDontMatch(t *test
 
 	returnedSession := NewSession()
 	returnedSession.Client = &Client{
-		"id2",
-		"name",
-		"redr",
+		Id:           "id2",
+		Name:         "name",
+		RedirectUris: []string{"redr"},
 	}
 	returnedSession.Owner = &Owner{
 		"id",
 		"name",
+		false,
 	}
 	returnedSession.RefreshToken = &Token{}
 	storage.On("FindSessionByRefreshToken", "good_refresh_token").Return(returnedSession, nil).Times(1)
@@ -183,12 +203,42 @@ func TestRefreshGrantGenerateSessionWhereSessionLoadsButClientsDontMatch(t *test
 	assert.IsType(t, &StorageSearchFailedError{}, error)
 }
 
+func TestRefreshGrantGenerateSessionWhereSessionWasIssuedByAnotherIssuer(t *testing.T) {
+
+	grant := &RefreshTokenGrant{}
+	server := &MockServer{}
+	config := NewConfig()
+	config.Issuer = "https://acme.example.com"
+	server.On("Config").Return(config)
+	server.On("Clock").Return(NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
+	client, request, _ := runClientLoadAssertions(t, grant, server)
+
+	storage := &MockSessionStorage{}
+	server.On("SessionStorage").Return(storage)
+
+	request.Set("refresh_token", "good_refresh_token")
+
+	returnedSession := NewSession()
+	returnedSession.Client = client
+	returnedSession.Issuer = "https://other.example.com"
+	returnedSession.RefreshToken = &Token{}
+	storage.On("FindSessionByRefreshToken", "good_refresh_token").Return(returnedSession, nil).Times(1)
+
+	session, error := grant.GenerateSession(request, server)
+
+	assert.Nil(t, session)
+	assert.Equal(t, &StorageSearchFailedError{"session", errors.New(
+		`session was issued by "https://other.example.com" not "https://acme.example.com"`,
+	)}, error)
+}
+
 func TestRefreshGrantGenerateSessionLoadsButOwnerRefreshFails(t *testing.T) {
 
 	grant := &RefreshTokenGrant{}
 	server := &MockServer{}
 	config := NewConfig()
 	server.On("Config").Return(config)
+	server.On("Clock").Return(NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
 	client, request, clientOwnerStorage := runClientLoadAssertions(t, grant, server)
 
 	request.Set("refresh_token", "refresh_token")
@@ -203,6 +253,7 @@ func TestRefreshGrantGenerateSessionLoadsButOwnerRefreshFails(t *testing.T) {
 	returnedSession.Owner = &Owner{
 		"id",
 		"name",
+		false,
 	}
 	returnedSession.RefreshToken = &Token{}
 	storage.On("FindSessionByRefreshToken", "good_refresh_token").Return(returnedSession, nil).Times(1)
@@ -226,6 +277,7 @@ func TestRefreshGrantGenerateSessionWhereOwnerRefreshSucceeds(t *testing.T) {
 	server := &MockServer{}
 	config := NewConfig()
 	server.On("Config").Return(config)
+	server.On("Clock").Return(NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
 	client, request, clientOwnerStorage := runClientLoadAssertions(t, grant, server)
 
 	request.Set("refresh_token", "refresh_token")
@@ -244,6 +296,7 @@ func TestRefreshGrantGenerateSessionWhereOwnerRefreshSucceeds(t *testing.T) {
 	expectedSession.Owner = &Owner{
 		"id",
 		"name",
+		false,
 	}
 	expectedSession.RefreshToken = &Token{}
 	returnedSession := &(*expectedSession)
@@ -264,6 +317,7 @@ func TestRefreshGrantGenerateSessionWhereSessionLoadsWithoutOwnerRefresh(t *test
 	server := &MockServer{}
 	config := NewConfig()
 	server.On("Config").Return(config)
+	server.On("Clock").Return(NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
 	client, request, _ := runClientLoadAssertions(t, grant, server)
 
 	request.Set("refresh_token", "refresh_token")
@@ -278,6 +332,7 @@ func TestRefreshGrantGenerateSessionWhereSessionLoadsWithoutOwnerRefresh(t *test
 	expectedSession.Owner = &Owner{
 		"id",
 		"name",
+		false,
 	}
 	expectedSession.RefreshToken = &Token{}
 	returnedSession := &(*expectedSession)
@@ -297,6 +352,7 @@ func TestRefreshGrantGenerateSessionWhereSessionLoadsAndRefreshTokensAreSupposed
 	server := &MockServer{}
 	config := NewConfig()
 	server.On("Config").Return(config)
+	server.On("Clock").Return(NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
 	client, request, _ := runClientLoadAssertions(t, grant, server)
 
 	request.Set("refresh_token", "refresh_token")
@@ -311,6 +367,7 @@ func TestRefreshGrantGenerateSessionWhereSessionLoadsAndRefreshTokensAreSupposed
 	expectedSession.Owner = &Owner{
 		"id",
 		"name",
+		false,
 	}
 	expectedSession.RefreshToken = &Token{}
 	returnedSession := &(*expectedSession)
@@ -333,6 +390,7 @@ func TestRefreshGrantGenerateSessionWhereScopesRequestedNotOnSession(t *testing.
 	server := &MockServer{}
 	config := NewConfig()
 	server.On("Config").Return(config)
+	server.On("Clock").Return(NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
 	client, request, _ := runClientLoadAssertions(t, grant, server)
 
 	request.Set("refresh_token", "refresh_token")
@@ -347,6 +405,7 @@ func TestRefreshGrantGenerateSessionWhereScopesRequestedNotOnSession(t *testing.
 	expectedSession.Owner = &Owner{
 		"id",
 		"name",
+		false,
 	}
 	expectedSession.RefreshToken = &Token{}
 	returnedSession := &(*expectedSession)
@@ -356,6 +415,7 @@ func TestRefreshGrantGenerateSessionWhereScopesRequestedNotOnSession(t *testing.
 	returnedSession.Scopes["scope1"] = &Scope{}
 	returnedSession.Scopes["scope3"] = &Scope{}
 	request.AddAll("scopes", []string{"scope1", "scope2", "scope3"})
+	server.On("ScopeStorage").Return(&MockScopeStorage{})
 
 	//scopes not on session requested
 	session, error := grant.GenerateSession(request, server)
@@ -370,6 +430,7 @@ func TestRefreshGrantGenerateSessionWhereAllScopesAreOnSession(t *testing.T) {
 	server := &MockServer{}
 	config := NewConfig()
 	server.On("Config").Return(config)
+	server.On("Clock").Return(NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
 	client, request, _ := runClientLoadAssertions(t, grant, server)
 
 	request.Set("refresh_token", "refresh_token")
@@ -384,9 +445,14 @@ func TestRefreshGrantGenerateSessionWhereAllScopesAreOnSession(t *testing.T) {
 	expectedSession.Owner = &Owner{
 		"id",
 		"name",
+		false,
 	}
 	expectedSession.RefreshToken = &Token{}
-	returnedSession := &(*expectedSession)
+	returnedSession := NewSession()
+	returnedSession.Client = client
+	returnedSession.Owner = expectedSession.Owner
+	returnedSession.RefreshToken = &Token{}
+	returnedSession.AccessToken = &Token{Token: "access_token"}
 
 	storage.On("FindSessionByRefreshToken", "good_refresh_token").Return(returnedSession, nil)
 
@@ -400,6 +466,140 @@ func TestRefreshGrantGenerateSessionWhereAllScopesAreOnSession(t *testing.T) {
 
 	assert.Equal(t, expectedSession, session)
 	assert.Nil(t, error)
+
+	//the stored session is left alone until the server saves the refreshed one
+	assert.Len(t, returnedSession.Scopes, 3)
+	assert.Equal(t, &Token{Token: "access_token"}, returnedSession.AccessToken)
+}
+
+func TestRefreshGrantGenerateSessionWhereSessionReachedMaxLifetime(t *testing.T) {
+
+	grant := &RefreshTokenGrant{}
+	server := &MockServer{}
+	config := NewConfig()
+	policy := NewDefaultLifetimePolicy()
+	policy.MaxSessionLifetime = 10 * time.Second
+	config.LifetimePolicy = policy
+	server.On("Config").Return(config)
+	server.On("Clock").Return(NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
+	client, request, _ := runClientLoadAssertions(t, grant, server)
+
+	storage := &MockSessionStorage{}
+	server.On("SessionStorage").Return(storage)
+
+	request.Set("refresh_token", "good_refresh_token")
+
+	returnedSession := NewSession()
+	returnedSession.Id = "session"
+	returnedSession.Client = client
+	returnedSession.RefreshToken = &Token{}
+	returnedSession.CreatedAt = server.Clock().Now().Add(-20 * time.Second)
+
+	storage.On("FindSessionByRefreshToken", "good_refresh_token").Return(returnedSession, nil)
+
+	session, error := grant.GenerateSession(request, server)
+
+	assert.Nil(t, session)
+	assert.Equal(t, &StorageSearchFailedError{"session", errors.New("session session has reached its maximum lifetime")}, error)
+}
+
+func TestRefreshGrantGenerateSessionWhereRefreshTokenIsExtended(t *testing.T) {
+
+	grant := &RefreshTokenGrant{}
+	server := &MockServer{}
+	config := NewConfig()
+	policy := NewDefaultLifetimePolicy()
+	policy.RefreshTokenIdleTimeout = time.Minute
+	config.LifetimePolicy = policy
+	server.On("Config").Return(config)
+	server.On("Clock").Return(NewFakeClock(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
+	client, request, _ := runClientLoadAssertions(t, grant, server)
+
+	storage := &MockSessionStorage{}
+	server.On("SessionStorage").Return(storage)
+
+	request.Set("refresh_token", "good_refresh_token")
+
+	returnedSession := NewSession()
+	returnedSession.Client = client
+	now := server.Clock().Now()
+	issuedAt := now.Add(-time.Hour)
+	returnedSession.RefreshToken = NewToken("good_refresh_token", issuedAt, time.Hour)
+
+	storage.On("FindSessionByRefreshToken", "good_refresh_token").Return(returnedSession, nil)
+
+	session, error := grant.GenerateSession(request, server)
+
+	assert.Nil(t, error)
+	assert.Equal(t, "good_refresh_token", session.RefreshToken.Token)
+	assert.Equal(t, issuedAt, session.RefreshToken.IssuedAt)
+	assert.Equal(t, now.Add(time.Minute), session.RefreshToken.ExpiresAt)
+}
+
+func TestBaseGrantSetAccessTokenExpiration(t *testing.T) {
+
+	grant := &PasswordGrant{}
+	assert.Equal(t, time.Duration(0), grant.AccessTokenExpiration())
+	grant.SetAccessTokenExpiration(time.Minute)
+	assert.Equal(t, time.Minute, grant.AccessTokenExpiration())
+}
+
+func TestAuthenticateClientWithPublicClient(t *testing.T) {
+
+	server := &MockServer{}
+	storage := &MockOwnerClientStorage{}
+	server.On("ClientStorage").Return(storage)
+	client := &Client{Id: "client_id", Type: PublicClient}
+	request := NewBasicOauthSessionRequest("password").Set("client_id", "client_id")
+	storage.On("FindClientById", "client_id").Return(client, nil).Times(1)
+
+	authenticatedClient, error := AuthenticateClient(request, server)
+	assert.Equal(t, client, authenticatedClient)
+	assert.Nil(t, error)
+
+	storage.On("FindClientById", "client_id").Return(nil, errors.New("missing")).Times(1)
+
+	authenticatedClient, error = AuthenticateClient(request, server)
+	assert.Nil(t, authenticatedClient)
+	assert.Equal(t, &RequiredValueMissingError{"client_secret"}, error)
+}
+
+func TestAuthenticateClientDispatchesEvents(t *testing.T) {
+
+	storage := &MockOwnerClientStorage{}
+	server := New(storage, storage, &MockSessionStorage{}, &MockScopeStorage{})
+	server.SetClock(NewFakeClock(testNow))
+	client := &Client{Id: "client_id", Type: PublicClient}
+	request := NewBasicOauthSessionRequest("password").Set("client_id", "client_id")
+	storage.On("FindClientById", "client_id").Return(client, nil)
+	events := []*Event{}
+	recorder := ListenerFunc(func(event *Event) error {
+
+		events = append(events, event)
+		return nil
+	})
+	lockout := ListenerFunc(func(event *Event) error {
+
+		return errors.New("locked out")
+	})
+	server.Events().
+		AddListener(BeforeClientAuthentication, recorder).
+		AddListener(AfterClientAuthentication, recorder)
+
+	authenticatedClient, error := AuthenticateClient(request, server)
+	assert.Equal(t, client, authenticatedClient)
+	assert.Nil(t, error)
+	assert.Equal(t, []*Event{
+		&Event{Type: BeforeClientAuthentication, Time: testNow, Request: request},
+		&Event{Type: AfterClientAuthentication, Time: testNow, Request: request, Client: client},
+	}, events)
+
+	server.Events().AddListener(BeforeClientAuthentication, lockout)
+
+	authenticatedClient, error = AuthenticateClient(request, server)
+	assert.Nil(t, authenticatedClient)
+	assert.Equal(t, &VetoedError{BeforeClientAuthentication, errors.New("locked out")}, error)
+	storage.AssertNumberOfCalls(t, "FindClientById", 1)
 }
 
 func runClientLoadAssertions(t *testing.T, grant Grant, server *MockServer) (*Client, *BasicOauthSessionRequest, *MockOwnerClientStorage) {
@@ -415,6 +615,7 @@ func runClientLoadAssertions(t *testing.T, grant Grant, server *MockServer) (*Cl
 	request := NewBasicOauthSessionRequest(grant.Name())
 
 	request.Set("client_id", "client_id")
+	storage.On("FindClientById", "client_id").Return(&Client{Id: "client_id", Type: ConfidentialClient}, nil).Times(1)
 
 	//no client secret
 	session, error = grant.GenerateSession(request, server)
@@ -431,9 +632,9 @@ func runClientLoadAssertions(t *testing.T, grant Grant, server *MockServer) (*Cl
 	assert.Equal(t, &StorageSearchFailedError{"client", errors.New("error")}, error)
 
 	client := &Client{
-		"client_id",
-		"name",
-		"redirect_uri",
+		Id:           "client_id",
+		Name:         "name",
+		RedirectUris: []string{"redirect_uri"},
 	}
 
 	request.Set("client_secret", "client_secret")
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"time"
+)
+
+// LifetimePolicy decides how long the tokens issued for a session live.
+// NoExpiration means the token never expires and 0 means the session is past
+// its maximum lifetime and must not be given new tokens.
+type LifetimePolicy interface {
+	AccessTokenLifetime(config *Config, grant Grant, session *Session, now time.Time) time.Duration
+	RefreshTokenLifetime(config *Config, grant Grant, session *Session, now time.Time) time.Duration
+	ExtendsRefreshTokens() bool
+}
+
+// DefaultLifetimePolicy picks the first lifetime set on the client, the grant,
+// the per grant overrides and finally the config. Access token lifetimes are
+// capped by the shortest lifetime of the granted scopes and every lifetime is
+// capped by what is left of MaxSessionLifetime.
+//
+// When RefreshTokenIdleTimeout is set it replaces the default refresh token
+// lifetime and every refresh slides the refresh token expiration forward,
+// which makes it an idle timeout rather than a fixed one.
+type DefaultLifetimePolicy struct {
+	GrantAccessTokenExpires  map[string]time.Duration
+	GrantRefreshTokenExpires map[string]time.Duration
+	ScopeAccessTokenExpires  map[string]time.Duration
+	MaxSessionLifetime       time.Duration
+	RefreshTokenIdleTimeout  time.Duration
+}
+
+func (policy *DefaultLifetimePolicy) AccessTokenLifetime(config *Config, grant Grant, session *Session, now time.Time) time.Duration {
+
+	var lifetime time.Duration
+
+	if session.Client != nil {
+
+		lifetime = session.Client.AccessTokenExpires
+	}
+
+	if lifetime == 0 {
+
+		lifetime = grant.AccessTokenExpiration()
+	}
+
+	if lifetime == 0 {
+
+		lifetime = policy.GrantAccessTokenExpires[grant.Name()]
+	}
+
+	if lifetime == 0 {
+
+		lifetime = config.DefaultAccessTokenExpires
+	}
+
+	for scopeName := range session.Scopes {
+
+		if scopeLifetime, ok := policy.ScopeAccessTokenExpires[scopeName]; ok {
+
+			lifetime = shortestLifetime(lifetime, scopeLifetime)
+		}
+	}
+
+	return policy.capToSession(lifetime, session, now)
+}
+
+func (policy *DefaultLifetimePolicy) RefreshTokenLifetime(config *Config, grant Grant, session *Session, now time.Time) time.Duration {
+
+	var lifetime time.Duration
+
+	if session.Client != nil {
+
+		lifetime = session.Client.RefreshTokenExpires
+	}
+
+	if lifetime == 0 {
+
+		lifetime = policy.GrantRefreshTokenExpires[grant.Name()]
+	}
+
+	if lifetime == 0 {
+
+		lifetime = policy.RefreshTokenIdleTimeout
+	}
+
+	if lifetime == 0 {
+
+		lifetime = config.DefaultRefreshTokenExpires
+	}
+
+	return policy.capToSession(lifetime, session, now)
+}
+
+func (policy *DefaultLifetimePolicy) ExtendsRefreshTokens() bool {
+
+	return policy.RefreshTokenIdleTimeout > 0
+}
+
+func (policy *DefaultLifetimePolicy) capToSession(lifetime time.Duration, session *Session, now time.Time) time.Duration {
+
+	if policy.MaxSessionLifetime <= 0 || session.CreatedAt.IsZero() {
+
+		return lifetime
+	}
+
+	remaining := session.CreatedAt.Add(policy.MaxSessionLifetime).Sub(now)
+
+	if remaining <= 0 {
+
+		return 0
+	}
+
+	return shortestLifetime(lifetime, remaining)
+}
+
+func NewDefaultLifetimePolicy() *DefaultLifetimePolicy {
+
+	return &DefaultLifetimePolicy{
+		make(map[string]time.Duration),
+		make(map[string]time.Duration),
+		make(map[string]time.Duration),
+		0,
+		0,
+	}
+}
+
+func shortestLifetime(first time.Duration, second time.Duration) time.Duration {
+
+	if first == NoExpiration || (second != NoExpiration && second < first) {
+
+		return second
+	}
+
+	return first
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewDefaultLifetimePolicy(t *testing.T) {
+
+	assert.Equal(t, &DefaultLifetimePolicy{
+		make(map[string]time.Duration),
+		make(map[string]time.Duration),
+		make(map[string]time.Duration),
+		0,
+		0,
+	}, NewDefaultLifetimePolicy())
+}
+
+func TestDefaultLifetimePolicyAccessTokenLifetime(t *testing.T) {
+
+	policy := NewDefaultLifetimePolicy()
+	config := NewConfig()
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	grant.On("AccessTokenExpiration").Return(time.Duration(0)).Times(2)
+	session := NewSession()
+	now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, config.DefaultAccessTokenExpires, policy.AccessTokenLifetime(config, grant, session, now))
+
+	policy.GrantAccessTokenExpires["test"] = 50 * time.Second
+	assert.Equal(t, 50*time.Second, policy.AccessTokenLifetime(config, grant, session, now))
+
+	grant.On("AccessTokenExpiration").Return(40 * time.Second)
+	assert.Equal(t, 40*time.Second, policy.AccessTokenLifetime(config, grant, session, now))
+
+	session.Client = &Client{Id: "id", AccessTokenExpires: 30 * time.Second}
+	assert.Equal(t, 30*time.Second, policy.AccessTokenLifetime(config, grant, session, now))
+
+	policy.ScopeAccessTokenExpires["admin"] = 10 * time.Second
+	session.Scopes["read"] = &Scope{Id: "id", Name: "read"}
+	assert.Equal(t, 30*time.Second, policy.AccessTokenLifetime(config, grant, session, now))
+
+	session.Scopes["admin"] = &Scope{Id: "id", Name: "admin"}
+	assert.Equal(t, 10*time.Second, policy.AccessTokenLifetime(config, grant, session, now))
+
+	session.Client.AccessTokenExpires = NoExpiration
+	assert.Equal(t, 10*time.Second, policy.AccessTokenLifetime(config, grant, session, now))
+}
+
+func TestDefaultLifetimePolicyRefreshTokenLifetime(t *testing.T) {
+
+	policy := NewDefaultLifetimePolicy()
+	config := NewConfig()
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	session := NewSession()
+	now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, config.DefaultRefreshTokenExpires, policy.RefreshTokenLifetime(config, grant, session, now))
+	assert.False(t, policy.ExtendsRefreshTokens())
+
+	policy.RefreshTokenIdleTimeout = 70 * time.Second
+	assert.Equal(t, 70*time.Second, policy.RefreshTokenLifetime(config, grant, session, now))
+	assert.True(t, policy.ExtendsRefreshTokens())
+
+	policy.GrantRefreshTokenExpires["test"] = 60 * time.Second
+	assert.Equal(t, 60*time.Second, policy.RefreshTokenLifetime(config, grant, session, now))
+
+	session.Client = &Client{Id: "id", RefreshTokenExpires: 50 * time.Second}
+	assert.Equal(t, 50*time.Second, policy.RefreshTokenLifetime(config, grant, session, now))
+}
+
+func TestDefaultLifetimePolicyCapsToMaxSessionLifetime(t *testing.T) {
+
+	policy := NewDefaultLifetimePolicy()
+	policy.MaxSessionLifetime = 100 * time.Second
+	config := NewConfig()
+	config.DefaultRefreshTokenExpires = NoExpiration
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	grant.On("AccessTokenExpiration").Return(time.Duration(0))
+	session := NewSession()
+	now := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	//sessions that were never issued are not capped
+	assert.Equal(t, NoExpiration, policy.RefreshTokenLifetime(config, grant, session, now))
+
+	session.CreatedAt = now.Add(-40 * time.Second)
+	assert.Equal(t, 60*time.Second, policy.RefreshTokenLifetime(config, grant, session, now))
+	assert.Equal(t, 60*time.Second, policy.AccessTokenLifetime(config, grant, session, now))
+
+	session.CreatedAt = now.Add(-100 * time.Second)
+	assert.Equal(t, time.Duration(0), policy.RefreshTokenLifetime(config, grant, session, now))
+	assert.Equal(t, time.Duration(0), policy.AccessTokenLifetime(config, grant, session, now))
+
+	session.CreatedAt = now
+	config.DefaultAccessTokenExpires = 30 * time.Second
+	assert.Equal(t, 30*time.Second, policy.AccessTokenLifetime(config, grant, session, now))
+}
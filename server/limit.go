@@ -0,0 +1,226 @@
+package server
+
+import (
+	"time"
+)
+
+// LimitState is what a limiter remembers about one key.
+type LimitState struct {
+	Tokens      float64
+	UpdatedAt   time.Time
+	Failures    int
+	LockedUntil time.Time
+}
+
+// LimitStorage keeps limit states. UpdateLimitState must run the update and
+// save its result atomically so concurrent attempts can not slip through, the
+// state handed to the update is a fresh one for unknown keys.
+type LimitStorage interface {
+	UpdateLimitState(key string, update func(state *LimitState)) (*LimitState, error)
+}
+
+// Limiter decides whether another attempt may be made for a key. The keys are
+// built by the server from the client id, username and source address.
+type Limiter interface {
+	Allow(key string, now time.Time) (time.Duration, error)
+	Failure(key string, now time.Time) error
+	Success(key string, now time.Time) error
+}
+
+// DefaultLimiter allows Burst attempts at once refilled at Rate attempts a
+// second. After LockoutThreshold failures in a row the key is locked out for
+// LockoutDuration, doubling with every further failure up to MaxLockout.
+type DefaultLimiter struct {
+	storage          LimitStorage
+	Rate             float64
+	Burst            float64
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	MaxLockout       time.Duration
+}
+
+// Allow takes an attempt from the key's bucket and returns how long to wait
+// when there is none left or the key is locked out.
+func (limiter *DefaultLimiter) Allow(key string, now time.Time) (time.Duration, error) {
+
+	var retryAfter time.Duration
+
+	_, error := limiter.storage.UpdateLimitState(key, func(state *LimitState) {
+
+		retryAfter = 0
+
+		if now.Before(state.LockedUntil) {
+
+			retryAfter = state.LockedUntil.Sub(now)
+			return
+		}
+
+		//without a rate only the lockout applies
+		if limiter.Rate <= 0 {
+
+			return
+		}
+
+		limiter.refill(state, now)
+
+		if state.Tokens < 1 {
+
+			retryAfter = time.Duration((1 - state.Tokens) / limiter.Rate * float64(time.Second))
+			return
+		}
+
+		state.Tokens--
+	})
+
+	return retryAfter, error
+}
+
+func (limiter *DefaultLimiter) Failure(key string, now time.Time) error {
+
+	_, error := limiter.storage.UpdateLimitState(key, func(state *LimitState) {
+
+		state.Failures++
+
+		if limiter.LockoutThreshold <= 0 || state.Failures < limiter.LockoutThreshold {
+
+			return
+		}
+
+		lockout := limiter.LockoutDuration
+
+		for i := limiter.LockoutThreshold; i < state.Failures && (limiter.MaxLockout <= 0 || lockout < limiter.MaxLockout); i++ {
+
+			lockout *= 2
+		}
+
+		if limiter.MaxLockout > 0 && lockout > limiter.MaxLockout {
+
+			lockout = limiter.MaxLockout
+		}
+
+		state.LockedUntil = now.Add(lockout)
+	})
+
+	return error
+}
+
+func (limiter *DefaultLimiter) Success(key string, now time.Time) error {
+
+	_, error := limiter.storage.UpdateLimitState(key, func(state *LimitState) {
+
+		state.Failures = 0
+		state.LockedUntil = time.Time{}
+	})
+
+	return error
+}
+
+func (limiter *DefaultLimiter) refill(state *LimitState, now time.Time) {
+
+	if state.UpdatedAt.IsZero() {
+
+		state.Tokens = limiter.Burst
+	} else if elapsed := now.Sub(state.UpdatedAt); elapsed > 0 {
+
+		state.Tokens += elapsed.Seconds() * limiter.Rate
+	}
+
+	if state.Tokens > limiter.Burst {
+
+		state.Tokens = limiter.Burst
+	}
+
+	state.UpdatedAt = now
+}
+
+// NewLimiter allows bursts of 10 attempts refilled at one every 6 seconds and
+// locks a key out for a minute after 5 failures in a row, up to an hour.
+func NewLimiter(storage LimitStorage) *DefaultLimiter {
+
+	return &DefaultLimiter{storage, 1.0 / 6, 10, 5, time.Minute, time.Hour}
+}
+
+// LimitingServer is implemented by servers that limit credential guessing.
+type LimitingServer interface {
+	Limiter() Limiter
+}
+
+func limiterFor(server Server) Limiter {
+
+	if limitingServer, ok := server.(LimitingServer); ok {
+
+		return limitingServer.Limiter()
+	}
+
+	return nil
+}
+
+// limitKeys are the credential key followed by the source address key when
+// the request knows where it came from.
+func limitKeys(oauthSessionRequest OauthSessionRequest, credentialKey string) []string {
+
+	keys := []string{credentialKey}
+
+	if remoteAddressRequest, ok := oauthSessionRequest.(RemoteAddressRequest); ok && remoteAddressRequest.RemoteAddress() != "" {
+
+		keys = append(keys, "ip:"+remoteAddressRequest.RemoteAddress())
+	}
+
+	return keys
+}
+
+// checkLimits asks the limiter about every key and fails with the longest
+// wait. Limiter errors let the attempt through rather than locking everyone
+// out when the limit storage is down.
+func checkLimits(server Server, keys []string) OauthError {
+
+	limiter := limiterFor(server)
+
+	if limiter == nil {
+
+		return nil
+	}
+
+	now := server.Clock().Now()
+	var longest time.Duration
+
+	for _, key := range keys {
+
+		if retryAfter, _ := limiter.Allow(key, now); retryAfter > longest {
+
+			longest = retryAfter
+		}
+	}
+
+	if longest > 0 {
+
+		return &RateLimitedError{longest}
+	}
+
+	return nil
+}
+
+// recordAttempt counts a failure against every key, a success only clears the
+// credential key so one good login can not unlock a guessing source address.
+func recordAttempt(server Server, keys []string, succeeded bool) {
+
+	limiter := limiterFor(server)
+
+	if limiter == nil {
+
+		return
+	}
+
+	now := server.Clock().Now()
+
+	if succeeded {
+
+		limiter.Success(keys[0], now)
+		return
+	}
+
+	for _, key := range keys {
+
+		limiter.Failure(key, now)
+	}
+}
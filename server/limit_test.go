@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type mapLimitStorage map[string]*LimitState
+
+func (storage mapLimitStorage) UpdateLimitState(key string, update func(state *LimitState)) (*LimitState, error) {
+
+	state, ok := storage[key]
+
+	if !ok {
+
+		state = &LimitState{}
+		storage[key] = state
+	}
+
+	update(state)
+	return state, nil
+}
+
+func TestNewLimiter(t *testing.T) {
+
+	storage := mapLimitStorage{}
+	assert.Equal(t, &DefaultLimiter{storage, 1.0 / 6, 10, 5, time.Minute, time.Hour}, NewLimiter(storage))
+}
+
+func TestDefaultLimiterTokenBucket(t *testing.T) {
+
+	limiter := &DefaultLimiter{storage: mapLimitStorage{}, Rate: 1, Burst: 2}
+
+	retryAfter, error := limiter.Allow("key", testNow)
+	assert.Equal(t, time.Duration(0), retryAfter)
+	assert.Nil(t, error)
+	retryAfter, _ = limiter.Allow("key", testNow)
+	assert.Equal(t, time.Duration(0), retryAfter)
+	retryAfter, _ = limiter.Allow("key", testNow)
+	assert.Equal(t, time.Second, retryAfter)
+	retryAfter, _ = limiter.Allow("other", testNow)
+	assert.Equal(t, time.Duration(0), retryAfter)
+
+	retryAfter, _ = limiter.Allow("key", testNow.Add(500*time.Millisecond))
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+	retryAfter, _ = limiter.Allow("key", testNow.Add(time.Second))
+	assert.Equal(t, time.Duration(0), retryAfter)
+	retryAfter, _ = limiter.Allow("key", testNow.Add(time.Hour))
+	assert.Equal(t, time.Duration(0), retryAfter)
+	retryAfter, _ = limiter.Allow("key", testNow.Add(time.Hour))
+	assert.Equal(t, time.Duration(0), retryAfter)
+	retryAfter, _ = limiter.Allow("key", testNow.Add(time.Hour))
+	assert.Equal(t, time.Second, retryAfter)
+}
+
+func TestDefaultLimiterLockout(t *testing.T) {
+
+	limiter := &DefaultLimiter{storage: mapLimitStorage{}, LockoutThreshold: 2, LockoutDuration: time.Minute, MaxLockout: 3 * time.Minute}
+
+	assert.Nil(t, limiter.Failure("key", testNow))
+	retryAfter, _ := limiter.Allow("key", testNow)
+	assert.Equal(t, time.Duration(0), retryAfter)
+
+	limiter.Failure("key", testNow)
+	retryAfter, _ = limiter.Allow("key", testNow)
+	assert.Equal(t, time.Minute, retryAfter)
+
+	limiter.Failure("key", testNow)
+	retryAfter, _ = limiter.Allow("key", testNow.Add(30*time.Second))
+	assert.Equal(t, 90*time.Second, retryAfter)
+
+	limiter.Failure("key", testNow)
+	retryAfter, _ = limiter.Allow("key", testNow)
+	assert.Equal(t, 3*time.Minute, retryAfter)
+
+	assert.Nil(t, limiter.Success("key", testNow))
+	retryAfter, _ = limiter.Allow("key", testNow)
+	assert.Equal(t, time.Duration(0), retryAfter)
+}
+
+func TestAuthenticateClientIsRateLimited(t *testing.T) {
+
+	storage := &MockOwnerClientStorage{}
+	limiter := &DefaultLimiter{storage: mapLimitStorage{}, LockoutThreshold: 2, LockoutDuration: time.Minute}
+	server := New(storage, storage, &MockSessionStorage{}, &MockScopeStorage{}).SetLimiter(limiter)
+	server.SetClock(NewFakeClock(testNow))
+	assert.Equal(t, limiter, server.Limiter())
+
+	request := &remoteAddressRequest{NewBasicOauthSessionRequest("client_credentials").SetAll(map[string]string{
+		"client_id":     "client",
+		"client_secret": "wrong",
+	})}
+	storage.On("FindClientByIdAndSecret", "client", "wrong").Return(nil, errors.New("not found"))
+
+	for i := 0; i < 2; i++ {
+
+		client, error := AuthenticateClient(request, server)
+		assert.Nil(t, client)
+		assert.Equal(t, &StorageSearchFailedError{"client", errors.New("not found")}, error)
+	}
+
+	client, error := AuthenticateClient(request, server)
+	assert.Nil(t, client)
+	assert.Equal(t, &RateLimitedError{time.Minute}, error)
+	storage.AssertNumberOfCalls(t, "FindClientByIdAndSecret", 2)
+	assert.Equal(t, 2, limiter.storage.(mapLimitStorage)["ip:10.0.0.1"].Failures)
+}
+
+func TestPasswordGrantIsRateLimitedByUsername(t *testing.T) {
+
+	storage := &MockOwnerClientStorage{}
+	limiter := &DefaultLimiter{storage: mapLimitStorage{}, LockoutThreshold: 1, LockoutDuration: time.Minute}
+	server := New(storage, storage, &MockSessionStorage{}, &MockScopeStorage{}).SetLimiter(limiter)
+	server.SetClock(NewFakeClock(testNow))
+	grant := &PasswordGrant{}
+
+	request := NewBasicOauthSessionRequest("password").SetAll(map[string]string{
+		"client_id":     "client",
+		"client_secret": "secret",
+		"username":      "owner",
+		"password":      "wrong",
+	})
+	storage.On("FindClientByIdAndSecret", "client", "secret").Return(&Client{Id: "client"}, nil)
+	storage.On("FindOwnerByUsernameAndPassword", "owner", "wrong").Return(nil, errors.New("not found"))
+
+	session, error := grant.GenerateSession(request, server)
+	assert.Nil(t, session)
+	assert.Equal(t, &StorageSearchFailedError{"owner", errors.New("not found")}, error)
+
+	session, error = grant.GenerateSession(request, server)
+	assert.Nil(t, session)
+	assert.Equal(t, &RateLimitedError{time.Minute}, error)
+	assert.Equal(t, time.Minute, error.(*RateLimitedError).RetryAfter())
+	storage.AssertNumberOfCalls(t, "FindOwnerByUsernameAndPassword", 1)
+	assert.Equal(t, 0, limiter.storage.(mapLimitStorage)["client:client"].Failures)
+}
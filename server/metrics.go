@@ -0,0 +1,217 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+// Metrics receives the measurements the server takes. Outcome is "success" or
+// the name of the error code the request failed with.
+type Metrics interface {
+	TokenRequest(grant string, outcome string, duration time.Duration)
+	StorageCall(method string, duration time.Duration)
+	Revocation()
+	RefreshTokenReuse()
+}
+
+// ActiveSessionCounter is implemented by session storages that can count the
+// sessions with a token that is still usable.
+type ActiveSessionCounter interface {
+	CountActiveSessions() (int, error)
+}
+
+type NoopMetrics struct {
+}
+
+func (metrics *NoopMetrics) TokenRequest(grant string, outcome string, duration time.Duration) {
+}
+
+func (metrics *NoopMetrics) StorageCall(method string, duration time.Duration) {
+}
+
+func (metrics *NoopMetrics) Revocation() {
+}
+
+func (metrics *NoopMetrics) RefreshTokenReuse() {
+}
+
+func NewNoopMetrics() *NoopMetrics {
+
+	return &NoopMetrics{}
+}
+
+func observeStorageCall(metrics Metrics, method string, start time.Time) {
+
+	metrics.StorageCall(method, time.Since(start))
+}
+
+type InstrumentedClientStorage struct {
+	storage ClientStorage
+	metrics Metrics
+}
+
+func (storage *InstrumentedClientStorage) FindClientById(clientId string) (*Client, error) {
+
+	defer observeStorageCall(storage.metrics, "FindClientById", time.Now())
+	return storage.storage.FindClientById(clientId)
+}
+
+func (storage *InstrumentedClientStorage) FindClientByIdAndSecret(clientId string, clientSecret string) (*Client, error) {
+
+	defer observeStorageCall(storage.metrics, "FindClientByIdAndSecret", time.Now())
+	return storage.storage.FindClientByIdAndSecret(clientId, clientSecret)
+}
+
+func (storage *InstrumentedClientStorage) RefreshClient(client *Client) (*Client, error) {
+
+	defer observeStorageCall(storage.metrics, "RefreshClient", time.Now())
+	return storage.storage.RefreshClient(client)
+}
+
+func NewInstrumentedClientStorage(storage ClientStorage, metrics Metrics) *InstrumentedClientStorage {
+
+	return &InstrumentedClientStorage{storage, metrics}
+}
+
+type InstrumentedOwnerStorage struct {
+	storage OwnerStorage
+	metrics Metrics
+}
+
+func (storage *InstrumentedOwnerStorage) FindOwnerByUsername(username string) (*Owner, error) {
+
+	defer observeStorageCall(storage.metrics, "FindOwnerByUsername", time.Now())
+	return storage.storage.FindOwnerByUsername(username)
+}
+
+func (storage *InstrumentedOwnerStorage) FindOwnerByUsernameAndPassword(username string, password string) (*Owner, error) {
+
+	defer observeStorageCall(storage.metrics, "FindOwnerByUsernameAndPassword", time.Now())
+	return storage.storage.FindOwnerByUsernameAndPassword(username, password)
+}
+
+func (storage *InstrumentedOwnerStorage) RefreshOwner(owner *Owner) (*Owner, error) {
+
+	defer observeStorageCall(storage.metrics, "RefreshOwner", time.Now())
+	return storage.storage.RefreshOwner(owner)
+}
+
+func NewInstrumentedOwnerStorage(storage OwnerStorage, metrics Metrics) *InstrumentedOwnerStorage {
+
+	return &InstrumentedOwnerStorage{storage, metrics}
+}
+
+type InstrumentedSessionStorage struct {
+	storage SessionStorage
+	metrics Metrics
+}
+
+func (storage *InstrumentedSessionStorage) FindSessionByAccessToken(accessToken string) (*Session, error) {
+
+	defer observeStorageCall(storage.metrics, "FindSessionByAccessToken", time.Now())
+	return storage.storage.FindSessionByAccessToken(accessToken)
+}
+
+func (storage *InstrumentedSessionStorage) FindSessionByRefreshToken(refreshToken string) (*Session, error) {
+
+	defer observeStorageCall(storage.metrics, "FindSessionByRefreshToken", time.Now())
+	return storage.storage.FindSessionByRefreshToken(refreshToken)
+}
+
+func (storage *InstrumentedSessionStorage) SaveSession(session *Session) {
+
+	defer observeStorageCall(storage.metrics, "SaveSession", time.Now())
+	storage.storage.SaveSession(session)
+}
+
+func (storage *InstrumentedSessionStorage) DeleteSession(session *Session) {
+
+	defer observeStorageCall(storage.metrics, "DeleteSession", time.Now())
+	storage.storage.DeleteSession(session)
+}
+
+// CountActiveSessions passes through to the wrapped storage so the active
+// session gauge keeps working when the storage is instrumented.
+func (storage *InstrumentedSessionStorage) CountActiveSessions() (int, error) {
+
+	counter, ok := storage.storage.(ActiveSessionCounter)
+
+	if !ok {
+
+		return 0, fmt.Errorf("the session storage can not count active sessions")
+	}
+
+	defer observeStorageCall(storage.metrics, "CountActiveSessions", time.Now())
+	return counter.CountActiveSessions()
+}
+
+func NewInstrumentedSessionStorage(storage SessionStorage, metrics Metrics) *InstrumentedSessionStorage {
+
+	return &InstrumentedSessionStorage{storage, metrics}
+}
+
+type InstrumentedScopeStorage struct {
+	storage ScopeStorage
+	metrics Metrics
+}
+
+func (storage *InstrumentedScopeStorage) FindScopeByName(name string) (*Scope, error) {
+
+	defer observeStorageCall(storage.metrics, "FindScopeByName", time.Now())
+	return storage.storage.FindScopeByName(name)
+}
+
+// FindAllScopes passes through to the wrapped storage so the scopes are still
+// advertised when the storage is instrumented.
+func (storage *InstrumentedScopeStorage) FindAllScopes() ([]*Scope, error) {
+
+	lister, ok := storage.storage.(ScopeLister)
+
+	if !ok {
+
+		return nil, fmt.Errorf("the scope storage can not list scopes")
+	}
+
+	defer observeStorageCall(storage.metrics, "FindAllScopes", time.Now())
+	return lister.FindAllScopes()
+}
+
+func NewInstrumentedScopeStorage(storage ScopeStorage, metrics Metrics) *InstrumentedScopeStorage {
+
+	return &InstrumentedScopeStorage{storage, metrics}
+}
+
+// tokenRequestOutcome names the outcome of a token request for metrics.
+func tokenRequestOutcome(error OauthError) string {
+
+	if error == nil {
+
+		return "success"
+	}
+
+	return error.OauthErrorCode().String()
+}
+
+// isRefreshTokenReuse looks through the error chain for a refresh token
+// reuse reported by the session storage.
+func isRefreshTokenReuse(error error) bool {
+
+	for error != nil {
+
+		if _, ok := error.(*RefreshTokenReusedError); ok {
+
+			return true
+		}
+
+		withPrevious, ok := error.(OauthErrorWithPrevious)
+
+		if !ok {
+
+			return false
+		}
+
+		error = withPrevious.Previous()
+	}
+
+	return false
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+type MockMetrics struct {
+	mock.Mock
+}
+
+func (metrics *MockMetrics) TokenRequest(grant string, outcome string, duration time.Duration) {
+
+	metrics.Mock.Called(grant, outcome, duration)
+}
+
+func (metrics *MockMetrics) StorageCall(method string, duration time.Duration) {
+
+	metrics.Mock.Called(method, duration)
+}
+
+func (metrics *MockMetrics) Revocation() {
+
+	metrics.Mock.Called()
+}
+
+func (metrics *MockMetrics) RefreshTokenReuse() {
+
+	metrics.Mock.Called()
+}
+
+func TestServerMetrics(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	sessionStorage := &MockSessionStorage{}
+	scopeStorage := &MockScopeStorage{}
+	metrics := &MockMetrics{}
+
+	server := New(
+		ownerClientStorage,
+		ownerClientStorage,
+		sessionStorage,
+		scopeStorage,
+	)
+	assert.Equal(t, NewNoopMetrics(), server.Metrics())
+	assert.Equal(t, server, server.SetMetrics(metrics))
+	assert.Equal(t, metrics, server.Metrics())
+
+	metrics.On("TokenRequest", "unknown", "grant_not_found", mock.AnythingOfType("time.Duration")).Return().Once()
+	server.GrantOauthSession(NewBasicOauthSessionRequest("missing"))
+
+	session := NewSession()
+	sessionStorage.On("DeleteSession", session).Return()
+	metrics.On("Revocation").Return().Once()
+	server.RevokeSession(session)
+
+	metrics.AssertExpectations(t)
+}
+
+func TestServerMetricsRecordsRefreshTokenReuse(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	sessionStorage := &MockSessionStorage{}
+	scopeStorage := &MockScopeStorage{}
+	metrics := &MockMetrics{}
+
+	server := New(
+		ownerClientStorage,
+		ownerClientStorage,
+		sessionStorage,
+		scopeStorage,
+	).SetMetrics(metrics)
+	server.AddGrant(&RefreshTokenGrant{})
+
+	request := NewBasicOauthSessionRequest("refresh_token").SetAll(map[string]string{
+		"client_id":     "client",
+		"client_secret": "secret",
+		"refresh_token": "reused",
+	})
+	ownerClientStorage.On("FindClientByIdAndSecret", "client", "secret").Return(&Client{Id: "client"}, nil)
+	sessionStorage.On("FindSessionByRefreshToken", "reused").Return(nil, &RefreshTokenReusedError{})
+	metrics.On("TokenRequest", "refresh_token", "storage_search_failed", mock.AnythingOfType("time.Duration")).Return().Once()
+	metrics.On("RefreshTokenReuse").Return().Once()
+
+	session, error := server.GrantOauthSession(request)
+
+	assert.Nil(t, session)
+	assert.Equal(t, &StorageSearchFailedError{"session", &RefreshTokenReusedError{}}, error)
+	metrics.AssertExpectations(t)
+}
+
+func TestIsRefreshTokenReuse(t *testing.T) {
+
+	assert.False(t, isRefreshTokenReuse(nil))
+	assert.False(t, isRefreshTokenReuse(errors.New("boom")))
+	assert.False(t, isRefreshTokenReuse(&StorageSearchFailedError{"session", errors.New("boom")}))
+	assert.True(t, isRefreshTokenReuse(&RefreshTokenReusedError{}))
+	assert.True(t, isRefreshTokenReuse(&StorageSearchFailedError{"session", &RefreshTokenReusedError{}}))
+}
+
+func TestInstrumentedStorages(t *testing.T) {
+
+	metrics := &MockMetrics{}
+	ownerClientStorage := &MockOwnerClientStorage{}
+	sessionStorage := &MockSessionStorage{}
+	scopeStorage := &MockScopeStorage{}
+	client := &Client{Id: "client"}
+	owner := &Owner{Id: "owner"}
+	session := NewSession()
+	scope := &Scope{Id: "id", Name: "read"}
+
+	for _, method := range []string{
+		"FindClientById",
+		"FindClientByIdAndSecret",
+		"RefreshClient",
+		"FindOwnerByUsername",
+		"FindOwnerByUsernameAndPassword",
+		"RefreshOwner",
+		"FindSessionByAccessToken",
+		"FindSessionByRefreshToken",
+		"SaveSession",
+		"DeleteSession",
+		"FindScopeByName",
+	} {
+		metrics.On("StorageCall", method, mock.AnythingOfType("time.Duration")).Return().Once()
+	}
+
+	ownerClientStorage.On("FindClientById", "client").Return(client, nil)
+	ownerClientStorage.On("FindClientByIdAndSecret", "client", "secret").Return(client, nil)
+	ownerClientStorage.On("RefreshClient", client).Return(client, nil)
+	ownerClientStorage.On("FindOwnerByUsername", "owner").Return(owner, nil)
+	ownerClientStorage.On("FindOwnerByUsernameAndPassword", "owner", "password").Return(owner, nil)
+	ownerClientStorage.On("RefreshOwner", owner).Return(owner, nil)
+	sessionStorage.On("FindSessionByAccessToken", "access").Return(session, nil)
+	sessionStorage.On("FindSessionByRefreshToken", "refresh").Return(session, nil)
+	sessionStorage.On("SaveSession", session).Return()
+	sessionStorage.On("DeleteSession", session).Return()
+	scopeStorage.On("FindScopeByName", "read").Return(scope, nil)
+
+	clientStorage := NewInstrumentedClientStorage(ownerClientStorage, metrics)
+	ownerStorage := NewInstrumentedOwnerStorage(ownerClientStorage, metrics)
+	instrumentedSessionStorage := NewInstrumentedSessionStorage(sessionStorage, metrics)
+	instrumentedScopeStorage := NewInstrumentedScopeStorage(scopeStorage, metrics)
+
+	returnedClient, _ := clientStorage.FindClientById("client")
+	assert.Equal(t, client, returnedClient)
+	returnedClient, _ = clientStorage.FindClientByIdAndSecret("client", "secret")
+	assert.Equal(t, client, returnedClient)
+	returnedClient, _ = clientStorage.RefreshClient(client)
+	assert.Equal(t, client, returnedClient)
+	returnedOwner, _ := ownerStorage.FindOwnerByUsername("owner")
+	assert.Equal(t, owner, returnedOwner)
+	returnedOwner, _ = ownerStorage.FindOwnerByUsernameAndPassword("owner", "password")
+	assert.Equal(t, owner, returnedOwner)
+	returnedOwner, _ = ownerStorage.RefreshOwner(owner)
+	assert.Equal(t, owner, returnedOwner)
+	returnedSession, _ := instrumentedSessionStorage.FindSessionByAccessToken("access")
+	assert.Equal(t, session, returnedSession)
+	returnedSession, _ = instrumentedSessionStorage.FindSessionByRefreshToken("refresh")
+	assert.Equal(t, session, returnedSession)
+	instrumentedSessionStorage.SaveSession(session)
+	instrumentedSessionStorage.DeleteSession(session)
+	returnedScope, _ := instrumentedScopeStorage.FindScopeByName("read")
+	assert.Equal(t, scope, returnedScope)
+
+	count, error := instrumentedSessionStorage.CountActiveSessions()
+	assert.Equal(t, 0, count)
+	assert.NotNil(t, error)
+
+	metrics.AssertExpectations(t)
+}
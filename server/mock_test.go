@@ -2,6 +2,7 @@ package server
 
 import (
 	"github.com/stretchr/testify/mock"
+	"time"
 )
 
 type MockServer struct {
@@ -44,6 +45,13 @@ func (server *MockServer) SessionStorage() SessionStorage {
 	return storage
 }
 
+func (server *MockServer) ScopeStorage() ScopeStorage {
+
+	args := server.Mock.Called()
+	storage, _ := args.Get(0).(ScopeStorage)
+	return storage
+}
+
 func (server *MockServer) Config() *Config {
 
 	args := server.Mock.Called()
@@ -51,6 +59,13 @@ func (server *MockServer) Config() *Config {
 	return config
 }
 
+func (server *MockServer) Clock() Clock {
+
+	args := server.Mock.Called()
+	clock, _ := args.Get(0).(Clock)
+	return clock
+}
+
 func (server *MockServer) GrantOauthSession(oauthSessionRequest OauthSessionRequest) (*Session, OauthError) {
 
 	args := server.Mock.Called(oauthSessionRequest)
@@ -106,6 +121,27 @@ func (storage *MockOwnerClientStorage) RefreshOwner(owner *Owner) (*Owner, error
 
 }
 
+type MockClientRegistry struct {
+	MockOwnerClientStorage
+}
+
+func (registry *MockClientRegistry) SaveClientRegistration(registration *ClientRegistration, clientSecret string) error {
+
+	return registry.Mock.Called(registration, clientSecret).Error(0)
+}
+
+func (registry *MockClientRegistry) FindClientRegistrationById(clientId string) (*ClientRegistration, error) {
+
+	args := registry.Mock.Called(clientId)
+	registration, _ := args.Get(0).(*ClientRegistration)
+	return registration, args.Error(1)
+}
+
+func (registry *MockClientRegistry) DeleteClientRegistration(registration *ClientRegistration) error {
+
+	return registry.Mock.Called(registration).Error(0)
+}
+
 type MockSessionStorage struct {
 	mock.Mock
 }
@@ -134,6 +170,79 @@ func (storage *MockSessionStorage) DeleteSession(session *Session) {
 	storage.Mock.Called(session)
 }
 
+type MockSessionQuery struct {
+	mock.Mock
+}
+
+func (query *MockSessionQuery) FindSessionById(sessionId string) (*Session, error) {
+
+	args := query.Mock.Called(sessionId)
+	session, _ := args.Get(0).(*Session)
+	return session, args.Error(1)
+}
+
+func (query *MockSessionQuery) FindSessionsByOwnerId(ownerId string) ([]*Session, error) {
+
+	args := query.Mock.Called(ownerId)
+	sessions, _ := args.Get(0).([]*Session)
+	return sessions, args.Error(1)
+}
+
+func (query *MockSessionQuery) FindSessionsByClientId(clientId string) ([]*Session, error) {
+
+	args := query.Mock.Called(clientId)
+	sessions, _ := args.Get(0).([]*Session)
+	return sessions, args.Error(1)
+}
+
+func (query *MockSessionQuery) FindSessionsByScope(scope string) ([]*Session, error) {
+
+	args := query.Mock.Called(scope)
+	sessions, _ := args.Get(0).([]*Session)
+	return sessions, args.Error(1)
+}
+
+func (query *MockSessionQuery) QuerySessions(filter *SessionFilter, cursor *SessionCursor, limit int) (*SessionPage, error) {
+
+	args := query.Mock.Called(filter, cursor, limit)
+	page, _ := args.Get(0).(*SessionPage)
+	return page, args.Error(1)
+}
+
+func (query *MockSessionQuery) CountSessions(filter *SessionFilter) (int, error) {
+
+	args := query.Mock.Called(filter)
+	return args.Int(0), args.Error(1)
+}
+
+type MockConsentStorage struct {
+	mock.Mock
+}
+
+func (storage *MockConsentStorage) FindConsent(ownerId string, clientId string) (*Consent, error) {
+
+	args := storage.Mock.Called(ownerId, clientId)
+	consent, _ := args.Get(0).(*Consent)
+	return consent, args.Error(1)
+}
+
+func (storage *MockConsentStorage) FindConsentsByOwnerId(ownerId string) ([]*Consent, error) {
+
+	args := storage.Mock.Called(ownerId)
+	consents, _ := args.Get(0).([]*Consent)
+	return consents, args.Error(1)
+}
+
+func (storage *MockConsentStorage) SaveConsent(consent *Consent) error {
+
+	return storage.Mock.Called(consent).Error(0)
+}
+
+func (storage *MockConsentStorage) DeleteConsent(consent *Consent) error {
+
+	return storage.Mock.Called(consent).Error(0)
+}
+
 type MockScopeStorage struct {
 	mock.Mock
 }
@@ -145,14 +254,33 @@ func (generator *MockScopeStorage) FindScopeByName(name string) (*Scope, error)
 	return scope, args.Error(1)
 }
 
+type MockScopeLister struct {
+	MockScopeStorage
+}
+
+func (lister *MockScopeLister) FindAllScopes() ([]*Scope, error) {
+
+	args := lister.Mock.Called()
+	scopes, _ := args.Get(0).([]*Scope)
+	return scopes, args.Error(1)
+}
+
 type MockGrant struct {
 	mock.Mock
 	Server Server
 }
 
+// GenerateSession calls a func() *Session return value on every call, so each
+// call can get its own session.
 func (grant *MockGrant) GenerateSession(oauthSessionRequest OauthSessionRequest, server Server) (*Session, error) {
 
 	args := grant.Mock.Called(oauthSessionRequest, server)
+
+	if generate, ok := args.Get(0).(func() *Session); ok {
+
+		return generate(), args.Error(1)
+	}
+
 	session, _ := args.Get(0).(*Session)
 	return session, args.Error(1)
 }
@@ -162,9 +290,9 @@ func (grant *MockGrant) Name() string {
 	return grant.Mock.Called().Get(0).(string)
 }
 
-func (grant *MockGrant) AccessTokenExpiration() int {
+func (grant *MockGrant) AccessTokenExpiration() time.Duration {
 
-	return grant.Mock.Called().Get(0).(int)
+	return grant.Mock.Called().Get(0).(time.Duration)
 }
 
 func (grant *MockGrant) ShouldGenerateRefreshToken(session *Session) bool {
@@ -185,12 +313,35 @@ type MockTokenGenerator struct {
 	mock.Mock
 }
 
-func (generator *MockTokenGenerator) GenerateAccessToken(config *Config, grant Grant) *Token {
+func (generator *MockTokenGenerator) GenerateAccessToken(config *Config, grant Grant, session *Session) *Token {
+
+	return generator.Mock.Called(config, grant, session).Get(0).(*Token)
+}
+
+func (generator *MockTokenGenerator) GenerateRefreshToken(config *Config, grant Grant, session *Session) *Token {
 
-	return generator.Mock.Called(config, grant).Get(0).(*Token)
+	return generator.Mock.Called(config, grant, session).Get(0).(*Token)
 }
 
-func (generator *MockTokenGenerator) GenerateRefreshToken(config *Config, grant Grant) *Token {
+// newMockGrantServer builds a server over mocks with a grant named test for
+// the request. The grant hands out a copy of the returned session on every
+// call, so the session the server saves in the background is not changed by
+// the next grant. Changes to the returned session apply to later calls.
+func newMockGrantServer(oauthSessionRequest OauthSessionRequest) (*DefaultServer, *MockGrant, *Session) {
 
-	return generator.Mock.Called(config, grant).Get(0).(*Token)
+	sessionStorage := &MockSessionStorage{}
+	server := NewWithTokenGenerator(
+		&MockTokenGenerator{},
+		&MockOwnerClientStorage{},
+		&MockOwnerClientStorage{},
+		sessionStorage,
+		&MockScopeStorage{},
+	)
+	session := NewSession()
+	session.AccessToken = &Token{}
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	grant.On("GenerateSession", oauthSessionRequest, server).Return(func() *Session { return session.Copy() }, nil)
+	sessionStorage.On("SaveSession", mock.Anything).Return()
+	return server, grant, session
 }
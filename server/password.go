@@ -0,0 +1,19 @@
+package server
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashPassword hashes an owner password with bcrypt for storages that keep
+// passwords.
+func HashPassword(password string) (string, error) {
+
+	hash, error := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), error
+}
+
+// CheckPassword reports whether password is the one hash was made from.
+func CheckPassword(hash string, password string) bool {
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,16 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+
+	hash, error := HashPassword("correct horse")
+	assert.Nil(t, error)
+	assert.NotEqual(t, "correct horse", hash)
+	assert.True(t, CheckPassword(hash, "correct horse"))
+	assert.False(t, CheckPassword(hash, "battery staple"))
+	assert.False(t, CheckPassword("not a hash", "correct horse"))
+}
@@ -0,0 +1,372 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ClientMetadata holds the client metadata fields from RFC 7591 section 2.
+type ClientMetadata struct {
+	RedirectUris            []string        `json:"redirect_uris,omitempty"`
+	TokenEndpointAuthMethod string          `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes              []string        `json:"grant_types,omitempty"`
+	ResponseTypes           []string        `json:"response_types,omitempty"`
+	ClientName              string          `json:"client_name,omitempty"`
+	ClientUri               string          `json:"client_uri,omitempty"`
+	LogoUri                 string          `json:"logo_uri,omitempty"`
+	Scope                   string          `json:"scope,omitempty"`
+	Contacts                []string        `json:"contacts,omitempty"`
+	TosUri                  string          `json:"tos_uri,omitempty"`
+	PolicyUri               string          `json:"policy_uri,omitempty"`
+	JwksUri                 string          `json:"jwks_uri,omitempty"`
+	Jwks                    json.RawMessage `json:"jwks,omitempty"`
+	SoftwareId              string          `json:"software_id,omitempty"`
+	SoftwareVersion         string          `json:"software_version,omitempty"`
+}
+
+type ClientRegistration struct {
+	Client                      *Client
+	Metadata                    *ClientMetadata
+	RegistrationAccessTokenHash string
+	ClientIdIssuedAt            time.Time
+	ClientSecretExpiresAt       time.Time
+}
+
+// RegisteredClient is returned once on registration and on update. The
+// secret and registration access token are never stored in the clear.
+type RegisteredClient struct {
+	Registration            *ClientRegistration
+	ClientSecret            string
+	RegistrationAccessToken string
+}
+
+type ClientRegistrar struct {
+	server           Server
+	registry         ClientRegistry
+	tokenIdGenerator TokenIdGeneratorFunc
+	authMethods      []string
+	scopes           []string
+	defaultScope     string
+}
+
+func (registrar *ClientRegistrar) Register(metadata *ClientMetadata) (*RegisteredClient, OauthError) {
+
+	if error := registrar.ValidateClientMetadata(metadata); error != nil {
+
+		return nil, error
+	}
+
+	registration := &ClientRegistration{
+		Client:           &Client{},
+		ClientIdIssuedAt: registrar.server.Clock().Now(),
+	}
+	registration.Client.Id = registrar.tokenIdGenerator()
+
+	return registrar.save(registration, metadata)
+}
+
+// Authenticate checks the registration access token presented to the client
+// configuration endpoint from RFC 7592.
+func (registrar *ClientRegistrar) Authenticate(clientId string, registrationAccessToken string) (*ClientRegistration, OauthError) {
+
+	registration, error := registrar.registry.FindClientRegistrationById(clientId)
+
+	if registration == nil {
+
+		return nil, &StorageSearchFailedError{"client", error}
+	}
+
+	expected := []byte(registration.RegistrationAccessTokenHash)
+	actual := []byte(HashRegistrationAccessToken(registrationAccessToken))
+
+	if subtle.ConstantTimeCompare(expected, actual) != 1 {
+
+		return nil, &StorageSearchFailedError{"client", fmt.Errorf("registration access token did not match client %s", clientId)}
+	}
+
+	return registration, nil
+}
+
+func (registrar *ClientRegistrar) Update(registration *ClientRegistration, metadata *ClientMetadata) (*RegisteredClient, OauthError) {
+
+	if error := registrar.ValidateClientMetadata(metadata); error != nil {
+
+		return nil, error
+	}
+
+	return registrar.save(registration, metadata)
+}
+
+func (registrar *ClientRegistrar) Delete(registration *ClientRegistration) OauthError {
+
+	if error := registrar.registry.DeleteClientRegistration(registration); error != nil {
+
+		return &UnexpectedError{error}
+	}
+
+	return nil
+}
+
+func (registrar *ClientRegistrar) ValidateClientMetadata(metadata *ClientMetadata) OauthError {
+
+	if metadata.TokenEndpointAuthMethod == "" {
+
+		metadata.TokenEndpointAuthMethod = registrar.authMethods[0]
+	}
+
+	if !containsString(registrar.authMethods, metadata.TokenEndpointAuthMethod) {
+
+		return &InvalidClientMetadataError{"token_endpoint_auth_method", "is not supported"}
+	}
+
+	if len(metadata.GrantTypes) == 0 {
+
+		metadata.GrantTypes = registrar.defaultGrantTypes(metadata)
+	}
+
+	if len(metadata.GrantTypes) == 0 {
+
+		return &InvalidClientMetadataError{"grant_types", "is required"}
+	}
+
+	for _, grantType := range metadata.GrantTypes {
+
+		if _, ok := registrar.server.GetGrant(grantType); !ok {
+
+			return &InvalidClientMetadataError{"grant_types", fmt.Sprintf("contains the unsupported grant %s", grantType)}
+		}
+	}
+
+	if metadata.TokenEndpointAuthMethod == "none" && containsString(metadata.GrantTypes, "client_credentials") {
+
+		return &InvalidClientMetadataError{"grant_types", "can not contain client_credentials for clients without a secret"}
+	}
+
+	if containsString(metadata.GrantTypes, "authorization_code") && len(metadata.RedirectUris) == 0 {
+
+		return &InvalidRedirectUriError{"", "is required for the authorization_code grant"}
+	}
+
+	for _, redirectUri := range metadata.RedirectUris {
+
+		parsed, error := url.Parse(redirectUri)
+
+		if error != nil || !parsed.IsAbs() {
+
+			return &InvalidRedirectUriError{redirectUri, "must be an absolute uri"}
+		}
+
+		if parsed.Fragment != "" {
+
+			return &InvalidRedirectUriError{redirectUri, "must not contain a fragment"}
+		}
+
+		if parsed.Scheme != "https" && (parsed.Scheme != "http" || !isLoopbackHost(parsed.Hostname())) {
+
+			return &InvalidRedirectUriError{redirectUri, "must use https, or http for a loopback host"}
+		}
+	}
+
+	//scope is optional in RFC 7591 section 2, clients leaving it out get the default one
+	if len(strings.Fields(metadata.Scope)) == 0 {
+
+		metadata.Scope = registrar.defaultScope
+	}
+
+	//registered clients are only granted the scopes they registered with, no scopes would not restrict them at all
+	if len(strings.Fields(metadata.Scope)) == 0 {
+
+		return &InvalidClientMetadataError{"scope", "can not be left out, there is no default scope"}
+	}
+
+	for _, scopeName := range strings.Fields(metadata.Scope) {
+
+		if !registrar.allowsScope(scopeName) {
+
+			return &InvalidClientMetadataError{"scope", fmt.Sprintf("contains the scope %s which clients can not register with", scopeName)}
+		}
+
+		if scope, _ := NewScopeMatcher(registrar.server.ScopeStorage()).FindScope(scopeName); scope == nil {
+
+			return &InvalidClientMetadataError{"scope", fmt.Sprintf("contains the unknown scope %s", scopeName)}
+		}
+	}
+
+	if metadata.JwksUri != "" && len(metadata.Jwks) > 0 {
+
+		return &InvalidClientMetadataError{"jwks", "must not be used together with jwks_uri"}
+	}
+
+	if len(metadata.Jwks) > 0 {
+
+		jwks := struct {
+			Keys []map[string]interface{} `json:"keys"`
+		}{}
+
+		if error := json.Unmarshal(metadata.Jwks, &jwks); error != nil || len(jwks.Keys) == 0 {
+
+			return &InvalidClientMetadataError{"jwks", "must be a json web key set with at least one key"}
+		}
+	}
+
+	return nil
+}
+
+// defaultGrantTypes is authorization_code as RFC 7591 section 2 asks when the
+// server has that grant. Otherwise clients with a secret get client_credentials
+// if the server has it, and others have to ask for their grants.
+func (registrar *ClientRegistrar) defaultGrantTypes(metadata *ClientMetadata) []string {
+
+	if _, ok := registrar.server.GetGrant("authorization_code"); ok {
+
+		return []string{"authorization_code"}
+	}
+
+	if _, ok := registrar.server.GetGrant("client_credentials"); ok && metadata.TokenEndpointAuthMethod != "none" {
+
+		return []string{"client_credentials"}
+	}
+
+	return nil
+}
+
+func (registrar *ClientRegistrar) allowsScope(scopeName string) bool {
+
+	for _, scope := range registrar.scopes {
+
+		if MatchScope(scope, scopeName) {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (registrar *ClientRegistrar) save(registration *ClientRegistration, metadata *ClientMetadata) (*RegisteredClient, OauthError) {
+
+	registered := &RegisteredClient{Registration: registration}
+
+	if registration.Metadata == nil || registration.Metadata.TokenEndpointAuthMethod != metadata.TokenEndpointAuthMethod {
+
+		if metadata.TokenEndpointAuthMethod != "none" {
+
+			registered.ClientSecret = registrar.tokenIdGenerator()
+		}
+	}
+
+	registered.RegistrationAccessToken = registrar.tokenIdGenerator()
+	registration.RegistrationAccessTokenHash = HashRegistrationAccessToken(registered.RegistrationAccessToken)
+	registration.Metadata = metadata
+	applyClientMetadata(registration.Client, metadata)
+
+	if error := registrar.registry.SaveClientRegistration(registration, registered.ClientSecret); error != nil {
+
+		return nil, &UnexpectedError{error}
+	}
+
+	return registered, nil
+}
+
+// SetAuthMethods replaces the token endpoint auth methods clients may register
+// with. The first one is used when a client does not ask for one.
+func (registrar *ClientRegistrar) SetAuthMethods(authMethods []string) *ClientRegistrar {
+
+	registrar.authMethods = authMethods
+	return registrar
+}
+
+// SetScopes replaces the scopes clients may register with, which can be
+// patterns like repo:*. No scopes are allowed until they are set.
+func (registrar *ClientRegistrar) SetScopes(scopes []string) *ClientRegistrar {
+
+	registrar.scopes = scopes
+	return registrar
+}
+
+// SetDefaultScope sets the space separated scopes clients get when they
+// register without a scope.
+func (registrar *ClientRegistrar) SetDefaultScope(defaultScope string) *ClientRegistrar {
+
+	registrar.defaultScope = defaultScope
+	return registrar
+}
+
+func applyClientMetadata(client *Client, metadata *ClientMetadata) {
+
+	client.Name = metadata.ClientName
+	client.Type = ConfidentialClient
+
+	if metadata.TokenEndpointAuthMethod == "none" {
+
+		client.Type = PublicClient
+	}
+
+	client.RedirectUris = metadata.RedirectUris
+	client.AllowedGrants = metadata.GrantTypes
+	client.AllowedScopes = strings.Fields(metadata.Scope)
+	client.Contacts = metadata.Contacts
+	client.LogoUri = metadata.LogoUri
+	client.PolicyUri = metadata.PolicyUri
+	client.Metadata = make(map[string]string)
+
+	for key, value := range map[string]string{
+		"client_uri":       metadata.ClientUri,
+		"tos_uri":          metadata.TosUri,
+		"jwks_uri":         metadata.JwksUri,
+		"software_id":      metadata.SoftwareId,
+		"software_version": metadata.SoftwareVersion,
+	} {
+
+		if value != "" {
+
+			client.Metadata[key] = value
+		}
+	}
+}
+
+func HashRegistrationAccessToken(registrationAccessToken string) string {
+
+	return HashToken(registrationAccessToken)
+}
+
+func NewClientRegistrar(server Server, registry ClientRegistry) *ClientRegistrar {
+
+	return NewClientRegistrarWithGeneratorFunc(server, registry, GenerateTokenId)
+}
+
+func NewClientRegistrarWithGeneratorFunc(server Server, registry ClientRegistry, generatorFunc TokenIdGeneratorFunc) *ClientRegistrar {
+
+	return &ClientRegistrar{server, registry, generatorFunc, []string{"client_secret_post"}, nil, ""}
+}
+
+// isLoopbackHost reports whether the host is localhost or a loopback ip, which
+// only the device itself can listen on.
+func isLoopbackHost(host string) bool {
+
+	if host == "localhost" {
+
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func containsString(values []string, value string) bool {
+
+	for _, candidate := range values {
+
+		if candidate == value {
+
+			return true
+		}
+	}
+
+	return false
+}
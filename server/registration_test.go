@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func TestClientRegistrarValidateClientMetadata(t *testing.T) {
+
+	server := &MockServer{}
+	scopeStorage := &MockScopeStorage{}
+	server.On("GetGrant", "authorization_code").Return(nil, false)
+	server.On("GetGrant", "client_credentials").Return(&ClientCredentialsGrant{}, true)
+	server.On("ScopeStorage").Return(scopeStorage)
+	scopeStorage.On("FindScopeByName", "read").Return(&Scope{Id: "id", Name: "read"}, nil)
+	scopeStorage.On("FindScopeByName", "admin").Return(nil, errors.New("not found"))
+	registrar := NewClientRegistrar(server, &MockClientRegistry{})
+
+	//without authorization_code on the server clients with a secret default to client_credentials
+	metadata := &ClientMetadata{}
+	assert.Equal(t, &InvalidClientMetadataError{"scope", "can not be left out, there is no default scope"}, registrar.ValidateClientMetadata(metadata))
+	assert.Equal(t, "client_secret_post", metadata.TokenEndpointAuthMethod)
+	assert.Equal(t, []string{"client_credentials"}, metadata.GrantTypes)
+
+	metadata = &ClientMetadata{GrantTypes: []string{"authorization_code"}}
+	assert.Equal(t, &InvalidClientMetadataError{"grant_types", "contains the unsupported grant authorization_code"}, registrar.ValidateClientMetadata(metadata))
+
+	metadata = &ClientMetadata{TokenEndpointAuthMethod: "private_key_jwt"}
+	assert.Equal(t, &InvalidClientMetadataError{"token_endpoint_auth_method", "is not supported"}, registrar.ValidateClientMetadata(metadata))
+
+	registrar.SetAuthMethods([]string{"client_secret_post", "none"})
+	metadata = &ClientMetadata{TokenEndpointAuthMethod: "none"}
+	assert.Equal(t, &InvalidClientMetadataError{"grant_types", "is required"}, registrar.ValidateClientMetadata(metadata))
+	assert.Nil(t, metadata.GrantTypes)
+
+	metadata = &ClientMetadata{GrantTypes: []string{"client_credentials"}, TokenEndpointAuthMethod: "none"}
+	assert.Equal(t, &InvalidClientMetadataError{"grant_types", "can not contain client_credentials for clients without a secret"}, registrar.ValidateClientMetadata(metadata))
+
+	metadata = &ClientMetadata{GrantTypes: []string{"client_credentials"}, RedirectUris: []string{"/relative"}}
+	assert.Equal(t, &InvalidRedirectUriError{"/relative", "must be an absolute uri"}, registrar.ValidateClientMetadata(metadata))
+
+	metadata = &ClientMetadata{GrantTypes: []string{"client_credentials"}, RedirectUris: []string{"https://example.com/cb#frag"}}
+	assert.Equal(t, &InvalidRedirectUriError{"https://example.com/cb#frag", "must not contain a fragment"}, registrar.ValidateClientMetadata(metadata))
+
+	for _, redirectUri := range []string{
+		"http://example.com/cb",
+		"javascript:alert(1)",
+		"data:text/html,hello",
+		"com.example.app:/cb",
+	} {
+
+		metadata = &ClientMetadata{GrantTypes: []string{"client_credentials"}, RedirectUris: []string{redirectUri}}
+		assert.Equal(t, &InvalidRedirectUriError{redirectUri, "must use https, or http for a loopback host"}, registrar.ValidateClientMetadata(metadata))
+	}
+
+	metadata = &ClientMetadata{GrantTypes: []string{"client_credentials"}, Scope: "read"}
+	assert.Equal(t, &InvalidClientMetadataError{"scope", "contains the scope read which clients can not register with"}, registrar.ValidateClientMetadata(metadata))
+
+	registrar.SetScopes([]string{"read", "admin"}).SetDefaultScope("read")
+	metadata = &ClientMetadata{GrantTypes: []string{"client_credentials"}}
+	assert.Nil(t, registrar.ValidateClientMetadata(metadata))
+	assert.Equal(t, "read", metadata.Scope)
+
+	metadata = &ClientMetadata{GrantTypes: []string{"client_credentials"}, Scope: "read write"}
+	assert.Equal(t, &InvalidClientMetadataError{"scope", "contains the scope write which clients can not register with"}, registrar.ValidateClientMetadata(metadata))
+
+	metadata = &ClientMetadata{GrantTypes: []string{"client_credentials"}, Scope: "read admin"}
+	assert.Equal(t, &InvalidClientMetadataError{"scope", "contains the unknown scope admin"}, registrar.ValidateClientMetadata(metadata))
+
+	metadata = &ClientMetadata{GrantTypes: []string{"client_credentials"}, Scope: "read", JwksUri: "https://example.com/jwks", Jwks: json.RawMessage(`{"keys":[{}]}`)}
+	assert.Equal(t, &InvalidClientMetadataError{"jwks", "must not be used together with jwks_uri"}, registrar.ValidateClientMetadata(metadata))
+
+	metadata = &ClientMetadata{GrantTypes: []string{"client_credentials"}, Scope: "read", Jwks: json.RawMessage(`{"keys":[]}`)}
+	assert.Equal(t, &InvalidClientMetadataError{"jwks", "must be a json web key set with at least one key"}, registrar.ValidateClientMetadata(metadata))
+
+	metadata = &ClientMetadata{
+		GrantTypes:   []string{"client_credentials"},
+		RedirectUris: []string{"https://example.com/cb", "http://localhost:8080/cb", "http://127.0.0.1/cb", "http://[::1]/cb"},
+		Scope:        "read",
+		Jwks:         json.RawMessage(`{"keys":[{"kty":"RSA"}]}`),
+	}
+	assert.Nil(t, registrar.ValidateClientMetadata(metadata))
+}
+
+func TestClientRegistrarValidateClientMetadataWithAuthorizationCode(t *testing.T) {
+
+	server := &MockServer{}
+	server.On("GetGrant", "authorization_code").Return(&MockGrant{}, true)
+	registrar := NewClientRegistrar(server, &MockClientRegistry{})
+
+	metadata := &ClientMetadata{}
+	assert.Equal(t, &InvalidRedirectUriError{"", "is required for the authorization_code grant"}, registrar.ValidateClientMetadata(metadata))
+	assert.Equal(t, []string{"authorization_code"}, metadata.GrantTypes)
+}
+
+func TestClientRegistrarRegister(t *testing.T) {
+
+	server := &MockServer{}
+	registry := &MockClientRegistry{}
+	server.On("GetGrant", "client_credentials").Return(&ClientCredentialsGrant{}, true)
+	server.On("Clock").Return(NewFakeClock(testNow))
+	server.On("ScopeStorage").Return(readScopeStorage())
+	registrar := NewClientRegistrarWithGeneratorFunc(server, registry, GeneratorFuncMock).SetScopes([]string{"read"})
+
+	metadata := &ClientMetadata{
+		GrantTypes:   []string{"client_credentials"},
+		Scope:        "read",
+		ClientName:   "name",
+		ClientUri:    "https://example.com",
+		RedirectUris: []string{"https://example.com/cb"},
+	}
+	registry.On("SaveClientRegistration", mock.AnythingOfType("*server.ClientRegistration"), "hello").Return(nil)
+
+	registered, error := registrar.Register(metadata)
+	assert.Nil(t, error)
+	assert.Equal(t, "hello", registered.ClientSecret)
+	assert.Equal(t, "hello", registered.RegistrationAccessToken)
+	assert.Equal(t, &Client{
+		Id:            "hello",
+		Name:          "name",
+		Type:          ConfidentialClient,
+		RedirectUris:  []string{"https://example.com/cb"},
+		AllowedGrants: []string{"client_credentials"},
+		AllowedScopes: []string{"read"},
+		Metadata:      map[string]string{"client_uri": "https://example.com"},
+	}, registered.Registration.Client)
+	assert.Equal(t, metadata, registered.Registration.Metadata)
+	assert.Equal(t, HashRegistrationAccessToken("hello"), registered.Registration.RegistrationAccessTokenHash)
+	assert.Equal(t, testNow, registered.Registration.ClientIdIssuedAt)
+	registry.AssertExpectations(t)
+}
+
+func TestClientRegistrarRegisterWhereSaveFails(t *testing.T) {
+
+	server := &MockServer{}
+	registry := &MockClientRegistry{}
+	server.On("GetGrant", "client_credentials").Return(&ClientCredentialsGrant{}, true)
+	server.On("Clock").Return(NewFakeClock(testNow))
+	server.On("ScopeStorage").Return(readScopeStorage())
+	registrar := NewClientRegistrarWithGeneratorFunc(server, registry, GeneratorFuncMock).SetScopes([]string{"read"})
+	registry.On("SaveClientRegistration", mock.AnythingOfType("*server.ClientRegistration"), "hello").Return(errors.New("boom"))
+
+	registered, error := registrar.Register(&ClientMetadata{GrantTypes: []string{"client_credentials"}, Scope: "read"})
+	assert.Nil(t, registered)
+	assert.Equal(t, &UnexpectedError{errors.New("boom")}, error)
+}
+
+func TestClientRegistrarAuthenticate(t *testing.T) {
+
+	registry := &MockClientRegistry{}
+	registrar := NewClientRegistrar(&MockServer{}, registry)
+	registration := &ClientRegistration{
+		Client:                      &Client{Id: "id", Name: "name"},
+		RegistrationAccessTokenHash: HashRegistrationAccessToken("token"),
+	}
+	registry.On("FindClientRegistrationById", "id").Return(registration, nil)
+	registry.On("FindClientRegistrationById", "missing").Return(nil, errors.New("not found"))
+
+	found, error := registrar.Authenticate("id", "token")
+	assert.Equal(t, registration, found)
+	assert.Nil(t, error)
+
+	found, error = registrar.Authenticate("id", "wrong")
+	assert.Nil(t, found)
+	assert.IsType(t, &StorageSearchFailedError{}, error)
+
+	found, error = registrar.Authenticate("missing", "token")
+	assert.Nil(t, found)
+	assert.Equal(t, &StorageSearchFailedError{"client", errors.New("not found")}, error)
+}
+
+func TestClientRegistrarUpdateKeepsSecretWhenAuthMethodUnchanged(t *testing.T) {
+
+	server := &MockServer{}
+	registry := &MockClientRegistry{}
+	server.On("GetGrant", "client_credentials").Return(&ClientCredentialsGrant{}, true)
+	server.On("ScopeStorage").Return(readScopeStorage())
+	registrar := NewClientRegistrarWithGeneratorFunc(server, registry, GeneratorFuncMock).SetScopes([]string{"read"})
+	registration := &ClientRegistration{
+		Client:   &Client{Id: "id", Name: "name"},
+		Metadata: &ClientMetadata{TokenEndpointAuthMethod: "client_secret_post"},
+	}
+	registry.On("SaveClientRegistration", registration, "").Return(nil)
+
+	registered, error := registrar.Update(registration, &ClientMetadata{GrantTypes: []string{"client_credentials"}, Scope: "read", ClientName: "new name"})
+	assert.Nil(t, error)
+	assert.Equal(t, "", registered.ClientSecret)
+	assert.Equal(t, "hello", registered.RegistrationAccessToken)
+	assert.Equal(t, "new name", registration.Client.Name)
+	registry.AssertExpectations(t)
+}
+
+func TestClientRegistrarDelete(t *testing.T) {
+
+	registry := &MockClientRegistry{}
+	registrar := NewClientRegistrar(&MockServer{}, registry)
+	registration := &ClientRegistration{Client: &Client{Id: "id", Name: "name"}}
+	registry.On("DeleteClientRegistration", registration).Return(nil).Once()
+
+	assert.Nil(t, registrar.Delete(registration))
+
+	registry.On("DeleteClientRegistration", registration).Return(errors.New("boom"))
+	assert.Equal(t, &UnexpectedError{errors.New("boom")}, registrar.Delete(registration))
+}
+
+func readScopeStorage() *MockScopeStorage {
+
+	scopeStorage := &MockScopeStorage{}
+	scopeStorage.On("FindScopeByName", "read").Return(&Scope{Id: "id", Name: "read"}, nil)
+	return scopeStorage
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/url"
+)
+
+// ValidateResource checks a resource parameter against RFC 8707 section 2, it
+// has to be an absolute uri without a fragment.
+func ValidateResource(resource string) OauthError {
+
+	parsed, error := url.Parse(resource)
+
+	if error != nil || !parsed.IsAbs() {
+
+		return &InvalidTargetError{resource, "must be an absolute uri"}
+	}
+
+	if parsed.Fragment != "" || parsed.RawFragment != "" {
+
+		return &InvalidTargetError{resource, "must not have a fragment"}
+	}
+
+	return nil
+}
+
+// HasAudience reports whether the access token of the session is meant for
+// the resource.
+func (session *Session) HasAudience(resource string) bool {
+
+	return containsString(session.Audience, resource)
+}
+
+// resolveResources sets the audience of the access token from the resource
+// parameters of the request. A new session is granted the resources the
+// client may use, they bound what its refresh token can ask for later. A
+// refresh may narrow the audience down to some of those resources, without
+// resource parameters the access token is meant for all of them again. A
+// session granted without resources is not restricted, its refreshes may
+// ask for any resource the client may use.
+func (server *DefaultServer) resolveResources(oauthSessionRequest OauthSessionRequest, session *Session, isNew bool) OauthError {
+
+	resources := oauthSessionRequest.Get("resource")
+
+	for _, resource := range resources {
+
+		if error := ValidateResource(resource); error != nil {
+
+			return error
+		}
+
+		if !isNew && len(session.Resources) > 0 {
+
+			if !containsString(session.Resources, resource) {
+
+				return &InvalidTargetError{resource, "was not granted to the session"}
+			}
+		} else if session.Client != nil && !session.Client.AllowsResource(resource) {
+
+			return &InvalidTargetError{resource, "is not allowed for the client"}
+		}
+	}
+
+	if isNew && len(resources) > 0 {
+
+		session.Resources = resources
+	}
+
+	if len(resources) == 0 {
+
+		resources = session.Resources
+	}
+
+	session.Audience = resources
+	return nil
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const (
+	billingApi = "https://api.example.com/billing"
+	hrApi      = "https://api.example.com/hr"
+)
+
+func TestValidateResource(t *testing.T) {
+
+	assert.Nil(t, ValidateResource(billingApi))
+	assert.Nil(t, ValidateResource("https://api.example.com/billing?region=eu"))
+	assert.Equal(t, &InvalidTargetError{"billing", "must be an absolute uri"}, ValidateResource("billing"))
+	assert.Nil(t, ValidateResource("urn:example:billing"))
+	assert.Equal(t, &InvalidTargetError{billingApi + "#invoices", "must not have a fragment"}, ValidateResource(billingApi+"#invoices"))
+	assert.Equal(t, InvalidTarget, ValidateResource("billing").OauthErrorCode())
+}
+
+func TestClientAllowsResource(t *testing.T) {
+
+	client := &Client{}
+	assert.True(t, client.AllowsResource(billingApi))
+
+	client.AllowedResources = []string{billingApi}
+	assert.True(t, client.AllowsResource(billingApi))
+	assert.False(t, client.AllowsResource(hrApi))
+}
+
+func TestServerGrantOauthSessionRestrictsTheAudience(t *testing.T) {
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test").AddAll("resource", []string{billingApi, hrApi})
+	server, grant, session := newMockGrantServer(oauthSessionRequest)
+	session.Client = &Client{Id: "client", AllowedResources: []string{billingApi, hrApi}}
+	server.AddGrant(grant)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, []string{billingApi, hrApi}, returnedSession.Resources)
+	assert.Equal(t, []string{billingApi, hrApi}, returnedSession.Audience)
+	assert.True(t, returnedSession.HasAudience(hrApi))
+	assert.False(t, returnedSession.HasAudience("https://api.example.com/payroll"))
+}
+
+func TestServerGrantOauthSessionWhereResourceIsNotAllowed(t *testing.T) {
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test").Set("resource", hrApi)
+	server, grant, session := newMockGrantServer(oauthSessionRequest)
+	session.Client = &Client{Id: "client", AllowedResources: []string{billingApi}}
+	server.AddGrant(grant)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, &InvalidTargetError{hrApi, "is not allowed for the client"}, error)
+
+	oauthSessionRequest.Set("resource", "billing")
+	returnedSession, error = server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, &InvalidTargetError{"billing", "must be an absolute uri"}, error)
+}
+
+func TestServerGrantOauthSessionRefreshNarrowsTheAudience(t *testing.T) {
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test").Set("resource", billingApi)
+	server, grant, session := newMockGrantServer(oauthSessionRequest)
+	session.Client = &Client{Id: "client"}
+	session.CreatedAt = time.Now()
+	session.Resources = []string{billingApi, hrApi}
+	session.Audience = []string{billingApi, hrApi}
+	server.AddGrant(grant)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, []string{billingApi}, returnedSession.Audience)
+	assert.Equal(t, []string{billingApi, hrApi}, returnedSession.Resources)
+
+	oauthSessionRequest.Delete("resource")
+	returnedSession, error = server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, []string{billingApi, hrApi}, returnedSession.Audience)
+
+	oauthSessionRequest.Set("resource", "https://api.example.com/payroll")
+	returnedSession, error = server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, &InvalidTargetError{"https://api.example.com/payroll", "was not granted to the session"}, error)
+}
+
+func TestServerGrantOauthSessionRefreshOfAnUnrestrictedSession(t *testing.T) {
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test").Set("resource", hrApi)
+	server, grant, session := newMockGrantServer(oauthSessionRequest)
+	session.Client = &Client{Id: "client", AllowedResources: []string{billingApi}}
+	session.CreatedAt = time.Now()
+	server.AddGrant(grant)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, &InvalidTargetError{hrApi, "is not allowed for the client"}, error)
+
+	oauthSessionRequest.Set("resource", billingApi)
+	returnedSession, error = server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Nil(t, returnedSession.Resources)
+	assert.Equal(t, []string{billingApi}, returnedSession.Audience)
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ScopeParameterValidatorFunc decides whether the session may be granted the
+// scope the parameters fill the template in to, like whether the owner may
+// see the account named in account:read:{account}.
+type ScopeParameterValidatorFunc func(session *Session, template *Scope, parameters map[string]string) error
+
+// ScopeParameterPatterns accepts the parameters of a template when each of
+// them matches the pattern given for it under the name of the template. Any
+// other template is refused.
+func ScopeParameterPatterns(patterns map[string]map[string]*regexp.Regexp) ScopeParameterValidatorFunc {
+
+	return func(session *Session, template *Scope, parameters map[string]string) error {
+
+		templatePatterns, ok := patterns[template.Name]
+
+		if !ok {
+
+			return fmt.Errorf("scope template %s has no parameter patterns", template.Name)
+		}
+
+		for name, value := range parameters {
+
+			if pattern, ok := templatePatterns[name]; !ok || !pattern.MatchString(value) {
+
+				return fmt.Errorf("%q is not a valid %s for scope template %s", value, name, template.Name)
+			}
+		}
+
+		return nil
+	}
+}
+
+// MatchScope reports whether the granted scope covers the required one.
+// Scopes are made of segments separated by colons. A * or {parameter} segment
+// matches any one segment, a * every remaining segment when it is the last
+// one, and a segment starting with a / matches that path and every path below
+// it. So repo:* covers repo:read and files:read:/docs covers
+// files:read:/docs/a.txt.
+func MatchScope(granted string, required string) bool {
+
+	if granted == required {
+
+		return true
+	}
+
+	grantedSegments := strings.Split(granted, ":")
+	requiredSegments := strings.Split(required, ":")
+
+	for index, segment := range grantedSegments {
+
+		if index >= len(requiredSegments) {
+
+			return false
+		}
+
+		switch {
+		case segment == "*" && index == len(grantedSegments)-1:
+			return true
+		case segment == "*" || isScopeParameter(segment):
+		case strings.HasPrefix(segment, "/"):
+			if !matchPath(segment, requiredSegments[index]) {
+
+				return false
+			}
+		case segment != requiredSegments[index]:
+			return false
+		}
+	}
+
+	return len(grantedSegments) == len(requiredSegments)
+}
+
+// MatchScopeTemplate returns the parameters the scope fills the template in
+// with, transaction:{id} gives an id of 1234 for transaction:1234. It reports
+// false when the scope does not fill in the template or the template has no
+// parameters. Values that would be patterns in a scope name, like * or a path
+// starting with /, do not fill in a parameter so a resolved scope never
+// grants more than the one value.
+func MatchScopeTemplate(template string, scope string) (map[string]string, bool) {
+
+	templateSegments := strings.Split(template, ":")
+	scopeSegments := strings.Split(scope, ":")
+
+	if len(templateSegments) != len(scopeSegments) {
+
+		return nil, false
+	}
+
+	parameters := make(map[string]string)
+
+	for index, segment := range templateSegments {
+
+		value := scopeSegments[index]
+
+		switch {
+		case isScopeParameter(segment):
+			//asking for the template itself does not fill it in
+			if value == "" || isScopeParameter(value) || strings.Contains(value, "*") || strings.HasPrefix(value, "/") {
+
+				return nil, false
+			}
+
+			parameters[segment[1:len(segment)-1]] = value
+		case segment != value:
+			return nil, false
+		}
+	}
+
+	return parameters, len(parameters) > 0
+}
+
+// Resolve returns the scope the parameters fill the template in to. They are
+// filled in in what it implies and its description as well.
+func (scope *Scope) Resolve(parameters map[string]string) *Scope {
+
+	replacements := make([]string, 0, len(parameters)*2)
+
+	for name, value := range parameters {
+
+		replacements = append(replacements, "{"+name+"}", value)
+	}
+
+	replacer := strings.NewReplacer(replacements...)
+	resolved := &Scope{
+		Id:          scope.Id,
+		Name:        replacer.Replace(scope.Name),
+		Description: replacer.Replace(scope.Description),
+		Sensitive:   scope.Sensitive,
+		Template:    scope.Name,
+		Parameters:  parameters,
+	}
+
+	for _, implied := range scope.Implies {
+
+		resolved.Implies = append(resolved.Implies, replacer.Replace(implied))
+	}
+
+	return resolved
+}
+
+func (scope *Scope) IsTemplate() bool {
+
+	for _, segment := range strings.Split(scope.Name, ":") {
+
+		if isScopeParameter(segment) {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func isScopeParameter(segment string) bool {
+
+	return len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// matchPath cleans the required path first so it can not climb out of the
+// granted one with dot dot segments.
+func matchPath(granted string, required string) bool {
+
+	required = path.Clean(required)
+	granted = path.Clean(granted)
+	return required == granted || strings.HasPrefix(required, strings.TrimSuffix(granted, "/")+"/")
+}
+
+// ScopeMatcher checks granted scopes against required ones taking the scopes
+// they imply and pattern scopes into account. The scopes granted scopes imply
+// are looked up in the storage, so when admin implies write and write implies
+// read a session granted admin satisfies read.
+type ScopeMatcher struct {
+	storage ScopeStorage
+}
+
+// Satisfies reports whether the granted scopes cover every required scope.
+func (matcher *ScopeMatcher) Satisfies(granted map[string]*Scope, required ...string) bool {
+
+	expanded := matcher.expand(granted)
+
+	for _, requiredScope := range required {
+
+		satisfied := false
+
+		for name := range expanded {
+
+			if MatchScope(name, requiredScope) {
+
+				satisfied = true
+				break
+			}
+		}
+
+		if !satisfied {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// FindScope finds the scope defining name. That is the scope of that name or,
+// when the storage can list its scopes, the first pattern scope matching it.
+func (matcher *ScopeMatcher) FindScope(name string) (*Scope, error) {
+
+	scope, error := matcher.storage.FindScopeByName(name)
+
+	if scope != nil {
+
+		return scope, nil
+	}
+
+	lister, ok := matcher.storage.(ScopeLister)
+
+	if !ok {
+
+		return nil, error
+	}
+
+	scopes, listError := lister.FindAllScopes()
+
+	if listError != nil {
+
+		return nil, error
+	}
+
+	for _, pattern := range scopes {
+
+		if MatchScope(pattern.Name, name) {
+
+			return pattern, nil
+		}
+	}
+
+	return nil, error
+}
+
+// ResolveScope finds the scope defining name like FindScope. When that is a
+// template name fills in, the validator has to accept the parameters for the
+// session and the concrete scope is returned. Templates are refused without a
+// validator.
+func (matcher *ScopeMatcher) ResolveScope(name string, session *Session, validator ScopeParameterValidatorFunc) (*Scope, error) {
+
+	scope, error := matcher.FindScope(name)
+
+	if scope == nil {
+
+		return nil, error
+	}
+
+	parameters, ok := MatchScopeTemplate(scope.Name, name)
+
+	if !ok && scope.IsTemplate() {
+
+		return nil, fmt.Errorf("scope %s is a template, its parameters have to be filled in", name)
+	}
+
+	if !ok {
+
+		return scope, nil
+	}
+
+	if validator == nil {
+
+		return nil, fmt.Errorf("scope %s has parameters but no validator accepts them", name)
+	}
+
+	if error := validator(session, scope, parameters); error != nil {
+
+		return nil, error
+	}
+
+	return scope.Resolve(parameters), nil
+}
+
+// expand returns the names of the granted scopes and every scope they imply.
+// The scopes on a session already know what they imply, only the scopes
+// those imply in turn are looked up.
+func (matcher *ScopeMatcher) expand(granted map[string]*Scope) map[string]bool {
+
+	expanded := make(map[string]bool, len(granted))
+	pending := []*Scope{}
+
+	for name, scope := range granted {
+
+		expanded[name] = true
+
+		if scope != nil {
+
+			pending = append(pending, scope)
+		}
+	}
+
+	for len(pending) > 0 {
+
+		scope := pending[0]
+		pending = pending[1:]
+
+		for _, name := range scope.Implies {
+
+			if expanded[name] {
+
+				continue
+			}
+
+			expanded[name] = true
+
+			if implied, _ := matcher.storage.FindScopeByName(name); implied != nil {
+
+				pending = append(pending, implied)
+			}
+		}
+	}
+
+	return expanded
+}
+
+func NewScopeMatcher(storage ScopeStorage) *ScopeMatcher {
+
+	return &ScopeMatcher{storage}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"regexp"
+	"testing"
+)
+
+func TestMatchScope(t *testing.T) {
+
+	for _, match := range [][2]string{
+		{"read", "read"},
+		{"repo:*", "repo:read"},
+		{"repo:*", "repo:read:issues"},
+		{"repo:*:read", "repo:goauth2:read"},
+		{"files:read:/docs", "files:read:/docs"},
+		{"files:read:/docs", "files:read:/docs/a.txt"},
+		{"files:read:/", "files:read:/docs"},
+	} {
+
+		assert.True(t, MatchScope(match[0], match[1]), "%s should cover %s", match[0], match[1])
+	}
+
+	for _, mismatch := range [][2]string{
+		{"read", "write"},
+		{"repo:*", "repo"},
+		{"repo:*", "issues:read"},
+		{"repo:*:read", "repo:goauth2:write"},
+		{"repo:read", "repo:read:issues"},
+		{"files:read:/docs", "files:read:/documents"},
+		{"files:read:/docs", "files:read:/docs/../etc"},
+		{"files:read:/docs", "files:write:/docs"},
+	} {
+
+		assert.False(t, MatchScope(mismatch[0], mismatch[1]), "%s should not cover %s", mismatch[0], mismatch[1])
+	}
+}
+
+func TestScopeMatcherSatisfies(t *testing.T) {
+
+	scopeStorage := &MockScopeStorage{}
+	matcher := NewScopeMatcher(scopeStorage)
+	scopeStorage.On("FindScopeByName", "write").Return(&Scope{Id: "write", Name: "write", Implies: []string{"read", "admin"}}, nil)
+	scopeStorage.On("FindScopeByName", "read").Return(&Scope{Id: "read", Name: "read"}, nil)
+	granted := map[string]*Scope{
+		"admin":  {Id: "admin", Name: "admin", Implies: []string{"write"}},
+		"repo:*": {Id: "repo", Name: "repo:*"},
+	}
+
+	assert.True(t, matcher.Satisfies(granted))
+	assert.True(t, matcher.Satisfies(granted, "admin", "write", "read"))
+	assert.True(t, matcher.Satisfies(granted, "repo:goauth2"))
+	assert.False(t, matcher.Satisfies(granted, "read", "delete"))
+	assert.False(t, matcher.Satisfies(map[string]*Scope{"read": {}}, "admin"))
+}
+
+func TestScopeMatcherFindScope(t *testing.T) {
+
+	scopeStorage := &MockScopeLister{}
+	matcher := NewScopeMatcher(scopeStorage)
+	pattern := &Scope{Id: "repo", Name: "repo:*"}
+	notFound := errors.New("not found")
+	scopeStorage.On("FindScopeByName", "read").Return(&Scope{Id: "read", Name: "read"}, nil)
+	scopeStorage.On("FindScopeByName", "repo:goauth2").Return(nil, notFound)
+	scopeStorage.On("FindScopeByName", "write").Return(nil, notFound)
+	scopeStorage.On("FindAllScopes").Return([]*Scope{{Id: "read", Name: "read"}, pattern}, nil)
+
+	scope, error := matcher.FindScope("read")
+	assert.Nil(t, error)
+	assert.Equal(t, &Scope{Id: "read", Name: "read"}, scope)
+
+	scope, error = matcher.FindScope("repo:goauth2")
+	assert.Nil(t, error)
+	assert.Equal(t, pattern, scope)
+
+	scope, error = matcher.FindScope("write")
+	assert.Nil(t, scope)
+	assert.Equal(t, notFound, error)
+
+	//storages that can not list their scopes only find scopes by name
+	plainStorage := &MockScopeStorage{}
+	plainStorage.On("FindScopeByName", "repo:goauth2").Return(nil, notFound)
+	scope, error = NewScopeMatcher(plainStorage).FindScope("repo:goauth2")
+	assert.Nil(t, scope)
+	assert.Equal(t, notFound, error)
+}
+
+func TestMatchScopeTemplate(t *testing.T) {
+
+	parameters, ok := MatchScopeTemplate("account:read:{account}", "account:read:ACME")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"account": "ACME"}, parameters)
+
+	parameters, ok = MatchScopeTemplate("transfer:{from}:{to}", "transfer:a:b")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"from": "a", "to": "b"}, parameters)
+
+	for _, mismatch := range [][2]string{
+		{"transaction:{id}", "transaction"},
+		{"transaction:{id}", "transaction:"},
+		{"transaction:{id}", "transaction:{id}"},
+		{"transaction:{id}", "transaction:1:2"},
+		{"transaction:{id}", "account:1"},
+		{"transaction:read", "transaction:read"},
+		{"transaction:{id}", "transaction:*"},
+		{"transaction:{id}", "transaction:12*"},
+		{"files:{path}", "files:/docs"},
+	} {
+
+		_, ok = MatchScopeTemplate(mismatch[0], mismatch[1])
+		assert.False(t, ok, "%s should not fill in %s", mismatch[1], mismatch[0])
+	}
+
+	assert.True(t, MatchScope("transaction:{id}", "transaction:1234"))
+}
+
+func TestScopeResolve(t *testing.T) {
+
+	template := &Scope{
+		Id:          "account",
+		Name:        "account:admin:{account}",
+		Description: "Manage account {account}",
+		Sensitive:   true,
+		Implies:     []string{"account:read:{account}", "profile"},
+	}
+
+	assert.True(t, template.IsTemplate())
+	assert.False(t, (&Scope{Name: "repo:*"}).IsTemplate())
+	assert.Equal(t, &Scope{
+		Id:          "account",
+		Name:        "account:admin:ACME",
+		Description: "Manage account ACME",
+		Sensitive:   true,
+		Implies:     []string{"account:read:ACME", "profile"},
+		Template:    "account:admin:{account}",
+		Parameters:  map[string]string{"account": "ACME"},
+	}, template.Resolve(map[string]string{"account": "ACME"}))
+}
+
+func TestScopeMatcherResolveScope(t *testing.T) {
+
+	scopeStorage := &MockScopeLister{}
+	matcher := NewScopeMatcher(scopeStorage)
+	template := &Scope{Id: "transaction", Name: "transaction:{id}"}
+	session := NewSession()
+	notFound := errors.New("not found")
+	scopeStorage.On("FindScopeByName", "read").Return(&Scope{Id: "read", Name: "read"}, nil)
+	scopeStorage.On("FindScopeByName", "transaction:{id}").Return(template, nil)
+	scopeStorage.On("FindScopeByName", mock.Anything).Return(nil, notFound)
+	scopeStorage.On("FindAllScopes").Return([]*Scope{template}, nil)
+	validator := func(validatedSession *Session, validatedTemplate *Scope, parameters map[string]string) error {
+
+		assert.Equal(t, session, validatedSession)
+		assert.Equal(t, template, validatedTemplate)
+
+		if parameters["id"] != "1234" {
+
+			return errors.New("not your transaction")
+		}
+
+		return nil
+	}
+
+	scope, error := matcher.ResolveScope("read", session, nil)
+	assert.Nil(t, error)
+	assert.Equal(t, &Scope{Id: "read", Name: "read"}, scope)
+
+	scope, error = matcher.ResolveScope("transaction:1234", session, validator)
+	assert.Nil(t, error)
+	assert.Equal(t, template.Resolve(map[string]string{"id": "1234"}), scope)
+
+	scope, error = matcher.ResolveScope("transaction:5678", session, validator)
+	assert.Nil(t, scope)
+	assert.Equal(t, errors.New("not your transaction"), error)
+
+	scope, error = matcher.ResolveScope("transaction:1234", session, nil)
+	assert.Nil(t, scope)
+	assert.EqualError(t, error, "scope transaction:1234 has parameters but no validator accepts them")
+
+	scope, error = matcher.ResolveScope("transaction:{id}", session, validator)
+	assert.Nil(t, scope)
+	assert.EqualError(t, error, "scope transaction:{id} is a template, its parameters have to be filled in")
+
+	scope, error = matcher.ResolveScope("write", session, validator)
+	assert.Nil(t, scope)
+	assert.Equal(t, notFound, error)
+}
+
+func TestScopeParameterPatterns(t *testing.T) {
+
+	validator := ScopeParameterPatterns(map[string]map[string]*regexp.Regexp{
+		"transaction:{id}": {"id": regexp.MustCompile(`^[0-9]+$`)},
+	})
+	template := &Scope{Name: "transaction:{id}"}
+
+	assert.Nil(t, validator(nil, template, map[string]string{"id": "1234"}))
+	assert.EqualError(t, validator(nil, template, map[string]string{"id": "abc"}), `"abc" is not a valid id for scope template transaction:{id}`)
+	assert.EqualError(t, validator(nil, template, map[string]string{"other": "1"}), `"1" is not a valid other for scope template transaction:{id}`)
+	assert.EqualError(t, validator(nil, &Scope{Name: "account:{id}"}, map[string]string{"id": "1"}), "scope template account:{id} has no parameter patterns")
+}
@@ -1,5 +1,12 @@
 package server
 
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
 type Server interface {
 	GetGrant(name string) (Grant, bool)
 	TokenGenerator() TokenGenerator
@@ -8,6 +15,7 @@ type Server interface {
 	SessionStorage() SessionStorage
 	ScopeStorage() ScopeStorage
 	Config() *Config
+	Clock() Clock
 	GrantOauthSession(oauthSessionRequest OauthSessionRequest) (*Session, OauthError)
 }
 
@@ -19,6 +27,13 @@ type DefaultServer struct {
 	ownerStorage   OwnerStorage
 	sessionStorage SessionStorage
 	scopeStorage   ScopeStorage
+	clock          Clock
+	events         *EventDispatcher
+	metrics        Metrics
+	tracer         Tracer
+	limiter        Limiter
+	sessionLimiter SessionLimiter
+	detailHandlers map[string]AuthorizationDetailHandler
 }
 
 func (server *DefaultServer) AddGrant(grant Grant) *DefaultServer {
@@ -43,6 +58,14 @@ func (server *DefaultServer) TokenGenerator() TokenGenerator {
 	return server.tokenGenerator
 }
 
+// SetTokenGenerator replaces the generator, like with one wrapping the current
+// generator to issue tokens in another format.
+func (server *DefaultServer) SetTokenGenerator(tokenGenerator TokenGenerator) *DefaultServer {
+
+	server.tokenGenerator = tokenGenerator
+	return server
+}
+
 func (server *DefaultServer) ClientStorage() ClientStorage {
 
 	return server.clientStorage
@@ -68,8 +91,157 @@ func (server *DefaultServer) Config() *Config {
 	return server.config
 }
 
+func (server *DefaultServer) Clock() Clock {
+
+	return server.clock
+}
+
+// SetClock replaces the clock the server and its grants read the time from.
+// Token generators and storages take their own clock so pass the same one to
+// them when travelling in time.
+func (server *DefaultServer) SetClock(clock Clock) *DefaultServer {
+
+	server.clock = clock
+	return server
+}
+
+// Events is where listeners for the server's lifecycle events are added.
+func (server *DefaultServer) Events() *EventDispatcher {
+
+	return server.events
+}
+
+func (server *DefaultServer) Metrics() Metrics {
+
+	return server.metrics
+}
+
+// SetMetrics replaces where the server reports token requests, revocations
+// and refresh token reuse. Wrap the storages with the Instrumented storages to
+// measure them as well.
+func (server *DefaultServer) SetMetrics(metrics Metrics) *DefaultServer {
+
+	server.metrics = metrics
+	return server
+}
+
+func (server *DefaultServer) Tracer() Tracer {
+
+	return server.tracer
+}
+
+// SetTracer turns on spans around granting sessions, the grants, client
+// authentication, token generation and storage calls. Nil turns them off.
+func (server *DefaultServer) SetTracer(tracer Tracer) *DefaultServer {
+
+	server.tracer = tracer
+	return server
+}
+
+func (server *DefaultServer) Limiter() Limiter {
+
+	return server.limiter
+}
+
+// SetLimiter limits client authentication by client id and password grants
+// by username, both also by source address when the request knows it. Nil
+// turns limiting off.
+func (server *DefaultServer) SetLimiter(limiter Limiter) *DefaultServer {
+
+	server.limiter = limiter
+	return server
+}
+
+func (server *DefaultServer) SessionLimiter() SessionLimiter {
+
+	return server.sessionLimiter
+}
+
+// SetSessionLimiter caps how many sessions an owner can have at once. It is
+// only asked about new sessions, refreshing a session does not count against
+// the limit. Nil turns the limit off.
+func (server *DefaultServer) SetSessionLimiter(sessionLimiter SessionLimiter) *DefaultServer {
+
+	server.sessionLimiter = sessionLimiter
+	return server
+}
+
+// AddAuthorizationDetailHandler has the handler approve the authorization
+// details of its type. Requests with details of a type no handler was added
+// for are refused.
+func (server *DefaultServer) AddAuthorizationDetailHandler(handler AuthorizationDetailHandler) *DefaultServer {
+
+	server.detailHandlers[handler.Type()] = handler
+	return server
+}
+
+func (server *DefaultServer) AuthorizationDetailTypes() []string {
+
+	types := make([]string, 0, len(server.detailHandlers))
+
+	for detailType := range server.detailHandlers {
+
+		types = append(types, detailType)
+	}
+
+	sort.Strings(types)
+	return types
+}
+
 func (server *DefaultServer) GrantOauthSession(oauthSessionRequest OauthSessionRequest) (*Session, OauthError) {
 
+	start := time.Now()
+	var scopedServer Server = server
+	var span Span = &noopSpan{}
+
+	if server.tracer != nil {
+
+		var ctx context.Context
+		ctx, span = server.tracer.Start(requestContext(oauthSessionRequest), "GrantOauthSession")
+		scopedServer = &tracedServer{server, server.tracer, ctx}
+	}
+
+	span.SetAttribute("oauth.grant", oauthSessionRequest.Grant())
+	session, error := server.grantOauthSession(oauthSessionRequest, scopedServer)
+
+	if session != nil && session.Client != nil {
+
+		span.SetAttribute("oauth.client_id", session.Client.Id)
+	}
+
+	endSpan(span, error)
+	server.metrics.TokenRequest(server.grantLabel(oauthSessionRequest.Grant()), tokenRequestOutcome(error), time.Since(start))
+
+	if isRefreshTokenReuse(error) {
+
+		server.metrics.RefreshTokenReuse()
+	}
+
+	if error != nil {
+
+		dispatchEvent(server, &Event{Type: GrantFailed, Request: oauthSessionRequest, Error: error})
+		return nil, error
+	}
+
+	return session, nil
+}
+
+// grantLabel is the grant the metrics are recorded under, grants the server
+// does not have are all recorded as unknown so clients can not make up labels.
+func (server *DefaultServer) grantLabel(grantName string) string {
+
+	if _, ok := server.GetGrant(grantName); !ok {
+
+		return "unknown"
+	}
+
+	return grantName
+}
+
+// grantOauthSession does the work for GrantOauthSession, scopedServer is the
+// server itself or the traced server for the current request.
+func (server *DefaultServer) grantOauthSession(oauthSessionRequest OauthSessionRequest, scopedServer Server) (*Session, OauthError) {
+
 	grant, ok := server.GetGrant(oauthSessionRequest.Grant())
 
 	if !ok {
@@ -77,7 +249,10 @@ func (server *DefaultServer) GrantOauthSession(oauthSessionRequest OauthSessionR
 		return nil, &GrantNotFoundError{oauthSessionRequest.Grant()}
 	}
 
-	session, error := grant.GenerateSession(oauthSessionRequest, server)
+	grantServer, span := startSpan(scopedServer, "Grant.GenerateSession")
+	span.SetAttribute("oauth.grant", grant.Name())
+	session, error := grant.GenerateSession(oauthSessionRequest, grantServer)
+	endSpan(span, error)
 
 	if session == nil {
 
@@ -92,37 +267,122 @@ func (server *DefaultServer) GrantOauthSession(oauthSessionRequest OauthSessionR
 		return nil, returnedError
 	}
 
-	if session.AccessToken == nil {
+	if session.Client != nil && !session.Client.AllowsGrant(grant.Name()) {
 
-		session.AccessToken = server.tokenGenerator.GenerateAccessToken(server.Config(), grant)
+		return nil, &UnauthorizedClientError{session.Client.Id, grant.Name()}
 	}
 
-	if server.config.AllowRefresh && grant.ShouldGenerateRefreshToken(session) {
-
-		session.RefreshToken = server.tokenGenerator.GenerateRefreshToken(server.Config(), grant)
-	}
+	//scopes are resolved before the tokens so the lifetime policy can see them
+	scopeMatcher := NewScopeMatcher(scopedServer.ScopeStorage())
 
 	for _, scopeName := range oauthSessionRequest.Get("scopes") {
 
-		scope, error := server.ScopeStorage().FindScopeByName(scopeName)
+		scope, error := scopeMatcher.ResolveScope(scopeName, session, server.config.ScopeParameterValidator)
 
 		if scope == nil {
 			return nil, &InvalidScopeError{scopeName, error}
 		}
 
+		if session.Client != nil && !session.Client.AllowsScope(scope) {
+
+			return nil, &InvalidScopeError{scopeName, fmt.Errorf("client %s may not be granted it", session.Client.Id)}
+		}
+
 		session.Scopes[scopeName] = scope
 	}
 
+	isNew := session.CreatedAt.IsZero()
+
+	if isNew {
+
+		session.CreatedAt = server.clock.Now()
+		session.Issuer = server.config.Issuer
+	}
+
+	//listeners and storages all need the id, the session is saved in the background
+	if session.Id == "" {
+
+		session.Id = GenerateTokenId()
+	}
+
+	if error := server.resolveResources(oauthSessionRequest, session, isNew); error != nil {
+
+		return nil, error
+	}
+
+	if error := server.bindDPoPKey(oauthSessionRequest, session, isNew); error != nil {
+
+		return nil, error
+	}
+
+	if error := server.approveAuthorizationDetails(oauthSessionRequest, session, isNew); error != nil {
+
+		return nil, error
+	}
+
+	issueEvent := &Event{Type: BeforeSessionIssue, Request: oauthSessionRequest, Client: session.Client, Session: session}
+
+	if error := dispatchEvent(server, issueEvent); error != nil {
+
+		return nil, error
+	}
+
+	var evicted []*Session
+
+	if isNew && server.sessionLimiter != nil {
+
+		var oauthError OauthError
+
+		if evicted, oauthError = server.sessionLimiter.Admit(grant, session); oauthError != nil {
+
+			return nil, oauthError
+		}
+	}
+
+	_, span = startSpan(scopedServer, "TokenGenerator.GenerateTokens")
+
+	if session.AccessToken == nil {
+
+		session.AccessToken = server.tokenGenerator.GenerateAccessToken(server.Config(), grant, session)
+	}
+
+	if server.config.AllowRefresh && grant.ShouldGenerateRefreshToken(session) {
+
+		session.RefreshToken = server.tokenGenerator.GenerateRefreshToken(server.Config(), grant, session)
+	}
+
+	span.End()
+
 	if v, ok := grant.(PostProcessingGrant); ok {
 
 		v.ProcessSession(session)
 	}
 
-	go server.sessionStorage.SaveSession(session)
+	dispatchEvent(server, &Event{Type: AfterTokenIssue, Request: oauthSessionRequest, Client: session.Client, Session: session})
+
+	if _, ok := grant.(*RefreshTokenGrant); ok {
+
+		dispatchEvent(server, &Event{Type: SessionRefreshed, Request: oauthSessionRequest, Client: session.Client, Session: session})
+	}
+
+	for _, evictedSession := range evicted {
+
+		server.RevokeSession(evictedSession)
+	}
+
+	go scopedServer.SessionStorage().SaveSession(session)
 
 	return session, nil
 }
 
+// RevokeSession deletes the session so neither of its tokens can be used again.
+func (server *DefaultServer) RevokeSession(session *Session) {
+
+	server.sessionStorage.DeleteSession(session)
+	server.metrics.Revocation()
+	dispatchEvent(server, &Event{Type: SessionRevoked, Client: session.Client, Session: session})
+}
+
 func New(clientStorage ClientStorage, ownerStorage OwnerStorage, sessionStorage SessionStorage, scopeStorage ScopeStorage) *DefaultServer {
 
 	return NewWithConfigAndTokenGenerator(
@@ -170,5 +430,12 @@ func NewWithConfigAndTokenGenerator(
 		ownerStorage,
 		sessionStorage,
 		scopeStorage,
+		NewSystemClock(),
+		NewEventDispatcher(),
+		NewNoopMetrics(),
+		nil,
+		nil,
+		nil,
+		make(map[string]AuthorizationDetailHandler),
 	}
 }
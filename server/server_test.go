@@ -38,6 +38,10 @@ func TestServerGettersAndGrantManagement(t *testing.T) {
 	assert.Equal(t, sessionStorage, server.SessionStorage())
 	assert.Equal(t, config, server.Config())
 	assert.Equal(t, tokenGenerator, server.TokenGenerator())
+	assert.Equal(t, NewSystemClock(), server.Clock())
+	clock := NewFakeClock(testNow)
+	assert.Equal(t, server, server.SetClock(clock))
+	assert.Equal(t, clock, server.Clock())
 }
 
 func TestServerGrantOauthSessionWhereGrantNotFound(t *testing.T) {
@@ -195,13 +199,18 @@ func TestServerGrantOauthSessionWhereGrantReturnsASessionWithoutAnAccessToken(t
 	grant.On("Name").Return("test")
 	grant.On("GenerateSession", oauthSessionRequest, server).Return(session, nil)
 	server.AddGrant(grant)
-	tokenGenerator.On("GenerateAccessToken", server.Config(), grant).Return(token)
+	tokenGenerator.On("GenerateAccessToken", server.Config(), grant, session).Return(token)
 	sessionStorage.On("SaveSession", session).Return()
 
+	server.SetClock(NewFakeClock(testNow))
+	server.Config().Issuer = "https://acme.example.com"
+
 	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
 
 	assert.Equal(t, session, returnedSession)
 	assert.Equal(t, token, returnedSession.AccessToken)
+	assert.Equal(t, testNow, returnedSession.CreatedAt)
+	assert.Equal(t, "https://acme.example.com", returnedSession.Issuer)
 	assert.Nil(t, error)
 }
 
@@ -230,7 +239,7 @@ func TestServerGrantOauthSessionWhereGrantReturnsASessionWithoutAnAccessTokenAnd
 	grant.On("GenerateSession", oauthSessionRequest, server).Return(session, nil)
 	grant.On("ShouldGenerateRefreshToken", session).Return(false)
 	server.AddGrant(grant)
-	tokenGenerator.On("GenerateAccessToken", server.Config(), grant).Return(accessToken)
+	tokenGenerator.On("GenerateAccessToken", server.Config(), grant, session).Return(accessToken)
 	sessionStorage.On("SaveSession", session).Return()
 
 	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
@@ -266,8 +275,8 @@ func TestServerGrantOauthSessionWhereGrantReturnsASessionWithoutAnAccessTokenAnd
 	grant.On("GenerateSession", oauthSessionRequest, server).Return(session, nil)
 	grant.On("ShouldGenerateRefreshToken", session).Return(true)
 	server.AddGrant(grant)
-	tokenGenerator.On("GenerateAccessToken", server.Config(), grant).Return(accessToken)
-	tokenGenerator.On("GenerateRefreshToken", server.Config(), grant).Return(refreshToken)
+	tokenGenerator.On("GenerateAccessToken", server.Config(), grant, session).Return(accessToken)
+	tokenGenerator.On("GenerateRefreshToken", server.Config(), grant, session).Return(refreshToken)
 	sessionStorage.On("SaveSession", session).Return()
 
 	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
@@ -305,8 +314,8 @@ func TestServerGrantOauthSessionWhereGrantReturnsASessionWithoutAnAccessTokenAnd
 	grant.On("ShouldGenerateRefreshToken", session).Return(true)
 	grant.On("ProcessSession", session).Return()
 	server.AddGrant(grant)
-	tokenGenerator.On("GenerateAccessToken", server.Config(), grant).Return(accessToken)
-	tokenGenerator.On("GenerateRefreshToken", server.Config(), grant).Return(refreshToken)
+	tokenGenerator.On("GenerateAccessToken", server.Config(), grant, session).Return(accessToken)
+	tokenGenerator.On("GenerateRefreshToken", server.Config(), grant, session).Return(refreshToken)
 	sessionStorage.On("SaveSession", session).Return()
 
 	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
@@ -349,6 +358,36 @@ func TestServerGrantOauthSessionWhereScopeIsInvalid(t *testing.T) {
 	assert.Nil(t, returnedSession)
 }
 
+func TestServerGrantOauthSessionWhereScopeIsNotAllowedForTheClient(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	scopeStorage := &MockScopeStorage{}
+
+	server := NewWithTokenGenerator(
+		&MockTokenGenerator{},
+		ownerClientStorage,
+		ownerClientStorage,
+		&MockSessionStorage{},
+		scopeStorage,
+	)
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test")
+	oauthSessionRequest.AddAll("scopes", []string{"read", "admin"})
+	session := NewSession()
+	session.Client = &Client{Id: "client", AllowedScopes: []string{"read"}}
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	grant.On("GenerateSession", oauthSessionRequest, server).Return(session, nil)
+	scopeStorage.On("FindScopeByName", "read").Return(&Scope{Id: "id", Name: "read"}, nil)
+	scopeStorage.On("FindScopeByName", "admin").Return(&Scope{Id: "id", Name: "admin"}, nil)
+	server.AddGrant(grant)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Equal(t, &InvalidScopeError{"admin", errors.New("client client may not be granted it")}, error)
+	assert.Nil(t, returnedSession)
+}
+
 func TestServerGrantOauthSessionWhereScopeAllValid(t *testing.T) {
 
 	ownerClientStorage := &MockOwnerClientStorage{}
@@ -364,9 +403,9 @@ func TestServerGrantOauthSessionWhereScopeAllValid(t *testing.T) {
 		scopeStorage,
 	)
 
-	scope1 := &Scope{"id", "scope1"}
-	scope2 := &Scope{"id", "scope2"}
-	scope3 := &Scope{"id", "scope3"}
+	scope1 := &Scope{Id: "id", Name: "scope1"}
+	scope2 := &Scope{Id: "id", Name: "scope2"}
+	scope3 := &Scope{Id: "id", Name: "scope3"}
 
 	oauthSessionRequest := NewBasicOauthSessionRequest("test")
 	oauthSessionRequest.AddAll("scopes", []string{"scope1", "scope2", "scope3"})
@@ -390,3 +429,232 @@ func TestServerGrantOauthSessionWhereScopeAllValid(t *testing.T) {
 	assert.Equal(t, scopes, returnedSession.Scopes)
 	assert.Nil(t, error)
 }
+
+func TestServerGrantOauthSessionResolvesScopeTemplates(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	sessionStorage := &MockSessionStorage{}
+	tokenGenerator := &MockTokenGenerator{}
+	scopeStorage := &MockScopeLister{}
+
+	server := NewWithTokenGenerator(
+		tokenGenerator,
+		ownerClientStorage,
+		ownerClientStorage,
+		sessionStorage,
+		scopeStorage,
+	)
+	server.Config().ScopeParameterValidator = func(session *Session, template *Scope, parameters map[string]string) error {
+
+		return nil
+	}
+
+	template := &Scope{Id: "transaction", Name: "transaction:{id}"}
+	oauthSessionRequest := NewBasicOauthSessionRequest("test")
+	oauthSessionRequest.AddAll("scopes", []string{"transaction:1234"})
+	session := NewSession()
+	session.AccessToken = &Token{}
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	grant.On("GenerateSession", oauthSessionRequest, server).Return(session, nil)
+	scopeStorage.On("FindScopeByName", "transaction:1234").Return(nil, errors.New("not found"))
+	scopeStorage.On("FindAllScopes").Return([]*Scope{template}, nil)
+	server.AddGrant(grant)
+	sessionStorage.On("SaveSession", session).Return()
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, map[string]*Scope{
+		"transaction:1234": {
+			Id:         "transaction",
+			Name:       "transaction:1234",
+			Template:   "transaction:{id}",
+			Parameters: map[string]string{"id": "1234"},
+		},
+	}, returnedSession.Scopes)
+}
+
+func TestServerGrantOauthSessionWhereClientIsNotAllowedToUseGrant(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	sessionStorage := &MockSessionStorage{}
+	tokenGenerator := &MockTokenGenerator{}
+	scopeStorage := &MockScopeStorage{}
+
+	server := NewWithTokenGenerator(
+		tokenGenerator,
+		ownerClientStorage,
+		ownerClientStorage,
+		sessionStorage,
+		scopeStorage,
+	)
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test")
+	session := NewSession()
+	session.Client = &Client{Id: "client", AllowedGrants: []string{"client_credentials"}}
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	grant.On("GenerateSession", oauthSessionRequest, server).Return(session, nil)
+	server.AddGrant(grant)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, &UnauthorizedClientError{"client", "test"}, error)
+}
+
+func TestServerGrantOauthSessionDispatchesEvents(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	sessionStorage := &MockSessionStorage{}
+	tokenGenerator := &MockTokenGenerator{}
+	scopeStorage := &MockScopeStorage{}
+
+	server := NewWithTokenGenerator(
+		tokenGenerator,
+		ownerClientStorage,
+		ownerClientStorage,
+		sessionStorage,
+		scopeStorage,
+	)
+	server.SetClock(NewFakeClock(testNow))
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test")
+	session := NewSession()
+	session.Client = &Client{Id: "client"}
+	token := &Token{}
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	grant.On("GenerateSession", oauthSessionRequest, server).Return(session, nil)
+	server.AddGrant(grant)
+	tokenGenerator.On("GenerateAccessToken", server.Config(), grant, session).Return(token)
+	sessionStorage.On("SaveSession", session).Return()
+
+	eventTypes := []EventType{}
+	recorder := ListenerFunc(func(event *Event) error {
+
+		assert.Equal(t, testNow, event.Time)
+		assert.Equal(t, oauthSessionRequest, event.Request)
+		assert.Equal(t, session.Client, event.Client)
+		assert.Equal(t, session, event.Session)
+		eventTypes = append(eventTypes, event.Type)
+		return nil
+	})
+	server.Events().
+		AddListener(BeforeSessionIssue, recorder).
+		AddListener(AfterTokenIssue, recorder).
+		AddListener(SessionRefreshed, recorder).
+		AddListener(BeforeSessionIssue, ListenerFunc(func(event *Event) error {
+
+			event.Session.Owner = &Owner{Id: "owner"}
+			return nil
+		}))
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, &Owner{Id: "owner"}, returnedSession.Owner)
+	assert.Equal(t, []EventType{BeforeSessionIssue, AfterTokenIssue}, eventTypes)
+}
+
+func TestServerGrantOauthSessionGivesNewSessionsAnId(t *testing.T) {
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test")
+	server, grant, session := newMockGrantServer(oauthSessionRequest)
+	server.AddGrant(grant)
+	issuedIds := []string{}
+	server.Events().AddListener(BeforeSessionIssue, ListenerFunc(func(event *Event) error {
+
+		issuedIds = append(issuedIds, event.Session.Id)
+		return nil
+	}))
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.NotEmpty(t, returnedSession.Id)
+	assert.Equal(t, []string{returnedSession.Id}, issuedIds)
+
+	//refreshed sessions keep their id
+	session.Id = "session"
+	session.CreatedAt = testNow
+	returnedSession, error = server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, "session", returnedSession.Id)
+}
+
+func TestServerGrantOauthSessionWhereSessionIssueIsVetoed(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	sessionStorage := &MockSessionStorage{}
+	tokenGenerator := &MockTokenGenerator{}
+	scopeStorage := &MockScopeStorage{}
+
+	server := NewWithTokenGenerator(
+		tokenGenerator,
+		ownerClientStorage,
+		ownerClientStorage,
+		sessionStorage,
+		scopeStorage,
+	)
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test")
+	session := NewSession()
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	grant.On("GenerateSession", oauthSessionRequest, server).Return(session, nil)
+	server.AddGrant(grant)
+
+	var failure *Event
+	server.Events().
+		AddListener(BeforeSessionIssue, ListenerFunc(func(event *Event) error {
+
+			return errors.New("not today")
+		})).
+		AddListener(GrantFailed, ListenerFunc(func(event *Event) error {
+
+			failure = event
+			return errors.New("ignored")
+		}))
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, &VetoedError{BeforeSessionIssue, errors.New("not today")}, error)
+	assert.Equal(t, GrantFailed, failure.Type)
+	assert.Equal(t, error, failure.Error)
+	tokenGenerator.AssertNotCalled(t, "GenerateAccessToken", server.Config(), grant, session)
+}
+
+func TestServerRevokeSession(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	sessionStorage := &MockSessionStorage{}
+	scopeStorage := &MockScopeStorage{}
+
+	server := New(
+		ownerClientStorage,
+		ownerClientStorage,
+		sessionStorage,
+		scopeStorage,
+	)
+
+	session := NewSession()
+	session.Client = &Client{Id: "client"}
+	sessionStorage.On("DeleteSession", session).Return().Once()
+	revoked := make(chan *Event, 1)
+	server.Events().AddAsyncListener(SessionRevoked, ListenerFunc(func(event *Event) error {
+
+		revoked <- event
+		return nil
+	}))
+
+	server.RevokeSession(session)
+
+	event := <-revoked
+	assert.Equal(t, session, event.Session)
+	assert.Equal(t, session.Client, event.Client)
+	sessionStorage.AssertExpectations(t)
+}
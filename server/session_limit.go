@@ -0,0 +1,84 @@
+package server
+
+// SessionLimitAction is what happens to a new session when its owner already
+// has as many active sessions as allowed.
+type SessionLimitAction int
+
+const (
+	RejectNewSession   SessionLimitAction = iota
+	EvictOldestSession SessionLimitAction = iota
+)
+
+// SessionLimiter decides whether a new session may be issued. It returns the
+// sessions that have to be revoked to make room for it, or an error when the
+// session must not be issued at all.
+type SessionLimiter interface {
+	Admit(grant Grant, session *Session) ([]*Session, OauthError)
+}
+
+// DefaultSessionLimiter allows MaxSessions active sessions per owner, or per
+// owner and client when PerClient is set. Only new sessions from the
+// CheckedGrants are checked, those of every grant when it is empty. The count
+// and the evicted sessions still cover all of the owner's active sessions,
+// whichever grant issued them.
+//
+// Sessions are saved after they are issued, so logins racing each other can
+// briefly go over the limit.
+type DefaultSessionLimiter struct {
+	sessionQuery  SessionQuery
+	MaxSessions   int
+	PerClient     bool
+	Action        SessionLimitAction
+	CheckedGrants []string
+}
+
+func (limiter *DefaultSessionLimiter) Admit(grant Grant, session *Session) ([]*Session, OauthError) {
+
+	if session.Owner == nil || limiter.MaxSessions <= 0 {
+
+		return nil, nil
+	}
+
+	if len(limiter.CheckedGrants) > 0 && !containsString(limiter.CheckedGrants, grant.Name()) {
+
+		return nil, nil
+	}
+
+	filter := &SessionFilter{OwnerId: session.Owner.Id}
+
+	if limiter.PerClient && session.Client != nil {
+
+		filter.ClientId = session.Client.Id
+	}
+
+	count, error := limiter.sessionQuery.CountSessions(filter)
+
+	if error != nil {
+
+		return nil, &UnexpectedError{error}
+	}
+
+	if count < limiter.MaxSessions {
+
+		return nil, nil
+	}
+
+	if limiter.Action != EvictOldestSession {
+
+		return nil, &SessionLimitReachedError{session.Owner.Id, limiter.MaxSessions}
+	}
+
+	page, error := limiter.sessionQuery.QuerySessions(filter, nil, count-limiter.MaxSessions+1)
+
+	if error != nil {
+
+		return nil, &UnexpectedError{error}
+	}
+
+	return page.Sessions, nil
+}
+
+func NewSessionLimiter(sessionQuery SessionQuery, maxSessions int) *DefaultSessionLimiter {
+
+	return &DefaultSessionLimiter{sessionQuery, maxSessions, false, RejectNewSession, nil}
+}
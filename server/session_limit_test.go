@@ -0,0 +1,174 @@
+package server
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func TestDefaultSessionLimiterAdmit(t *testing.T) {
+
+	sessionQuery := &MockSessionQuery{}
+	limiter := NewSessionLimiter(sessionQuery, 3)
+	grant := &PasswordGrant{}
+	session := NewSession()
+	session.Owner = &Owner{Id: "owner"}
+	session.Client = &Client{Id: "client"}
+	filter := &SessionFilter{OwnerId: "owner"}
+
+	sessionQuery.On("CountSessions", filter).Return(2, nil).Once()
+	evicted, error := limiter.Admit(grant, session)
+	assert.Nil(t, evicted)
+	assert.Nil(t, error)
+
+	sessionQuery.On("CountSessions", filter).Return(3, nil).Once()
+	evicted, error = limiter.Admit(grant, session)
+	assert.Nil(t, evicted)
+	assert.Equal(t, &SessionLimitReachedError{"owner", 3}, error)
+
+	sessionQuery.On("CountSessions", filter).Return(0, errors.New("down")).Once()
+	evicted, error = limiter.Admit(grant, session)
+	assert.Nil(t, evicted)
+	assert.Equal(t, &UnexpectedError{errors.New("down")}, error)
+
+	//more sessions than allowed are left over when the limit was lowered
+	oldest := []*Session{{Id: "a"}, {Id: "b"}}
+	limiter.Action = EvictOldestSession
+	sessionQuery.On("CountSessions", filter).Return(4, nil).Once()
+	sessionQuery.On("QuerySessions", filter, (*SessionCursor)(nil), 2).Return(&SessionPage{oldest, nil}, nil).Once()
+	evicted, error = limiter.Admit(grant, session)
+	assert.Equal(t, oldest, evicted)
+	assert.Nil(t, error)
+
+	limiter.PerClient = true
+	sessionQuery.On("CountSessions", &SessionFilter{OwnerId: "owner", ClientId: "client"}).Return(1, nil).Once()
+	evicted, error = limiter.Admit(grant, session)
+	assert.Nil(t, evicted)
+	assert.Nil(t, error)
+
+	limiter.CheckedGrants = []string{"refresh_token"}
+	evicted, error = limiter.Admit(grant, session)
+	assert.Nil(t, evicted)
+	assert.Nil(t, error)
+
+	limiter.CheckedGrants = nil
+	evicted, error = limiter.Admit(grant, NewSession())
+	assert.Nil(t, evicted)
+	assert.Nil(t, error)
+
+	sessionQuery.AssertExpectations(t)
+}
+
+func TestServerGrantOauthSessionWhereSessionLimitIsReached(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	sessionStorage := &MockSessionStorage{}
+	tokenGenerator := &MockTokenGenerator{}
+	scopeStorage := &MockScopeStorage{}
+	sessionQuery := &MockSessionQuery{}
+
+	server := NewWithTokenGenerator(
+		tokenGenerator,
+		ownerClientStorage,
+		ownerClientStorage,
+		sessionStorage,
+		scopeStorage,
+	)
+	server.SetSessionLimiter(NewSessionLimiter(sessionQuery, 1))
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test")
+	session := NewSession()
+	session.Owner = &Owner{Id: "owner"}
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	grant.On("GenerateSession", oauthSessionRequest, server).Return(session, nil)
+	server.AddGrant(grant)
+	sessionQuery.On("CountSessions", &SessionFilter{OwnerId: "owner"}).Return(1, nil)
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, returnedSession)
+	assert.Equal(t, &SessionLimitReachedError{"owner", 1}, error)
+	tokenGenerator.AssertNotCalled(t, "GenerateAccessToken", server.Config(), grant, session)
+}
+
+func TestServerGrantOauthSessionEvictsTheOldestSession(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	sessionStorage := &MockSessionStorage{}
+	tokenGenerator := &MockTokenGenerator{}
+	scopeStorage := &MockScopeStorage{}
+	sessionQuery := &MockSessionQuery{}
+
+	server := NewWithTokenGenerator(
+		tokenGenerator,
+		ownerClientStorage,
+		ownerClientStorage,
+		sessionStorage,
+		scopeStorage,
+	)
+	limiter := NewSessionLimiter(sessionQuery, 1)
+	limiter.Action = EvictOldestSession
+	server.SetSessionLimiter(limiter)
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test")
+	session := NewSession()
+	session.Owner = &Owner{Id: "owner"}
+	oldest := &Session{Id: "oldest"}
+	token := &Token{}
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	grant.On("GenerateSession", oauthSessionRequest, server).Return(session, nil)
+	server.AddGrant(grant)
+	tokenGenerator.On("GenerateAccessToken", server.Config(), grant, session).Return(token)
+	filter := &SessionFilter{OwnerId: "owner"}
+	sessionQuery.On("CountSessions", filter).Return(1, nil)
+	sessionQuery.On("QuerySessions", filter, (*SessionCursor)(nil), 1).Return(&SessionPage{[]*Session{oldest}, nil}, nil)
+	sessionStorage.On("DeleteSession", oldest).Return().Once()
+	saved := make(chan *Session, 1)
+	sessionStorage.On("SaveSession", session).Run(func(args mock.Arguments) { saved <- session }).Return()
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, session, returnedSession)
+	assert.Equal(t, session, <-saved)
+	sessionStorage.AssertExpectations(t)
+}
+
+func TestServerGrantOauthSessionDoesNotLimitRefreshedSessions(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	sessionStorage := &MockSessionStorage{}
+	tokenGenerator := &MockTokenGenerator{}
+	scopeStorage := &MockScopeStorage{}
+	sessionQuery := &MockSessionQuery{}
+
+	server := NewWithTokenGenerator(
+		tokenGenerator,
+		ownerClientStorage,
+		ownerClientStorage,
+		sessionStorage,
+		scopeStorage,
+	)
+	server.SetSessionLimiter(NewSessionLimiter(sessionQuery, 1))
+
+	oauthSessionRequest := NewBasicOauthSessionRequest("test")
+	session := NewSession()
+	session.Owner = &Owner{Id: "owner"}
+	session.CreatedAt = testNow
+	token := &Token{}
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	grant.On("GenerateSession", oauthSessionRequest, server).Return(session, nil)
+	server.AddGrant(grant)
+	tokenGenerator.On("GenerateAccessToken", server.Config(), grant, session).Return(token)
+	sessionStorage.On("SaveSession", session).Return()
+
+	returnedSession, error := server.GrantOauthSession(oauthSessionRequest)
+
+	assert.Nil(t, error)
+	assert.Equal(t, session, returnedSession)
+	sessionQuery.AssertNotCalled(t, "CountSessions", &SessionFilter{OwnerId: "owner"})
+}
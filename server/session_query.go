@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionFilter narrows a session query down to the sessions of an owner, of
+// a client and with a scope. Empty fields match every session.
+type SessionFilter struct {
+	OwnerId  string
+	ClientId string
+	Scope    string
+}
+
+func (filter *SessionFilter) Matches(session *Session) bool {
+
+	if filter.OwnerId != "" && (session.Owner == nil || session.Owner.Id != filter.OwnerId) {
+
+		return false
+	}
+
+	if filter.ClientId != "" && (session.Client == nil || session.Client.Id != filter.ClientId) {
+
+		return false
+	}
+
+	if filter.Scope != "" {
+
+		if _, ok := session.Scopes[filter.Scope]; !ok {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// SessionCursor points just past the last session of a page. Sessions are
+// ordered by when they were created and then by id, so a cursor keeps
+// working while sessions are added and revoked in between pages.
+type SessionCursor struct {
+	CreatedAt time.Time
+	SessionId string
+}
+
+// After reports whether the session comes after the cursor.
+func (cursor *SessionCursor) After(session *Session) bool {
+
+	if !session.CreatedAt.Equal(cursor.CreatedAt) {
+
+		return session.CreatedAt.After(cursor.CreatedAt)
+	}
+
+	return session.Id > cursor.SessionId
+}
+
+// String encodes the cursor so it can be handed to clients as an opaque
+// value and read back with ParseSessionCursor.
+func (cursor *SessionCursor) String() string {
+
+	createdAt := int64(0)
+
+	if !cursor.CreatedAt.IsZero() {
+
+		createdAt = cursor.CreatedAt.UnixNano()
+	}
+
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(createdAt, 10) + ":" + cursor.SessionId))
+}
+
+func NewSessionCursor(session *Session) *SessionCursor {
+
+	return &SessionCursor{session.CreatedAt, session.Id}
+}
+
+// ParseSessionCursor reads a cursor made by SessionCursor.String. The empty
+// string is the start of the sessions and gives a nil cursor.
+func ParseSessionCursor(cursor string) (*SessionCursor, error) {
+
+	if cursor == "" {
+
+		return nil, nil
+	}
+
+	decoded, error := base64.RawURLEncoding.DecodeString(cursor)
+
+	if error != nil {
+
+		return nil, &InvalidSessionCursorError{cursor}
+	}
+
+	parts := strings.SplitN(string(decoded), ":", 2)
+
+	if len(parts) != 2 || parts[1] == "" {
+
+		return nil, &InvalidSessionCursorError{cursor}
+	}
+
+	createdAt, error := strconv.ParseInt(parts[0], 10, 64)
+
+	if error != nil {
+
+		return nil, &InvalidSessionCursorError{cursor}
+	}
+
+	if createdAt == 0 {
+
+		return &SessionCursor{time.Time{}, parts[1]}, nil
+	}
+
+	return &SessionCursor{time.Unix(0, createdAt).UTC(), parts[1]}, nil
+}
+
+type InvalidSessionCursorError struct {
+	cursor string
+}
+
+func (error *InvalidSessionCursorError) Error() string {
+	return fmt.Sprintf("%q is not a valid session cursor", error.cursor)
+}
+
+// SessionPage is one page of a session query. NextCursor is nil on the last
+// page.
+type SessionPage struct {
+	Sessions   []*Session
+	NextCursor *SessionCursor
+}
+
+// NewSessionPage takes ordered sessions that storages fetched one more of
+// than the limit, so it can tell whether there is a next page without a
+// second query. A limit of zero or less puts every session on the page.
+func NewSessionPage(sessions []*Session, limit int) *SessionPage {
+
+	if limit <= 0 || len(sessions) <= limit {
+
+		return &SessionPage{sessions, nil}
+	}
+
+	sessions = sessions[:limit]
+	return &SessionPage{sessions, NewSessionCursor(sessions[limit-1])}
+}
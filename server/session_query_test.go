@@ -0,0 +1,61 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSessionFilterMatches(t *testing.T) {
+
+	session := NewSession()
+	session.Owner = &Owner{Id: "owner"}
+	session.Client = &Client{Id: "client"}
+	session.Scopes["read"] = &Scope{Id: "read", Name: "read"}
+
+	assert.True(t, (&SessionFilter{}).Matches(session))
+	assert.True(t, (&SessionFilter{"owner", "client", "read"}).Matches(session))
+	assert.False(t, (&SessionFilter{OwnerId: "other"}).Matches(session))
+	assert.False(t, (&SessionFilter{ClientId: "other"}).Matches(session))
+	assert.False(t, (&SessionFilter{Scope: "write"}).Matches(session))
+	assert.False(t, (&SessionFilter{OwnerId: "owner"}).Matches(NewSession()))
+}
+
+func TestSessionCursor(t *testing.T) {
+
+	createdAt := time.Date(2026, 3, 4, 5, 6, 7, 8, time.UTC)
+	cursor := &SessionCursor{createdAt, "b"}
+	parsed, error := ParseSessionCursor(cursor.String())
+	assert.Nil(t, error)
+	assert.Equal(t, cursor, parsed)
+
+	parsed, error = ParseSessionCursor((&SessionCursor{time.Time{}, "a"}).String())
+	assert.Nil(t, error)
+	assert.True(t, parsed.CreatedAt.IsZero())
+
+	parsed, error = ParseSessionCursor("")
+	assert.Nil(t, parsed)
+	assert.Nil(t, error)
+
+	for _, invalid := range []string{"!!", "bm9jb2xvbg", "MTIzOg", "YWJjOmlk"} {
+
+		parsed, error = ParseSessionCursor(invalid)
+		assert.Nil(t, parsed, invalid)
+		assert.IsType(t, &InvalidSessionCursorError{}, error, invalid)
+	}
+
+	assert.True(t, cursor.After(&Session{Id: "a", CreatedAt: createdAt.Add(time.Nanosecond)}))
+	assert.True(t, cursor.After(&Session{Id: "c", CreatedAt: createdAt}))
+	assert.False(t, cursor.After(&Session{Id: "b", CreatedAt: createdAt}))
+	assert.False(t, cursor.After(&Session{Id: "c", CreatedAt: createdAt.Add(-time.Nanosecond)}))
+}
+
+func TestNewSessionPage(t *testing.T) {
+
+	createdAt := time.Now()
+	sessions := []*Session{{Id: "a", CreatedAt: createdAt}, {Id: "b", CreatedAt: createdAt}, {Id: "c", CreatedAt: createdAt}}
+
+	assert.Equal(t, &SessionPage{sessions, nil}, NewSessionPage(sessions, 0))
+	assert.Equal(t, &SessionPage{sessions, nil}, NewSessionPage(sessions, 3))
+	assert.Equal(t, &SessionPage{sessions[:2], &SessionCursor{createdAt, "b"}}, NewSessionPage(sessions, 2))
+}
@@ -6,6 +6,15 @@ type ClientStorage interface {
 	RefreshClient(client *Client) (*Client, error)
 }
 
+// ClientRegistry is the writable side of ClientStorage used by dynamic client
+// registration. Saving with an empty secret keeps the current secret.
+type ClientRegistry interface {
+	ClientStorage
+	SaveClientRegistration(registration *ClientRegistration, clientSecret string) error
+	FindClientRegistrationById(clientId string) (*ClientRegistration, error)
+	DeleteClientRegistration(registration *ClientRegistration) error
+}
+
 type OwnerStorage interface {
 	FindOwnerByUsername(username string) (*Owner, error)
 	FindOwnerByUsernameAndPassword(username string, password string) (*Owner, error)
@@ -22,3 +31,48 @@ type SessionStorage interface {
 type ScopeStorage interface {
 	FindScopeByName(name string) (*Scope, error)
 }
+
+type ScopeLister interface {
+	FindAllScopes() ([]*Scope, error)
+}
+
+// ClientManager is implemented by client storages that clients can be created
+// in and removed from, like the ones the admin tools work with. UpdateClient
+// saves a client that already exists and keeps its secret.
+type ClientManager interface {
+	ClientStorage
+	SaveClient(client *Client, clientSecret string) error
+	UpdateClient(client *Client) error
+	FindAllClients() ([]*Client, error)
+	DeleteClient(clientId string) error
+}
+
+// OwnerManager is implemented by owner storages that owners can be created in
+// and removed from. Storages that persist passwords keep them hashed with
+// HashPassword.
+type OwnerManager interface {
+	OwnerStorage
+	SaveOwner(owner *Owner, username string, password string) error
+	DeleteOwner(ownerId string) error
+}
+
+type ScopeManager interface {
+	ScopeStorage
+	ScopeLister
+	SaveScope(scope *Scope) error
+	DeleteScope(name string) error
+}
+
+// SessionQuery is implemented by session storages that can find sessions by
+// something other than their tokens. Only sessions with a token that can
+// still be used are returned, oldest first. QuerySessions returns the page of
+// at most limit sessions after the cursor, a nil cursor starts at the oldest
+// session.
+type SessionQuery interface {
+	FindSessionById(sessionId string) (*Session, error)
+	FindSessionsByOwnerId(ownerId string) ([]*Session, error)
+	FindSessionsByClientId(clientId string) ([]*Session, error)
+	FindSessionsByScope(scope string) ([]*Session, error)
+	QuerySessions(filter *SessionFilter, cursor *SessionCursor, limit int) (*SessionPage, error)
+	CountSessions(filter *SessionFilter) (int, error)
+}
@@ -3,7 +3,6 @@ package server
 import (
 	"code.google.com/p/go-uuid/uuid"
 	"encoding/base64"
-	"time"
 )
 
 type TokenIdGeneratorFunc func() string
@@ -14,54 +13,59 @@ func GenerateTokenId() string {
 }
 
 type TokenGenerator interface {
-	GenerateAccessToken(serverConfig *Config, grant Grant) *Token
-	GenerateRefreshToken(serverConfig *Config, grant Grant) *Token
+	GenerateAccessToken(serverConfig *Config, grant Grant, session *Session) *Token
+	GenerateRefreshToken(serverConfig *Config, grant Grant, session *Session) *Token
 }
 
 type DefaultTokenGenerator struct {
 	tokenIdGenerator TokenIdGeneratorFunc
+	clock            Clock
 }
 
-func (generator *DefaultTokenGenerator) GenerateAccessToken(config *Config, grant Grant) *Token {
+func (generator *DefaultTokenGenerator) GenerateAccessToken(config *Config, grant Grant, session *Session) *Token {
 
-	var expiration int
-
-	expiration = grant.AccessTokenExpiration()
+	now := generator.clock.Now()
+	return NewToken(generator.tokenIdGenerator(), now, lifetimePolicy(config).AccessTokenLifetime(config, grant, session, now))
+}
 
-	if expiration == 0 {
+func (generator *DefaultTokenGenerator) GenerateRefreshToken(config *Config, grant Grant, session *Session) *Token {
 
-		expiration = config.DefaultAccessTokenExpires
-	}
-
-	return &Token{
-		generator.tokenIdGenerator(),
-		int(time.Now().UTC().Add(time.Duration(expiration) * time.Second).Unix()),
-	}
+	now := generator.clock.Now()
+	return NewToken(generator.tokenIdGenerator(), now, lifetimePolicy(config).RefreshTokenLifetime(config, grant, session, now))
 }
 
-func (generator *DefaultTokenGenerator) GenerateRefreshToken(config *Config, grant Grant) *Token {
+func (generator *DefaultTokenGenerator) TokenIdGenerator() TokenIdGeneratorFunc {
 
-	var expiration int
+	return generator.tokenIdGenerator
+}
 
-	expiration = config.DefaultRefreshTokenExpires
+func (generator *DefaultTokenGenerator) Clock() Clock {
 
-	return &Token{
-		generator.tokenIdGenerator(),
-		int(time.Now().UTC().Add(time.Duration(expiration) * time.Second).Unix()),
-	}
+	return generator.clock
 }
 
-func (generator *DefaultTokenGenerator) TokenIdGenerator() TokenIdGeneratorFunc {
+func (generator *DefaultTokenGenerator) SetClock(clock Clock) *DefaultTokenGenerator {
 
-	return generator.tokenIdGenerator
+	generator.clock = clock
+	return generator
 }
 
 func NewDefaultTokenGenerator() *DefaultTokenGenerator {
 
-	return &DefaultTokenGenerator{GenerateTokenId}
+	return &DefaultTokenGenerator{GenerateTokenId, NewSystemClock()}
 }
 
 func NewDefaultTokenGeneratorWithGeneratorFunc(generatorFunc TokenIdGeneratorFunc) *DefaultTokenGenerator {
 
-	return &DefaultTokenGenerator{generatorFunc}
+	return &DefaultTokenGenerator{generatorFunc, NewSystemClock()}
+}
+
+func lifetimePolicy(config *Config) LifetimePolicy {
+
+	if config.LifetimePolicy == nil {
+
+		return NewDefaultLifetimePolicy()
+	}
+
+	return config.LifetimePolicy
 }
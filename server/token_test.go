@@ -8,52 +8,87 @@ import (
 
 func TestNewDefaultTokenGenerator(t *testing.T) {
 
-	generator := &DefaultTokenGenerator{GenerateTokenId}
+	generator := &DefaultTokenGenerator{GenerateTokenId, NewSystemClock()}
 	assert.Equal(t, generator, NewDefaultTokenGenerator())
 }
 
 func TestDefaultTokenGeneratorGenerateAccessTokenWithGrantNotReturningExpiration(t *testing.T) {
 
-	generator := NewDefaultTokenGeneratorWithGeneratorFunc(GeneratorFuncMock)
+	generator := NewDefaultTokenGeneratorWithGeneratorFunc(GeneratorFuncMock).SetClock(NewFakeClock(testNow))
 	config := NewConfig()
-	config.DefaultAccessTokenExpires = 2
+	config.DefaultAccessTokenExpires = 2 * time.Second
 	grant := &MockGrant{}
-	grant.On("AccessTokenExpiration").Return(0)
-	token := generator.GenerateAccessToken(config, grant)
-	assert.Equal(t, &Token{
-		"hello",
-		int(time.Now().UTC().Add(time.Duration(2) * time.Second).Unix()),
-	}, token)
+	grant.On("Name").Return("test")
+	grant.On("AccessTokenExpiration").Return(time.Duration(0))
+	token := generator.GenerateAccessToken(config, grant, NewSession())
+	assertGeneratedToken(t, token, 2*time.Second)
 }
 
 func TestDefaultTokenGeneratorGenerateAccessTokenWithGrantReturningExpiration(t *testing.T) {
 
-	generator := NewDefaultTokenGeneratorWithGeneratorFunc(GeneratorFuncMock)
+	generator := NewDefaultTokenGeneratorWithGeneratorFunc(GeneratorFuncMock).SetClock(NewFakeClock(testNow))
 	config := NewConfig()
-	config.DefaultAccessTokenExpires = 2
+	config.DefaultAccessTokenExpires = 2 * time.Second
 	grant := &MockGrant{}
-	grant.On("AccessTokenExpiration").Return(5)
-	token := generator.GenerateAccessToken(config, grant)
-	assert.Equal(t, &Token{
-		"hello",
-		int(time.Now().UTC().Add(time.Duration(5) * time.Second).Unix()),
-	}, token)
+	grant.On("AccessTokenExpiration").Return(5 * time.Second)
+	token := generator.GenerateAccessToken(config, grant, NewSession())
+	assertGeneratedToken(t, token, 5*time.Second)
 }
 
 func TestDefaultTokenGeneratorGenerateRefreshToken(t *testing.T) {
 
-	generator := NewDefaultTokenGeneratorWithGeneratorFunc(GeneratorFuncMock)
+	generator := NewDefaultTokenGeneratorWithGeneratorFunc(GeneratorFuncMock).SetClock(NewFakeClock(testNow))
 	config := NewConfig()
-	config.DefaultRefreshTokenExpires = 2
+	config.DefaultRefreshTokenExpires = 2 * time.Second
 	grant := &MockGrant{}
-	grant.On("AccessTokenExpiration").Return(0)
-	token := generator.GenerateRefreshToken(config, grant)
-	assert.Equal(t, &Token{
-		"hello",
-		int(time.Now().UTC().Add(time.Duration(2) * time.Second).Unix()),
-	}, token)
+	grant.On("Name").Return("test")
+	grant.On("AccessTokenExpiration").Return(time.Duration(0))
+	token := generator.GenerateRefreshToken(config, grant, NewSession())
+	assertGeneratedToken(t, token, 2*time.Second)
 }
 
+func TestDefaultTokenGeneratorGenerateRefreshTokenWithoutExpiration(t *testing.T) {
+
+	generator := NewDefaultTokenGeneratorWithGeneratorFunc(GeneratorFuncMock).SetClock(NewFakeClock(testNow))
+	config := NewConfig()
+	config.DefaultRefreshTokenExpires = NoExpiration
+	grant := &MockGrant{}
+	grant.On("Name").Return("test")
+	token := generator.GenerateRefreshToken(config, grant, NewSession())
+	assert.Equal(t, "hello", token.Token)
+	assert.True(t, token.ExpiresAt.IsZero())
+}
+
+func TestDefaultTokenGeneratorGenerateTokensWithClientReturningExpiration(t *testing.T) {
+
+	generator := NewDefaultTokenGeneratorWithGeneratorFunc(GeneratorFuncMock).SetClock(NewFakeClock(testNow))
+	config := NewConfig()
+	grant := &MockGrant{}
+	session := NewSession()
+	session.Client = &Client{Id: "id", AccessTokenExpires: 7 * time.Second, RefreshTokenExpires: 9 * time.Second}
+	token := generator.GenerateAccessToken(config, grant, session)
+	assertGeneratedToken(t, token, 7*time.Second)
+	token = generator.GenerateRefreshToken(config, grant, session)
+	assertGeneratedToken(t, token, 9*time.Second)
+	grant.AssertNotCalled(t, "AccessTokenExpiration")
+}
+
+func TestDefaultTokenGeneratorClock(t *testing.T) {
+
+	clock := NewFakeClock(testNow)
+	generator := NewDefaultTokenGenerator()
+	assert.Equal(t, NewSystemClock(), generator.Clock())
+	assert.Equal(t, generator, generator.SetClock(clock))
+	assert.Equal(t, clock, generator.Clock())
+}
+
+func assertGeneratedToken(t *testing.T, token *Token, lifetime time.Duration) {
+
+	assert.Equal(t, &Token{"hello", testNow, testNow.Add(lifetime)}, token)
+}
+
+var testNow = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
 func GeneratorFuncMock() string {
 
 	return "hello"
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// Tracer starts spans, it mirrors the OpenTelemetry tracer closely enough that
+// an adapter is a few lines and the server does not depend on it.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttribute(key string, value string)
+	SetError(error error)
+	End()
+}
+
+// ContextRequest is implemented by session requests that carry the context of
+// the request they came in on, spans started for them join its trace.
+type ContextRequest interface {
+	Context() context.Context
+}
+
+// SpanContext identifies a span from another process, usually parsed from a
+// W3C traceparent header.
+type SpanContext struct {
+	TraceId string
+	SpanId  string
+}
+
+type spanContextKey struct{}
+
+func ContextWithSpanContext(ctx context.Context, spanContext SpanContext) context.Context {
+
+	return context.WithValue(ctx, spanContextKey{}, spanContext)
+}
+
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+
+	spanContext, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return spanContext, ok
+}
+
+type noopSpan struct {
+}
+
+func (span *noopSpan) SetAttribute(key string, value string) {
+}
+
+func (span *noopSpan) SetError(error error) {
+}
+
+func (span *noopSpan) End() {
+}
+
+// RecordedSpan is a span kept by the RecordingTracer.
+type RecordedSpan struct {
+	tracer       *RecordingTracer
+	Name         string
+	TraceId      string
+	SpanId       string
+	ParentSpanId string
+	Attributes   map[string]string
+	Error        error
+	Ended        bool
+}
+
+func (span *RecordedSpan) SetAttribute(key string, value string) {
+
+	span.tracer.mutex.Lock()
+	defer span.tracer.mutex.Unlock()
+	span.Attributes[key] = value
+}
+
+func (span *RecordedSpan) SetError(error error) {
+
+	span.tracer.mutex.Lock()
+	defer span.tracer.mutex.Unlock()
+	span.Error = error
+}
+
+func (span *RecordedSpan) End() {
+
+	span.tracer.mutex.Lock()
+	defer span.tracer.mutex.Unlock()
+	span.Ended = true
+}
+
+// HasEnded is safe to call while the span may still be ending on another
+// goroutine.
+func (span *RecordedSpan) HasEnded() bool {
+
+	span.tracer.mutex.Lock()
+	defer span.tracer.mutex.Unlock()
+	return span.Ended
+}
+
+// RecordingTracer keeps every span in memory, it is meant for tests.
+type RecordingTracer struct {
+	mutex sync.Mutex
+	spans []*RecordedSpan
+}
+
+func (tracer *RecordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+
+	span := &RecordedSpan{
+		tracer:     tracer,
+		Name:       name,
+		TraceId:    randomHex(16),
+		SpanId:     randomHex(8),
+		Attributes: make(map[string]string),
+	}
+
+	if parent, ok := SpanContextFromContext(ctx); ok {
+
+		span.TraceId = parent.TraceId
+		span.ParentSpanId = parent.SpanId
+	}
+
+	tracer.mutex.Lock()
+	tracer.spans = append(tracer.spans, span)
+	tracer.mutex.Unlock()
+
+	return ContextWithSpanContext(ctx, SpanContext{span.TraceId, span.SpanId}), span
+}
+
+// Spans returns the spans in the order they were started.
+func (tracer *RecordingTracer) Spans() []*RecordedSpan {
+
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	return append([]*RecordedSpan{}, tracer.spans...)
+}
+
+// Span returns the first span started with the name.
+func (tracer *RecordingTracer) Span(name string) *RecordedSpan {
+
+	for _, span := range tracer.Spans() {
+
+		if span.Name == name {
+
+			return span
+		}
+	}
+
+	return nil
+}
+
+func (tracer *RecordingTracer) Reset() {
+
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	tracer.spans = nil
+}
+
+func NewRecordingTracer() *RecordingTracer {
+
+	return &RecordingTracer{}
+}
+
+// tracedServer is handed to grants while a traced request is running so the
+// storages and client authentication they use join the request's trace.
+type tracedServer struct {
+	*DefaultServer
+	tracer Tracer
+	ctx    context.Context
+}
+
+func (server *tracedServer) ClientStorage() ClientStorage {
+
+	return &tracedClientStorage{server.DefaultServer.ClientStorage(), server}
+}
+
+func (server *tracedServer) OwnerStorage() OwnerStorage {
+
+	return &tracedOwnerStorage{server.DefaultServer.OwnerStorage(), server}
+}
+
+func (server *tracedServer) SessionStorage() SessionStorage {
+
+	return &tracedSessionStorage{server.DefaultServer.SessionStorage(), server}
+}
+
+func (server *tracedServer) ScopeStorage() ScopeStorage {
+
+	return &tracedScopeStorage{server.DefaultServer.ScopeStorage(), server}
+}
+
+func (server *tracedServer) start(name string) (*tracedServer, Span) {
+
+	ctx, span := server.tracer.Start(server.ctx, name)
+	return &tracedServer{server.DefaultServer, server.tracer, ctx}, span
+}
+
+// startSpan starts a span under whatever span the server is part of. Servers
+// that are not traced get a span that does nothing.
+func startSpan(server Server, name string) (Server, Span) {
+
+	traced, ok := server.(*tracedServer)
+
+	if !ok {
+
+		return server, &noopSpan{}
+	}
+
+	return traced.start(name)
+}
+
+func endSpan(span Span, error error) {
+
+	if error != nil {
+
+		span.SetError(error)
+
+		if oauthError, ok := error.(OauthError); ok {
+
+			span.SetAttribute("oauth.error_code", oauthError.OauthErrorCode().String())
+		}
+	}
+
+	span.End()
+}
+
+func requestContext(oauthSessionRequest OauthSessionRequest) context.Context {
+
+	if contextRequest, ok := oauthSessionRequest.(ContextRequest); ok {
+
+		return contextRequest.Context()
+	}
+
+	return context.Background()
+}
+
+type tracedClientStorage struct {
+	storage ClientStorage
+	server  *tracedServer
+}
+
+func (storage *tracedClientStorage) FindClientById(clientId string) (client *Client, error error) {
+
+	_, span := storage.server.start("ClientStorage.FindClientById")
+	defer func() { endSpan(span, error) }()
+	return storage.storage.FindClientById(clientId)
+}
+
+func (storage *tracedClientStorage) FindClientByIdAndSecret(clientId string, clientSecret string) (client *Client, error error) {
+
+	_, span := storage.server.start("ClientStorage.FindClientByIdAndSecret")
+	defer func() { endSpan(span, error) }()
+	return storage.storage.FindClientByIdAndSecret(clientId, clientSecret)
+}
+
+func (storage *tracedClientStorage) RefreshClient(client *Client) (refreshed *Client, error error) {
+
+	_, span := storage.server.start("ClientStorage.RefreshClient")
+	defer func() { endSpan(span, error) }()
+	return storage.storage.RefreshClient(client)
+}
+
+type tracedOwnerStorage struct {
+	storage OwnerStorage
+	server  *tracedServer
+}
+
+func (storage *tracedOwnerStorage) FindOwnerByUsername(username string) (owner *Owner, error error) {
+
+	_, span := storage.server.start("OwnerStorage.FindOwnerByUsername")
+	defer func() { endSpan(span, error) }()
+	return storage.storage.FindOwnerByUsername(username)
+}
+
+func (storage *tracedOwnerStorage) FindOwnerByUsernameAndPassword(username string, password string) (owner *Owner, error error) {
+
+	_, span := storage.server.start("OwnerStorage.FindOwnerByUsernameAndPassword")
+	defer func() { endSpan(span, error) }()
+	return storage.storage.FindOwnerByUsernameAndPassword(username, password)
+}
+
+func (storage *tracedOwnerStorage) RefreshOwner(owner *Owner) (refreshed *Owner, error error) {
+
+	_, span := storage.server.start("OwnerStorage.RefreshOwner")
+	defer func() { endSpan(span, error) }()
+	return storage.storage.RefreshOwner(owner)
+}
+
+type tracedSessionStorage struct {
+	storage SessionStorage
+	server  *tracedServer
+}
+
+func (storage *tracedSessionStorage) FindSessionByAccessToken(accessToken string) (session *Session, error error) {
+
+	_, span := storage.server.start("SessionStorage.FindSessionByAccessToken")
+	defer func() { endSpan(span, error) }()
+	return storage.storage.FindSessionByAccessToken(accessToken)
+}
+
+func (storage *tracedSessionStorage) FindSessionByRefreshToken(refreshToken string) (session *Session, error error) {
+
+	_, span := storage.server.start("SessionStorage.FindSessionByRefreshToken")
+	defer func() { endSpan(span, error) }()
+	return storage.storage.FindSessionByRefreshToken(refreshToken)
+}
+
+func (storage *tracedSessionStorage) SaveSession(session *Session) {
+
+	_, span := storage.server.start("SessionStorage.SaveSession")
+	defer span.End()
+	storage.storage.SaveSession(session)
+}
+
+func (storage *tracedSessionStorage) DeleteSession(session *Session) {
+
+	_, span := storage.server.start("SessionStorage.DeleteSession")
+	defer span.End()
+	storage.storage.DeleteSession(session)
+}
+
+type tracedScopeStorage struct {
+	storage ScopeStorage
+	server  *tracedServer
+}
+
+func (storage *tracedScopeStorage) FindScopeByName(name string) (scope *Scope, error error) {
+
+	_, span := storage.server.start("ScopeStorage.FindScopeByName")
+	defer func() { endSpan(span, error) }()
+	return storage.storage.FindScopeByName(name)
+}
+
+// FindAllScopes passes through to the wrapped storage so pattern scopes are
+// still found when the server is traced.
+func (storage *tracedScopeStorage) FindAllScopes() (scopes []*Scope, error error) {
+
+	lister, ok := storage.storage.(ScopeLister)
+
+	if !ok {
+
+		return nil, fmt.Errorf("the scope storage can not list scopes")
+	}
+
+	_, span := storage.server.start("ScopeStorage.FindAllScopes")
+	defer func() { endSpan(span, error) }()
+	return lister.FindAllScopes()
+}
+
+func randomHex(length int) string {
+
+	bytes := make([]byte, length)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+type contextRequest struct {
+	*BasicOauthSessionRequest
+	ctx context.Context
+}
+
+func (request *contextRequest) Context() context.Context {
+
+	return request.ctx
+}
+
+func TestRecordingTracer(t *testing.T) {
+
+	tracer := NewRecordingTracer()
+	parent := SpanContext{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"}
+
+	ctx, span := tracer.Start(ContextWithSpanContext(context.Background(), parent), "first")
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("boom"))
+	span.End()
+	_, child := tracer.Start(ctx, "second")
+
+	spans := tracer.Spans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "first", spans[0].Name)
+	assert.Equal(t, parent.TraceId, spans[0].TraceId)
+	assert.Equal(t, parent.SpanId, spans[0].ParentSpanId)
+	assert.Equal(t, map[string]string{"key": "value"}, spans[0].Attributes)
+	assert.Equal(t, errors.New("boom"), spans[0].Error)
+	assert.True(t, spans[0].Ended)
+	assert.Equal(t, child, tracer.Span("second"))
+	assert.Equal(t, parent.TraceId, spans[1].TraceId)
+	assert.Equal(t, spans[0].SpanId, spans[1].ParentSpanId)
+	assert.False(t, spans[1].Ended)
+	assert.Nil(t, tracer.Span("third"))
+
+	_, root := tracer.Start(context.Background(), "root")
+	assert.Len(t, root.(*RecordedSpan).TraceId, 32)
+	assert.Equal(t, "", root.(*RecordedSpan).ParentSpanId)
+
+	tracer.Reset()
+	assert.Empty(t, tracer.Spans())
+}
+
+func TestServerGrantOauthSessionIsTraced(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	sessionStorage := &MockSessionStorage{}
+	tokenGenerator := &MockTokenGenerator{}
+	scopeStorage := &MockScopeStorage{}
+	tracer := NewRecordingTracer()
+
+	server := NewWithTokenGenerator(
+		tokenGenerator,
+		ownerClientStorage,
+		ownerClientStorage,
+		sessionStorage,
+		scopeStorage,
+	)
+	assert.Nil(t, server.Tracer())
+	assert.Equal(t, server, server.SetTracer(tracer))
+	assert.Equal(t, tracer, server.Tracer())
+	server.AddGrant(&ClientCredentialsGrant{})
+
+	parent := SpanContext{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"}
+	request := &contextRequest{
+		NewBasicOauthSessionRequest("client_credentials").
+			SetAll(map[string]string{"client_id": "client", "client_secret": "secret"}).
+			Add("scopes", "read"),
+		ContextWithSpanContext(context.Background(), parent),
+	}
+	client := &Client{Id: "client", Name: "name"}
+	ownerClientStorage.On("FindClientByIdAndSecret", "client", "secret").Return(client, nil)
+	scopeStorage.On("FindScopeByName", "read").Return(&Scope{Id: "id", Name: "read"}, nil)
+	saved := make(chan bool, 1)
+	tokenGenerator.On("GenerateAccessToken", server.Config(), server.grants["client_credentials"], mock.AnythingOfType("*server.Session")).Return(&Token{Token: "token"})
+	sessionStorage.On("SaveSession", mock.AnythingOfType("*server.Session")).Return().Run(func(args mock.Arguments) {
+
+		saved <- true
+	})
+
+	session, error := server.GrantOauthSession(request)
+	<-saved
+	assert.Eventually(t, tracer.Span("SessionStorage.SaveSession").HasEnded, time.Second, time.Millisecond)
+
+	assert.Nil(t, error)
+	assert.Equal(t, client, session.Client)
+
+	root := tracer.Span("GrantOauthSession")
+	assert.Equal(t, parent.SpanId, root.ParentSpanId)
+	assert.Equal(t, map[string]string{"oauth.grant": "client_credentials", "oauth.client_id": "client"}, root.Attributes)
+	generate := tracer.Span("Grant.GenerateSession")
+	assert.Equal(t, root.SpanId, generate.ParentSpanId)
+	authenticate := tracer.Span("AuthenticateClient")
+	assert.Equal(t, generate.SpanId, authenticate.ParentSpanId)
+	assert.Equal(t, "client", authenticate.Attributes["oauth.client_id"])
+	assert.Equal(t, authenticate.SpanId, tracer.Span("ClientStorage.FindClientByIdAndSecret").ParentSpanId)
+	assert.Equal(t, root.SpanId, tracer.Span("ScopeStorage.FindScopeByName").ParentSpanId)
+	assert.Equal(t, root.SpanId, tracer.Span("TokenGenerator.GenerateTokens").ParentSpanId)
+	assert.Equal(t, root.SpanId, tracer.Span("SessionStorage.SaveSession").ParentSpanId)
+
+	for _, span := range tracer.Spans() {
+
+		assert.Equal(t, parent.TraceId, span.TraceId)
+		assert.True(t, span.HasEnded(), span.Name)
+	}
+}
+
+func TestServerGrantOauthSessionTracesErrors(t *testing.T) {
+
+	ownerClientStorage := &MockOwnerClientStorage{}
+	tracer := NewRecordingTracer()
+
+	server := New(
+		ownerClientStorage,
+		ownerClientStorage,
+		&MockSessionStorage{},
+		&MockScopeStorage{},
+	).SetTracer(tracer)
+	server.AddGrant(&ClientCredentialsGrant{})
+
+	request := NewBasicOauthSessionRequest("client_credentials").SetAll(map[string]string{"client_id": "client", "client_secret": "secret"})
+	ownerClientStorage.On("FindClientByIdAndSecret", "client", "secret").Return(nil, errors.New("not found"))
+
+	session, error := server.GrantOauthSession(request)
+
+	assert.Nil(t, session)
+	assert.Equal(t, &StorageSearchFailedError{"client", errors.New("not found")}, error)
+	assert.Equal(t, "", tracer.Span("GrantOauthSession").ParentSpanId)
+	assert.Equal(t, "storage_search_failed", tracer.Span("GrantOauthSession").Attributes["oauth.error_code"])
+	assert.Equal(t, "storage_search_failed", tracer.Span("AuthenticateClient").Attributes["oauth.error_code"])
+	assert.Equal(t, errors.New("not found"), tracer.Span("ClientStorage.FindClientByIdAndSecret").Error)
+}
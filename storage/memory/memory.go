@@ -3,31 +3,55 @@ package memory
 import (
 	"fmt"
 	"github.com/yjv/goauth2-server/server"
+	"sort"
+	"sync"
 	"time"
 )
 
 type OwnerClientStorage struct {
+	mutex                       sync.RWMutex
 	ownersByUsername            map[string]*server.Owner
 	ownersByUsernameAndPassword map[string]*server.Owner
 	clientsByClientId           map[string]*server.Client
 	clientsByClientIdAndSecret  map[string]*server.Client
+	clientSecretsByClientId     map[string]string
+	registrationsByClientId     map[string]*server.ClientRegistration
 }
 
 func (storage *OwnerClientStorage) AddClient(clientId string, clientSecret string, client *server.Client) *OwnerClientStorage {
 
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.addClient(clientId, clientSecret, client)
+	return storage
+}
+
+func (storage *OwnerClientStorage) addClient(clientId string, clientSecret string, client *server.Client) {
+
+	delete(storage.clientsByClientIdAndSecret, clientId+":"+storage.clientSecretsByClientId[clientId])
 	storage.clientsByClientId[clientId] = client
 	storage.clientsByClientIdAndSecret[clientId+":"+clientSecret] = client
-	return storage
+	storage.clientSecretsByClientId[clientId] = clientSecret
 }
+
 func (storage *OwnerClientStorage) AddOwner(username string, password string, owner *server.Owner) *OwnerClientStorage {
 
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.addOwner(username, password, owner)
+	return storage
+}
+
+func (storage *OwnerClientStorage) addOwner(username string, password string, owner *server.Owner) {
+
 	storage.ownersByUsername[username] = owner
 	storage.ownersByUsernameAndPassword[username+":"+password] = owner
-	return storage
 }
 
 func (storage *OwnerClientStorage) FindClientById(clientId string) (*server.Client, error) {
 
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
 	client, ok := storage.clientsByClientId[clientId]
 
 	if !ok {
@@ -40,6 +64,8 @@ func (storage *OwnerClientStorage) FindClientById(clientId string) (*server.Clie
 
 func (storage *OwnerClientStorage) FindClientByIdAndSecret(clientId string, clientSecret string) (*server.Client, error) {
 
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
 	client, ok := storage.clientsByClientIdAndSecret[clientId+":"+clientSecret]
 
 	if !ok {
@@ -52,6 +78,8 @@ func (storage *OwnerClientStorage) FindClientByIdAndSecret(clientId string, clie
 
 func (storage *OwnerClientStorage) RefreshClient(client *server.Client) (*server.Client, error) {
 
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
 	client, exists := storage.clientsByClientId[client.Id]
 
 	if !exists {
@@ -62,8 +90,108 @@ func (storage *OwnerClientStorage) RefreshClient(client *server.Client) (*server
 	return client, nil
 }
 
+func (storage *OwnerClientStorage) SaveClientRegistration(registration *server.ClientRegistration, clientSecret string) error {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	clientId := registration.Client.Id
+
+	//an update without a new secret keeps the old one, unless the client no longer has a secret
+	if clientSecret == "" && !registration.Client.IsPublic() {
+
+		clientSecret = storage.clientSecretsByClientId[clientId]
+	}
+
+	storage.addClient(clientId, clientSecret, registration.Client)
+	storage.registrationsByClientId[clientId] = registration
+	return nil
+}
+
+func (storage *OwnerClientStorage) FindClientRegistrationById(clientId string) (*server.ClientRegistration, error) {
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	registration, ok := storage.registrationsByClientId[clientId]
+
+	if !ok {
+
+		return nil, fmt.Errorf("couldnt find the client registration with id %s", clientId)
+	}
+
+	return registration, nil
+}
+
+func (storage *OwnerClientStorage) DeleteClientRegistration(registration *server.ClientRegistration) error {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	clientId := registration.Client.Id
+
+	delete(storage.clientsByClientIdAndSecret, clientId+":"+storage.clientSecretsByClientId[clientId])
+	delete(storage.clientSecretsByClientId, clientId)
+	delete(storage.clientsByClientId, clientId)
+	delete(storage.registrationsByClientId, clientId)
+	return nil
+}
+
+func (storage *OwnerClientStorage) SaveClient(client *server.Client, clientSecret string) error {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.addClient(client.Id, clientSecret, client)
+	return nil
+}
+
+func (storage *OwnerClientStorage) UpdateClient(client *server.Client) error {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	clientSecret, ok := storage.clientSecretsByClientId[client.Id]
+
+	if !ok {
+
+		return fmt.Errorf("couldnt find the client with id %s", client.Id)
+	}
+
+	storage.addClient(client.Id, clientSecret, client)
+	return nil
+}
+
+func (storage *OwnerClientStorage) FindAllClients() ([]*server.Client, error) {
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	clients := make([]*server.Client, 0, len(storage.clientsByClientId))
+
+	for _, client := range storage.clientsByClientId {
+		clients = append(clients, client)
+	}
+
+	sort.Slice(clients, func(i int, j int) bool { return clients[i].Id < clients[j].Id })
+	return clients, nil
+}
+
+func (storage *OwnerClientStorage) DeleteClient(clientId string) error {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	if _, ok := storage.clientsByClientId[clientId]; !ok {
+
+		return fmt.Errorf("couldnt find the client with id %s", clientId)
+	}
+
+	delete(storage.clientsByClientIdAndSecret, clientId+":"+storage.clientSecretsByClientId[clientId])
+	delete(storage.clientSecretsByClientId, clientId)
+	delete(storage.clientsByClientId, clientId)
+	delete(storage.registrationsByClientId, clientId)
+	return nil
+}
+
 func (storage *OwnerClientStorage) FindOwnerByUsername(username string) (*server.Owner, error) {
 
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
 	owner, ok := storage.ownersByUsername[username]
 
 	if !ok {
@@ -76,6 +204,8 @@ func (storage *OwnerClientStorage) FindOwnerByUsername(username string) (*server
 
 func (storage *OwnerClientStorage) FindOwnerByUsernameAndPassword(username string, password string) (*server.Owner, error) {
 
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
 	owner, ok := storage.ownersByUsernameAndPassword[username+":"+password]
 
 	if !ok {
@@ -88,6 +218,8 @@ func (storage *OwnerClientStorage) FindOwnerByUsernameAndPassword(username strin
 
 func (storage *OwnerClientStorage) RefreshOwner(owner *server.Owner) (*server.Owner, error) {
 
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
 	owner, exists := storage.ownersByUsername[owner.Id]
 
 	if !exists {
@@ -98,28 +230,88 @@ func (storage *OwnerClientStorage) RefreshOwner(owner *server.Owner) (*server.Ow
 	return owner, nil
 }
 
+// SaveOwner replaces the owner with the same id along with the username and
+// password it had.
+func (storage *OwnerClientStorage) SaveOwner(owner *server.Owner, username string, password string) error {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.deleteOwner(owner.Id)
+	storage.addOwner(username, password, owner)
+	return nil
+}
+
+func (storage *OwnerClientStorage) DeleteOwner(ownerId string) error {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	if !storage.deleteOwner(ownerId) {
+
+		return fmt.Errorf("couldnt find the owner with id %s", ownerId)
+	}
+
+	return nil
+}
+
+// deleteOwner reports whether there was an owner with the id.
+func (storage *OwnerClientStorage) deleteOwner(ownerId string) bool {
+
+	found := false
+
+	for username, owner := range storage.ownersByUsername {
+
+		if owner.Id == ownerId {
+
+			delete(storage.ownersByUsername, username)
+			found = true
+		}
+	}
+
+	for credentials, owner := range storage.ownersByUsernameAndPassword {
+
+		if owner.Id == ownerId {
+
+			delete(storage.ownersByUsernameAndPassword, credentials)
+		}
+	}
+
+	return found
+}
+
 func NewOwnerClientStorage() *OwnerClientStorage {
 
 	return &OwnerClientStorage{
-		make(map[string]*server.Owner),
-		make(map[string]*server.Owner),
-		make(map[string]*server.Client),
-		make(map[string]*server.Client),
+		ownersByUsername:            make(map[string]*server.Owner),
+		ownersByUsernameAndPassword: make(map[string]*server.Owner),
+		clientsByClientId:           make(map[string]*server.Client),
+		clientsByClientIdAndSecret:  make(map[string]*server.Client),
+		clientSecretsByClientId:     make(map[string]string),
+		registrationsByClientId:     make(map[string]*server.ClientRegistration),
 	}
 }
 
+// SessionStorage keeps copies of the sessions it saves and hands out copies,
+// so sessions being changed by a request are not seen by others until they
+// are saved again.
 type SessionStorage struct {
-	sessionsByAccessToken  map[string]*server.Session
-	sessionsByRefreshToken map[string]*server.Session
+	mutex                    sync.RWMutex
+	sessions                 map[string]*server.Session
+	sessionIdsByAccessToken  map[string]string
+	sessionIdsByRefreshToken map[string]string
+	retiredRefreshTokens     map[string]string
+	clock                    server.Clock
 }
 
 func (storage *SessionStorage) FindSessionByAccessToken(accessToken string) (*server.Session, error) {
 
-	session, ok := storage.sessionsByAccessToken[accessToken]
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	session, ok := storage.sessions[storage.sessionIdsByAccessToken[accessToken]]
 
 	if !ok {
 
-		return nil, fmt.Errorf("Session not found for access token ending in %s", accessToken[len(accessToken)-5:])
+		return nil, fmt.Errorf("Session not found for access token")
 	}
 
 	if storage.isExpired(session.AccessToken) {
@@ -129,65 +321,218 @@ func (storage *SessionStorage) FindSessionByAccessToken(accessToken string) (*se
 			go storage.DeleteSession(session)
 		}
 
-		return nil, fmt.Errorf("Refresh token ending in %s is expired", session.RefreshToken[len(session.RefreshToken)-5:])
+		return nil, fmt.Errorf("Access token for session %s is expired", session.Id)
 	}
 
-	return session, nil
+	return session.Copy(), nil
 }
 
+// FindSessionByRefreshToken revokes the whole session when a refresh token it
+// rotated out is presented again.
 func (storage *SessionStorage) FindSessionByRefreshToken(refreshToken string) (*server.Session, error) {
 
-	session, ok := storage.sessionsByRefreshToken[refreshToken]
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	session, ok := storage.sessions[storage.sessionIdsByRefreshToken[refreshToken]]
 
 	if !ok {
 
-		return nil, fmt.Errorf("Session for refresh token ending in %q not found", refreshToken[len(refreshToken)-5:])
+		if sessionId, retired := storage.retiredRefreshTokens[refreshToken]; retired {
+
+			go storage.DeleteSession(&server.Session{Id: sessionId})
+			return nil, &server.RefreshTokenReusedError{}
+		}
+
+		return nil, fmt.Errorf("Session not found for refresh token")
 	}
 
 	if storage.isExpired(session.RefreshToken) {
 
 		go storage.DeleteSession(session)
-		return nil, fmt.Errorf("Refresh token ending in %s is expired", session.RefreshToken[len(session.RefreshToken)-5:])
+		return nil, fmt.Errorf("Refresh token for session %s is expired", session.Id)
 	}
 
-	return session, nil
+	return session.Copy(), nil
 }
 
 func (storage *SessionStorage) SaveSession(session *server.Session) {
 
-	storage.sessionsByAccessToken[session.AccessToken.Token] = session
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	saved := session.Copy()
+
+	//the tokens the session was saved with before stop working once it has new ones
+	if previous, ok := storage.sessions[saved.Id]; ok {
+
+		if previous.AccessToken.Token != saved.AccessToken.Token {
+
+			delete(storage.sessionIdsByAccessToken, previous.AccessToken.Token)
+		}
+
+		if previous.RefreshToken != nil && (saved.RefreshToken == nil || previous.RefreshToken.Token != saved.RefreshToken.Token) {
+
+			delete(storage.sessionIdsByRefreshToken, previous.RefreshToken.Token)
+			storage.retiredRefreshTokens[previous.RefreshToken.Token] = saved.Id
+		}
+	}
+
+	storage.sessions[saved.Id] = saved
+	storage.sessionIdsByAccessToken[saved.AccessToken.Token] = saved.Id
 
-	if session.RefreshToken != nil {
+	if saved.RefreshToken != nil {
 
-		storage.sessionsByRefreshToken[session.RefreshToken.Token] = session
+		storage.sessionIdsByRefreshToken[saved.RefreshToken.Token] = saved.Id
 	}
 }
 
 func (storage *SessionStorage) DeleteSession(session *server.Session) {
 
-	delete(storage.sessionsByAccessToken, session.AccessToken.Token)
-	delete(storage.sessionsByRefreshToken, session.RefreshToken.Token)
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	saved, ok := storage.sessions[session.Id]
+
+	if !ok {
+
+		return
+	}
+
+	delete(storage.sessionIdsByAccessToken, saved.AccessToken.Token)
+
+	if saved.RefreshToken != nil {
+
+		delete(storage.sessionIdsByRefreshToken, saved.RefreshToken.Token)
+	}
+
+	delete(storage.sessions, saved.Id)
+
+	for refreshToken, sessionId := range storage.retiredRefreshTokens {
+
+		if sessionId == saved.Id {
+
+			delete(storage.retiredRefreshTokens, refreshToken)
+		}
+	}
+}
+
+func (storage *SessionStorage) FindSessionById(sessionId string) (*server.Session, error) {
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	if session, ok := storage.sessions[sessionId]; ok && storage.isActive(session) {
+
+		return session.Copy(), nil
+	}
+
+	return nil, fmt.Errorf("Session with id %s not found", sessionId)
+}
+
+func (storage *SessionStorage) FindSessionsByOwnerId(ownerId string) ([]*server.Session, error) {
+
+	return storage.findSessions(&server.SessionFilter{OwnerId: ownerId}, nil), nil
+}
+
+func (storage *SessionStorage) FindSessionsByClientId(clientId string) ([]*server.Session, error) {
+
+	return storage.findSessions(&server.SessionFilter{ClientId: clientId}, nil), nil
+}
+
+func (storage *SessionStorage) FindSessionsByScope(scope string) ([]*server.Session, error) {
+
+	return storage.findSessions(&server.SessionFilter{Scope: scope}, nil), nil
+}
+
+func (storage *SessionStorage) QuerySessions(filter *server.SessionFilter, cursor *server.SessionCursor, limit int) (*server.SessionPage, error) {
+
+	return server.NewSessionPage(storage.findSessions(filter, cursor), limit), nil
+}
+
+func (storage *SessionStorage) CountSessions(filter *server.SessionFilter) (int, error) {
+
+	return len(storage.findSessions(filter, nil)), nil
+}
+
+// findSessions returns the active sessions matching the filter that come
+// after the cursor, oldest first.
+func (storage *SessionStorage) findSessions(filter *server.SessionFilter, cursor *server.SessionCursor) []*server.Session {
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	sessions := []*server.Session{}
+
+	for _, session := range storage.sessions {
+
+		if storage.isActive(session) && filter.Matches(session) && (cursor == nil || cursor.After(session)) {
+
+			sessions = append(sessions, session.Copy())
+		}
+	}
+
+	sort.Slice(sessions, func(i int, j int) bool {
+
+		if !sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+
+			return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+		}
+
+		return sessions[i].Id < sessions[j].Id
+	})
+
+	return sessions
+}
+
+func (storage *SessionStorage) CountActiveSessions() (int, error) {
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	count := 0
+
+	for _, session := range storage.sessions {
+
+		if storage.isActive(session) {
+
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (storage *SessionStorage) isActive(session *server.Session) bool {
+
+	return !storage.isExpired(session.AccessToken) || !storage.isExpired(session.RefreshToken)
 }
 
 func (storage *SessionStorage) isExpired(token *server.Token) bool {
 
-	return token == nil || (token.Expires != server.NoExpiration && token.Expires < int(time.Now().UTC().Unix()))
+	return token == nil || token.IsExpired(storage.clock.Now())
 }
 
 func NewSessionStorage() *SessionStorage {
 
+	return NewSessionStorageWithClock(server.NewSystemClock())
+}
+
+func NewSessionStorageWithClock(clock server.Clock) *SessionStorage {
+
 	return &SessionStorage{
-		make(map[string]*server.Session),
-		make(map[string]*server.Session),
+		sessions:                 make(map[string]*server.Session),
+		sessionIdsByAccessToken:  make(map[string]string),
+		sessionIdsByRefreshToken: make(map[string]string),
+		retiredRefreshTokens:     make(map[string]string),
+		clock:                    clock,
 	}
 }
 
 type ScopeStorage struct {
+	mutex  sync.RWMutex
 	scopes map[string]*server.Scope
 }
 
 func (storage *ScopeStorage) FindScopeByName(name string) (*server.Scope, error) {
 
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
 	scope, ok := storage.scopes[name]
 
 	if !ok {
@@ -197,15 +542,183 @@ func (storage *ScopeStorage) FindScopeByName(name string) (*server.Scope, error)
 	return scope, nil
 }
 
+func (storage *ScopeStorage) FindAllScopes() ([]*server.Scope, error) {
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	names := make([]string, 0, len(storage.scopes))
+
+	for name := range storage.scopes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	scopes := make([]*server.Scope, 0, len(names))
+
+	for _, name := range names {
+		scopes = append(scopes, storage.scopes[name])
+	}
+
+	return scopes, nil
+}
+
 func (storage *ScopeStorage) Set(name string, scope *server.Scope) *ScopeStorage {
 
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
 	storage.scopes[name] = scope
 	return storage
 }
 
+func (storage *ScopeStorage) SaveScope(scope *server.Scope) error {
+
+	storage.Set(scope.Name, scope)
+	return nil
+}
+
+func (storage *ScopeStorage) DeleteScope(name string) error {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	if _, ok := storage.scopes[name]; !ok {
+
+		return fmt.Errorf("Scope named %s not found", name)
+	}
+
+	delete(storage.scopes, name)
+	return nil
+}
+
 func NewScopeStorage() *ScopeStorage {
 
-	return &ScopeStorage{
-		make(map[string]*server.Scope),
+	return &ScopeStorage{scopes: make(map[string]*server.Scope)}
+}
+
+type LimitStorage struct {
+	mutex  sync.Mutex
+	states map[string]*server.LimitState
+}
+
+func (storage *LimitStorage) UpdateLimitState(key string, update func(state *server.LimitState)) (*server.LimitState, error) {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	state, ok := storage.states[key]
+
+	if !ok {
+
+		state = &server.LimitState{}
+		storage.states[key] = state
+	}
+
+	update(state)
+	updated := *state
+	return &updated, nil
+}
+
+func NewLimitStorage() *LimitStorage {
+
+	return &LimitStorage{states: make(map[string]*server.LimitState)}
+}
+
+// ReplayCache remembers ids in memory, so it only catches replays sent to the
+// same instance. Expired ids are dropped once a minute.
+type ReplayCache struct {
+	mutex     sync.Mutex
+	ids       map[string]time.Time
+	nextSweep time.Time
+}
+
+func (cache *ReplayCache) Remember(id string, expiresAt time.Time, now time.Time) (bool, error) {
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if now.After(cache.nextSweep) {
+
+		for remembered, rememberedUntil := range cache.ids {
+
+			if !now.Before(rememberedUntil) {
+
+				delete(cache.ids, remembered)
+			}
+		}
+
+		cache.nextSweep = now.Add(time.Minute)
+	}
+
+	if rememberedUntil, ok := cache.ids[id]; ok && now.Before(rememberedUntil) {
+
+		return false, nil
+	}
+
+	cache.ids[id] = expiresAt
+	return true, nil
+}
+
+func NewReplayCache() *ReplayCache {
+
+	return &ReplayCache{ids: make(map[string]time.Time)}
+}
+
+// ConsentStorage keeps one consent per owner and client.
+type ConsentStorage struct {
+	mutex    sync.RWMutex
+	consents map[string]map[string]*server.Consent
+}
+
+func (storage *ConsentStorage) FindConsent(ownerId string, clientId string) (*server.Consent, error) {
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	consent, ok := storage.consents[ownerId][clientId]
+
+	if !ok {
+
+		return nil, fmt.Errorf("couldnt find a consent of owner %s for client %s", ownerId, clientId)
+	}
+
+	return consent, nil
+}
+
+func (storage *ConsentStorage) FindConsentsByOwnerId(ownerId string) ([]*server.Consent, error) {
+
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+	consents := make([]*server.Consent, 0, len(storage.consents[ownerId]))
+
+	for _, consent := range storage.consents[ownerId] {
+		consents = append(consents, consent)
 	}
+
+	sort.Slice(consents, func(i int, j int) bool { return consents[i].ClientId < consents[j].ClientId })
+	return consents, nil
+}
+
+func (storage *ConsentStorage) SaveConsent(consent *server.Consent) error {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	if _, ok := storage.consents[consent.OwnerId]; !ok {
+
+		storage.consents[consent.OwnerId] = make(map[string]*server.Consent)
+	}
+
+	storage.consents[consent.OwnerId][consent.ClientId] = consent
+	return nil
+}
+
+func (storage *ConsentStorage) DeleteConsent(consent *server.Consent) error {
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	delete(storage.consents[consent.OwnerId], consent.ClientId)
+	return nil
+}
+
+func NewConsentStorage() *ConsentStorage {
+
+	return &ConsentStorage{consents: make(map[string]map[string]*server.Consent)}
 }
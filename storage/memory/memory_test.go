@@ -0,0 +1,281 @@
+package memory
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yjv/goauth2-server/server"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func TestOwnerClientStorageClients(t *testing.T) {
+
+	storage := NewOwnerClientStorage()
+	client := &server.Client{Id: "app", Type: server.ConfidentialClient}
+
+	assert.Nil(t, storage.SaveClient(client, "secret"))
+
+	found, error := storage.FindClientByIdAndSecret("app", "secret")
+	assert.Nil(t, error)
+	assert.Equal(t, client, found)
+
+	//updating the client keeps its secret
+	assert.Nil(t, storage.UpdateClient(&server.Client{Id: "app", Name: "App"}))
+	found, error = storage.FindClientByIdAndSecret("app", "secret")
+	assert.Nil(t, error)
+	assert.Equal(t, "App", found.Name)
+	assert.EqualError(t, storage.UpdateClient(&server.Client{Id: "missing"}), "couldnt find the client with id missing")
+
+	//saving it with a new secret makes the old one stop working
+	assert.Nil(t, storage.SaveClient(client, "rotated"))
+	found, error = storage.FindClientByIdAndSecret("app", "secret")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "couldnt find the client with id app and secret")
+
+	clients, error := storage.FindAllClients()
+	assert.Nil(t, error)
+	assert.Equal(t, []*server.Client{client}, clients)
+
+	assert.Nil(t, storage.DeleteClient("app"))
+	assert.EqualError(t, storage.DeleteClient("app"), "couldnt find the client with id app")
+}
+
+func TestOwnerClientStorageOwners(t *testing.T) {
+
+	storage := NewOwnerClientStorage()
+	owner := &server.Owner{Id: "bob", Name: "Bob"}
+
+	assert.Nil(t, storage.SaveOwner(owner, "bob", "password"))
+	assert.Nil(t, storage.SaveOwner(owner, "bob", "changed"))
+
+	found, error := storage.FindOwnerByUsernameAndPassword("bob", "changed")
+	assert.Nil(t, error)
+	assert.Equal(t, owner, found)
+
+	found, error = storage.FindOwnerByUsernameAndPassword("bob", "password")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "couldnt find the owner by username bob and password")
+
+	assert.Nil(t, storage.DeleteOwner("bob"))
+	assert.EqualError(t, storage.DeleteOwner("bob"), "couldnt find the owner with id bob")
+}
+
+func TestOwnerClientStorageClientRegistrations(t *testing.T) {
+
+	storage := NewOwnerClientStorage()
+	registration := &server.ClientRegistration{Client: &server.Client{Id: "app", Type: server.ConfidentialClient}}
+
+	assert.Nil(t, storage.SaveClientRegistration(registration, "secret"))
+
+	found, error := storage.FindClientRegistrationById("app")
+	assert.Nil(t, error)
+	assert.Equal(t, registration, found)
+
+	//saving without a secret keeps the one the client has
+	assert.Nil(t, storage.SaveClientRegistration(registration, ""))
+	client, _ := storage.FindClientByIdAndSecret("app", "secret")
+	assert.Equal(t, registration.Client, client)
+
+	//a client that became public loses its secret
+	registration.Client.Type = server.PublicClient
+	assert.Nil(t, storage.SaveClientRegistration(registration, ""))
+	client, _ = storage.FindClientByIdAndSecret("app", "secret")
+	assert.Nil(t, client)
+
+	assert.Nil(t, storage.DeleteClientRegistration(registration))
+	found, error = storage.FindClientRegistrationById("app")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "couldnt find the client registration with id app")
+}
+
+func TestSessionStorage(t *testing.T) {
+
+	storage := NewSessionStorageWithClock(server.NewFakeClock(testNow))
+	session := testSession("session", "owner", "client", testNow)
+
+	storage.SaveSession(session)
+
+	found, error := storage.FindSessionByAccessToken("access-session")
+	assert.Nil(t, error)
+	assert.Equal(t, session, found)
+
+	//the storage hands out copies, changing one does not change what is saved
+	found.Scopes = map[string]*server.Scope{}
+	found.AccessToken.ExpiresAt = time.Time{}
+	found, error = storage.FindSessionByRefreshToken("refresh-session")
+	assert.Nil(t, error)
+	assert.Equal(t, session, found)
+
+	storage.DeleteSession(&server.Session{Id: "session"})
+	found, error = storage.FindSessionByAccessToken("access-session")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Session not found for access token")
+}
+
+func TestSessionStorageWithRotatedRefreshToken(t *testing.T) {
+
+	storage := NewSessionStorageWithClock(server.NewFakeClock(testNow))
+	session := testSession("session", "owner", "client", testNow)
+	storage.SaveSession(session)
+
+	session.AccessToken = server.NewToken("access-rotated", testNow, time.Hour)
+	session.RefreshToken = server.NewToken("refresh-rotated", testNow, 24*time.Hour)
+	storage.SaveSession(session)
+
+	_, error := storage.FindSessionByAccessToken("access-session")
+	assert.NotNil(t, error)
+
+	found, error := storage.FindSessionByRefreshToken("refresh-rotated")
+	assert.Nil(t, error)
+	assert.Equal(t, session, found)
+
+	//presenting the rotated out token again revokes the whole session
+	found, error = storage.FindSessionByRefreshToken("refresh-session")
+	assert.Nil(t, found)
+	assert.Equal(t, &server.RefreshTokenReusedError{}, error)
+	assert.Eventually(t, func() bool {
+
+		_, error := storage.FindSessionByRefreshToken("refresh-rotated")
+		return error != nil
+	}, time.Second, time.Millisecond)
+}
+
+func TestSessionStorageWhenExpired(t *testing.T) {
+
+	clock := server.NewFakeClock(testNow)
+	storage := NewSessionStorageWithClock(clock)
+	storage.SaveSession(testSession("session", "owner", "client", testNow))
+
+	clock.Advance(2 * time.Hour)
+	found, error := storage.FindSessionByAccessToken("access-session")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Access token for session session is expired")
+
+	found, error = storage.FindSessionByRefreshToken("refresh-session")
+	assert.Nil(t, error)
+	assert.Equal(t, "session", found.Id)
+
+	clock.Advance(24 * time.Hour)
+	count, error := storage.CountActiveSessions()
+	assert.Nil(t, error)
+	assert.Equal(t, 0, count)
+
+	found, error = storage.FindSessionById("session")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Session with id session not found")
+}
+
+func TestSessionStorageQuerySessions(t *testing.T) {
+
+	storage := NewSessionStorageWithClock(server.NewFakeClock(testNow))
+	second := testSession("b", "owner", "other", testNow.Add(time.Second))
+
+	for _, session := range []*server.Session{
+		testSession("c", "owner", "client", testNow.Add(2*time.Second)),
+		testSession("d", "stranger", "client", testNow),
+		testSession("a", "owner", "client", testNow),
+		second,
+	} {
+
+		storage.SaveSession(session)
+	}
+
+	page, error := storage.QuerySessions(&server.SessionFilter{OwnerId: "owner"}, nil, 2)
+	assert.Nil(t, error)
+	assert.Equal(t, []string{"a", "b"}, sessionIds(page.Sessions))
+	assert.Equal(t, &server.SessionCursor{CreatedAt: second.CreatedAt, SessionId: "b"}, page.NextCursor)
+
+	page, error = storage.QuerySessions(&server.SessionFilter{OwnerId: "owner"}, page.NextCursor, 2)
+	assert.Nil(t, error)
+	assert.Equal(t, []string{"c"}, sessionIds(page.Sessions))
+	assert.Nil(t, page.NextCursor)
+
+	page, error = storage.QuerySessions(&server.SessionFilter{ClientId: "client", Scope: "read"}, nil, 0)
+	assert.Nil(t, error)
+	assert.Equal(t, []string{"a", "d", "c"}, sessionIds(page.Sessions))
+
+	count, error := storage.CountSessions(&server.SessionFilter{OwnerId: "owner", ClientId: "client"})
+	assert.Nil(t, error)
+	assert.Equal(t, 2, count)
+}
+
+func TestConsentStorage(t *testing.T) {
+
+	storage := NewConsentStorage()
+	consent := &server.Consent{Id: "consent", OwnerId: "owner", ClientId: "client", Scopes: []string{"read"}}
+	other := &server.Consent{Id: "other", OwnerId: "owner", ClientId: "another", Scopes: []string{"read"}}
+
+	assert.Nil(t, storage.SaveConsent(consent))
+	assert.Nil(t, storage.SaveConsent(other))
+
+	found, error := storage.FindConsent("owner", "client")
+	assert.Nil(t, error)
+	assert.Equal(t, consent, found)
+
+	consents, error := storage.FindConsentsByOwnerId("owner")
+	assert.Nil(t, error)
+	assert.Equal(t, []*server.Consent{other, consent}, consents)
+
+	assert.Nil(t, storage.DeleteConsent(consent))
+	found, error = storage.FindConsent("owner", "client")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "couldnt find a consent of owner owner for client client")
+}
+
+func TestLimitStorageUpdateLimitState(t *testing.T) {
+
+	storage := NewLimitStorage()
+	increment := func(state *server.LimitState) { state.Failures++ }
+
+	state, error := storage.UpdateLimitState("client:app", increment)
+	assert.Nil(t, error)
+	assert.Equal(t, 1, state.Failures)
+
+	//the returned state is a copy of the one kept
+	state.Failures = 10
+	state, error = storage.UpdateLimitState("client:app", increment)
+	assert.Nil(t, error)
+	assert.Equal(t, 2, state.Failures)
+}
+
+func TestReplayCacheRemember(t *testing.T) {
+
+	cache := NewReplayCache()
+
+	remembered, error := cache.Remember("proof", testNow.Add(time.Minute), testNow)
+	assert.Nil(t, error)
+	assert.True(t, remembered)
+
+	remembered, _ = cache.Remember("proof", testNow.Add(time.Minute), testNow.Add(time.Second))
+	assert.False(t, remembered)
+
+	//once it expired the id is forgotten
+	remembered, _ = cache.Remember("proof", testNow.Add(3*time.Minute), testNow.Add(2*time.Minute))
+	assert.True(t, remembered)
+}
+
+func testSession(id string, ownerId string, clientId string, createdAt time.Time) *server.Session {
+
+	session := server.NewSession()
+	session.Id = id
+	session.Owner = &server.Owner{Id: ownerId}
+	session.Client = &server.Client{Id: clientId}
+	session.CreatedAt = createdAt
+	session.AccessToken = server.NewToken("access-"+id, createdAt, time.Hour)
+	session.RefreshToken = server.NewToken("refresh-"+id, createdAt, 24*time.Hour)
+	session.Scopes["read"] = &server.Scope{Id: "read", Name: "read"}
+	return session
+}
+
+func sessionIds(sessions []*server.Session) []string {
+
+	ids := []string{}
+
+	for _, session := range sessions {
+
+		ids = append(ids, session.Id)
+	}
+
+	return ids
+}
@@ -0,0 +1,25 @@
+package mongo
+
+import (
+	"github.com/yjv/goauth2-server/config"
+)
+
+// StorageFactory dials the mongo backend for the config builder.
+func StorageFactory(file *config.File) (*config.Storages, error) {
+
+	storage, error := Dial(file.Storage.Url, file.Storage.Database)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	return &config.Storages{
+		Client:  storage,
+		Owner:   storage,
+		Session: storage,
+		Scope:   storage,
+		Limit:   storage,
+		Consent: storage,
+	}, nil
+}
@@ -1 +1,1026 @@
 package mongo
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/yjv/goauth2-server/server"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"log/slog"
+	"time"
+)
+
+// limitStateAttempts is how often UpdateLimitState retries when another
+// request changed the same state in between.
+const limitStateAttempts = 5
+
+type clientDocument struct {
+	Id         string         `bson:"_id"`
+	SecretHash string         `bson:"secret_hash"`
+	Client     *server.Client `bson:"client"`
+}
+
+// clientRegistrationDocument keeps what dynamic registration knows about a
+// client next to its document in clients.
+type clientRegistrationDocument struct {
+	ClientId                    string                 `bson:"_id"`
+	RegistrationAccessTokenHash string                 `bson:"registration_access_token_hash"`
+	ClientIdIssuedAt            time.Time              `bson:"client_id_issued_at"`
+	ClientSecretExpiresAt       time.Time              `bson:"client_secret_expires_at"`
+	Metadata                    *server.ClientMetadata `bson:"metadata"`
+}
+
+type ownerDocument struct {
+	Id           string `bson:"_id"`
+	Username     string `bson:"username"`
+	PasswordHash string `bson:"password_hash"`
+	Name         string `bson:"name"`
+}
+
+type scopeDocument struct {
+	Name        string   `bson:"_id"`
+	Id          string   `bson:"id"`
+	Description string   `bson:"description,omitempty"`
+	Sensitive   bool     `bson:"sensitive,omitempty"`
+	Implies     []string `bson:"implies,omitempty"`
+}
+
+// sessionDocument stores the session with its token values left out, the
+// tokens are only stored hashed.
+type sessionDocument struct {
+	Id               string          `bson:"_id"`
+	AccessTokenHash  string          `bson:"access_token_hash"`
+	RefreshTokenHash string          `bson:"refresh_token_hash,omitempty"`
+	ClientId         string          `bson:"client_id"`
+	OwnerId          string          `bson:"owner_id"`
+	CreatedAt        time.Time       `bson:"created_at"`
+	ExpiresAt        time.Time       `bson:"expires_at"`
+	Scopes           []string        `bson:"scopes"`
+	Session          *server.Session `bson:"session"`
+}
+
+type consentDocument struct {
+	Id        string    `bson:"_id"`
+	OwnerId   string    `bson:"owner_id"`
+	ClientId  string    `bson:"client_id"`
+	Scopes    []string  `bson:"scopes"`
+	GrantedAt time.Time `bson:"granted_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+type retiredRefreshTokenDocument struct {
+	TokenHash string `bson:"_id"`
+	SessionId string `bson:"session_id"`
+}
+
+type limitStateDocument struct {
+	Key     string             `bson:"_id"`
+	Version int                `bson:"version"`
+	State   *server.LimitState `bson:"state"`
+}
+
+// Storage keeps clients, owners, scopes, sessions, consents and limit states
+// in MongoDB.
+// Tokens, client secrets and passwords are only stored hashed, so a session
+// found by one of its tokens comes back without the other token's value.
+type Storage struct {
+	session      *mgo.Session
+	database     string
+	clock        server.Clock
+	errorHandler func(error error)
+}
+
+// EnsureIndexes creates the indexes the lookups rely on.
+func (storage *Storage) EnsureIndexes() error {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	database := session.DB(storage.database)
+
+	for collection, indexes := range map[string][]mgo.Index{
+		"owners": {
+			{Key: []string{"username"}, Unique: true},
+		},
+		"sessions": {
+			{Key: []string{"access_token_hash"}, Unique: true},
+			{Key: []string{"refresh_token_hash"}, Unique: true, Sparse: true},
+			{Key: []string{"client_id"}},
+			{Key: []string{"owner_id"}},
+			{Key: []string{"scopes"}},
+		},
+		"consents": {
+			{Key: []string{"owner_id", "client_id"}, Unique: true},
+		},
+		"retired_refresh_tokens": {
+			{Key: []string{"session_id"}},
+		},
+	} {
+
+		for _, index := range indexes {
+
+			if error := database.C(collection).EnsureIndex(index); error != nil {
+
+				return error
+			}
+		}
+	}
+
+	return nil
+}
+
+func (storage *Storage) Ping() error {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	return session.Ping()
+}
+
+func (storage *Storage) Close() {
+
+	storage.session.Close()
+}
+
+// SetErrorHandler is called with the errors of the methods that can not
+// return them, like SaveSession. Errors are logged with slog by default.
+func (storage *Storage) SetErrorHandler(errorHandler func(error error)) *Storage {
+
+	storage.errorHandler = errorHandler
+	return storage
+}
+
+func (storage *Storage) SetClock(clock server.Clock) *Storage {
+
+	storage.clock = clock
+	return storage
+}
+
+func (storage *Storage) FindClientById(clientId string) (*server.Client, error) {
+
+	document, error := storage.findClient(clientId)
+
+	if document == nil {
+
+		return nil, error
+	}
+
+	return document.Client, nil
+}
+
+func (storage *Storage) FindClientByIdAndSecret(clientId string, clientSecret string) (*server.Client, error) {
+
+	document, error := storage.findClient(clientId)
+
+	if document == nil {
+
+		return nil, error
+	}
+
+	if subtle.ConstantTimeCompare([]byte(document.SecretHash), []byte(server.HashToken(clientSecret))) != 1 {
+
+		return nil, fmt.Errorf("Client with id %s and secret not found", clientId)
+	}
+
+	return document.Client, nil
+}
+
+func (storage *Storage) RefreshClient(client *server.Client) (*server.Client, error) {
+
+	return storage.FindClientById(client.Id)
+}
+
+func (storage *Storage) findClient(clientId string) (*clientDocument, error) {
+
+	document := &clientDocument{}
+
+	if error := storage.findOne("clients", bson.M{"_id": clientId}, document); error != nil {
+
+		return nil, notFound(error, "Client with id %s not found", clientId)
+	}
+
+	return document, nil
+}
+
+// SaveClient creates or replaces the client, public clients are saved with an
+// empty secret.
+func (storage *Storage) SaveClient(client *server.Client, clientSecret string) error {
+
+	document := &clientDocument{client.Id, "", client}
+
+	if !client.IsPublic() {
+
+		document.SecretHash = server.HashToken(clientSecret)
+	}
+
+	return storage.upsert("clients", client.Id, document)
+}
+
+func (storage *Storage) UpdateClient(client *server.Client) error {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	return notFound(
+		session.DB(storage.database).C("clients").UpdateId(client.Id, bson.M{"$set": bson.M{"client": client}}),
+		"Client with id %s not found",
+		client.Id,
+	)
+}
+
+func (storage *Storage) FindAllClients() ([]*server.Client, error) {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	documents := []*clientDocument{}
+
+	if error := session.DB(storage.database).C("clients").Find(nil).Sort("_id").All(&documents); error != nil {
+
+		return nil, error
+	}
+
+	clients := make([]*server.Client, 0, len(documents))
+
+	for _, document := range documents {
+
+		clients = append(clients, document.Client)
+	}
+
+	return clients, nil
+}
+
+func (storage *Storage) DeleteClient(clientId string) error {
+
+	return storage.remove("clients", clientId, "Client with id %s not found")
+}
+
+// SaveClientRegistration saves the client along with its registration. An
+// empty secret keeps the stored one, unless the client became public.
+func (storage *Storage) SaveClientRegistration(registration *server.ClientRegistration, clientSecret string) error {
+
+	client := registration.Client
+	document := &clientDocument{client.Id, "", client}
+
+	if clientSecret != "" {
+
+		document.SecretHash = server.HashToken(clientSecret)
+	}
+
+	if clientSecret == "" && !client.IsPublic() {
+
+		if stored, _ := storage.findClient(client.Id); stored != nil {
+
+			document.SecretHash = stored.SecretHash
+		}
+	}
+
+	if error := storage.upsert("clients", client.Id, document); error != nil {
+
+		return error
+	}
+
+	return storage.upsert("client_registrations", client.Id, &clientRegistrationDocument{
+		client.Id,
+		registration.RegistrationAccessTokenHash,
+		registration.ClientIdIssuedAt,
+		registration.ClientSecretExpiresAt,
+		registration.Metadata,
+	})
+}
+
+func (storage *Storage) FindClientRegistrationById(clientId string) (*server.ClientRegistration, error) {
+
+	document := &clientRegistrationDocument{}
+
+	if error := storage.findOne("client_registrations", bson.M{"_id": clientId}, document); error != nil {
+
+		return nil, notFound(error, "Client registration with id %s not found", clientId)
+	}
+
+	client, error := storage.FindClientById(clientId)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	return &server.ClientRegistration{
+		Client:                      client,
+		Metadata:                    document.Metadata,
+		RegistrationAccessTokenHash: document.RegistrationAccessTokenHash,
+		ClientIdIssuedAt:            document.ClientIdIssuedAt,
+		ClientSecretExpiresAt:       document.ClientSecretExpiresAt,
+	}, nil
+}
+
+func (storage *Storage) DeleteClientRegistration(registration *server.ClientRegistration) error {
+
+	session := storage.session.Copy()
+	defer session.Close()
+
+	for _, collection := range []string{"client_registrations", "clients"} {
+
+		if error := session.DB(storage.database).C(collection).RemoveId(registration.Client.Id); error != nil && error != mgo.ErrNotFound {
+
+			return error
+		}
+	}
+
+	return nil
+}
+
+func (storage *Storage) FindOwnerByUsername(username string) (*server.Owner, error) {
+
+	document, error := storage.findOwner(bson.M{"username": username})
+
+	if document == nil {
+
+		return nil, error
+	}
+
+	return &server.Owner{Id: document.Id, Name: document.Name}, nil
+}
+
+func (storage *Storage) FindOwnerByUsernameAndPassword(username string, password string) (*server.Owner, error) {
+
+	document, error := storage.findOwner(bson.M{"username": username})
+
+	if document == nil {
+
+		return nil, error
+	}
+
+	if !server.CheckPassword(document.PasswordHash, password) {
+
+		return nil, fmt.Errorf("Owner with username %s and password not found", username)
+	}
+
+	return &server.Owner{Id: document.Id, Name: document.Name}, nil
+}
+
+func (storage *Storage) RefreshOwner(owner *server.Owner) (*server.Owner, error) {
+
+	document, error := storage.findOwner(bson.M{"_id": owner.Id})
+
+	if document == nil {
+
+		return nil, error
+	}
+
+	return &server.Owner{Id: document.Id, Name: document.Name}, nil
+}
+
+func (storage *Storage) findOwner(query bson.M) (*ownerDocument, error) {
+
+	document := &ownerDocument{}
+
+	if error := storage.findOne("owners", query, document); error != nil {
+
+		return nil, notFound(error, "Owner matching %v not found", query)
+	}
+
+	return document, nil
+}
+
+// SaveOwner creates or replaces the owner with the username and password it
+// logs in with, the password is hashed with server.HashPassword.
+func (storage *Storage) SaveOwner(owner *server.Owner, username string, password string) error {
+
+	passwordHash, error := server.HashPassword(password)
+
+	if error != nil {
+
+		return error
+	}
+
+	return storage.upsert("owners", owner.Id, &ownerDocument{owner.Id, username, passwordHash, owner.Name})
+}
+
+func (storage *Storage) DeleteOwner(ownerId string) error {
+
+	return storage.remove("owners", ownerId, "Owner with id %s not found")
+}
+
+func (storage *Storage) FindScopeByName(name string) (*server.Scope, error) {
+
+	document := &scopeDocument{}
+
+	if error := storage.findOne("scopes", bson.M{"_id": name}, document); error != nil {
+
+		return nil, notFound(error, "Scope named %s not found", name)
+	}
+
+	return scopeFromDocument(document), nil
+}
+
+func (storage *Storage) FindAllScopes() ([]*server.Scope, error) {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	documents := []*scopeDocument{}
+
+	if error := session.DB(storage.database).C("scopes").Find(nil).Sort("_id").All(&documents); error != nil {
+
+		return nil, error
+	}
+
+	scopes := make([]*server.Scope, 0, len(documents))
+
+	for _, document := range documents {
+
+		scopes = append(scopes, scopeFromDocument(document))
+	}
+
+	return scopes, nil
+}
+
+func (storage *Storage) SaveScope(scope *server.Scope) error {
+
+	return storage.upsert("scopes", scope.Name, &scopeDocument{scope.Name, scope.Id, scope.Description, scope.Sensitive, scope.Implies})
+}
+
+func (storage *Storage) DeleteScope(name string) error {
+
+	return storage.remove("scopes", name, "Scope named %s not found")
+}
+
+func (storage *Storage) FindSessionByAccessToken(accessToken string) (*server.Session, error) {
+
+	document, error := storage.findSession(bson.M{"access_token_hash": server.HashToken(accessToken)})
+
+	if document == nil {
+
+		return nil, error
+	}
+
+	session := document.Session
+	session.AccessToken.Token = accessToken
+
+	if storage.isExpired(session.AccessToken) {
+
+		if storage.isExpired(session.RefreshToken) {
+
+			storage.DeleteSession(session)
+		}
+
+		return nil, fmt.Errorf("Access token for session %s is expired", session.Id)
+	}
+
+	return session, nil
+}
+
+// FindSessionByRefreshToken revokes the whole session when a refresh token it
+// rotated out is presented again.
+func (storage *Storage) FindSessionByRefreshToken(refreshToken string) (*server.Session, error) {
+
+	refreshTokenHash := server.HashToken(refreshToken)
+	document, error := storage.findSession(bson.M{"refresh_token_hash": refreshTokenHash})
+
+	if document == nil {
+
+		retired := &retiredRefreshTokenDocument{}
+
+		if storage.findOne("retired_refresh_tokens", bson.M{"_id": refreshTokenHash}, retired) == nil {
+
+			storage.DeleteSession(&server.Session{Id: retired.SessionId})
+			return nil, &server.RefreshTokenReusedError{}
+		}
+
+		return nil, error
+	}
+
+	session := document.Session
+
+	if session.RefreshToken == nil || storage.isExpired(session.RefreshToken) {
+
+		storage.DeleteSession(session)
+		return nil, fmt.Errorf("Refresh token for session %s is expired", session.Id)
+	}
+
+	session.RefreshToken.Token = refreshToken
+	return session, nil
+}
+
+func (storage *Storage) findSession(query bson.M) (*sessionDocument, error) {
+
+	document := &sessionDocument{}
+
+	if error := storage.findOne("sessions", query, document); error != nil {
+
+		return nil, notFound(error, "Session not found")
+	}
+
+	sessionFromDocument(document)
+	return document, nil
+}
+
+// sessionFromDocument fills in what bson leaves out of a stored session.
+func sessionFromDocument(document *sessionDocument) *server.Session {
+
+	if document.Session.Scopes == nil {
+
+		document.Session.Scopes = make(map[string]*server.Scope)
+	}
+
+	if document.Session.ExtraData == nil {
+
+		document.Session.ExtraData = make(map[string]string)
+	}
+
+	document.Session.Id = document.Id
+	return document.Session
+}
+
+// SaveSession keeps the hash that is already stored for a token that was
+// loaded without its value.
+func (storage *Storage) SaveSession(session *server.Session) {
+
+	mongoSession := storage.session.Copy()
+	defer mongoSession.Close()
+	database := mongoSession.DB(storage.database)
+	stored := &sessionDocument{}
+
+	if error := database.C("sessions").FindId(session.Id).One(stored); error != nil && error != mgo.ErrNotFound {
+
+		storage.handleError(error)
+		return
+	}
+
+	document := &sessionDocument{
+		Id:              session.Id,
+		AccessTokenHash: tokenHash(session.AccessToken, stored.AccessTokenHash),
+		ClientId:        sessionClientId(session),
+		OwnerId:         sessionOwnerId(session),
+		CreatedAt:       session.CreatedAt,
+		ExpiresAt:       sessionExpiresAt(session),
+		Scopes:          make([]string, 0, len(session.Scopes)),
+		Session:         withoutTokenValues(session),
+	}
+
+	for scope := range session.Scopes {
+		document.Scopes = append(document.Scopes, scope)
+	}
+
+	if session.RefreshToken != nil {
+
+		document.RefreshTokenHash = tokenHash(session.RefreshToken, stored.RefreshTokenHash)
+	}
+
+	if stored.RefreshTokenHash != "" && stored.RefreshTokenHash != document.RefreshTokenHash {
+
+		retired := &retiredRefreshTokenDocument{stored.RefreshTokenHash, session.Id}
+
+		if _, error := database.C("retired_refresh_tokens").UpsertId(retired.TokenHash, retired); error != nil {
+
+			storage.handleError(error)
+			return
+		}
+	}
+
+	_, error := database.C("sessions").UpsertId(session.Id, document)
+	storage.handleError(error)
+}
+
+func (storage *Storage) DeleteSession(session *server.Session) {
+
+	mongoSession := storage.session.Copy()
+	defer mongoSession.Close()
+	database := mongoSession.DB(storage.database)
+
+	if _, error := database.C("retired_refresh_tokens").RemoveAll(bson.M{"session_id": session.Id}); error != nil {
+
+		storage.handleError(error)
+		return
+	}
+
+	if error := database.C("sessions").RemoveId(session.Id); error != nil && error != mgo.ErrNotFound {
+
+		storage.handleError(error)
+	}
+}
+
+func (storage *Storage) FindSessionById(sessionId string) (*server.Session, error) {
+
+	sessions, error := storage.findSessions(bson.M{"_id": sessionId}, 0)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	if len(sessions) == 0 {
+
+		return nil, fmt.Errorf("Session with id %s not found", sessionId)
+	}
+
+	return sessions[0], nil
+}
+
+func (storage *Storage) FindSessionsByOwnerId(ownerId string) ([]*server.Session, error) {
+
+	return storage.findSessions(bson.M{"owner_id": ownerId}, 0)
+}
+
+func (storage *Storage) FindSessionsByClientId(clientId string) ([]*server.Session, error) {
+
+	return storage.findSessions(bson.M{"client_id": clientId}, 0)
+}
+
+func (storage *Storage) FindSessionsByScope(scope string) ([]*server.Session, error) {
+
+	return storage.findSessions(bson.M{"scopes": scope}, 0)
+}
+
+// QuerySessions fetches one session more than the limit to find out whether
+// there is a next page.
+func (storage *Storage) QuerySessions(filter *server.SessionFilter, cursor *server.SessionCursor, limit int) (*server.SessionPage, error) {
+
+	query := sessionFilterQuery(filter)
+
+	if cursor != nil {
+
+		query["$and"] = []bson.M{{"$or": []bson.M{
+			{"created_at": bson.M{"$gt": cursor.CreatedAt}},
+			{"created_at": cursor.CreatedAt, "_id": bson.M{"$gt": cursor.SessionId}},
+		}}}
+	}
+
+	fetch := 0
+
+	if limit > 0 {
+
+		fetch = limit + 1
+	}
+
+	sessions, error := storage.findSessions(query, fetch)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	return server.NewSessionPage(sessions, limit), nil
+}
+
+func (storage *Storage) CountSessions(filter *server.SessionFilter) (int, error) {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	query := sessionFilterQuery(filter)
+	query["$or"] = storage.activeSessionQuery()
+	return session.DB(storage.database).C("sessions").Find(query).Count()
+}
+
+// findSessions returns the active sessions matching the query, oldest first
+// and without their token values. A limit of zero returns them all.
+func (storage *Storage) findSessions(query bson.M, limit int) ([]*server.Session, error) {
+
+	mongoSession := storage.session.Copy()
+	defer mongoSession.Close()
+	documents := []*sessionDocument{}
+	query["$or"] = storage.activeSessionQuery()
+
+	if error := mongoSession.DB(storage.database).C("sessions").Find(query).Sort("created_at", "_id").Limit(limit).All(&documents); error != nil {
+
+		return nil, error
+	}
+
+	sessions := make([]*server.Session, 0, len(documents))
+
+	for _, document := range documents {
+
+		sessions = append(sessions, sessionFromDocument(document))
+	}
+
+	return sessions, nil
+}
+
+func (storage *Storage) CountActiveSessions() (int, error) {
+
+	session := storage.session.Copy()
+	defer session.Close()
+
+	return session.DB(storage.database).C("sessions").Find(bson.M{"$or": storage.activeSessionQuery()}).Count()
+}
+
+// activeSessionQuery matches sessions with a token that can still be used.
+func (storage *Storage) activeSessionQuery() []bson.M {
+
+	return []bson.M{
+		{"expires_at": time.Time{}},
+		{"expires_at": bson.M{"$gt": storage.clock.Now()}},
+	}
+}
+
+func (storage *Storage) FindConsent(ownerId string, clientId string) (*server.Consent, error) {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	document := &consentDocument{}
+
+	if error := session.DB(storage.database).C("consents").Find(bson.M{"owner_id": ownerId, "client_id": clientId}).One(document); error != nil {
+
+		return nil, notFound(error, "Consent of owner %s for client %s not found", ownerId, clientId)
+	}
+
+	return consentFromDocument(document), nil
+}
+
+func (storage *Storage) FindConsentsByOwnerId(ownerId string) ([]*server.Consent, error) {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	documents := []*consentDocument{}
+
+	if error := session.DB(storage.database).C("consents").Find(bson.M{"owner_id": ownerId}).Sort("client_id").All(&documents); error != nil {
+
+		return nil, error
+	}
+
+	consents := make([]*server.Consent, 0, len(documents))
+
+	for _, document := range documents {
+
+		consents = append(consents, consentFromDocument(document))
+	}
+
+	return consents, nil
+}
+
+// SaveConsent replaces the consent the owner had for the client, which may
+// have had another id.
+func (storage *Storage) SaveConsent(consent *server.Consent) error {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	collection := session.DB(storage.database).C("consents")
+
+	if _, error := collection.RemoveAll(bson.M{"owner_id": consent.OwnerId, "client_id": consent.ClientId, "_id": bson.M{"$ne": consent.Id}}); error != nil {
+
+		return error
+	}
+
+	_, error := collection.UpsertId(consent.Id, &consentDocument{
+		Id:        consent.Id,
+		OwnerId:   consent.OwnerId,
+		ClientId:  consent.ClientId,
+		Scopes:    consent.Scopes,
+		GrantedAt: consent.GrantedAt,
+		UpdatedAt: consent.UpdatedAt,
+		ExpiresAt: consent.ExpiresAt,
+	})
+	return error
+}
+
+func (storage *Storage) DeleteConsent(consent *server.Consent) error {
+
+	session := storage.session.Copy()
+	defer session.Close()
+
+	if error := session.DB(storage.database).C("consents").RemoveId(consent.Id); error != mgo.ErrNotFound {
+
+		return error
+	}
+
+	return nil
+}
+
+// UpdateLimitState reads, updates and writes the state back only when its
+// version did not change in between, retrying when it did.
+func (storage *Storage) UpdateLimitState(key string, update func(state *server.LimitState)) (*server.LimitState, error) {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	collection := session.DB(storage.database).C("limit_states")
+
+	for attempt := 0; attempt < limitStateAttempts; attempt++ {
+
+		document := &limitStateDocument{}
+		error := collection.FindId(key).One(document)
+
+		if error != nil && error != mgo.ErrNotFound {
+
+			return nil, error
+		}
+
+		if error == mgo.ErrNotFound {
+
+			document = &limitStateDocument{key, 0, &server.LimitState{}}
+		}
+
+		version := document.Version
+		update(document.State)
+		document.Version++
+
+		if version == 0 {
+
+			error = collection.Insert(document)
+		} else {
+
+			error = collection.Update(bson.M{"_id": key, "version": version}, document)
+		}
+
+		if error == nil {
+
+			return document.State, nil
+		}
+
+		if error != mgo.ErrNotFound && !mgo.IsDup(error) {
+
+			return nil, error
+		}
+	}
+
+	return nil, fmt.Errorf("limit state %s kept changing while it was updated", key)
+}
+
+func (storage *Storage) findOne(collection string, query bson.M, document interface{}) error {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	return session.DB(storage.database).C(collection).Find(query).One(document)
+}
+
+func (storage *Storage) upsert(collection string, id string, document interface{}) error {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	_, error := session.DB(storage.database).C(collection).UpsertId(id, document)
+	return error
+}
+
+func (storage *Storage) remove(collection string, id string, notFoundFormat string) error {
+
+	session := storage.session.Copy()
+	defer session.Close()
+	return notFound(session.DB(storage.database).C(collection).RemoveId(id), notFoundFormat, id)
+}
+
+func (storage *Storage) isExpired(token *server.Token) bool {
+
+	return token == nil || token.IsExpired(storage.clock.Now())
+}
+
+func (storage *Storage) handleError(error error) {
+
+	if error != nil {
+
+		storage.errorHandler(error)
+	}
+}
+
+func tokenHash(token *server.Token, storedHash string) string {
+
+	if token.Token == "" {
+
+		return storedHash
+	}
+
+	return server.HashToken(token.Token)
+}
+
+// withoutTokenValues copies the session leaving the token values out so they
+// only end up in the database hashed.
+func withoutTokenValues(session *server.Session) *server.Session {
+
+	stored := *session
+
+	if session.AccessToken != nil {
+
+		stored.AccessToken = &server.Token{IssuedAt: session.AccessToken.IssuedAt, ExpiresAt: session.AccessToken.ExpiresAt}
+	}
+
+	if session.RefreshToken != nil {
+
+		stored.RefreshToken = &server.Token{IssuedAt: session.RefreshToken.IssuedAt, ExpiresAt: session.RefreshToken.ExpiresAt}
+	}
+
+	return &stored
+}
+
+func sessionClientId(session *server.Session) string {
+
+	if session.Client == nil {
+
+		return ""
+	}
+
+	return session.Client.Id
+}
+
+func sessionOwnerId(session *server.Session) string {
+
+	if session.Owner == nil {
+
+		return ""
+	}
+
+	return session.Owner.Id
+}
+
+// sessionExpiresAt is when the last of the session's tokens expires, the zero
+// time when one of them never does.
+func sessionExpiresAt(session *server.Session) time.Time {
+
+	expiresAt := session.AccessToken.ExpiresAt
+
+	if session.RefreshToken == nil || expiresAt.IsZero() {
+
+		return expiresAt
+	}
+
+	if session.RefreshToken.ExpiresAt.IsZero() || session.RefreshToken.ExpiresAt.After(expiresAt) {
+
+		return session.RefreshToken.ExpiresAt
+	}
+
+	return expiresAt
+}
+
+func scopeFromDocument(document *scopeDocument) *server.Scope {
+
+	return &server.Scope{
+		Id:          document.Id,
+		Name:        document.Name,
+		Description: document.Description,
+		Sensitive:   document.Sensitive,
+		Implies:     document.Implies,
+	}
+}
+
+func consentFromDocument(document *consentDocument) *server.Consent {
+
+	return &server.Consent{
+		Id:        document.Id,
+		OwnerId:   document.OwnerId,
+		ClientId:  document.ClientId,
+		Scopes:    document.Scopes,
+		GrantedAt: document.GrantedAt,
+		UpdatedAt: document.UpdatedAt,
+		ExpiresAt: document.ExpiresAt,
+	}
+}
+
+func sessionFilterQuery(filter *server.SessionFilter) bson.M {
+
+	query := bson.M{}
+
+	if filter.OwnerId != "" {
+
+		query["owner_id"] = filter.OwnerId
+	}
+
+	if filter.ClientId != "" {
+
+		query["client_id"] = filter.ClientId
+	}
+
+	if filter.Scope != "" {
+
+		query["scopes"] = filter.Scope
+	}
+
+	return query
+}
+
+func notFound(error error, format string, arguments ...interface{}) error {
+
+	if error == mgo.ErrNotFound {
+
+		return fmt.Errorf(format, arguments...)
+	}
+
+	return error
+}
+
+func New(session *mgo.Session, database string) *Storage {
+
+	return &Storage{
+		session,
+		database,
+		server.NewSystemClock(),
+		func(error error) {
+
+			slog.Error("mongo storage error", "error", error)
+		},
+	}
+}
+
+// Dial connects to the url and makes sure the indexes exist.
+func Dial(url string, database string) (*Storage, error) {
+
+	session, error := mgo.Dial(url)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	storage := New(session, database)
+
+	if error := storage.EnsureIndexes(); error != nil {
+
+		session.Close()
+		return nil, error
+	}
+
+	return storage, nil
+}
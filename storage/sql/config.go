@@ -0,0 +1,26 @@
+package sql
+
+import (
+	"github.com/yjv/goauth2-server/config"
+)
+
+// StorageFactory opens the sql backend for the config builder. The driver
+// named in the config has to be imported by the program.
+func StorageFactory(file *config.File) (*config.Storages, error) {
+
+	storage, error := Open(file.Storage.Driver, file.Storage.Dsn)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	return &config.Storages{
+		Client:  storage,
+		Owner:   storage,
+		Session: storage,
+		Scope:   storage,
+		Limit:   storage,
+		Consent: storage,
+	}, nil
+}
@@ -0,0 +1,1270 @@
+package sql
+
+import (
+	"crypto/subtle"
+	database "database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/yjv/goauth2-server/server"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage keeps clients, owners, scopes, sessions, consents and limit states
+// in a SQL database through database/sql. Tokens, client secrets and
+// passwords are only stored hashed, so a session found by one of its tokens
+// comes back without the other token's value.
+//
+// Postgres, MySQL and SQLite are supported, the driver name picks the
+// placeholder style and locking clause the queries are written with.
+type Storage struct {
+	db           *database.DB
+	dialect      *dialect
+	clock        server.Clock
+	errorHandler func(error error)
+}
+
+type dialect struct {
+	numberedPlaceholders bool
+	forUpdate            string
+}
+
+func dialectFor(driver string) *dialect {
+
+	switch driver {
+	case "postgres", "pgx":
+		return &dialect{true, " FOR UPDATE"}
+	case "sqlite3", "sqlite":
+		//sqlite locks the whole database for writes so there is nothing to add
+		return &dialect{false, ""}
+	default:
+		return &dialect{false, " FOR UPDATE"}
+	}
+}
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS clients (
+		id VARCHAR(255) PRIMARY KEY,
+		secret_hash VARCHAR(64) NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS client_registrations (
+		client_id VARCHAR(255) PRIMARY KEY,
+		registration_access_token_hash VARCHAR(64) NOT NULL,
+		client_id_issued_at BIGINT NOT NULL,
+		client_secret_expires_at BIGINT NOT NULL,
+		metadata TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS owners (
+		id VARCHAR(255) PRIMARY KEY,
+		username VARCHAR(255) NOT NULL UNIQUE,
+		password_hash VARCHAR(255) NOT NULL,
+		name VARCHAR(255) NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS scopes (
+		name VARCHAR(255) PRIMARY KEY,
+		id VARCHAR(255) NOT NULL,
+		description TEXT NOT NULL,
+		sensitive BOOLEAN NOT NULL,
+		implies TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS sessions (
+		id VARCHAR(255) PRIMARY KEY,
+		access_token_hash VARCHAR(64) NOT NULL UNIQUE,
+		refresh_token_hash VARCHAR(64) NULL UNIQUE,
+		client_id VARCHAR(255) NOT NULL,
+		owner_id VARCHAR(255) NOT NULL,
+		created_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS session_scopes (
+		session_id VARCHAR(255) NOT NULL,
+		scope VARCHAR(255) NOT NULL,
+		PRIMARY KEY (session_id, scope)
+	)`,
+	`CREATE TABLE IF NOT EXISTS retired_refresh_tokens (
+		token_hash VARCHAR(64) PRIMARY KEY,
+		session_id VARCHAR(255) NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS consents (
+		id VARCHAR(255) PRIMARY KEY,
+		owner_id VARCHAR(255) NOT NULL,
+		client_id VARCHAR(255) NOT NULL,
+		scopes TEXT NOT NULL,
+		granted_at BIGINT NOT NULL,
+		updated_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		UNIQUE (owner_id, client_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS limit_states (
+		limit_key VARCHAR(255) PRIMARY KEY,
+		tokens DOUBLE PRECISION NOT NULL,
+		updated_at BIGINT NOT NULL,
+		failures INTEGER NOT NULL,
+		locked_until BIGINT NOT NULL
+	)`,
+}
+
+const scopeQuery = "SELECT name, id, description, sensitive, implies FROM scopes"
+
+// scopeCondition matches the sessions that were granted a scope.
+const scopeCondition = "id IN (SELECT session_id FROM session_scopes WHERE scope = ?)"
+
+// CreateSchema creates the tables that do not exist yet.
+func (storage *Storage) CreateSchema() error {
+
+	for _, statement := range schema {
+
+		if _, error := storage.db.Exec(statement); error != nil {
+
+			return error
+		}
+	}
+
+	return nil
+}
+
+func (storage *Storage) Ping() error {
+
+	return storage.db.Ping()
+}
+
+func (storage *Storage) Close() error {
+
+	return storage.db.Close()
+}
+
+func (storage *Storage) DB() *database.DB {
+
+	return storage.db
+}
+
+// SetErrorHandler is called with the errors of the methods that can not
+// return them, like SaveSession. Errors are logged with slog by default.
+func (storage *Storage) SetErrorHandler(errorHandler func(error error)) *Storage {
+
+	storage.errorHandler = errorHandler
+	return storage
+}
+
+func (storage *Storage) SetClock(clock server.Clock) *Storage {
+
+	storage.clock = clock
+	return storage
+}
+
+func (storage *Storage) FindClientById(clientId string) (*server.Client, error) {
+
+	client, _, error := storage.findClient(clientId)
+	return client, error
+}
+
+func (storage *Storage) FindClientByIdAndSecret(clientId string, clientSecret string) (*server.Client, error) {
+
+	client, secretHash, error := storage.findClient(clientId)
+
+	if client == nil {
+
+		return nil, error
+	}
+
+	if subtle.ConstantTimeCompare([]byte(secretHash), []byte(server.HashToken(clientSecret))) != 1 {
+
+		return nil, fmt.Errorf("Client with id %s and secret not found", clientId)
+	}
+
+	return client, nil
+}
+
+func (storage *Storage) RefreshClient(client *server.Client) (*server.Client, error) {
+
+	return storage.FindClientById(client.Id)
+}
+
+func (storage *Storage) findClient(clientId string) (*server.Client, string, error) {
+
+	var secretHash, data string
+	row := storage.queryRow("SELECT secret_hash, data FROM clients WHERE id = ?", clientId)
+
+	if error := row.Scan(&secretHash, &data); error != nil {
+
+		return nil, "", notFound(error, "Client with id %s not found", clientId)
+	}
+
+	client := &server.Client{}
+
+	if error := json.Unmarshal([]byte(data), client); error != nil {
+
+		return nil, "", error
+	}
+
+	return client, secretHash, nil
+}
+
+// SaveClient creates or replaces the client, public clients are saved with an
+// empty secret.
+func (storage *Storage) SaveClient(client *server.Client, clientSecret string) error {
+
+	secretHash := ""
+
+	if !client.IsPublic() {
+
+		secretHash = server.HashToken(clientSecret)
+	}
+
+	return storage.transaction(func(transaction *database.Tx) error {
+
+		data, error := json.Marshal(client)
+
+		if error != nil {
+
+			return error
+		}
+
+		if _, error := storage.execTx(transaction, "DELETE FROM clients WHERE id = ?", client.Id); error != nil {
+
+			return error
+		}
+
+		_, error = storage.execTx(transaction, "INSERT INTO clients (id, secret_hash, data) VALUES (?, ?, ?)", client.Id, secretHash, string(data))
+		return error
+	})
+}
+
+func (storage *Storage) UpdateClient(client *server.Client) error {
+
+	data, error := json.Marshal(client)
+
+	if error != nil {
+
+		return error
+	}
+
+	result, error := storage.db.Exec(storage.rebind("UPDATE clients SET data = ? WHERE id = ?"), string(data), client.Id)
+
+	if error != nil {
+
+		return error
+	}
+
+	if updated, error := result.RowsAffected(); error == nil && updated == 0 {
+
+		return fmt.Errorf("Client with id %s not found", client.Id)
+	}
+
+	return nil
+}
+
+func (storage *Storage) FindAllClients() ([]*server.Client, error) {
+
+	rows, error := storage.db.Query("SELECT data FROM clients ORDER BY id")
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	defer rows.Close()
+	clients := []*server.Client{}
+
+	for rows.Next() {
+
+		var data string
+		client := &server.Client{}
+
+		if error := rows.Scan(&data); error != nil {
+
+			return nil, error
+		}
+
+		if error := json.Unmarshal([]byte(data), client); error != nil {
+
+			return nil, error
+		}
+
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+func (storage *Storage) DeleteClient(clientId string) error {
+
+	return storage.deleteOne("DELETE FROM clients WHERE id = ?", "Client with id %s not found", clientId)
+}
+
+// SaveClientRegistration saves the client along with its registration. An
+// empty secret keeps the stored one, unless the client became public.
+func (storage *Storage) SaveClientRegistration(registration *server.ClientRegistration, clientSecret string) error {
+
+	client := registration.Client
+
+	return storage.transaction(func(transaction *database.Tx) error {
+
+		clientData, error := json.Marshal(client)
+
+		if error != nil {
+
+			return error
+		}
+
+		metadata, error := json.Marshal(registration.Metadata)
+
+		if error != nil {
+
+			return error
+		}
+
+		secretHash := ""
+
+		if clientSecret != "" {
+
+			secretHash = server.HashToken(clientSecret)
+		}
+
+		if clientSecret == "" && !client.IsPublic() {
+
+			row := storage.queryRowTx(transaction, "SELECT secret_hash FROM clients WHERE id = ?"+storage.dialect.forUpdate, client.Id)
+
+			if error := row.Scan(&secretHash); error != nil && error != database.ErrNoRows {
+
+				return error
+			}
+		}
+
+		for _, query := range []string{"DELETE FROM clients WHERE id = ?", "DELETE FROM client_registrations WHERE client_id = ?"} {
+
+			if _, error := storage.execTx(transaction, query, client.Id); error != nil {
+
+				return error
+			}
+		}
+
+		if _, error := storage.execTx(transaction, "INSERT INTO clients (id, secret_hash, data) VALUES (?, ?, ?)", client.Id, secretHash, string(clientData)); error != nil {
+
+			return error
+		}
+
+		_, error = storage.execTx(
+			transaction,
+			`INSERT INTO client_registrations (client_id, registration_access_token_hash, client_id_issued_at, client_secret_expires_at, metadata)
+				VALUES (?, ?, ?, ?, ?)`,
+			client.Id,
+			registration.RegistrationAccessTokenHash,
+			unixNano(registration.ClientIdIssuedAt),
+			unixNano(registration.ClientSecretExpiresAt),
+			string(metadata),
+		)
+		return error
+	})
+}
+
+func (storage *Storage) FindClientRegistrationById(clientId string) (*server.ClientRegistration, error) {
+
+	var issuedAt, secretExpiresAt int64
+	var metadata string
+	registration := &server.ClientRegistration{Metadata: &server.ClientMetadata{}}
+	row := storage.queryRow(
+		"SELECT registration_access_token_hash, client_id_issued_at, client_secret_expires_at, metadata FROM client_registrations WHERE client_id = ?",
+		clientId,
+	)
+
+	if error := row.Scan(&registration.RegistrationAccessTokenHash, &issuedAt, &secretExpiresAt, &metadata); error != nil {
+
+		return nil, notFound(error, "Client registration with id %s not found", clientId)
+	}
+
+	if error := json.Unmarshal([]byte(metadata), registration.Metadata); error != nil {
+
+		return nil, error
+	}
+
+	client, error := storage.FindClientById(clientId)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	registration.Client = client
+	registration.ClientIdIssuedAt = fromUnixNano(issuedAt)
+	registration.ClientSecretExpiresAt = fromUnixNano(secretExpiresAt)
+	return registration, nil
+}
+
+func (storage *Storage) DeleteClientRegistration(registration *server.ClientRegistration) error {
+
+	return storage.transaction(func(transaction *database.Tx) error {
+
+		for _, query := range []string{"DELETE FROM client_registrations WHERE client_id = ?", "DELETE FROM clients WHERE id = ?"} {
+
+			if _, error := storage.execTx(transaction, query, registration.Client.Id); error != nil {
+
+				return error
+			}
+		}
+
+		return nil
+	})
+}
+
+func (storage *Storage) FindOwnerByUsername(username string) (*server.Owner, error) {
+
+	owner, _, error := storage.findOwner("username", username)
+	return owner, error
+}
+
+func (storage *Storage) FindOwnerByUsernameAndPassword(username string, password string) (*server.Owner, error) {
+
+	owner, passwordHash, error := storage.findOwner("username", username)
+
+	if owner == nil {
+
+		return nil, error
+	}
+
+	if !server.CheckPassword(passwordHash, password) {
+
+		return nil, fmt.Errorf("Owner with username %s and password not found", username)
+	}
+
+	return owner, nil
+}
+
+func (storage *Storage) RefreshOwner(owner *server.Owner) (*server.Owner, error) {
+
+	refreshed, _, error := storage.findOwner("id", owner.Id)
+	return refreshed, error
+}
+
+func (storage *Storage) findOwner(column string, value string) (*server.Owner, string, error) {
+
+	owner := &server.Owner{}
+	var passwordHash string
+	row := storage.queryRow("SELECT id, name, password_hash FROM owners WHERE "+column+" = ?", value)
+
+	if error := row.Scan(&owner.Id, &owner.Name, &passwordHash); error != nil {
+
+		return nil, "", notFound(error, "Owner with %s %s not found", column, value)
+	}
+
+	return owner, passwordHash, nil
+}
+
+// SaveOwner creates or replaces the owner with the username and password it
+// logs in with, the password is hashed with server.HashPassword.
+func (storage *Storage) SaveOwner(owner *server.Owner, username string, password string) error {
+
+	passwordHash, error := server.HashPassword(password)
+
+	if error != nil {
+
+		return error
+	}
+
+	return storage.saveOwner(owner, username, passwordHash)
+}
+
+func (storage *Storage) saveOwner(owner *server.Owner, username string, passwordHash string) error {
+
+	return storage.transaction(func(transaction *database.Tx) error {
+
+		if _, error := storage.execTx(transaction, "DELETE FROM owners WHERE id = ? OR username = ?", owner.Id, username); error != nil {
+
+			return error
+		}
+
+		_, error := storage.execTx(
+			transaction,
+			"INSERT INTO owners (id, username, password_hash, name) VALUES (?, ?, ?, ?)",
+			owner.Id,
+			username,
+			passwordHash,
+			owner.Name,
+		)
+		return error
+	})
+}
+
+func (storage *Storage) DeleteOwner(ownerId string) error {
+
+	return storage.deleteOne("DELETE FROM owners WHERE id = ?", "Owner with id %s not found", ownerId)
+}
+
+func (storage *Storage) FindScopeByName(name string) (*server.Scope, error) {
+
+	scope, error := scanScope(storage.queryRow(scopeQuery+" WHERE name = ?", name))
+
+	if error != nil {
+
+		return nil, notFound(error, "Scope named %s not found", name)
+	}
+
+	return scope, nil
+}
+
+func (storage *Storage) FindAllScopes() ([]*server.Scope, error) {
+
+	rows, error := storage.db.Query(scopeQuery + " ORDER BY name")
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	defer rows.Close()
+	scopes := []*server.Scope{}
+
+	for rows.Next() {
+
+		scope, error := scanScope(rows)
+
+		if error != nil {
+
+			return nil, error
+		}
+
+		scopes = append(scopes, scope)
+	}
+
+	return scopes, rows.Err()
+}
+
+func (storage *Storage) SaveScope(scope *server.Scope) error {
+
+	return storage.transaction(func(transaction *database.Tx) error {
+
+		if _, error := storage.execTx(transaction, "DELETE FROM scopes WHERE name = ?", scope.Name); error != nil {
+
+			return error
+		}
+
+		_, error := storage.execTx(
+			transaction,
+			"INSERT INTO scopes (name, id, description, sensitive, implies) VALUES (?, ?, ?, ?, ?)",
+			scope.Name,
+			scope.Id,
+			scope.Description,
+			scope.Sensitive,
+			strings.Join(scope.Implies, " "),
+		)
+		return error
+	})
+}
+
+func (storage *Storage) DeleteScope(name string) error {
+
+	return storage.deleteOne("DELETE FROM scopes WHERE name = ?", "Scope named %s not found", name)
+}
+
+func (storage *Storage) FindSessionByAccessToken(accessToken string) (*server.Session, error) {
+
+	session, error := storage.findSession("access_token_hash", accessToken)
+
+	if session == nil {
+
+		return nil, error
+	}
+
+	session.AccessToken.Token = accessToken
+
+	if storage.isExpired(session.AccessToken) {
+
+		if storage.isExpired(session.RefreshToken) {
+
+			storage.DeleteSession(session)
+		}
+
+		return nil, fmt.Errorf("Access token for session %s is expired", session.Id)
+	}
+
+	return session, nil
+}
+
+// FindSessionByRefreshToken revokes the whole session when a refresh token it
+// rotated out is presented again.
+func (storage *Storage) FindSessionByRefreshToken(refreshToken string) (*server.Session, error) {
+
+	session, error := storage.findSession("refresh_token_hash", refreshToken)
+
+	if session == nil {
+
+		var sessionId string
+		row := storage.queryRow("SELECT session_id FROM retired_refresh_tokens WHERE token_hash = ?", server.HashToken(refreshToken))
+
+		if row.Scan(&sessionId) == nil {
+
+			storage.DeleteSession(&server.Session{Id: sessionId})
+			return nil, &server.RefreshTokenReusedError{}
+		}
+
+		return nil, error
+	}
+
+	if session.RefreshToken == nil || storage.isExpired(session.RefreshToken) {
+
+		storage.DeleteSession(session)
+		return nil, fmt.Errorf("Refresh token for session %s is expired", session.Id)
+	}
+
+	session.RefreshToken.Token = refreshToken
+	return session, nil
+}
+
+func (storage *Storage) findSession(column string, token string) (*server.Session, error) {
+
+	var data string
+	row := storage.queryRow("SELECT data FROM sessions WHERE "+column+" = ?", server.HashToken(token))
+
+	if error := row.Scan(&data); error != nil {
+
+		return nil, notFound(error, "Session not found for %s", strings.TrimSuffix(column, "_hash"))
+	}
+
+	session := server.NewSession()
+
+	if error := json.Unmarshal([]byte(data), session); error != nil {
+
+		return nil, error
+	}
+
+	return session, nil
+}
+
+// SaveSession keeps the hash that is already stored for a token that was
+// loaded without its value.
+func (storage *Storage) SaveSession(session *server.Session) {
+
+	error := storage.transaction(func(transaction *database.Tx) error {
+
+		var storedAccessTokenHash string
+		var storedRefreshTokenHash database.NullString
+		row := storage.queryRowTx(transaction, "SELECT access_token_hash, refresh_token_hash FROM sessions WHERE id = ?"+storage.dialect.forUpdate, session.Id)
+
+		if error := row.Scan(&storedAccessTokenHash, &storedRefreshTokenHash); error != nil && error != database.ErrNoRows {
+
+			return error
+		}
+
+		accessTokenHash := tokenHash(session.AccessToken, storedAccessTokenHash)
+		refreshTokenHash := database.NullString{}
+
+		if session.RefreshToken != nil {
+
+			refreshTokenHash = database.NullString{String: tokenHash(session.RefreshToken, storedRefreshTokenHash.String), Valid: true}
+		}
+
+		if storedRefreshTokenHash.Valid && storedRefreshTokenHash.String != refreshTokenHash.String {
+
+			if _, error := storage.execTx(
+				transaction,
+				"INSERT INTO retired_refresh_tokens (token_hash, session_id) VALUES (?, ?)",
+				storedRefreshTokenHash.String,
+				session.Id,
+			); error != nil {
+
+				return error
+			}
+		}
+
+		data, error := json.Marshal(withoutTokenValues(session))
+
+		if error != nil {
+
+			return error
+		}
+
+		if error := storage.deleteSessionRows(transaction, session.Id); error != nil {
+
+			return error
+		}
+
+		if _, error := storage.execTx(
+			transaction,
+			"INSERT INTO sessions (id, access_token_hash, refresh_token_hash, client_id, owner_id, created_at, expires_at, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			session.Id,
+			accessTokenHash,
+			refreshTokenHash,
+			sessionClientId(session),
+			sessionOwnerId(session),
+			unixNano(session.CreatedAt),
+			unixNano(sessionExpiresAt(session)),
+			string(data),
+		); error != nil {
+
+			return error
+		}
+
+		for scope := range session.Scopes {
+
+			if _, error := storage.execTx(transaction, "INSERT INTO session_scopes (session_id, scope) VALUES (?, ?)", session.Id, scope); error != nil {
+
+				return error
+			}
+		}
+
+		return nil
+	})
+
+	storage.handleError(error)
+}
+
+func (storage *Storage) DeleteSession(session *server.Session) {
+
+	storage.handleError(storage.transaction(func(transaction *database.Tx) error {
+
+		if _, error := storage.execTx(transaction, "DELETE FROM retired_refresh_tokens WHERE session_id = ?", session.Id); error != nil {
+
+			return error
+		}
+
+		return storage.deleteSessionRows(transaction, session.Id)
+	}))
+}
+
+func (storage *Storage) deleteSessionRows(transaction *database.Tx, sessionId string) error {
+
+	if _, error := storage.execTx(transaction, "DELETE FROM session_scopes WHERE session_id = ?", sessionId); error != nil {
+
+		return error
+	}
+
+	_, error := storage.execTx(transaction, "DELETE FROM sessions WHERE id = ?", sessionId)
+	return error
+}
+
+func (storage *Storage) FindSessionById(sessionId string) (*server.Session, error) {
+
+	sessions, error := storage.findSessions("id = ?", 0, sessionId)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	if len(sessions) == 0 {
+
+		return nil, fmt.Errorf("Session with id %s not found", sessionId)
+	}
+
+	return sessions[0], nil
+}
+
+func (storage *Storage) FindSessionsByOwnerId(ownerId string) ([]*server.Session, error) {
+
+	return storage.findSessions("owner_id = ?", 0, ownerId)
+}
+
+func (storage *Storage) FindSessionsByClientId(clientId string) ([]*server.Session, error) {
+
+	return storage.findSessions("client_id = ?", 0, clientId)
+}
+
+func (storage *Storage) FindSessionsByScope(scope string) ([]*server.Session, error) {
+
+	return storage.findSessions(scopeCondition, 0, scope)
+}
+
+// QuerySessions fetches one session more than the limit to find out whether
+// there is a next page.
+func (storage *Storage) QuerySessions(filter *server.SessionFilter, cursor *server.SessionCursor, limit int) (*server.SessionPage, error) {
+
+	condition, arguments := sessionFilterCondition(filter)
+
+	if cursor != nil {
+
+		createdAt := unixNano(cursor.CreatedAt)
+		condition += " AND (created_at > ? OR (created_at = ? AND id > ?))"
+		arguments = append(arguments, createdAt, createdAt, cursor.SessionId)
+	}
+
+	fetch := 0
+
+	if limit > 0 {
+
+		fetch = limit + 1
+	}
+
+	sessions, error := storage.findSessions(condition, fetch, arguments...)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	return server.NewSessionPage(sessions, limit), nil
+}
+
+func (storage *Storage) CountSessions(filter *server.SessionFilter) (int, error) {
+
+	var count int
+	condition, arguments := sessionFilterCondition(filter)
+	arguments = append(arguments, unixNano(storage.clock.Now()))
+	row := storage.queryRow("SELECT COUNT(*) FROM sessions WHERE "+condition+" AND (expires_at = 0 OR expires_at > ?)", arguments...)
+	return count, row.Scan(&count)
+}
+
+// findSessions returns the active sessions matching the condition, oldest
+// first and without their token values. A limit of zero returns them all.
+func (storage *Storage) findSessions(condition string, limit int, arguments ...interface{}) ([]*server.Session, error) {
+
+	query := "SELECT data FROM sessions WHERE " + condition + " AND (expires_at = 0 OR expires_at > ?) ORDER BY created_at, id"
+	arguments = append(arguments, unixNano(storage.clock.Now()))
+
+	if limit > 0 {
+
+		query += " LIMIT ?"
+		arguments = append(arguments, limit)
+	}
+
+	rows, error := storage.db.Query(storage.rebind(query), arguments...)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	defer rows.Close()
+	sessions := []*server.Session{}
+
+	for rows.Next() {
+
+		var data string
+
+		if error := rows.Scan(&data); error != nil {
+
+			return nil, error
+		}
+
+		session := server.NewSession()
+
+		if error := json.Unmarshal([]byte(data), session); error != nil {
+
+			return nil, error
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (storage *Storage) CountActiveSessions() (int, error) {
+
+	var count int
+	row := storage.queryRow("SELECT COUNT(*) FROM sessions WHERE expires_at = 0 OR expires_at > ?", unixNano(storage.clock.Now()))
+	return count, row.Scan(&count)
+}
+
+func (storage *Storage) FindConsent(ownerId string, clientId string) (*server.Consent, error) {
+
+	consents, error := storage.findConsents("owner_id = ? AND client_id = ?", ownerId, clientId)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	if len(consents) == 0 {
+
+		return nil, fmt.Errorf("Consent of owner %s for client %s not found", ownerId, clientId)
+	}
+
+	return consents[0], nil
+}
+
+func (storage *Storage) FindConsentsByOwnerId(ownerId string) ([]*server.Consent, error) {
+
+	return storage.findConsents("owner_id = ?", ownerId)
+}
+
+// SaveConsent replaces the consent the owner had for the client, which may
+// have had another id.
+func (storage *Storage) SaveConsent(consent *server.Consent) error {
+
+	return storage.transaction(func(transaction *database.Tx) error {
+
+		if _, error := storage.execTx(
+			transaction,
+			"DELETE FROM consents WHERE owner_id = ? AND client_id = ?",
+			consent.OwnerId,
+			consent.ClientId,
+		); error != nil {
+
+			return error
+		}
+
+		_, error := storage.execTx(
+			transaction,
+			"INSERT INTO consents (id, owner_id, client_id, scopes, granted_at, updated_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			consent.Id,
+			consent.OwnerId,
+			consent.ClientId,
+			strings.Join(consent.Scopes, " "),
+			unixNano(consent.GrantedAt),
+			unixNano(consent.UpdatedAt),
+			unixNano(consent.ExpiresAt),
+		)
+		return error
+	})
+}
+
+func (storage *Storage) DeleteConsent(consent *server.Consent) error {
+
+	_, error := storage.db.Exec(storage.rebind("DELETE FROM consents WHERE id = ?"), consent.Id)
+	return error
+}
+
+func (storage *Storage) findConsents(condition string, arguments ...interface{}) ([]*server.Consent, error) {
+
+	rows, error := storage.db.Query(
+		storage.rebind("SELECT id, owner_id, client_id, scopes, granted_at, updated_at, expires_at FROM consents WHERE "+condition+" ORDER BY client_id"),
+		arguments...,
+	)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	defer rows.Close()
+	consents := []*server.Consent{}
+
+	for rows.Next() {
+
+		var scopes string
+		var grantedAt, updatedAt, expiresAt int64
+		consent := &server.Consent{}
+
+		if error := rows.Scan(&consent.Id, &consent.OwnerId, &consent.ClientId, &scopes, &grantedAt, &updatedAt, &expiresAt); error != nil {
+
+			return nil, error
+		}
+
+		consent.Scopes = strings.Fields(scopes)
+		consent.GrantedAt = fromUnixNano(grantedAt)
+		consent.UpdatedAt = fromUnixNano(updatedAt)
+		consent.ExpiresAt = fromUnixNano(expiresAt)
+		consents = append(consents, consent)
+	}
+
+	return consents, rows.Err()
+}
+
+func (storage *Storage) UpdateLimitState(key string, update func(state *server.LimitState)) (*server.LimitState, error) {
+
+	state := &server.LimitState{}
+
+	error := storage.transaction(func(transaction *database.Tx) error {
+
+		var updatedAt, lockedUntil int64
+		row := storage.queryRowTx(
+			transaction,
+			"SELECT tokens, updated_at, failures, locked_until FROM limit_states WHERE limit_key = ?"+storage.dialect.forUpdate,
+			key,
+		)
+		error := row.Scan(&state.Tokens, &updatedAt, &state.Failures, &lockedUntil)
+
+		if error != nil && error != database.ErrNoRows {
+
+			return error
+		}
+
+		exists := error == nil
+		state.UpdatedAt = fromUnixNano(updatedAt)
+		state.LockedUntil = fromUnixNano(lockedUntil)
+		update(state)
+		query := "INSERT INTO limit_states (tokens, updated_at, failures, locked_until, limit_key) VALUES (?, ?, ?, ?, ?)"
+
+		if exists {
+
+			query = "UPDATE limit_states SET tokens = ?, updated_at = ?, failures = ?, locked_until = ? WHERE limit_key = ?"
+		}
+
+		_, error = storage.execTx(transaction, query, state.Tokens, unixNano(state.UpdatedAt), state.Failures, unixNano(state.LockedUntil), key)
+		return error
+	})
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	return state, nil
+}
+
+func (storage *Storage) deleteOne(query string, notFoundFormat string, id string) error {
+
+	result, error := storage.db.Exec(storage.rebind(query), id)
+
+	if error != nil {
+
+		return error
+	}
+
+	if deleted, error := result.RowsAffected(); error == nil && deleted == 0 {
+
+		return fmt.Errorf(notFoundFormat, id)
+	}
+
+	return nil
+}
+
+func (storage *Storage) isExpired(token *server.Token) bool {
+
+	return token == nil || token.IsExpired(storage.clock.Now())
+}
+
+func (storage *Storage) handleError(error error) {
+
+	if error != nil {
+
+		storage.errorHandler(error)
+	}
+}
+
+func (storage *Storage) transaction(work func(transaction *database.Tx) error) error {
+
+	transaction, error := storage.db.Begin()
+
+	if error != nil {
+
+		return error
+	}
+
+	if error := work(transaction); error != nil {
+
+		transaction.Rollback()
+		return error
+	}
+
+	return transaction.Commit()
+}
+
+func (storage *Storage) queryRow(query string, arguments ...interface{}) *database.Row {
+
+	return storage.db.QueryRow(storage.rebind(query), arguments...)
+}
+
+func (storage *Storage) queryRowTx(transaction *database.Tx, query string, arguments ...interface{}) *database.Row {
+
+	return transaction.QueryRow(storage.rebind(query), arguments...)
+}
+
+func (storage *Storage) execTx(transaction *database.Tx, query string, arguments ...interface{}) (database.Result, error) {
+
+	return transaction.Exec(storage.rebind(query), arguments...)
+}
+
+// rebind rewrites the ? placeholders queries are written with for drivers
+// that number them.
+func (storage *Storage) rebind(query string) string {
+
+	if !storage.dialect.numberedPlaceholders {
+
+		return query
+	}
+
+	var rebound strings.Builder
+	number := 0
+
+	for _, character := range query {
+
+		if character == '?' {
+
+			number++
+			rebound.WriteString("$" + strconv.Itoa(number))
+			continue
+		}
+
+		rebound.WriteRune(character)
+	}
+
+	return rebound.String()
+}
+
+func tokenHash(token *server.Token, storedHash string) string {
+
+	if token.Token == "" {
+
+		return storedHash
+	}
+
+	return server.HashToken(token.Token)
+}
+
+// withoutTokenValues copies the session leaving the token values out so they
+// only end up in the database hashed.
+func withoutTokenValues(session *server.Session) *server.Session {
+
+	stored := *session
+
+	if session.AccessToken != nil {
+
+		stored.AccessToken = &server.Token{IssuedAt: session.AccessToken.IssuedAt, ExpiresAt: session.AccessToken.ExpiresAt}
+	}
+
+	if session.RefreshToken != nil {
+
+		stored.RefreshToken = &server.Token{IssuedAt: session.RefreshToken.IssuedAt, ExpiresAt: session.RefreshToken.ExpiresAt}
+	}
+
+	return &stored
+}
+
+func sessionClientId(session *server.Session) string {
+
+	if session.Client == nil {
+
+		return ""
+	}
+
+	return session.Client.Id
+}
+
+func sessionOwnerId(session *server.Session) string {
+
+	if session.Owner == nil {
+
+		return ""
+	}
+
+	return session.Owner.Id
+}
+
+// sessionExpiresAt is when the last of the session's tokens expires, the zero
+// time when one of them never does.
+func sessionExpiresAt(session *server.Session) time.Time {
+
+	expiresAt := session.AccessToken.ExpiresAt
+
+	if session.RefreshToken == nil || expiresAt.IsZero() {
+
+		return expiresAt
+	}
+
+	if session.RefreshToken.ExpiresAt.IsZero() || session.RefreshToken.ExpiresAt.After(expiresAt) {
+
+		return session.RefreshToken.ExpiresAt
+	}
+
+	return expiresAt
+}
+
+func sessionFilterCondition(filter *server.SessionFilter) (string, []interface{}) {
+
+	conditions := []string{}
+	arguments := []interface{}{}
+
+	if filter.OwnerId != "" {
+
+		conditions = append(conditions, "owner_id = ?")
+		arguments = append(arguments, filter.OwnerId)
+	}
+
+	if filter.ClientId != "" {
+
+		conditions = append(conditions, "client_id = ?")
+		arguments = append(arguments, filter.ClientId)
+	}
+
+	if filter.Scope != "" {
+
+		conditions = append(conditions, scopeCondition)
+		arguments = append(arguments, filter.Scope)
+	}
+
+	if len(conditions) == 0 {
+
+		return "1 = 1", arguments
+	}
+
+	return strings.Join(conditions, " AND "), arguments
+}
+
+func unixNano(value time.Time) int64 {
+
+	if value.IsZero() {
+
+		return 0
+	}
+
+	return value.UnixNano()
+}
+
+func fromUnixNano(value int64) time.Time {
+
+	if value == 0 {
+
+		return time.Time{}
+	}
+
+	return time.Unix(0, value).UTC()
+}
+
+// scanScope reads a row of the scopeQuery.
+func scanScope(row interface{ Scan(...interface{}) error }) (*server.Scope, error) {
+
+	scope := &server.Scope{}
+	var implies string
+
+	if error := row.Scan(&scope.Name, &scope.Id, &scope.Description, &scope.Sensitive, &implies); error != nil {
+
+		return nil, error
+	}
+
+	if implies != "" {
+
+		scope.Implies = strings.Fields(implies)
+	}
+
+	return scope, nil
+}
+
+func notFound(error error, format string, arguments ...interface{}) error {
+
+	if error == database.ErrNoRows {
+
+		return fmt.Errorf(format, arguments...)
+	}
+
+	return error
+}
+
+func New(db *database.DB, driver string) *Storage {
+
+	return &Storage{
+		db,
+		dialectFor(driver),
+		server.NewSystemClock(),
+		func(error error) {
+
+			slog.Error("sql storage error", "error", error)
+		},
+	}
+}
+
+// Open connects with the driver, which has to be imported by the program, and
+// creates the schema.
+func Open(driver string, dsn string) (*Storage, error) {
+
+	db, error := database.Open(driver, dsn)
+
+	if error != nil {
+
+		return nil, error
+	}
+
+	storage := New(db, driver)
+
+	if error := storage.CreateSchema(); error != nil {
+
+		db.Close()
+		return nil, error
+	}
+
+	return storage, nil
+}
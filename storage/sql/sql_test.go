@@ -0,0 +1,366 @@
+package sql
+
+import (
+	database "database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/yjv/goauth2-server/server"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func TestStorageClients(t *testing.T) {
+
+	storage, _ := newTestStorage(t)
+	client := &server.Client{Id: "app", Name: "App", Type: server.ConfidentialClient, AllowedScopes: []string{"read"}}
+	public := &server.Client{Id: "cli", Type: server.PublicClient}
+
+	assert.Nil(t, storage.SaveClient(client, "secret"))
+	assert.Nil(t, storage.SaveClient(public, "ignored"))
+
+	found, error := storage.FindClientByIdAndSecret("app", "secret")
+	assert.Nil(t, error)
+	assert.Equal(t, client, found)
+
+	found, error = storage.FindClientByIdAndSecret("app", "wrong")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Client with id app and secret not found")
+
+	found, error = storage.FindClientByIdAndSecret("cli", "ignored")
+	assert.Nil(t, found)
+	assert.NotNil(t, error)
+
+	client.Name = "Renamed"
+	assert.Nil(t, storage.UpdateClient(client))
+	found, error = storage.RefreshClient(client)
+	assert.Nil(t, error)
+	assert.Equal(t, "Renamed", found.Name)
+	assert.EqualError(t, storage.UpdateClient(&server.Client{Id: "missing"}), "Client with id missing not found")
+
+	clients, error := storage.FindAllClients()
+	assert.Nil(t, error)
+	assert.Equal(t, []*server.Client{client, public}, clients)
+
+	assert.Nil(t, storage.DeleteClient("cli"))
+	assert.EqualError(t, storage.DeleteClient("cli"), "Client with id cli not found")
+	found, error = storage.FindClientById("cli")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Client with id cli not found")
+}
+
+func TestStorageClientRegistrations(t *testing.T) {
+
+	storage, _ := newTestStorage(t)
+	registration := &server.ClientRegistration{
+		Client:                      &server.Client{Id: "app", Type: server.ConfidentialClient, AllowedScopes: []string{"read"}},
+		Metadata:                    &server.ClientMetadata{GrantTypes: []string{"client_credentials"}, Scope: "read"},
+		RegistrationAccessTokenHash: server.HashRegistrationAccessToken("registration-token"),
+		ClientIdIssuedAt:            testNow,
+	}
+
+	assert.Nil(t, storage.SaveClientRegistration(registration, "secret"))
+	found, error := storage.FindClientRegistrationById("app")
+	assert.Nil(t, error)
+	assert.Equal(t, registration, found)
+
+	//an update without a new secret keeps the old one
+	registration.Metadata.ClientName = "App"
+	assert.Nil(t, storage.SaveClientRegistration(registration, ""))
+	client, error := storage.FindClientByIdAndSecret("app", "secret")
+	assert.Nil(t, error)
+	assert.Equal(t, registration.Client, client)
+	found, _ = storage.FindClientRegistrationById("app")
+	assert.Equal(t, "App", found.Metadata.ClientName)
+
+	//unless the client no longer has one
+	registration.Client.Type = server.PublicClient
+	assert.Nil(t, storage.SaveClientRegistration(registration, ""))
+	client, _ = storage.FindClientByIdAndSecret("app", "secret")
+	assert.Nil(t, client)
+
+	assert.Nil(t, storage.DeleteClientRegistration(registration))
+	found, error = storage.FindClientRegistrationById("app")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Client registration with id app not found")
+	client, _ = storage.FindClientById("app")
+	assert.Nil(t, client)
+}
+
+func TestStorageOwners(t *testing.T) {
+
+	storage, _ := newTestStorage(t)
+	owner := &server.Owner{Id: "42", Name: "Bob"}
+
+	assert.Nil(t, storage.SaveOwner(owner, "bob", "password"))
+
+	found, error := storage.FindOwnerByUsernameAndPassword("bob", "password")
+	assert.Nil(t, error)
+	assert.Equal(t, owner, found)
+
+	found, error = storage.FindOwnerByUsernameAndPassword("bob", "wrong")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Owner with username bob and password not found")
+
+	found, error = storage.RefreshOwner(&server.Owner{Id: "42"})
+	assert.Nil(t, error)
+	assert.Equal(t, owner, found)
+
+	assert.Nil(t, storage.DeleteOwner("42"))
+	found, error = storage.FindOwnerByUsername("bob")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Owner with username bob not found")
+}
+
+func TestStorageScopes(t *testing.T) {
+
+	storage, _ := newTestStorage(t)
+	admin := &server.Scope{Id: "admin", Name: "admin", Description: "Everything", Sensitive: true, Implies: []string{"read", "write"}}
+	read := &server.Scope{Id: "read", Name: "read"}
+
+	assert.Nil(t, storage.SaveScope(read))
+	assert.Nil(t, storage.SaveScope(admin))
+
+	found, error := storage.FindScopeByName("admin")
+	assert.Nil(t, error)
+	assert.Equal(t, admin, found)
+
+	scopes, error := storage.FindAllScopes()
+	assert.Nil(t, error)
+	assert.Equal(t, []*server.Scope{admin, read}, scopes)
+
+	assert.Nil(t, storage.DeleteScope("admin"))
+	assert.EqualError(t, storage.DeleteScope("admin"), "Scope named admin not found")
+	found, error = storage.FindScopeByName("admin")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Scope named admin not found")
+}
+
+func TestStorageSessions(t *testing.T) {
+
+	storage, _ := newTestStorage(t)
+	session := testSession("session", "owner", "client", testNow)
+
+	storage.SaveSession(session)
+
+	found, error := storage.FindSessionByAccessToken("access-session")
+	assert.Nil(t, error)
+	assert.Equal(t, "access-session", found.AccessToken.Token)
+	assert.Equal(t, "", found.RefreshToken.Token)
+	assert.Equal(t, session.Scopes, found.Scopes)
+	assert.Equal(t, session.Client, found.Client)
+
+	found, error = storage.FindSessionByRefreshToken("refresh-session")
+	assert.Nil(t, error)
+	assert.Equal(t, "refresh-session", found.RefreshToken.Token)
+	assert.Equal(t, "", found.AccessToken.Token)
+
+	//saving a session loaded by one token keeps the hash of the other one
+	found.Scopes["write"] = &server.Scope{Id: "write", Name: "write"}
+	storage.SaveSession(found)
+
+	found, error = storage.FindSessionByAccessToken("access-session")
+	assert.Nil(t, error)
+	assert.Len(t, found.Scopes, 2)
+
+	found, error = storage.FindSessionById("session")
+	assert.Nil(t, error)
+	assert.Equal(t, "", found.AccessToken.Token)
+
+	storage.DeleteSession(session)
+	found, error = storage.FindSessionByAccessToken("access-session")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Session not found for access_token")
+}
+
+func TestStorageSessionsWithRotatedRefreshToken(t *testing.T) {
+
+	storage, _ := newTestStorage(t)
+	session := testSession("session", "owner", "client", testNow)
+	storage.SaveSession(session)
+
+	session.AccessToken = server.NewToken("access-rotated", testNow, time.Hour)
+	session.RefreshToken = server.NewToken("refresh-rotated", testNow, 24*time.Hour)
+	storage.SaveSession(session)
+
+	found, error := storage.FindSessionByRefreshToken("refresh-rotated")
+	assert.Nil(t, error)
+	assert.Equal(t, "session", found.Id)
+
+	//presenting the rotated out token again revokes the whole session
+	found, error = storage.FindSessionByRefreshToken("refresh-session")
+	assert.Nil(t, found)
+	assert.Equal(t, &server.RefreshTokenReusedError{}, error)
+
+	found, error = storage.FindSessionByRefreshToken("refresh-rotated")
+	assert.Nil(t, found)
+	assert.NotNil(t, error)
+}
+
+func TestStorageSessionsWhenExpired(t *testing.T) {
+
+	storage, clock := newTestStorage(t)
+	storage.SaveSession(testSession("session", "owner", "client", testNow))
+
+	clock.Advance(2 * time.Hour)
+	found, error := storage.FindSessionByAccessToken("access-session")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Access token for session session is expired")
+
+	//the refresh token outlives the access token
+	found, error = storage.FindSessionByRefreshToken("refresh-session")
+	assert.Nil(t, error)
+	assert.Equal(t, "session", found.Id)
+
+	clock.Advance(24 * time.Hour)
+	found, error = storage.FindSessionByRefreshToken("refresh-session")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Refresh token for session session is expired")
+
+	count, error := storage.CountActiveSessions()
+	assert.Nil(t, error)
+	assert.Equal(t, 0, count)
+}
+
+func TestStorageQuerySessions(t *testing.T) {
+
+	storage, _ := newTestStorage(t)
+	first := testSession("a", "owner", "client", testNow)
+	second := testSession("b", "owner", "other", testNow.Add(time.Second))
+	third := testSession("c", "owner", "client", testNow.Add(2*time.Second))
+	stranger := testSession("d", "stranger", "client", testNow)
+
+	for _, session := range []*server.Session{third, stranger, first, second} {
+
+		storage.SaveSession(session)
+	}
+
+	page, error := storage.QuerySessions(&server.SessionFilter{OwnerId: "owner"}, nil, 2)
+	assert.Nil(t, error)
+	assert.Equal(t, []string{"a", "b"}, sessionIds(page.Sessions))
+	assert.Equal(t, &server.SessionCursor{CreatedAt: second.CreatedAt, SessionId: "b"}, page.NextCursor)
+
+	page, error = storage.QuerySessions(&server.SessionFilter{OwnerId: "owner"}, page.NextCursor, 2)
+	assert.Nil(t, error)
+	assert.Equal(t, []string{"c"}, sessionIds(page.Sessions))
+	assert.Nil(t, page.NextCursor)
+
+	page, error = storage.QuerySessions(&server.SessionFilter{ClientId: "client", Scope: "read"}, nil, 0)
+	assert.Nil(t, error)
+	assert.Equal(t, []string{"a", "d", "c"}, sessionIds(page.Sessions))
+
+	count, error := storage.CountSessions(&server.SessionFilter{OwnerId: "owner", ClientId: "client"})
+	assert.Nil(t, error)
+	assert.Equal(t, 2, count)
+
+	count, error = storage.CountActiveSessions()
+	assert.Nil(t, error)
+	assert.Equal(t, 4, count)
+
+	sessions, error := storage.FindSessionsByScope("write")
+	assert.Nil(t, error)
+	assert.Empty(t, sessions)
+}
+
+func TestStorageConsents(t *testing.T) {
+
+	storage, _ := newTestStorage(t)
+	consent := &server.Consent{Id: "consent", OwnerId: "owner", ClientId: "client", Scopes: []string{"read", "write"}, GrantedAt: testNow, UpdatedAt: testNow}
+
+	assert.Nil(t, storage.SaveConsent(consent))
+
+	found, error := storage.FindConsent("owner", "client")
+	assert.Nil(t, error)
+	assert.Equal(t, consent, found)
+
+	//a new consent for the same owner and client replaces the old one
+	replacement := &server.Consent{Id: "replacement", OwnerId: "owner", ClientId: "client", Scopes: []string{"read"}, GrantedAt: testNow, UpdatedAt: testNow, ExpiresAt: testNow.Add(time.Hour)}
+	assert.Nil(t, storage.SaveConsent(replacement))
+	assert.Nil(t, storage.SaveConsent(&server.Consent{Id: "other", OwnerId: "owner", ClientId: "another", Scopes: []string{"read"}, GrantedAt: testNow, UpdatedAt: testNow}))
+
+	consents, error := storage.FindConsentsByOwnerId("owner")
+	assert.Nil(t, error)
+	assert.Equal(t, []string{"another", "client"}, []string{consents[0].ClientId, consents[1].ClientId})
+	assert.Equal(t, replacement, consents[1])
+
+	assert.Nil(t, storage.DeleteConsent(replacement))
+	found, error = storage.FindConsent("owner", "client")
+	assert.Nil(t, found)
+	assert.EqualError(t, error, "Consent of owner owner for client client not found")
+}
+
+func TestStorageUpdateLimitState(t *testing.T) {
+
+	storage, _ := newTestStorage(t)
+	increment := func(state *server.LimitState) {
+
+		state.Failures++
+		state.UpdatedAt = testNow
+	}
+
+	state, error := storage.UpdateLimitState("client:app", increment)
+	assert.Nil(t, error)
+	assert.Equal(t, &server.LimitState{Failures: 1, UpdatedAt: testNow}, state)
+
+	state, error = storage.UpdateLimitState("client:app", increment)
+	assert.Nil(t, error)
+	assert.Equal(t, 2, state.Failures)
+}
+
+func TestStorageErrorHandler(t *testing.T) {
+
+	storage, _ := newTestStorage(t)
+	var handled error
+	storage.SetErrorHandler(func(error error) { handled = error })
+	storage.DB().Exec("DROP TABLE sessions")
+
+	storage.SaveSession(testSession("session", "owner", "client", testNow))
+	assert.NotNil(t, handled)
+}
+
+func TestStorageRebind(t *testing.T) {
+
+	assert.Equal(t, "SELECT ? WHERE ?", New(nil, "sqlite3").rebind("SELECT ? WHERE ?"))
+	assert.Equal(t, "SELECT $1 WHERE $2", New(nil, "postgres").rebind("SELECT ? WHERE ?"))
+}
+
+// newTestStorage keeps a single connection since every connection to
+// :memory: opens a database of its own.
+func newTestStorage(t *testing.T) (*Storage, *server.FakeClock) {
+
+	db, error := database.Open("sqlite3", ":memory:")
+	assert.Nil(t, error)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	clock := server.NewFakeClock(testNow)
+	storage := New(db, "sqlite3").SetClock(clock)
+	assert.Nil(t, storage.CreateSchema())
+	return storage, clock
+}
+
+func testSession(id string, ownerId string, clientId string, createdAt time.Time) *server.Session {
+
+	session := server.NewSession()
+	session.Id = id
+	session.Owner = &server.Owner{Id: ownerId}
+	session.Client = &server.Client{Id: clientId}
+	session.CreatedAt = createdAt
+	session.AccessToken = server.NewToken("access-"+id, createdAt, time.Hour)
+	session.RefreshToken = server.NewToken("refresh-"+id, createdAt, 24*time.Hour)
+	session.Scopes["read"] = &server.Scope{Id: "read", Name: "read"}
+	return session
+}
+
+func sessionIds(sessions []*server.Session) []string {
+
+	ids := []string{}
+
+	for _, session := range sessions {
+
+		ids = append(ids, session.Id)
+	}
+
+	return ids
+}